@@ -0,0 +1,128 @@
+// Package audit records who changed what through the proxy.
+//
+// Entries are written to a Sink by a Logger in the background, so recording them never blocks a request.
+package audit
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultBufferSize is how many entries may wait to be written, unless NewLogger is told otherwise.
+const DefaultBufferSize = 1024
+
+// Result has the counts of documents affected by an operation.
+type Result struct {
+	Matched  int64 `json:"matched,omitempty" bson:"matched,omitempty"`
+	Modified int64 `json:"modified,omitempty" bson:"modified,omitempty"`
+	Upserted int64 `json:"upserted,omitempty" bson:"upserted,omitempty"`
+	Inserted int64 `json:"inserted,omitempty" bson:"inserted,omitempty"`
+	Deleted  int64 `json:"deleted,omitempty" bson:"deleted,omitempty"`
+}
+
+// Entry describes a single write or admin operation.
+type Entry struct {
+	Time       time.Time   `json:"time" bson:"time"`
+	Principal  string      `json:"principal" bson:"principal"`
+	AuthMethod string      `json:"auth_method,omitempty" bson:"auth_method,omitempty"`
+	Client     string      `json:"client,omitempty" bson:"client,omitempty"`
+	Backend    string      `json:"backend,omitempty" bson:"backend,omitempty"`
+	Operation  string      `json:"operation" bson:"operation"`
+	Database   string      `json:"database" bson:"database"`
+	Collection string      `json:"collection,omitempty" bson:"collection,omitempty"`
+	ID         interface{} `json:"id,omitempty" bson:"id,omitempty"`
+	Filter     interface{} `json:"filter,omitempty" bson:"filter,omitempty"`
+	Change     interface{} `json:"change,omitempty" bson:"change,omitempty"`
+	Result     *Result     `json:"result,omitempty" bson:"result,omitempty"`
+	Error      string      `json:"error,omitempty" bson:"error,omitempty"`
+}
+
+// Sink stores audit entries.
+type Sink interface {
+	Write(entry Entry) error
+	Close() error
+}
+
+// Logger sends entries to a sink in the background. When the sink can't keep up, new entries are dropped
+// (and counted) instead of slowing down the requests.
+type Logger struct {
+	sink    Sink
+	entries chan Entry
+	done    chan struct{}
+	dropped uint64
+	// mutex keeps Record from sending entries while Close closes the channel.
+	mutex  sync.RWMutex
+	closed bool
+}
+
+// NewLogger starts writing entries to sink. If bufferSize is not positive, DefaultBufferSize is used.
+func NewLogger(sink Sink, bufferSize int) *Logger {
+	if bufferSize <= 0 {
+		bufferSize = DefaultBufferSize
+	}
+
+	l := &Logger{
+		sink:    sink,
+		entries: make(chan Entry, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// Record queues entry to be written. It never blocks, and ignores the entries recorded after Close.
+func (l *Logger) Record(entry Entry) {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+	if l.closed {
+		return
+	}
+	select {
+	case l.entries <- entry:
+	default:
+		dropped := atomic.AddUint64(&l.dropped, 1)
+		if dropped == 1 || dropped%1000 == 0 {
+			log.Warn().
+				Uint64("dropped", dropped).
+				Msg("audit sink is too slow; dropping entries")
+		}
+	}
+}
+
+// Dropped returns how many entries were dropped because the buffer was full.
+func (l *Logger) Dropped() uint64 {
+	return atomic.LoadUint64(&l.dropped)
+}
+
+// Close writes the queued entries and closes the sink. Entries recorded after Close are lost.
+func (l *Logger) Close() error {
+	l.mutex.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.mutex.Unlock()
+	<-l.done
+	return l.sink.Close()
+}
+
+func (l *Logger) run() {
+	defer close(l.done)
+	for entry := range l.entries {
+		err := l.sink.Write(entry)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("operation", entry.Operation).
+				Str("principal", entry.Principal).
+				Msg("failed to write audit entry")
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package audit_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// memorySink keeps the entries it receives. If blocked is set, writes wait until it is closed.
+type memorySink struct {
+	mutex   sync.Mutex
+	entries []audit.Entry
+	blocked chan struct{}
+	closed  bool
+}
+
+func (s *memorySink) Write(entry audit.Entry) error {
+	if s.blocked != nil {
+		<-s.blocked
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memorySink) Close() error {
+	s.closed = true
+	return nil
+}
+
+func TestLoggerWritesInOrder(t *testing.T) {
+	sink := &memorySink{}
+	logger := audit.NewLogger(sink, 0)
+
+	for _, operation := range []string{"insert", "update", "dropCollection"} {
+		logger.Record(audit.Entry{Operation: operation})
+	}
+	assert.NoError(t, logger.Close())
+
+	assert.True(t, sink.closed)
+	assert.Len(t, sink.entries, 3)
+	for i, operation := range []string{"insert", "update", "dropCollection"} {
+		assert.Equal(t, operation, sink.entries[i].Operation)
+		assert.False(t, sink.entries[i].Time.IsZero(), "the time should be filled")
+	}
+	assert.Zero(t, logger.Dropped())
+}
+
+func TestLoggerDropsWhenFull(t *testing.T) {
+	sink := &memorySink{blocked: make(chan struct{})}
+	logger := audit.NewLogger(sink, 1)
+
+	// The first entry is taken by the writer, the second one waits in the buffer, the others are dropped.
+	for i := 0; i < 10; i++ {
+		logger.Record(audit.Entry{Operation: "insert"})
+	}
+	assert.GreaterOrEqual(t, logger.Dropped(), uint64(8))
+
+	close(sink.blocked)
+	assert.NoError(t, logger.Close())
+	assert.Equal(t, uint64(10), uint64(len(sink.entries))+logger.Dropped())
+}
+
+func TestLoggerRecordAfterClose(t *testing.T) {
+	sink := &memorySink{}
+	logger := audit.NewLogger(sink, 0)
+	logger.Record(audit.Entry{Operation: "insert"})
+	assert.NoError(t, logger.Close())
+
+	assert.NotPanics(t, func() {
+		logger.Record(audit.Entry{Operation: "update"})
+	})
+	assert.Len(t, sink.entries, 1)
+}
+
+func TestWriterSink(t *testing.T) {
+	var buffer bytes.Buffer
+	sink := audit.NewWriterSink(&buffer)
+
+	err := sink.Write(audit.Entry{
+		Principal: "reporting",
+		Operation: "update",
+		Database:  "quotes",
+		Filter:    bson.D{{Key: "author", Value: "Seneca"}},
+		Result:    &audit.Result{Matched: 2, Modified: 1},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, sink.Write(audit.Entry{Operation: "dropDatabase"}))
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	assert.Len(t, lines, 2)
+
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &entry))
+	assert.Equal(t, "reporting", entry["principal"])
+	assert.Equal(t, map[string]interface{}{"author": "Seneca"}, entry["filter"])
+	assert.Equal(t, map[string]interface{}{"matched": 2.0, "modified": 1.0}, entry["result"])
+}
+
+func TestFileSinkAppends(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "audit.log")
+
+	for i := 0; i < 2; i++ {
+		sink, err := audit.NewFileSink(filename)
+		assert.NoError(t, err)
+		assert.NoError(t, sink.Write(audit.Entry{Operation: "insert"}))
+		assert.NoError(t, sink.Close())
+	}
+
+	content, err := ioutil.ReadFile(filename)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(string(content), "\n"))
+}
+
+func TestProxy(t *testing.T) {
+	actor := audit.Actor{Principal: "editor", AuthMethod: "apikey", Client: "10.0.0.1"}
+
+	testCases := []struct {
+		name          string
+		testCaseID    string
+		includeValues bool
+		call          func(p *audit.Proxy)
+		expected      audit.Entry
+	}{
+		{
+			name:       "insert summary",
+			testCaseID: "insertOK",
+			call: func(p *audit.Proxy) {
+				p.Insert("quotes", "classics", db.Quote{Author: "Seneca", OriginalQuote: "Errare humanum est"})
+			},
+			expected: audit.Entry{
+				Operation: "insert", Database: "quotes", Collection: "classics",
+				ID:     "5f4d641403490cb668ed8313",
+				Change: bson.M{"fields": []string{"author", "originalquote", "originaltitle", "translatedquote", "translatedtitle"}},
+				Result: &audit.Result{Inserted: 1},
+			},
+		},
+		{
+			name:       "update summary",
+			testCaseID: "updateOK",
+			call: func(p *audit.Proxy) {
+				p.Update("quotes", "classics", bson.M{"author": "Seneca"}, bson.M{"$set": bson.M{"translated_quote": "x", "author": "y"}})
+			},
+			expected: audit.Entry{
+				Operation: "update", Database: "quotes", Collection: "classics",
+				Filter: bson.M{"fields": []string{"author"}},
+				Change: bson.M{"$set": []string{"author", "translated_quote"}},
+				Result: &audit.Result{Matched: 1, Modified: 1},
+			},
+		},
+		{
+			// Values of the filter, like those of encrypted fields, are never recorded.
+			name:       "update filter summary",
+			testCaseID: "updateOK",
+			call: func(p *audit.Proxy) {
+				p.Update("quotes", "classics", bson.M{
+					"password": "hunter2",
+					"$or":      bson.A{bson.M{"author": "Seneca"}, bson.M{"publications": bson.M{"$gt": 2}}},
+				}, bson.M{"$set": bson.M{"author": "y"}})
+			},
+			expected: audit.Entry{
+				Operation: "update", Database: "quotes", Collection: "classics",
+				Filter: bson.M{"fields": []string{"author", "password", "publications"}},
+				Change: bson.M{"$set": []string{"author"}},
+				Result: &audit.Result{Matched: 1, Modified: 1},
+			},
+		},
+		{
+			name:          "update with values",
+			testCaseID:    "updateOK",
+			includeValues: true,
+			call: func(p *audit.Proxy) {
+				p.Update("quotes", "classics", bson.M{}, bson.M{"$inc": bson.M{"publications": 1}})
+			},
+			expected: audit.Entry{
+				Operation: "update", Database: "quotes", Collection: "classics",
+				Filter: bson.M{},
+				Change: bson.M{"$inc": bson.M{"publications": 1}},
+				Result: &audit.Result{Matched: 1, Modified: 1},
+			},
+		},
+		{
+			name:       "failed update",
+			testCaseID: "updateEmptyUpdate",
+			call: func(p *audit.Proxy) {
+				p.Update("quotes", "classics", bson.M{}, bson.M{})
+			},
+			expected: audit.Entry{
+				Operation: "update", Database: "quotes", Collection: "classics",
+				Filter: bson.M{"fields": []string{}},
+				Change: bson.M{"fields": []string(nil)},
+				Error:  "No updates given",
+			},
+		},
+		{
+			name:       "admin",
+			testCaseID: "adminRenameOK",
+			call: func(p *audit.Proxy) {
+				p.RenameCollection("quotes", "classics", db.RenameCollectionRequest{To: "archive"})
+			},
+			expected: audit.Entry{
+				Operation: "renameCollection", Database: "quotes", Collection: "classics",
+				Change: bson.M{"to": "archive", "drop_target": false},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := &memorySink{}
+			logger := audit.NewLogger(sink, 0)
+			proxy := audit.NewProxy(&mock.DBProxy{TestCaseID: tc.testCaseID}, logger, actor, tc.includeValues)
+
+			tc.call(proxy)
+			assert.NoError(t, logger.Close())
+
+			if assert.Len(t, sink.entries, 1) {
+				entry := sink.entries[0]
+				entry.Time = tc.expected.Time
+				tc.expected.Principal = "editor"
+				tc.expected.AuthMethod = "apikey"
+				tc.expected.Client = "10.0.0.1"
+				assert.Equal(t, tc.expected, entry)
+			}
+		})
+	}
+}
+
+func TestProxyPassesReadsThrough(t *testing.T) {
+	sink := &memorySink{}
+	logger := audit.NewLogger(sink, 0)
+	proxy := audit.NewProxy(&mock.DBProxy{TestCaseID: "findOK"}, logger, audit.Actor{}, false)
+
+	_, err := proxy.Find("quotes", "classics", bson.M{})
+	assert.NoError(t, err)
+	assert.NoError(t, logger.Close())
+	assert.Empty(t, sink.entries)
+}
+
+// failingSink refuses every entry.
+type failingSink struct{}
+
+func (failingSink) Write(audit.Entry) error { return errors.New("disk full") }
+func (failingSink) Close() error            { return nil }
+
+func TestLoggerSurvivesSinkErrors(t *testing.T) {
+	logger := audit.NewLogger(failingSink{}, 0)
+	logger.Record(audit.Entry{Operation: "insert"})
+	assert.NoError(t, logger.Close())
+}
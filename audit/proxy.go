@@ -0,0 +1,240 @@
+package audit
+
+import (
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Actor identifies who made a request.
+type Actor struct {
+	Principal  string
+	AuthMethod string
+	Client     string
+	// Backend is the MongoDB deployment chosen by the request, if not the default one.
+	Backend string
+}
+
+// Proxy records the writes and admin operations of another Proxy; reads are passed through.
+// Unless IncludeValues is set, filters and changes are summarized by the names of the fields they read
+// and write, so values like passwords or encrypted fields don't end up in the audit trail.
+type Proxy struct {
+	db.Proxy
+	Logger        *Logger
+	Actor         Actor
+	IncludeValues bool
+}
+
+// NewProxy wraps proxy, recording the operations of actor in logger.
+func NewProxy(proxy db.Proxy, logger *Logger, actor Actor, includeValues bool) *Proxy {
+	return &Proxy{
+		Proxy:         proxy,
+		Logger:        logger,
+		Actor:         actor,
+		IncludeValues: includeValues,
+	}
+}
+
+// Insert records the inserted document.
+func (p *Proxy) Insert(database, collection string, entry db.Quote) (*db.InsertResponse, error) {
+	response, err := p.Proxy.Insert(database, collection, entry)
+
+	record := p.entry("insert", database, collection, err)
+	record.Change = p.summarize(entry)
+	if err == nil && response != nil {
+		record.ID = response.InsertedID
+		record.Result = &Result{Inserted: 1}
+	}
+	p.Logger.Record(record)
+	return response, err
+}
+
+// Update records the filter, the update and how many documents it changed.
+func (p *Proxy) Update(database, collection string, filter, entry interface{}) (*db.UpdateResponse, error) {
+	response, err := p.Proxy.Update(database, collection, filter, entry)
+
+	record := p.entry("update", database, collection, err)
+	record.Filter = p.summarizeFilter(filter)
+	record.Change = p.summarize(entry)
+	if err == nil && response != nil && response.Results != nil {
+		record.ID = response.Results.UpsertedID
+		record.Result = &Result{
+			Matched:  response.Results.MatchedCount,
+			Modified: response.Results.ModifiedCount,
+			Upserted: response.Results.UpsertedCount,
+		}
+	}
+	p.Logger.Record(record)
+	return response, err
+}
+
+// ListCollections records that the collections of database were listed.
+func (p *Proxy) ListCollections(database string) (*db.CollectionsResponse, error) {
+	response, err := p.Proxy.ListCollections(database)
+	p.Logger.Record(p.entry("listCollections", database, "", err))
+	return response, err
+}
+
+// CreateCollection records the creation and its options.
+func (p *Proxy) CreateCollection(database, collection string, request db.CreateCollectionRequest) (*db.AdminResponse, error) {
+	response, err := p.Proxy.CreateCollection(database, collection, request)
+
+	record := p.entry("createCollection", database, collection, err)
+	record.Change = request
+	p.Logger.Record(record)
+	return response, err
+}
+
+// RenameCollection records the new name of the collection.
+func (p *Proxy) RenameCollection(database, collection string, request db.RenameCollectionRequest) (*db.AdminResponse, error) {
+	response, err := p.Proxy.RenameCollection(database, collection, request)
+
+	record := p.entry("renameCollection", database, collection, err)
+	record.Change = bson.M{"to": request.To, "drop_target": request.DropTarget}
+	p.Logger.Record(record)
+	return response, err
+}
+
+// DropCollection records the dropped collection.
+func (p *Proxy) DropCollection(database, collection string) (*db.AdminResponse, error) {
+	response, err := p.Proxy.DropCollection(database, collection)
+	p.Logger.Record(p.entry("dropCollection", database, collection, err))
+	return response, err
+}
+
+// DropDatabase records the dropped database.
+func (p *Proxy) DropDatabase(database string) (*db.AdminResponse, error) {
+	response, err := p.Proxy.DropDatabase(database)
+	p.Logger.Record(p.entry("dropDatabase", database, "", err))
+	return response, err
+}
+
+// UploadFile records the stored file, but never its content.
+func (p *Proxy) UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (*db.FileInfo, error) {
+	info, err := p.Proxy.UploadFile(database, bucket, filename, contentType, metadata, content)
+
+	record := p.entry("uploadFile", database, bucket, err)
+	change := bson.M{"filename": filename}
+	if len(contentType) > 0 {
+		change["content_type"] = contentType
+	}
+	if err == nil && info != nil {
+		change["length"] = info.Length
+		record.ID = info.ID
+		record.Result = &Result{Inserted: 1}
+	}
+	record.Change = change
+	p.Logger.Record(record)
+	return info, err
+}
+
+// DeleteFile records the deleted file.
+func (p *Proxy) DeleteFile(database, bucket, id string) (*db.DeleteResponse, error) {
+	response, err := p.Proxy.DeleteFile(database, bucket, id)
+
+	record := p.entry("deleteFile", database, bucket, err)
+	record.ID = id
+	if err == nil && response != nil {
+		record.Result = &Result{Deleted: response.DeletedCount}
+	}
+	p.Logger.Record(record)
+	return response, err
+}
+
+func (p *Proxy) entry(operation, database, collection string, err error) Entry {
+	entry := Entry{
+		Principal:  p.Actor.Principal,
+		AuthMethod: p.Actor.AuthMethod,
+		Client:     p.Actor.Client,
+		Backend:    p.Actor.Backend,
+		Operation:  operation,
+		Database:   database,
+		Collection: collection,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	return entry
+}
+
+// summarize returns the change itself, or only the fields it writes, grouped by update operator.
+func (p *Proxy) summarize(change interface{}) interface{} {
+	if p.IncludeValues || change == nil {
+		return change
+	}
+
+	document, err := toDocument(change)
+	if err != nil {
+		return nil
+	}
+
+	operators := bson.M{}
+	var fields []string
+	for key, value := range document {
+		nested, ok := value.(bson.M)
+		if strings.HasPrefix(key, "$") && ok {
+			operators[key] = sortedKeys(nested)
+			continue
+		}
+		fields = append(fields, key)
+	}
+	if len(operators) == 0 {
+		sort.Strings(fields)
+		return bson.M{"fields": fields}
+	}
+	return operators
+}
+
+// summarizeFilter returns the filter itself, or only the fields it reads, including those of $and, $or and $nor.
+func (p *Proxy) summarizeFilter(filter interface{}) interface{} {
+	if p.IncludeValues || filter == nil {
+		return filter
+	}
+
+	document, err := toDocument(filter)
+	if err != nil {
+		return nil
+	}
+	fields := bson.M{}
+	collectFields(document, fields)
+	return bson.M{"fields": sortedKeys(fields)}
+}
+
+// collectFields adds the fields of the conditions of filter to fields.
+func collectFields(filter bson.M, fields bson.M) {
+	for key, value := range filter {
+		conditions, ok := value.(bson.A)
+		if ok && (key == "$and" || key == "$or" || key == "$nor") {
+			for _, condition := range conditions {
+				if nested, ok := condition.(bson.M); ok {
+					collectFields(nested, fields)
+				}
+			}
+			continue
+		}
+		fields[key] = true
+	}
+}
+
+func sortedKeys(document bson.M) []string {
+	keys := make([]string, 0, len(document))
+	for key := range document {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// toDocument converts value to a bson.M, by encoding it as BSON.
+func toDocument(value interface{}) (bson.M, error) {
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	err = bson.Unmarshal(raw, &document)
+	return document, err
+}
@@ -0,0 +1,123 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// WriterSink writes each entry as a line of JSON.
+type WriterSink struct {
+	mutex  sync.Mutex
+	writer io.Writer
+	closer io.Closer
+}
+
+// NewWriterSink writes the entries to writer, like os.Stdout.
+func NewWriterSink(writer io.Writer) *WriterSink {
+	return &WriterSink{writer: writer}
+}
+
+// NewFileSink appends the entries to filename, creating it if needed.
+func NewFileSink(filename string) (*WriterSink, error) {
+	file, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &WriterSink{writer: file, closer: file}, nil
+}
+
+// Write writes entry as a single line of JSON.
+func (s *WriterSink) Write(entry Entry) error {
+	line, err := json.Marshal(toJSON(entry))
+	if err != nil {
+		return err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	_, err = s.writer.Write(append(line, '\n'))
+	return err
+}
+
+// Close closes the file, if the sink has one.
+func (s *WriterSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+// MongoSink inserts the entries in a MongoDB collection.
+type MongoSink struct {
+	client     *mongo.Client
+	collection *mongo.Collection
+	timeout    time.Duration
+}
+
+// NewMongoSink connects to MongoDB with clientOptions, to insert the entries in collection, in database.
+func NewMongoSink(clientOptions *options.ClientOptions, database, collection string) (*MongoSink, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &MongoSink{
+		client:     client,
+		collection: client.Database(database).Collection(collection),
+		timeout:    10 * time.Second,
+	}, nil
+}
+
+// Write inserts entry as a new document.
+func (s *MongoSink) Write(entry Entry) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	_, err := s.collection.InsertOne(ctx, entry)
+	return err
+}
+
+// Close disconnects from MongoDB.
+func (s *MongoSink) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
+
+// toJSON converts the BSON values of entry, like filters, to relaxed Extended JSON.
+func toJSON(entry Entry) interface{} {
+	type jsonEntry Entry
+	converted := jsonEntry(entry)
+	converted.ID = toExtJSON(entry.ID)
+	converted.Filter = toExtJSON(entry.Filter)
+	converted.Change = toExtJSON(entry.Change)
+	return converted
+}
+
+func toExtJSON(value interface{}) interface{} {
+	if value == nil {
+		return nil
+	}
+	// Extended JSON must be a document, so the value is wrapped and unwrapped.
+	raw, err := bson.MarshalExtJSON(bson.M{"v": value}, false, false)
+	if err != nil {
+		return value
+	}
+	var wrapper struct {
+		V json.RawMessage `json:"v"`
+	}
+	if json.Unmarshal(raw, &wrapper) != nil {
+		return value
+	}
+	return wrapper.V
+}
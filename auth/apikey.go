@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// APIKeyHeader is the header where clients may send their API key. It may also be sent as a bearer token.
+const APIKeyHeader = "X-API-Key"
+
+// APIKey is a key accepted by the proxy. Only the SHA-256 digest of the key is kept.
+type APIKey struct {
+	ID    string   `json:"id"`
+	Hash  string   `json:"hash"`
+	Roles []string `json:"roles,omitempty"`
+
+	digest []byte
+}
+
+// APIKeyStore authenticates requests by their API key.
+type APIKeyStore struct {
+	keys []APIKey
+}
+
+// HashAPIKey returns the hexadecimal SHA-256 digest of key, as expected in the key files.
+func HashAPIKey(key string) string {
+	digest := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(digest[:])
+}
+
+// NewAPIKeyStore creates a store with keys. Every key must have an ID and a valid SHA-256 hash.
+func NewAPIKeyStore(keys []APIKey) (*APIKeyStore, error) {
+	store := &APIKeyStore{}
+	ids := map[string]bool{}
+	for _, key := range keys {
+		if len(strings.TrimSpace(key.ID)) == 0 {
+			return nil, fmt.Errorf("API key without id")
+		}
+		if ids[key.ID] {
+			return nil, fmt.Errorf("duplicated API key id: %s", key.ID)
+		}
+		ids[key.ID] = true
+
+		digest, err := hex.DecodeString(strings.TrimPrefix(strings.ToLower(key.Hash), "sha256:"))
+		if err != nil || len(digest) != sha256.Size {
+			return nil, fmt.Errorf("API key %s: hash must be a hexadecimal SHA-256 digest", key.ID)
+		}
+		key.digest = digest
+		store.keys = append(store.keys, key)
+	}
+	return store, nil
+}
+
+// LoadAPIKeyStore reads the keys from a JSON file, in the format {"keys": [{"id": "...", "hash": "...", "roles": [...]}]},
+// and from a comma separated list of "id:hash" entries (usually an environment variable). Both are optional.
+func LoadAPIKeyStore(filename, list string) (*APIKeyStore, error) {
+	var keys []APIKey
+
+	if len(filename) > 0 {
+		content, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return nil, err
+		}
+		var file struct {
+			Keys []APIKey `json:"keys"`
+		}
+		err = json.Unmarshal(content, &file)
+		if err != nil {
+			return nil, fmt.Errorf("invalid API key file %s: %v", filename, err)
+		}
+		keys = append(keys, file.Keys...)
+	}
+
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("API keys must be defined as id:hash")
+		}
+		keys = append(keys, APIKey{ID: parts[0], Hash: parts[1]})
+	}
+
+	return NewAPIKeyStore(keys)
+}
+
+// Len returns how many keys are in the store.
+func (s *APIKeyStore) Len() int {
+	return len(s.keys)
+}
+
+// Authenticate looks for the API key in the X-API-Key header, or as a bearer token.
+func (s *APIKeyStore) Authenticate(r *http.Request) (*Principal, error) {
+	key := r.Header.Get(APIKeyHeader)
+	if len(key) == 0 {
+		key = getBearerToken(r)
+		// JSON Web Tokens are left to another authenticator.
+		if len(key) == 0 || strings.Count(key, ".") == 2 {
+			return nil, ErrNoCredentials
+		}
+	}
+
+	digest := sha256.Sum256([]byte(key))
+	for _, candidate := range s.keys {
+		if subtle.ConstantTimeCompare(candidate.digest, digest[:]) == 1 {
+			return &Principal{
+				ID:     candidate.ID,
+				Method: "apikey",
+				Roles:  candidate.Roles,
+			}, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// getBearerToken returns the token in the Authorization header, if it uses the Bearer scheme.
+func getBearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) < 7 || !strings.EqualFold(header[:7], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(header[7:])
+}
@@ -0,0 +1,88 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type APIKeyTestCase struct {
+	name          string
+	headers       map[string]string
+	expectedID    string
+	expectedError error
+}
+
+func TestAPIKeyAuthenticate(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key"), Roles: []string{"reader"}},
+		{ID: "admin-ui", Hash: "sha256:" + auth.HashAPIKey("ui-key")},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []APIKeyTestCase{
+		{name: "header", headers: map[string]string{"X-API-Key": "bot-key"}, expectedID: "twitter-bot"},
+		{name: "bearer", headers: map[string]string{"Authorization": "Bearer ui-key"}, expectedID: "admin-ui"},
+		{name: "bearerLowercase", headers: map[string]string{"Authorization": "bearer ui-key"}, expectedID: "admin-ui"},
+		{name: "wrongKey", headers: map[string]string{"X-API-Key": "guess"}, expectedError: auth.ErrInvalidCredentials},
+		{name: "noKey", headers: map[string]string{}, expectedError: auth.ErrNoCredentials},
+		{name: "basic", headers: map[string]string{"Authorization": "Basic dXNlcjpwYXNz"}, expectedError: auth.ErrNoCredentials},
+		{name: "jwt", headers: map[string]string{"Authorization": "Bearer aaa.bbb.ccc"}, expectedError: auth.ErrNoCredentials},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "http://localhost/find/a/b", nil)
+			for key, value := range tc.headers {
+				request.Header.Set(key, value)
+			}
+
+			principal, err := store.Authenticate(request)
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err, "unexpected error")
+				assert.Nil(t, principal, "principal should be nil")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedID, principal.ID, "unexpected principal")
+			assert.Equal(t, "apikey", principal.Method, "unexpected method")
+		})
+	}
+}
+
+func TestLoadAPIKeyStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "apikeys")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "keys.json")
+	content := `{"keys":[{"id":"twitter-bot","hash":"` + auth.HashAPIKey("bot-key") + `","roles":["reader"]}]}`
+	if ioutil.WriteFile(filename, []byte(content), 0600) != nil {
+		t.FailNow()
+	}
+
+	store, err := auth.LoadAPIKeyStore(filename, "website:"+auth.HashAPIKey("site-key"))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, store.Len(), "unexpected number of keys")
+
+	request, _ := http.NewRequest("GET", "http://localhost/", nil)
+	request.Header.Set("X-API-Key", "bot-key")
+	principal, err := store.Authenticate(request)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"reader"}, principal.Roles, "unexpected roles")
+
+	_, err = auth.LoadAPIKeyStore("", "website:not-a-hash")
+	assert.Error(t, err, "invalid hashes must be refused")
+
+	_, err = auth.LoadAPIKeyStore("", "a:"+auth.HashAPIKey("1")+",a:"+auth.HashAPIKey("2"))
+	assert.Error(t, err, "duplicated ids must be refused")
+}
@@ -0,0 +1,67 @@
+// Package auth identifies the clients of the proxy, and decides what they are allowed to do.
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+)
+
+// ErrNoCredentials is returned by an Authenticator when the request has no credentials it understands.
+// Other authenticators may still accept the request.
+var ErrNoCredentials = errors.New("no credentials")
+
+// ErrInvalidCredentials is returned by an Authenticator when the credentials were understood but rejected.
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
+// Principal is the identity of an authenticated client.
+type Principal struct {
+	// ID identifies the client in logs and policies. It is never a secret.
+	ID string `json:"id"`
+	// Method tells how the client was authenticated, e.g. "apikey".
+	Method string `json:"method"`
+	// Roles are the groups the client belongs to.
+	Roles []string `json:"roles,omitempty"`
+	// Scopes are the permissions granted to the client, if the credential carries them.
+	Scopes []string `json:"scopes,omitempty"`
+	// Claims has all claims of the token, when the client was authenticated by a JWT.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// HasRole tells if the principal belongs to role.
+func (p *Principal) HasRole(role string) bool {
+	return contains(p.Roles, role)
+}
+
+// HasScope tells if the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	return contains(p.Scopes, scope)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// principalKey is where NewContext keeps the principal in a context.
+type principalKey struct{}
+
+// NewContext returns a copy of ctx carrying principal.
+func NewContext(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// FromContext returns the principal carried by ctx, or nil if the client is anonymous.
+func FromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
+
+// Authenticator checks the credentials of a request and returns who sent it.
+type Authenticator interface {
+	Authenticate(r *http.Request) (*Principal, error)
+}
@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"net/http"
+)
+
+// CertificateAuthenticator identifies clients by the TLS certificate they presented, once the server verified it
+// against its client CA bundle. The common name of the subject is the principal, and its organizational units
+// are the roles.
+type CertificateAuthenticator struct {
+	// FullSubject uses the whole distinguished name of the subject (like "CN=reporting,OU=analytics,O=Acme")
+	// as the principal, instead of only its common name.
+	FullSubject bool
+}
+
+// NewCertificateAuthenticator creates an authenticator of client certificates.
+func NewCertificateAuthenticator(fullSubject bool) *CertificateAuthenticator {
+	return &CertificateAuthenticator{FullSubject: fullSubject}
+}
+
+// Authenticate returns the subject of the verified client certificate. Certificates that were not verified
+// by the server are ignored, so they can't be used to claim any identity.
+func (a *CertificateAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, ErrNoCredentials
+	}
+
+	subject := r.TLS.VerifiedChains[0][0].Subject
+	id := subject.CommonName
+	if a.FullSubject {
+		id = subject.String()
+	}
+	if len(id) == 0 {
+		return nil, ErrInvalidCredentials
+	}
+
+	return &Principal{
+		ID:     id,
+		Method: "certificate",
+		Roles:  subject.OrganizationalUnit,
+	}, nil
+}
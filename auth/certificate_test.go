@@ -0,0 +1,47 @@
+package auth_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCertificateAuthenticate(t *testing.T) {
+	subject := pkix.Name{CommonName: "reporting", OrganizationalUnit: []string{"analytics", "ops"}, Organization: []string{"Acme"}}
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: subject}}}}
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: subject}}}
+	anonymous := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{}}}}}
+
+	testCases := []struct {
+		name          string
+		fullSubject   bool
+		state         *tls.ConnectionState
+		expected      *auth.Principal
+		expectedError error
+	}{
+		{"plain HTTP", false, nil, nil, auth.ErrNoCredentials},
+		{"unverified certificate", false, unverified, nil, auth.ErrNoCredentials},
+		{"common name", false, verified, &auth.Principal{ID: "reporting", Method: "certificate", Roles: []string{"analytics", "ops"}}, nil},
+		{"full subject", true, verified, &auth.Principal{ID: "CN=reporting,OU=analytics+OU=ops,O=Acme", Method: "certificate", Roles: []string{"analytics", "ops"}}, nil},
+		{"without common name", false, anonymous, nil, auth.ErrInvalidCredentials},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("GET", "https://localhost/find/db/coll", nil)
+			if err != nil {
+				t.FailNow()
+			}
+			request.TLS = tc.state
+
+			principal, err := auth.NewCertificateAuthenticator(tc.fullSubject).Authenticate(request)
+			assert.Equal(t, tc.expectedError, err)
+			assert.Equal(t, tc.expected, principal)
+		})
+	}
+}
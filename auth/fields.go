@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// FieldRule hides fields of the documents in some namespaces from every client, except the ones it lists.
+// Databases and collections are glob patterns (as in path.Match); fields may be dotted paths, like "salary.amount".
+type FieldRule struct {
+	Databases       []string `json:"databases"`
+	Collections     []string `json:"collections,omitempty"`
+	Fields          []string `json:"fields"`
+	AllowRoles      []string `json:"allow_roles,omitempty"`
+	AllowPrincipals []string `json:"allow_principals,omitempty"`
+}
+
+// FieldPolicy decides which fields each client may not read nor write.
+type FieldPolicy struct {
+	Rules []FieldRule `json:"rules"`
+}
+
+// NewFieldPolicy creates a field policy with rules, checking that they are valid.
+func NewFieldPolicy(rules []FieldRule) (*FieldPolicy, error) {
+	for i, rule := range rules {
+		if len(rule.Databases) == 0 {
+			return nil, fmt.Errorf("field rule %d: databases are required", i)
+		}
+		if len(rule.Fields) == 0 {
+			return nil, fmt.Errorf("field rule %d: fields are required", i)
+		}
+		for _, field := range rule.Fields {
+			if len(field) == 0 || strings.HasPrefix(field, "$") || strings.Contains(field, "..") {
+				return nil, fmt.Errorf("field rule %d: invalid field %q", i, field)
+			}
+		}
+		for _, pattern := range append(append([]string{}, rule.Databases...), rule.Collections...) {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("field rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+	return &FieldPolicy{Rules: rules}, nil
+}
+
+// LoadFieldPolicy reads the field policy from a JSON file, in the format {"rules": [...]}.
+func LoadFieldPolicy(filename string) (*FieldPolicy, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy FieldPolicy
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid field policy file %s: %v", filename, err)
+	}
+	return NewFieldPolicy(policy.Rules)
+}
+
+// HiddenFields returns the fields of collection, in database, that principal may not read nor write.
+func (p *FieldPolicy) HiddenFields(principal *Principal, database, collection string) []string {
+	if principal == nil {
+		principal = Anonymous
+	}
+
+	var hidden []string
+	for _, rule := range p.Rules {
+		if !matchesAny(rule.Databases, database) {
+			continue
+		}
+		if len(rule.Collections) > 0 && !matchesAny(rule.Collections, collection) {
+			continue
+		}
+		if rule.allows(principal) {
+			continue
+		}
+		hidden = append(hidden, rule.Fields...)
+	}
+	return hidden
+}
+
+func (r FieldRule) allows(principal *Principal) bool {
+	for _, id := range r.AllowPrincipals {
+		if id == principal.ID {
+			return true
+		}
+	}
+	for _, role := range r.AllowRoles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldPolicyHiddenFields(t *testing.T) {
+	policy, err := auth.NewFieldPolicy([]auth.FieldRule{
+		{Databases: []string{"hr"}, Collections: []string{"employees"}, Fields: []string{"salary.amount"}, AllowRoles: []string{"payroll"}},
+		{Databases: []string{"*"}, Fields: []string{"email"}, AllowPrincipals: []string{"admin-ui"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create field policy: %v", err)
+	}
+
+	bot := &auth.Principal{ID: "twitter-bot"}
+	payroll := &auth.Principal{ID: "payroll-job", Roles: []string{"payroll"}}
+	admin := &auth.Principal{ID: "admin-ui"}
+
+	assert.Equal(t, []string{"salary.amount", "email"}, policy.HiddenFields(bot, "hr", "employees"))
+	assert.Equal(t, []string{"email"}, policy.HiddenFields(payroll, "hr", "employees"))
+	assert.Equal(t, []string{"salary.amount"}, policy.HiddenFields(admin, "hr", "employees"))
+	assert.Equal(t, []string{"email"}, policy.HiddenFields(nil, "okr", "quotes"))
+	assert.Empty(t, policy.HiddenFields(admin, "okr", "quotes"))
+}
+
+func TestNewFieldPolicyErrors(t *testing.T) {
+	testCases := map[string]auth.FieldRule{
+		"noDatabases":    {Fields: []string{"email"}},
+		"noFields":       {Databases: []string{"*"}},
+		"operator":       {Databases: []string{"*"}, Fields: []string{"$where"}},
+		"invalidPattern": {Databases: []string{"[hr"}, Fields: []string{"email"}},
+	}
+
+	for name, rule := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.NewFieldPolicy([]auth.FieldRule{rule})
+			assert.Error(t, err)
+		})
+	}
+}
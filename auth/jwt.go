@@ -0,0 +1,398 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// jwksRefreshInterval is how long keys fetched from a JWKS URL are used before being fetched again.
+	jwksRefreshInterval = time.Hour
+	// jwksMinRefreshInterval limits how often an unknown key ID may trigger a new fetch of the JWKS URL.
+	jwksMinRefreshInterval = time.Minute
+)
+
+// jwtAlgorithms are the only signing algorithms accepted.
+var jwtAlgorithms = []string{"HS256", "RS256", "ES256"}
+
+// JWTConfig defines how JSON Web Tokens are validated. At least one of HMACSecret, JWKSFile or JWKSURL is required.
+type JWTConfig struct {
+	// Issuer, if defined, must be the "iss" claim of every token.
+	Issuer string
+	// Audience, if defined, must be one of the values of the "aud" claim of every token.
+	Audience string
+	// HMACSecret validates HS256 tokens.
+	HMACSecret []byte
+	// JWKSFile is a local JSON Web Key Set, useful for offline tests.
+	JWKSFile string
+	// JWKSURL is where the identity provider publishes its JSON Web Key Set.
+	JWKSURL string
+	// RolesClaim is the claim with the roles of the subject. Defaults to "roles".
+	RolesClaim string
+	// Leeway tolerates small clock differences when checking exp, nbf and iat.
+	Leeway time.Duration
+}
+
+// JWTAuthenticator authenticates requests by the JSON Web Token sent as bearer token.
+type JWTAuthenticator struct {
+	config JWTConfig
+	parser *jwt.Parser
+	client *http.Client
+	now    func() time.Time
+
+	mutex       sync.RWMutex
+	keys        map[string]interface{}
+	lastFetched time.Time
+}
+
+// jsonWebKey is a single key of a JSON Web Key Set (RFC 7517).
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// NewJWTAuthenticator creates an authenticator, loading the keys defined in config.
+func NewJWTAuthenticator(config JWTConfig) (*JWTAuthenticator, error) {
+	if len(config.HMACSecret) == 0 && len(config.JWKSFile) == 0 && len(config.JWKSURL) == 0 {
+		return nil, fmt.Errorf("JWT validation requires a HMAC secret, a JWKS file or a JWKS URL")
+	}
+	if len(config.RolesClaim) == 0 {
+		config.RolesClaim = "roles"
+	}
+
+	a := &JWTAuthenticator{
+		config: config,
+		parser: jwt.NewParser(jwt.WithValidMethods(jwtAlgorithms), jwt.WithoutClaimsValidation()),
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+		keys:   map[string]interface{}{},
+	}
+
+	err := a.loadKeys()
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Authenticate validates the bearer token of the request, and returns its subject as principal.
+// The claims of the token are available in Principal.Claims.
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (*Principal, error) {
+	raw := getBearerToken(r)
+	if strings.Count(raw, ".") != 2 {
+		return nil, ErrNoCredentials
+	}
+
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(raw, claims, a.getKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	err = a.validateClaims(claims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCredentials, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	return &Principal{
+		ID:     subject,
+		Method: "jwt",
+		Roles:  getStrings(claims[a.config.RolesClaim]),
+		Scopes: getScopes(claims),
+		Claims: claims,
+	}, nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims jwt.MapClaims) error {
+	now := a.now()
+	leeway := a.config.Leeway
+
+	subject, _ := claims["sub"].(string)
+	if len(subject) == 0 {
+		return errors.New("token has no subject")
+	}
+	if !claims.VerifyExpiresAt(now.Add(-leeway).Unix(), true) {
+		return errors.New("token is expired or has no expiration")
+	}
+	if !claims.VerifyNotBefore(now.Add(leeway).Unix(), false) {
+		return errors.New("token is not valid yet")
+	}
+	if !claims.VerifyIssuedAt(now.Add(leeway).Unix(), false) {
+		return errors.New("token was issued in the future")
+	}
+	if len(a.config.Issuer) > 0 && !claims.VerifyIssuer(a.config.Issuer, true) {
+		return errors.New("unexpected issuer")
+	}
+	if len(a.config.Audience) > 0 && !claims.VerifyAudience(a.config.Audience, true) {
+		return errors.New("unexpected audience")
+	}
+	return nil
+}
+
+// getKey returns the key that validates token, making sure its type matches the algorithm of the token.
+func (a *JWTAuthenticator) getKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	if len(a.config.JWKSURL) > 0 {
+		a.mutex.RLock()
+		stale := a.now().Sub(a.lastFetched) > jwksRefreshInterval
+		a.mutex.RUnlock()
+		if stale {
+			a.refreshKeys()
+		}
+	}
+
+	key := a.findKey(kid, token.Method.Alg())
+	if key == nil && len(a.config.JWKSURL) > 0 {
+		// The identity provider may have rotated its keys.
+		a.refreshKeys()
+		key = a.findKey(kid, token.Method.Alg())
+	}
+	if key == nil {
+		return nil, fmt.Errorf("no key found for kid %q and alg %s", kid, token.Method.Alg())
+	}
+	return key, nil
+}
+
+func (a *JWTAuthenticator) findKey(kid, alg string) interface{} {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+
+	if len(kid) > 0 {
+		key := a.keys[kid]
+		if matchesAlgorithm(key, alg) {
+			return key
+		}
+		return nil
+	}
+
+	// Tokens without kid are only accepted if there is a single key for the algorithm.
+	var found interface{}
+	for _, key := range a.keys {
+		if matchesAlgorithm(key, alg) {
+			if found != nil {
+				return nil
+			}
+			found = key
+		}
+	}
+	return found
+}
+
+func matchesAlgorithm(key interface{}, alg string) bool {
+	switch key.(type) {
+	case []byte:
+		return alg == "HS256"
+	case *rsa.PublicKey:
+		return alg == "RS256"
+	case *ecdsa.PublicKey:
+		return alg == "ES256"
+	default:
+		return false
+	}
+}
+
+func (a *JWTAuthenticator) loadKeys() error {
+	keys := map[string]interface{}{}
+
+	if len(a.config.HMACSecret) > 0 {
+		keys[""] = a.config.HMACSecret
+	}
+
+	if len(a.config.JWKSFile) > 0 {
+		content, err := ioutil.ReadFile(a.config.JWKSFile)
+		if err != nil {
+			return err
+		}
+		err = parseJWKS(content, keys)
+		if err != nil {
+			return fmt.Errorf("invalid JWKS file %s: %v", a.config.JWKSFile, err)
+		}
+	}
+
+	if len(a.config.JWKSURL) > 0 {
+		err := a.fetchJWKS(keys)
+		if err != nil {
+			return err
+		}
+	}
+
+	a.mutex.Lock()
+	a.keys = keys
+	a.lastFetched = a.now()
+	a.mutex.Unlock()
+	return nil
+}
+
+// refreshKeys fetches the JWKS URL again, unless it was fetched too recently.
+func (a *JWTAuthenticator) refreshKeys() {
+	a.mutex.RLock()
+	recent := a.now().Sub(a.lastFetched) < jwksMinRefreshInterval
+	a.mutex.RUnlock()
+	if recent {
+		return
+	}
+
+	err := a.loadKeys()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("url", a.config.JWKSURL).
+			Msg("failed to refresh JWKS")
+		a.mutex.Lock()
+		a.lastFetched = a.now()
+		a.mutex.Unlock()
+	}
+}
+
+func (a *JWTAuthenticator) fetchJWKS(keys map[string]interface{}) error {
+	response, err := a.client.Get(a.config.JWKSURL)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", response.Status)
+	}
+
+	content, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	return parseJWKS(content, keys)
+}
+
+// parseJWKS adds the signing keys of a JSON Web Key Set to keys, indexed by their key ID.
+func parseJWKS(content []byte, keys map[string]interface{}) error {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	err := json.Unmarshal(content, &set)
+	if err != nil {
+		return err
+	}
+
+	for _, jwk := range set.Keys {
+		if len(jwk.Use) > 0 && jwk.Use != "sig" {
+			continue
+		}
+
+		var key interface{}
+		switch jwk.Kty {
+		case "RSA":
+			key, err = jwk.rsaPublicKey()
+		case "EC":
+			key, err = jwk.ecdsaPublicKey()
+		case "oct":
+			key, err = decodeBase64URL(jwk.K)
+		default:
+			log.Warn().
+				Str("kid", jwk.Kid).
+				Str("kty", jwk.Kty).
+				Msg("ignoring JWK of unsupported type")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("key %q: %v", jwk.Kid, err)
+		}
+		keys[jwk.Kid] = key
+	}
+	return nil
+}
+
+func (k jsonWebKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := decodeBase64URL(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBase64URL(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if len(n) == 0 || len(e) == 0 || len(e) > 4 {
+		return nil, errors.New("invalid RSA key")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(new(big.Int).SetBytes(e).Int64()),
+	}, nil
+}
+
+func (k jsonWebKey) ecdsaPublicKey() (*ecdsa.PublicKey, error) {
+	if k.Crv != "P-256" {
+		return nil, fmt.Errorf("unsupported curve: %s", k.Crv)
+	}
+	x, err := decodeBase64URL(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBase64URL(k.Y)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !key.Curve.IsOnCurve(key.X, key.Y) {
+		return nil, errors.New("point is not on curve P-256")
+	}
+	return key, nil
+}
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// getScopes reads the OAuth scopes, either from "scope" (space separated) or "scp" (list).
+func getScopes(claims jwt.MapClaims) []string {
+	if scope, ok := claims["scope"].(string); ok {
+		return strings.Fields(scope)
+	}
+	return getStrings(claims["scp"])
+}
+
+// getStrings converts a claim with a string or a list of strings.
+func getStrings(claim interface{}) []string {
+	switch value := claim.(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
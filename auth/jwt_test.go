@@ -0,0 +1,216 @@
+package auth_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type JWTTestCase struct {
+	name          string
+	token         func() string
+	expectedID    string
+	expectedError bool
+}
+
+func TestJWTAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.FailNow()
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	hmacSecret := []byte("very-secret")
+
+	jwksFile := writeJWKS(t, map[string]interface{}{
+		"keys": []map[string]string{
+			{"kty": "RSA", "kid": "rsa-1", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+			{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+		},
+	})
+	defer os.RemoveAll(filepath.Dir(jwksFile))
+
+	authenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{
+		Issuer:     "https://id.example.com",
+		Audience:   "mongodb-proxy",
+		HMACSecret: hmacSecret,
+		JWKSFile:   jwksFile,
+	})
+	if err != nil {
+		t.Fatalf("failed to create authenticator: %v", err)
+	}
+
+	validClaims := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   "twitter-bot",
+			"iss":   "https://id.example.com",
+			"aud":   []string{"mongodb-proxy", "other"},
+			"exp":   time.Now().Add(time.Hour).Unix(),
+			"scope": "quotes:read quotes:update",
+			"roles": []string{"bot"},
+		}
+	}
+	sign := func(method jwt.SigningMethod, kid string, key interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(method, claims)
+		if len(kid) > 0 {
+			token.Header["kid"] = kid
+		}
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("failed to sign token: %v", err)
+		}
+		return signed
+	}
+
+	testCases := []JWTTestCase{
+		{
+			name:       "rs256",
+			token:      func() string { return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()) },
+			expectedID: "twitter-bot",
+		},
+		{
+			name:       "es256",
+			token:      func() string { return sign(jwt.SigningMethodES256, "ec-1", ecKey, validClaims()) },
+			expectedID: "twitter-bot",
+		},
+		{
+			name:       "hs256WithoutKid",
+			token:      func() string { return sign(jwt.SigningMethodHS256, "", hmacSecret, validClaims()) },
+			expectedID: "twitter-bot",
+		},
+		{
+			name: "expired",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, claims)
+			},
+			expectedError: true,
+		},
+		{
+			name: "withoutExpiration",
+			token: func() string {
+				claims := validClaims()
+				delete(claims, "exp")
+				return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, claims)
+			},
+			expectedError: true,
+		},
+		{
+			name: "wrongIssuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://evil.example.com"
+				return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, claims)
+			},
+			expectedError: true,
+		},
+		{
+			name: "wrongAudience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "another-service"
+				return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, claims)
+			},
+			expectedError: true,
+		},
+		{
+			name: "unknownKid",
+			token: func() string {
+				return sign(jwt.SigningMethodRS256, "rsa-2", rsaKey, validClaims())
+			},
+			expectedError: true,
+		},
+		{
+			name: "algorithmConfusion",
+			token: func() string {
+				// An ES256 key ID, but signed with the HMAC secret.
+				return sign(jwt.SigningMethodHS256, "ec-1", hmacSecret, validClaims())
+			},
+			expectedError: true,
+		},
+		{
+			name: "unsupportedAlgorithm",
+			token: func() string {
+				return sign(jwt.SigningMethodHS512, "", hmacSecret, validClaims())
+			},
+			expectedError: true,
+		},
+		{
+			name: "tampered",
+			token: func() string {
+				return sign(jwt.SigningMethodRS256, "rsa-1", rsaKey, validClaims()) + "x"
+			},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, _ := http.NewRequest("GET", "http://localhost/find/a/b", nil)
+			request.Header.Set("Authorization", "Bearer "+tc.token())
+
+			principal, err := authenticator.Authenticate(request)
+			if tc.expectedError {
+				assert.ErrorIs(t, err, auth.ErrInvalidCredentials)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedID, principal.ID, "unexpected subject")
+			assert.Equal(t, "jwt", principal.Method, "unexpected method")
+			assert.Equal(t, []string{"quotes:read", "quotes:update"}, principal.Scopes, "unexpected scopes")
+			assert.Equal(t, []string{"bot"}, principal.Roles, "unexpected roles")
+			assert.True(t, principal.HasScope("quotes:read"), "scope should be granted")
+			assert.Equal(t, "https://id.example.com", principal.Claims["iss"], "claims should be exposed")
+		})
+	}
+}
+
+func TestJWTIgnoresOtherCredentials(t *testing.T) {
+	authenticator, err := auth.NewJWTAuthenticator(auth.JWTConfig{HMACSecret: []byte("secret")})
+	if err != nil {
+		t.FailNow()
+	}
+
+	request, _ := http.NewRequest("GET", "http://localhost/", nil)
+	request.Header.Set("Authorization", "Bearer plain-api-key")
+
+	_, err = authenticator.Authenticate(request)
+	assert.Equal(t, auth.ErrNoCredentials, err, "API keys should be left to another authenticator")
+}
+
+func encode(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+func writeJWKS(t *testing.T, jwks interface{}) string {
+	dir, err := ioutil.TempDir("", "jwks")
+	if err != nil {
+		t.FailNow()
+	}
+	content, err := json.Marshal(jwks)
+	if err != nil {
+		t.FailNow()
+	}
+	filename := filepath.Join(dir, "jwks.json")
+	if ioutil.WriteFile(filename, content, 0600) != nil {
+		t.FailNow()
+	}
+	return filename
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+)
+
+// ErrNamespaceNotAllowed is returned when a database or collection may not be accessed through the proxy.
+var ErrNamespaceNotAllowed = errors.New("namespace not allowed")
+
+// DefaultDeniedNamespaces are the internal databases of MongoDB, denied unless NamespaceConfig.Deny says otherwise.
+var DefaultDeniedNamespaces = []string{"admin.*", "local.*", "config.*"}
+
+// NamespaceConfig lists which namespaces may be accessed, and the public names of some of them.
+//
+// Namespaces are written as "database.collection", and both parts may be glob patterns (as in path.Match).
+// A pattern without collection, like "okr", is the same as "okr.*".
+type NamespaceConfig struct {
+	// Allow, if not empty, lists the only namespaces that may be accessed.
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists namespaces that may never be accessed, even if allowed. If nil, DefaultDeniedNamespaces is used.
+	Deny []string `json:"deny,omitempty"`
+	// Aliases maps public names to real ones, either whole databases ("quotes": "okr_prod")
+	// or single collections ("quotes.latest": "okr_prod.quotes_2021"). Real names that have an alias are hidden.
+	Aliases map[string]string `json:"aliases,omitempty"`
+}
+
+// Namespaces resolves the names used by clients into the real database and collection names,
+// refusing the namespaces that are not allowed.
+type Namespaces struct {
+	allow   []namespacePattern
+	deny    []namespacePattern
+	aliases map[string]string
+	hidden  map[string]bool
+}
+
+type namespacePattern struct {
+	database   string
+	collection string
+}
+
+// NewNamespaces creates the namespace rules defined in config, checking that they are valid.
+func NewNamespaces(config NamespaceConfig) (*Namespaces, error) {
+	deny := config.Deny
+	if deny == nil {
+		deny = DefaultDeniedNamespaces
+	}
+
+	n := &Namespaces{
+		aliases: map[string]string{},
+		hidden:  map[string]bool{},
+	}
+
+	var err error
+	n.allow, err = parseNamespacePatterns(config.Allow)
+	if err != nil {
+		return nil, err
+	}
+	n.deny, err = parseNamespacePatterns(deny)
+	if err != nil {
+		return nil, err
+	}
+
+	for public, real := range config.Aliases {
+		if len(public) == 0 || len(real) == 0 {
+			return nil, fmt.Errorf("invalid alias %q: %q", public, real)
+		}
+		if strings.Contains(public, ".") != strings.Contains(real, ".") {
+			return nil, fmt.Errorf("alias %q must map a database to a database, or a collection to a collection", public)
+		}
+		if _, exists := config.Aliases[real]; exists && real != public {
+			return nil, fmt.Errorf("alias %q points to another alias", public)
+		}
+		n.aliases[public] = real
+		if real != public {
+			n.hidden[real] = true
+		}
+	}
+	return n, nil
+}
+
+// LoadNamespaces reads the namespace rules from a JSON file, with the fields of NamespaceConfig.
+func LoadNamespaces(filename string) (*Namespaces, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var config NamespaceConfig
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid namespaces file %s: %v", filename, err)
+	}
+	return NewNamespaces(config)
+}
+
+// Resolve returns the real names of database and collection, or ErrNamespaceNotAllowed.
+// Collection is empty for operations on the whole database.
+func (n *Namespaces) Resolve(database, collection string) (string, string, error) {
+	realDatabase, realCollection := database, collection
+
+	if real, ok := n.aliases[database+"."+collection]; ok && len(collection) > 0 {
+		realDatabase, realCollection = splitNamespace(real)
+	} else {
+		if n.hidden[database] {
+			// Clients must use the public name.
+			return "", "", ErrNamespaceNotAllowed
+		}
+		if real, ok := n.aliases[database]; ok {
+			realDatabase = real
+		}
+		if len(collection) > 0 && n.hidden[realDatabase+"."+realCollection] {
+			return "", "", ErrNamespaceNotAllowed
+		}
+	}
+
+	if len(n.allow) > 0 && !matchesNamespace(n.allow, realDatabase, realCollection) {
+		return "", "", ErrNamespaceNotAllowed
+	}
+	if matchesNamespace(n.deny, realDatabase, realCollection) {
+		return "", "", ErrNamespaceNotAllowed
+	}
+	return realDatabase, realCollection, nil
+}
+
+// PublicDatabases returns the names clients use for the real databases given, leaving out those they can't access.
+// Databases with an alias are listed by their public name.
+func (n *Namespaces) PublicDatabases(databases []string) []string {
+	exists := map[string]bool{}
+	public := map[string]bool{}
+	for _, database := range databases {
+		exists[database] = true
+		if real, ok := n.aliases[database]; (ok && real != database) || n.hidden[database] {
+			// Clients using this name get another database, or none.
+			continue
+		}
+		if n.mayAccess(database) {
+			public[database] = true
+		}
+	}
+
+	for alias, real := range n.aliases {
+		aliasDatabase, aliasCollection := splitNamespace(alias)
+		realDatabase, _ := splitNamespace(real)
+		if !exists[realDatabase] {
+			continue
+		}
+		if len(aliasCollection) == 0 && n.mayAccess(realDatabase) {
+			public[aliasDatabase] = true
+		} else if _, _, err := n.Resolve(aliasDatabase, aliasCollection); len(aliasCollection) > 0 && err == nil {
+			public[aliasDatabase] = true
+		}
+	}
+
+	result := make([]string, 0, len(public))
+	for database := range public {
+		result = append(result, database)
+	}
+	sort.Strings(result)
+	return result
+}
+
+// mayAccess tells if some collection of the real database may be accessed.
+func (n *Namespaces) mayAccess(database string) bool {
+	if matchesNamespace(n.deny, database, "") {
+		return false
+	}
+	if len(n.allow) == 0 {
+		return true
+	}
+	for _, pattern := range n.allow {
+		if matched, _ := path.Match(pattern.database, database); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func parseNamespacePatterns(patterns []string) ([]namespacePattern, error) {
+	result := make([]namespacePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		database, collection := splitNamespace(strings.TrimSpace(pattern))
+		if len(collection) == 0 {
+			collection = "*"
+		}
+		for _, part := range []string{database, collection} {
+			if _, err := path.Match(part, ""); err != nil || len(part) == 0 {
+				return nil, fmt.Errorf("invalid namespace pattern %q", pattern)
+			}
+		}
+		result = append(result, namespacePattern{database: database, collection: collection})
+	}
+	return result, nil
+}
+
+// matchesNamespace tells if any pattern matches the namespace. Operations on a whole database
+// (without collection) only match patterns that cover all of its collections.
+func matchesNamespace(patterns []namespacePattern, database, collection string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern.database, database); !matched {
+			continue
+		}
+		if len(collection) == 0 {
+			if pattern.collection == "*" {
+				return true
+			}
+			continue
+		}
+		if matched, _ := path.Match(pattern.collection, collection); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func splitNamespace(namespace string) (string, string) {
+	parts := strings.SplitN(namespace, ".", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
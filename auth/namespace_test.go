@@ -0,0 +1,106 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type NamespaceTestCase struct {
+	name               string
+	database           string
+	collection         string
+	expectedDatabase   string
+	expectedCollection string
+	expectedError      error
+}
+
+func TestNamespacesResolve(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{
+		Allow: []string{"okr_*", "quotes", "news.public_*"},
+		Aliases: map[string]string{
+			"quotes":        "okr_prod",
+			"quotes.latest": "okr_prod.quotes_2021",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create namespaces: %v", err)
+	}
+
+	testCases := []NamespaceTestCase{
+		{name: "allowed", database: "okr_dev", collection: "quotes", expectedDatabase: "okr_dev", expectedCollection: "quotes"},
+		{name: "allowedCollection", database: "news", collection: "public_feed", expectedDatabase: "news", expectedCollection: "public_feed"},
+		{name: "notAllowedCollection", database: "news", collection: "drafts", expectedError: auth.ErrNamespaceNotAllowed},
+		{name: "notAllowedWholeDatabase", database: "news", expectedError: auth.ErrNamespaceNotAllowed},
+		{name: "notAllowedDatabase", database: "users", collection: "accounts", expectedError: auth.ErrNamespaceNotAllowed},
+		{name: "databaseAlias", database: "quotes", collection: "authors", expectedDatabase: "okr_prod", expectedCollection: "authors"},
+		{name: "collectionAlias", database: "quotes", collection: "latest", expectedDatabase: "okr_prod", expectedCollection: "quotes_2021"},
+		{name: "wholeDatabaseAlias", database: "quotes", expectedDatabase: "okr_prod"},
+		{name: "hiddenDatabase", database: "okr_prod", collection: "authors", expectedError: auth.ErrNamespaceNotAllowed},
+		{name: "hiddenCollection", database: "quotes", collection: "quotes_2021", expectedError: auth.ErrNamespaceNotAllowed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			database, collection, err := namespaces.Resolve(tc.database, tc.collection)
+			if tc.expectedError != nil {
+				assert.Equal(t, tc.expectedError, err, "unexpected error")
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expectedDatabase, database, "unexpected database")
+			assert.Equal(t, tc.expectedCollection, collection, "unexpected collection")
+		})
+	}
+}
+
+func TestNamespacesDefaultDeny(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{})
+	if err != nil {
+		t.FailNow()
+	}
+
+	for _, database := range []string{"admin", "local", "config"} {
+		_, _, err = namespaces.Resolve(database, "system.users")
+		assert.Equal(t, auth.ErrNamespaceNotAllowed, err, "internal databases should be denied")
+	}
+	_, _, err = namespaces.Resolve("okr", "quotes")
+	assert.NoError(t, err)
+}
+
+func TestNamespacesPublicDatabases(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{
+		Allow: []string{"okr_*", "news.public_*"},
+		Aliases: map[string]string{
+			"quotes":        "okr_prod",
+			"archive.daily": "okr_archive.daily_2021",
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create namespaces: %v", err)
+	}
+
+	databases := namespaces.PublicDatabases([]string{"admin", "config", "local", "news", "okr_archive", "okr_dev", "okr_prod", "users"})
+	assert.Equal(t, []string{"archive", "news", "okr_archive", "okr_dev", "quotes"}, databases)
+
+	// Aliases of databases that don't exist are not listed.
+	assert.Equal(t, []string{"okr_dev"}, namespaces.PublicDatabases([]string{"okr_dev"}))
+}
+
+func TestNewNamespacesErrors(t *testing.T) {
+	testCases := map[string]auth.NamespaceConfig{
+		"invalidPattern":   {Allow: []string{"[okr"}},
+		"emptyDatabase":    {Deny: []string{".quotes"}},
+		"mixedAlias":       {Aliases: map[string]string{"quotes": "okr.quotes"}},
+		"aliasToAlias":     {Aliases: map[string]string{"a": "b", "b": "c"}},
+		"emptyAliasTarget": {Aliases: map[string]string{"quotes": ""}},
+	}
+
+	for name, config := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.NewNamespaces(config)
+			assert.Error(t, err)
+		})
+	}
+}
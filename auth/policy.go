@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path"
+)
+
+// Operation is something a client may do with a namespace (database and collection).
+type Operation string
+
+const (
+	// OpFind reads documents (find, search, geospatial queries, explain, file downloads).
+	OpFind Operation = "find"
+	// OpInsert creates documents or files.
+	OpInsert Operation = "insert"
+	// OpUpdate changes existing documents.
+	OpUpdate Operation = "update"
+	// OpDelete removes documents or files.
+	OpDelete Operation = "delete"
+	// OpAggregate runs aggregation pipelines.
+	OpAggregate Operation = "aggregate"
+	// OpAdmin creates, renames and drops collections and databases.
+	OpAdmin Operation = "admin"
+)
+
+// Anonymous is the principal used for requests that were not authenticated.
+var Anonymous = &Principal{ID: "anonymous", Method: "none"}
+
+var operations = map[Operation]bool{
+	OpFind:      true,
+	OpInsert:    true,
+	OpUpdate:    true,
+	OpDelete:    true,
+	OpAggregate: true,
+	OpAdmin:     true,
+}
+
+// Rule grants operations on namespaces to principals or roles.
+// Databases and collections are glob patterns (as in path.Match); "*" in operations grants all of them.
+type Rule struct {
+	Principals  []string    `json:"principals,omitempty"`
+	Roles       []string    `json:"roles,omitempty"`
+	Operations  []Operation `json:"operations"`
+	Databases   []string    `json:"databases"`
+	Collections []string    `json:"collections,omitempty"`
+}
+
+// Policy decides what each principal may do. Anything not granted by a rule is denied.
+type Policy struct {
+	Rules []Rule `json:"rules"`
+}
+
+// NewPolicy creates a policy with rules, checking that they are valid.
+func NewPolicy(rules []Rule) (*Policy, error) {
+	for i, rule := range rules {
+		if len(rule.Principals) == 0 && len(rule.Roles) == 0 {
+			return nil, fmt.Errorf("rule %d: principals or roles are required", i)
+		}
+		if len(rule.Operations) == 0 {
+			return nil, fmt.Errorf("rule %d: operations are required", i)
+		}
+		for _, op := range rule.Operations {
+			if op != "*" && !operations[op] {
+				return nil, fmt.Errorf("rule %d: unknown operation %q", i, op)
+			}
+		}
+		if len(rule.Databases) == 0 {
+			return nil, fmt.Errorf("rule %d: databases are required", i)
+		}
+		for _, pattern := range append(append([]string{}, rule.Databases...), rule.Collections...) {
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+	}
+	return &Policy{Rules: rules}, nil
+}
+
+// LoadPolicy reads the policy from a JSON file, in the format {"rules": [...]}.
+func LoadPolicy(filename string) (*Policy, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	err = json.Unmarshal(content, &policy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy file %s: %v", filename, err)
+	}
+	return NewPolicy(policy.Rules)
+}
+
+// Allows tells if principal may perform op on collection, in database. Collection is empty for
+// operations on the whole database, and then only rules without collections (or with "*") apply.
+func (p *Policy) Allows(principal *Principal, op Operation, database, collection string) bool {
+	if principal == nil {
+		principal = Anonymous
+	}
+
+	for _, rule := range p.Rules {
+		if rule.matchesPrincipal(principal) &&
+			rule.matchesOperation(op) &&
+			matchesAny(rule.Databases, database) &&
+			(len(rule.Collections) == 0 || matchesAny(rule.Collections, collection)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesPrincipal(principal *Principal) bool {
+	for _, id := range r.Principals {
+		if id == principal.ID || (id == "*" && principal != Anonymous) {
+			return true
+		}
+	}
+	for _, role := range r.Roles {
+		if principal.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matchesOperation(op Operation) bool {
+	for _, allowed := range r.Operations {
+		if allowed == op || allowed == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package auth_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+type PolicyTestCase struct {
+	name       string
+	principal  *auth.Principal
+	operation  auth.Operation
+	database   string
+	collection string
+	expected   bool
+}
+
+func TestPolicyAllows(t *testing.T) {
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Roles: []string{"bot"}, Operations: []auth.Operation{auth.OpFind, auth.OpUpdate}, Databases: []string{"okr"}, Collections: []string{"quotes*"}},
+		{Principals: []string{"admin-ui"}, Operations: []auth.Operation{"*"}, Databases: []string{"*"}},
+		{Principals: []string{"*"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"public"}, Collections: []string{"*"}},
+		{Principals: []string{"anonymous"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"open"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create policy: %v", err)
+	}
+
+	bot := &auth.Principal{ID: "twitter-bot", Roles: []string{"bot"}}
+	admin := &auth.Principal{ID: "admin-ui"}
+
+	testCases := []PolicyTestCase{
+		{name: "roleGranted", principal: bot, operation: auth.OpFind, database: "okr", collection: "quotes", expected: true},
+		{name: "roleGlob", principal: bot, operation: auth.OpUpdate, database: "okr", collection: "quotes_2021", expected: true},
+		{name: "roleOperationDenied", principal: bot, operation: auth.OpInsert, database: "okr", collection: "quotes", expected: false},
+		{name: "roleCollectionDenied", principal: bot, operation: auth.OpFind, database: "okr", collection: "users", expected: false},
+		{name: "roleDatabaseDenied", principal: bot, operation: auth.OpFind, database: "other", collection: "quotes", expected: false},
+		{name: "roleWholeDatabaseDenied", principal: bot, operation: auth.OpAdmin, database: "okr", expected: false},
+		{name: "principalAllOperations", principal: admin, operation: auth.OpAdmin, database: "okr", expected: true},
+		{name: "anyAuthenticated", principal: bot, operation: auth.OpFind, database: "public", collection: "news", expected: true},
+		{name: "anyAuthenticatedExcludesAnonymous", principal: nil, operation: auth.OpFind, database: "public", collection: "news", expected: false},
+		{name: "anonymous", principal: nil, operation: auth.OpFind, database: "open", collection: "news", expected: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, policy.Allows(tc.principal, tc.operation, tc.database, tc.collection), "unexpected decision")
+		})
+	}
+}
+
+func TestNewPolicyErrors(t *testing.T) {
+	testCases := map[string]auth.Rule{
+		"noSubject":        {Operations: []auth.Operation{auth.OpFind}, Databases: []string{"*"}},
+		"noOperations":     {Roles: []string{"bot"}, Databases: []string{"*"}},
+		"unknownOperation": {Roles: []string{"bot"}, Operations: []auth.Operation{"drop"}, Databases: []string{"*"}},
+		"noDatabases":      {Roles: []string{"bot"}, Operations: []auth.Operation{auth.OpFind}},
+		"invalidPattern":   {Roles: []string{"bot"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"[okr"}},
+	}
+
+	for name, rule := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := auth.NewPolicy([]auth.Rule{rule})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.FailNow()
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "policy.json")
+	content := `{"rules":[{"roles":["bot"],"operations":["find"],"databases":["okr"],"collections":["quotes"]}]}`
+	if ioutil.WriteFile(filename, []byte(content), 0600) != nil {
+		t.FailNow()
+	}
+
+	policy, err := auth.LoadPolicy(filename)
+	assert.NoError(t, err)
+	assert.True(t, policy.Allows(&auth.Principal{ID: "x", Roles: []string{"bot"}}, auth.OpFind, "okr", "quotes"))
+
+	_, err = auth.LoadPolicy(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,322 @@
+// Package cache keeps the results of reads in memory, so repeated queries don't reach MongoDB, and forgets
+// them when the proxy writes to their collection.
+package cache
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long the results are kept, unless a rule says otherwise.
+	DefaultTTL = time.Minute
+	// DefaultMaxEntries is how many results are kept at most.
+	DefaultMaxEntries = 10000
+	// DefaultMaxBytes is how large the results kept may be in total, approximately.
+	DefaultMaxBytes = 64 << 20
+)
+
+// Rule sets the TTL of the results of the matching databases and collections (glob patterns; empty matches any).
+// A TTL of zero doesn't cache them at all.
+type Rule struct {
+	Databases   []string `json:"databases,omitempty"`
+	Collections []string `json:"collections,omitempty"`
+	TTLSeconds  int      `json:"ttl_seconds"`
+}
+
+// Config defines the size of the cache and the TTL of each namespace. Zero values use the defaults.
+type Config struct {
+	// TTLSeconds applies to the namespaces that match no rule.
+	TTLSeconds int `json:"ttl_seconds,omitempty"`
+	// MaxEntries and MaxBytes limit the size of the cache; the least recently used results are evicted first.
+	MaxEntries int   `json:"max_entries,omitempty"`
+	MaxBytes   int64 `json:"max_bytes,omitempty"`
+	// Rules are checked in order; the first matching one applies.
+	Rules []Rule `json:"rules,omitempty"`
+
+	// Clock replaces time.Now, for tests.
+	Clock func() time.Time `json:"-"`
+}
+
+// Stats counts how the cache was used.
+type Stats struct {
+	Hits          int64 `json:"hits"`
+	Misses        int64 `json:"misses"`
+	Coalesced     int64 `json:"coalesced"`
+	Evictions     int64 `json:"evictions"`
+	Invalidations int64 `json:"invalidations"`
+	Entries       int   `json:"entries"`
+	Bytes         int64 `json:"bytes"`
+}
+
+// Cache is a LRU cache of results, indexed by namespace so all the results of a collection or database can
+// be invalidated at once. It may be shared by several proxies, each in its own scope, like a backend.
+type Cache struct {
+	config Config
+
+	mutex    sync.Mutex
+	order    *list.List
+	entries  map[string]*list.Element
+	byDB     map[string]map[string]*list.Element
+	versions map[string]uint64
+	bytes    int64
+	stats    Stats
+
+	flights flightGroup
+}
+
+type entry struct {
+	key        string
+	database   string
+	collection string
+	value      interface{}
+	size       int64
+	expires    time.Time
+}
+
+// New creates a cache with config, filling its defaults.
+func New(config Config) (*Cache, error) {
+	if config.TTLSeconds < 0 || config.MaxEntries < 0 || config.MaxBytes < 0 {
+		return nil, errors.New("cache TTL and limits must not be negative")
+	}
+	for i, rule := range config.Rules {
+		if rule.TTLSeconds < 0 {
+			return nil, fmt.Errorf("rule %d: TTL must not be negative", i)
+		}
+		for _, pattern := range append(append([]string{}, rule.Databases...), rule.Collections...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("rule %d: invalid pattern %q: %w", i, pattern, err)
+			}
+		}
+	}
+	if config.TTLSeconds == 0 {
+		config.TTLSeconds = int(DefaultTTL / time.Second)
+	}
+	if config.MaxEntries == 0 {
+		config.MaxEntries = DefaultMaxEntries
+	}
+	if config.MaxBytes == 0 {
+		config.MaxBytes = DefaultMaxBytes
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	return &Cache{
+		config:   config,
+		order:    list.New(),
+		entries:  map[string]*list.Element{},
+		byDB:     map[string]map[string]*list.Element{},
+		versions: map[string]uint64{},
+		flights:  flightGroup{calls: map[string]*flight{}},
+	}, nil
+}
+
+// Load reads the config of the cache from a JSON file.
+func Load(filename string) (*Cache, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+	return New(config)
+}
+
+// TTL returns how long the results of collection, in database, are kept. Zero means they are not cached.
+func (c *Cache) TTL(database, collection string) time.Duration {
+	for _, rule := range c.config.Rules {
+		if matches(rule.Databases, database) && matches(rule.Collections, collection) {
+			return time.Duration(rule.TTLSeconds) * time.Second
+		}
+	}
+	return time.Duration(c.config.TTLSeconds) * time.Second
+}
+
+// Get returns the result kept under key, if it hasn't expired.
+func (c *Cache) Get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+	cached := element.Value.(*entry)
+	if !c.config.Clock().Before(cached.expires) {
+		c.remove(element)
+		c.stats.Misses++
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	c.stats.Hits++
+	return cached.value, true
+}
+
+// Version returns the version of the namespace, which changes whenever it is invalidated. Results read before
+// an invalidation are not stored, as they may be stale: pass the version seen before reading them to Set.
+func (c *Cache) Version(scope, database, collection string) uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.versions[databaseKey(scope, database)] + c.versions[namespaceKey(scope, database, collection)]
+}
+
+// Set keeps value under key for ttl, unless the namespace was invalidated since version, or value is larger
+// than the whole cache. The least recently used results are evicted to make room for it.
+func (c *Cache) Set(scope, database, collection string, version uint64, key string, value interface{}, size int64, ttl time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dbKey := databaseKey(scope, database)
+	if ttl <= 0 || size > c.config.MaxBytes ||
+		c.versions[dbKey]+c.versions[namespaceKey(scope, database, collection)] != version {
+		return
+	}
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	element := c.order.PushFront(&entry{
+		key:        key,
+		database:   dbKey,
+		collection: collection,
+		value:      value,
+		size:       size,
+		expires:    c.config.Clock().Add(ttl),
+	})
+	c.entries[key] = element
+	if c.byDB[dbKey] == nil {
+		c.byDB[dbKey] = map[string]*list.Element{}
+	}
+	c.byDB[dbKey][key] = element
+	c.bytes += size
+
+	for c.order.Len() > c.config.MaxEntries || c.bytes > c.config.MaxBytes {
+		c.remove(c.order.Back())
+		c.stats.Evictions++
+	}
+}
+
+// Invalidate forgets the results of collection, in database, or of the whole database if collection is empty.
+func (c *Cache) Invalidate(scope, database, collection string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	dbKey := databaseKey(scope, database)
+	if len(collection) == 0 {
+		c.versions[dbKey]++
+	} else {
+		c.versions[namespaceKey(scope, database, collection)]++
+	}
+	for _, element := range c.byDB[dbKey] {
+		if len(collection) == 0 || element.Value.(*entry).collection == collection {
+			c.remove(element)
+		}
+	}
+	c.stats.Invalidations++
+}
+
+// Stats returns a snapshot of the usage of the cache.
+func (c *Cache) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	stats := c.stats
+	stats.Entries = c.order.Len()
+	stats.Bytes = c.bytes
+	stats.Coalesced = c.flights.coalesced()
+	return stats
+}
+
+// remove deletes element from the cache. The lock must be held.
+func (c *Cache) remove(element *list.Element) {
+	cached := element.Value.(*entry)
+	c.order.Remove(element)
+	delete(c.entries, cached.key)
+	delete(c.byDB[cached.database], cached.key)
+	if len(c.byDB[cached.database]) == 0 {
+		delete(c.byDB, cached.database)
+	}
+	c.bytes -= cached.size
+}
+
+func databaseKey(scope, database string) string {
+	return scope + "\x00" + database
+}
+
+func namespaceKey(scope, database, collection string) string {
+	return scope + "\x00" + database + "\x00" + collection
+}
+
+func matches(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// flightGroup coalesces identical concurrent reads: only the first one runs, and the others wait for its result.
+type flightGroup struct {
+	mutex   sync.Mutex
+	calls   map[string]*flight
+	waiters int64
+}
+
+type flight struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+// do runs fn, unless a call with the same key is already running; then it waits for that call and returns
+// its result, with shared set.
+func (g *flightGroup) do(key string, fn func() (interface{}, error)) (value interface{}, err error, shared bool) {
+	g.mutex.Lock()
+	if running, ok := g.calls[key]; ok {
+		g.waiters++
+		g.mutex.Unlock()
+		<-running.done
+		return running.value, running.err, true
+	}
+	call := &flight{done: make(chan struct{})}
+	g.calls[key] = call
+	g.mutex.Unlock()
+
+	defer func() {
+		if r := recover(); r != nil {
+			call.err = fmt.Errorf("coalesced read failed: %v", r)
+			g.finish(key, call)
+			panic(r)
+		}
+		g.finish(key, call)
+	}()
+	call.value, call.err = fn()
+	return call.value, call.err, false
+}
+
+func (g *flightGroup) finish(key string, call *flight) {
+	g.mutex.Lock()
+	delete(g.calls, key)
+	g.mutex.Unlock()
+	close(call.done)
+}
+
+func (g *flightGroup) coalesced() int64 {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	return g.waiters
+}
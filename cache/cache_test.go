@@ -0,0 +1,257 @@
+package cache_test
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// countingProxy counts the finds that reach it, and may hold them until release is closed.
+type countingProxy struct {
+	mock.DBProxy
+	finds   int32
+	err     error
+	started chan struct{}
+	release chan struct{}
+}
+
+func (p *countingProxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
+	atomic.AddInt32(&p.finds, 1)
+	if p.started != nil {
+		p.started <- struct{}{}
+	}
+	if p.release != nil {
+		<-p.release
+	}
+	if p.err != nil {
+		return nil, p.err
+	}
+	return &db.FindResponse{Results: []bson.M{{"author": "Seneca", "tags": bson.A{"stoic"}}}}, nil
+}
+
+func (p *countingProxy) Insert(database, collection string, entry db.Quote) (*db.InsertResponse, error) {
+	return &db.InsertResponse{InsertedID: "1"}, nil
+}
+
+func (p *countingProxy) DropDatabase(database string) (*db.AdminResponse, error) {
+	return &db.AdminResponse{}, nil
+}
+
+func newCache(t *testing.T, config cache.Config) *cache.Cache {
+	c, err := cache.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestNew(t *testing.T) {
+	_, err := cache.New(cache.Config{TTLSeconds: -1})
+	assert.Error(t, err)
+	_, err = cache.New(cache.Config{Rules: []cache.Rule{{Databases: []string{"["}}}})
+	assert.Error(t, err)
+
+	c := newCache(t, cache.Config{Rules: []cache.Rule{
+		{Databases: []string{"quotes"}, Collections: []string{"daily*"}, TTLSeconds: 0},
+		{Databases: []string{"quotes"}, TTLSeconds: 300},
+	}})
+	assert.Equal(t, time.Duration(0), c.TTL("quotes", "daily_quote"))
+	assert.Equal(t, 5*time.Minute, c.TTL("quotes", "quote"))
+	assert.Equal(t, cache.DefaultTTL, c.TTL("other", "quote"))
+}
+
+func TestProxyCachesReads(t *testing.T) {
+	now := time.Now()
+	inner := &countingProxy{}
+	c := newCache(t, cache.Config{
+		TTLSeconds: 10,
+		Rules:      []cache.Rule{{Collections: []string{"live"}, TTLSeconds: 0}},
+		Clock:      func() time.Time { return now },
+	})
+	proxy := cache.NewProxy(inner, c, "main")
+
+	// The order of the fields of maps doesn't matter.
+	first, err := proxy.Find("quotes", "quote", bson.M{"author": "Seneca", "year": 65})
+	assert.NoError(t, err)
+	second, err := proxy.Find("quotes", "quote", map[string]interface{}{"year": 65, "author": "Seneca"})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(1), inner.finds)
+	assert.Equal(t, first, second)
+
+	// Callers get copies: changing them doesn't change the cache.
+	delete(second.Results[0], "author")
+	second.Results[0]["tags"].(bson.A)[0] = "changed"
+	third, _ := proxy.Find("quotes", "quote", bson.M{"author": "Seneca", "year": 65})
+	assert.Equal(t, "Seneca", third.Results[0]["author"])
+	assert.Equal(t, "stoic", third.Results[0]["tags"].(bson.A)[0])
+
+	// The order of ordered documents, like sorts, does matter.
+	proxy.Find("quotes", "quote", bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}})
+	proxy.Find("quotes", "quote", bson.D{{Key: "b", Value: 1}, {Key: "a", Value: 1}})
+	assert.Equal(t, int32(3), inner.finds)
+
+	// Namespaces with a TTL of zero are not cached.
+	proxy.Find("quotes", "live", bson.M{})
+	proxy.Find("quotes", "live", bson.M{})
+	assert.Equal(t, int32(5), inner.finds)
+
+	// Results expire after the TTL.
+	now = now.Add(10 * time.Second)
+	proxy.Find("quotes", "quote", bson.M{"author": "Seneca", "year": 65})
+	assert.Equal(t, int32(6), inner.finds)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(2), stats.Hits)
+	assert.Equal(t, 3, stats.Entries)
+}
+
+func TestProxyDoesNotCacheErrors(t *testing.T) {
+	inner := &countingProxy{err: errors.New("no primary")}
+	proxy := cache.NewProxy(inner, newCache(t, cache.Config{}), "main")
+
+	_, err := proxy.Find("quotes", "quote", bson.M{})
+	assert.Error(t, err)
+	inner.err = nil
+	_, err = proxy.Find("quotes", "quote", bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), inner.finds)
+}
+
+func TestProxyInvalidates(t *testing.T) {
+	inner := &countingProxy{}
+	c := newCache(t, cache.Config{})
+	proxy := cache.NewProxy(inner, c, "main")
+	other := cache.NewProxy(inner, c, "archive")
+
+	proxy.Find("quotes", "quote", bson.M{})
+	proxy.Find("quotes", "author", bson.M{})
+	proxy.Find("library", "quote", bson.M{})
+	other.Find("quotes", "quote", bson.M{})
+	assert.Equal(t, int32(4), inner.finds)
+
+	// Writing to a collection only invalidates it, in the same scope.
+	_, err := proxy.Insert("quotes", "quote", db.Quote{Author: "Seneca"})
+	assert.NoError(t, err)
+	proxy.Find("quotes", "quote", bson.M{})
+	proxy.Find("quotes", "author", bson.M{})
+	proxy.Find("library", "quote", bson.M{})
+	other.Find("quotes", "quote", bson.M{})
+	assert.Equal(t, int32(5), inner.finds)
+
+	// Dropping a database invalidates all of its collections.
+	_, err = proxy.DropDatabase("quotes")
+	assert.NoError(t, err)
+	proxy.Find("quotes", "quote", bson.M{})
+	proxy.Find("quotes", "author", bson.M{})
+	proxy.Find("library", "quote", bson.M{})
+	assert.Equal(t, int32(7), inner.finds)
+}
+
+func TestProxyIgnoresReadsOlderThanWrites(t *testing.T) {
+	inner := &countingProxy{started: make(chan struct{}), release: make(chan struct{})}
+	proxy := cache.NewProxy(inner, newCache(t, cache.Config{}), "main")
+
+	done := make(chan struct{})
+	go func() {
+		proxy.Find("quotes", "quote", bson.M{})
+		close(done)
+	}()
+	<-inner.started
+	// The insert happens while the find is running: its result may be stale, so it isn't kept.
+	proxy.Insert("quotes", "quote", db.Quote{Author: "Seneca"})
+	close(inner.release)
+	<-done
+
+	inner.started = nil
+	proxy.Find("quotes", "quote", bson.M{})
+	assert.Equal(t, int32(2), inner.finds)
+}
+
+func TestProxyReadsDontJoinFlightsOlderThanWrites(t *testing.T) {
+	inner := &countingProxy{started: make(chan struct{}, 2), release: make(chan struct{})}
+	proxy := cache.NewProxy(inner, newCache(t, cache.Config{}), "main")
+
+	var wg sync.WaitGroup
+	find := func() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			proxy.Find("quotes", "quote", bson.M{})
+		}()
+	}
+	find()
+	<-inner.started
+	proxy.Insert("quotes", "quote", db.Quote{Author: "Seneca"})
+
+	// The second find must see the insert, so it runs on its own.
+	find()
+	select {
+	case <-inner.started:
+	case <-time.After(5 * time.Second):
+		t.Error("the find after the insert joined the find before it")
+	}
+	close(inner.release)
+	wg.Wait()
+	assert.Equal(t, int32(2), inner.finds)
+}
+
+func TestProxyCoalescesReads(t *testing.T) {
+	inner := &countingProxy{started: make(chan struct{}, 1), release: make(chan struct{})}
+	c := newCache(t, cache.Config{})
+	proxy := cache.NewProxy(inner, c, "main")
+
+	const readers = 5
+	var wg sync.WaitGroup
+	wg.Add(readers)
+	for i := 0; i < readers; i++ {
+		go func() {
+			defer wg.Done()
+			response, err := proxy.Find("quotes", "quote", bson.M{"author": "Seneca"})
+			assert.NoError(t, err)
+			assert.Len(t, response.Results, 1)
+		}()
+	}
+	<-inner.started
+	for c.Stats().Coalesced < readers-1 {
+		time.Sleep(time.Millisecond)
+	}
+	close(inner.release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), inner.finds)
+	assert.Equal(t, int64(readers-1), c.Stats().Coalesced)
+}
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	c := newCache(t, cache.Config{MaxEntries: 2, MaxBytes: 100})
+	c.Set("main", "quotes", "quote", 0, "a", "A", 10, time.Minute)
+	c.Set("main", "quotes", "quote", 0, "b", "B", 10, time.Minute)
+	c.Get("a")
+	c.Set("main", "quotes", "quote", 0, "c", "C", 10, time.Minute)
+
+	_, ok := c.Get("b")
+	assert.False(t, ok)
+	_, ok = c.Get("a")
+	assert.True(t, ok)
+
+	// Large results evict others until they fit; larger than the cache, they aren't kept.
+	c.Set("main", "quotes", "quote", 0, "d", "D", 95, time.Minute)
+	_, ok = c.Get("a")
+	assert.False(t, ok)
+	c.Set("main", "quotes", "quote", 0, "e", "E", 101, time.Minute)
+	_, ok = c.Get("e")
+	assert.False(t, ok)
+
+	stats := c.Stats()
+	assert.Equal(t, int64(3), stats.Evictions)
+	assert.Equal(t, 1, stats.Entries)
+	assert.Equal(t, int64(95), stats.Bytes)
+}
@@ -0,0 +1,287 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Proxy answers the finds and aggregations of another Proxy from a Cache, and invalidates the namespaces
+// it writes to. Identical concurrent reads are sent to MongoDB only once.
+type Proxy struct {
+	db.Proxy
+	cache *Cache
+	scope string
+	// variant tells apart the reads with different consistency settings.
+	variant string
+}
+
+// NewProxy wraps proxy, keeping its results in cache. scope identifies the deployment behind proxy, like
+// the name of the backend: proxies to the same deployment must share it, so their writes invalidate each other.
+func NewProxy(proxy db.Proxy, cache *Cache, scope string) *Proxy {
+	return &Proxy{
+		Proxy: proxy,
+		cache: cache,
+		scope: scope,
+	}
+}
+
+// WithConsistency applies consistency to the wrapped proxy. Its reads are cached apart from the others.
+func (p *Proxy) WithConsistency(consistency db.Consistency) (db.Proxy, error) {
+	proxy, err := db.WithConsistency(p.Proxy, consistency)
+	if err != nil {
+		return nil, err
+	}
+	variant := p.variant
+	if !consistency.IsZero() {
+		encoded, err := json.Marshal(consistency)
+		if err != nil {
+			return nil, err
+		}
+		variant = string(encoded)
+	}
+	return &Proxy{Proxy: proxy, cache: p.cache, scope: p.scope, variant: variant}, nil
+}
+
+// Find returns the cached documents, or finds them.
+func (p *Proxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
+	value, err := p.read("find", database, collection, filter, func() (interface{}, error) {
+		return p.Proxy.Find(database, collection, filter)
+	})
+	response, _ := value.(*db.FindResponse)
+	return copyFindResponse(response), err
+}
+
+// Aggregate returns the cached result, or runs the aggregation.
+func (p *Proxy) Aggregate(database, collection string, filter interface{}) (*db.AggregateResponse, error) {
+	value, err := p.read("aggregate", database, collection, filter, func() (interface{}, error) {
+		return p.Proxy.Aggregate(database, collection, filter)
+	})
+	response, _ := value.(*db.AggregateResponse)
+	if response != nil {
+		copied := *response
+		response = &copied
+	}
+	return response, err
+}
+
+// AggregatePipeline returns the cached documents, or runs the pipeline.
+func (p *Proxy) AggregatePipeline(database, collection string, pipeline interface{}) (*db.FindResponse, error) {
+	value, err := p.read("pipeline", database, collection, pipeline, func() (interface{}, error) {
+		return p.Proxy.AggregatePipeline(database, collection, pipeline)
+	})
+	response, _ := value.(*db.FindResponse)
+	return copyFindResponse(response), err
+}
+
+// read returns the cached result of the operation, or runs it once for all the identical concurrent
+// calls and keeps the result, if it succeeded. Callers must copy the result before handing it out.
+func (p *Proxy) read(operation, database, collection string, query interface{}, run func() (interface{}, error)) (interface{}, error) {
+	ttl := p.cache.TTL(database, collection)
+	if ttl <= 0 {
+		return run()
+	}
+	key, err := p.key(operation, database, collection, query)
+	if err != nil {
+		// Queries that can't be encoded are not cached; MongoDB reports what's wrong with them.
+		return run()
+	}
+
+	if value, ok := p.cache.Get(key); ok {
+		return value, nil
+	}
+	// Reads only join the flights that started after the last write to the namespace, or they could get
+	// documents older than the writes their clients already saw.
+	version := p.cache.Version(p.scope, database, collection)
+	flight := key + ":" + strconv.FormatUint(version, 10)
+	value, err, _ := p.cache.flights.do(flight, func() (interface{}, error) {
+		value, err := run()
+		if err == nil {
+			p.cache.Set(p.scope, database, collection, version, key, value, sizeOf(value), ttl)
+		}
+		return value, err
+	})
+	return value, err
+}
+
+// key identifies the read by the scope and consistency of the proxy, the operation, its namespace and the
+// normalized query: the fields of unordered documents are sorted, while ordered ones, like $sort, are kept.
+func (p *Proxy) key(operation, database, collection string, query interface{}) (string, error) {
+	encoded, err := bson.MarshalExtJSON(bson.D{{Key: "q", Value: normalize(query)}}, true, false)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	for _, part := range []string{p.scope, p.variant, operation, database, collection} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(encoded)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Insert inserts entry and invalidates its collection.
+func (p *Proxy) Insert(database, collection string, entry db.Quote) (*db.InsertResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, collection)
+	return p.Proxy.Insert(database, collection, entry)
+}
+
+// Update updates the documents and invalidates their collection.
+func (p *Proxy) Update(database, collection string, filter, entry interface{}) (*db.UpdateResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, collection)
+	return p.Proxy.Update(database, collection, filter, entry)
+}
+
+// CreateCollection creates the collection and invalidates it, in case it held a view or old results.
+func (p *Proxy) CreateCollection(database, collection string, request db.CreateCollectionRequest) (*db.AdminResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, collection)
+	return p.Proxy.CreateCollection(database, collection, request)
+}
+
+// RenameCollection renames the collection and invalidates both names.
+func (p *Proxy) RenameCollection(database, collection string, request db.RenameCollectionRequest) (*db.AdminResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, request.To)
+	defer p.cache.Invalidate(p.scope, database, collection)
+	return p.Proxy.RenameCollection(database, collection, request)
+}
+
+// DropCollection drops the collection and invalidates it.
+func (p *Proxy) DropCollection(database, collection string) (*db.AdminResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, collection)
+	return p.Proxy.DropCollection(database, collection)
+}
+
+// DropDatabase drops the database and invalidates all of its collections.
+func (p *Proxy) DropDatabase(database string) (*db.AdminResponse, error) {
+	defer p.cache.Invalidate(p.scope, database, "")
+	return p.Proxy.DropDatabase(database)
+}
+
+// UploadFile stores the file and invalidates the collections of its bucket.
+func (p *Proxy) UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (*db.FileInfo, error) {
+	defer p.invalidateBucket(database, bucket)
+	return p.Proxy.UploadFile(database, bucket, filename, contentType, metadata, content)
+}
+
+// DeleteFile deletes the file and invalidates the collections of its bucket.
+func (p *Proxy) DeleteFile(database, bucket, id string) (*db.DeleteResponse, error) {
+	defer p.invalidateBucket(database, bucket)
+	return p.Proxy.DeleteFile(database, bucket, id)
+}
+
+func (p *Proxy) invalidateBucket(database, bucket string) {
+	p.cache.Invalidate(p.scope, database, bucket+".files")
+	p.cache.Invalidate(p.scope, database, bucket+".chunks")
+}
+
+// normalize turns the maps in value into documents with sorted fields, so equal queries are encoded alike.
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		return normalizeMap(v)
+	case map[string]interface{}:
+		return normalizeMap(v)
+	case bson.D:
+		normalized := make(bson.D, len(v))
+		for i, element := range v {
+			normalized[i] = bson.E{Key: element.Key, Value: normalize(element.Value)}
+		}
+		return normalized
+	case bson.A:
+		return normalizeSlice(v)
+	case []interface{}:
+		return normalizeSlice(v)
+	default:
+		return value
+	}
+}
+
+func normalizeMap(m map[string]interface{}) bson.D {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	normalized := make(bson.D, len(keys))
+	for i, key := range keys {
+		normalized[i] = bson.E{Key: key, Value: normalize(m[key])}
+	}
+	return normalized
+}
+
+func normalizeSlice(values []interface{}) bson.A {
+	normalized := make(bson.A, len(values))
+	for i, value := range values {
+		normalized[i] = normalize(value)
+	}
+	return normalized
+}
+
+// copyFindResponse copies the documents of response, so callers, like the field policy, may change them
+// without changing the cached ones.
+func copyFindResponse(response *db.FindResponse) *db.FindResponse {
+	if response == nil {
+		return nil
+	}
+	copied := *response
+	if response.Results != nil {
+		copied.Results = make([]bson.M, len(response.Results))
+		for i, document := range response.Results {
+			copied.Results[i] = deepCopy(document).(bson.M)
+		}
+	}
+	return &copied
+}
+
+func deepCopy(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.M:
+		copied := make(bson.M, len(v))
+		for key, element := range v {
+			copied[key] = deepCopy(element)
+		}
+		return copied
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(v))
+		for key, element := range v {
+			copied[key] = deepCopy(element)
+		}
+		return copied
+	case bson.D:
+		copied := make(bson.D, len(v))
+		for i, element := range v {
+			copied[i] = bson.E{Key: element.Key, Value: deepCopy(element.Value)}
+		}
+		return copied
+	case bson.A:
+		copied := make(bson.A, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(v))
+		for i, element := range v {
+			copied[i] = deepCopy(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// sizeOf estimates the memory used by value from the size of its BSON encoding.
+func sizeOf(value interface{}) int64 {
+	encoded, err := bson.Marshal(bson.M{"v": value})
+	if err != nil {
+		return 0
+	}
+	return int64(len(encoded))
+}
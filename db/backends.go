@@ -0,0 +1,184 @@
+package db
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/otaviokr/mongodb-proxy-ms/secrets"
+)
+
+// backendName is the format of the names of backends, as used in paths and headers.
+var backendName = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// BackendConfig defines how to connect to one MongoDB deployment. Like the main connection, it may use a hostname
+// and port or a complete URI. The username, password and URI may be read from files instead, like Docker secrets.
+// Each backend has its own connections, so pool settings, like maxPoolSize, go in its URI.
+type BackendConfig struct {
+	ConnectionOptions
+	Hostname     string `json:"hostname,omitempty"`
+	Port         int    `json:"port,omitempty"`
+	Username     string `json:"username,omitempty"`
+	UsernameFile string `json:"username_file,omitempty"`
+	Password     string `json:"password,omitempty"`
+	PasswordFile string `json:"password_file,omitempty"`
+	URIFile      string `json:"uri_file,omitempty"`
+}
+
+// BackendsConfig names the MongoDB deployments behind the proxy. Default, if defined, is the backend used by
+// the requests that don't choose one.
+type BackendsConfig struct {
+	Default  string                   `json:"default,omitempty"`
+	Backends map[string]BackendConfig `json:"backends"`
+}
+
+// Backends holds a Proxy for each named MongoDB deployment.
+type Backends struct {
+	// Default is the name of the backend used by the requests that don't choose one. If empty,
+	// they use the main connection.
+	Default string
+	proxies map[string]Proxy
+}
+
+// NewBackends creates the backends with proxies, by name.
+func NewBackends(defaultName string, proxies map[string]Proxy) (*Backends, error) {
+	for name := range proxies {
+		if !backendName.MatchString(name) {
+			return nil, fmt.Errorf("invalid backend name: %q", name)
+		}
+	}
+	if _, ok := proxies[defaultName]; len(defaultName) > 0 && !ok {
+		return nil, fmt.Errorf("default backend not found: %q", defaultName)
+	}
+	return &Backends{Default: defaultName, proxies: proxies}, nil
+}
+
+// ConnectBackends creates a MongoDBProxy for each backend in config. The settings shared by all of them,
+// like the encryption of fields, come from opts.
+func ConnectBackends(config BackendsConfig, opts ConnectionOptions) (*Backends, error) {
+	proxies := map[string]Proxy{}
+	for name, backend := range config.Backends {
+		proxy, err := connectBackend(backend, opts)
+		if err != nil {
+			return nil, fmt.Errorf("backend %s: %v", name, err)
+		}
+		proxies[name] = proxy
+	}
+	return NewBackends(config.Default, proxies)
+}
+
+// LoadBackends reads the backends from a JSON file, with the fields of BackendsConfig, and connects to them.
+func LoadBackends(filename string, opts ConnectionOptions) (*Backends, error) {
+	var config BackendsConfig
+	err := readJSONFile(filename, &config)
+	if err != nil {
+		return nil, err
+	}
+	return ConnectBackends(config, opts)
+}
+
+func connectBackend(backend BackendConfig, shared ConnectionOptions) (Proxy, error) {
+	for _, secret := range []struct {
+		value    *string
+		filename string
+	}{
+		{&backend.Username, backend.UsernameFile},
+		{&backend.Password, backend.PasswordFile},
+		{&backend.URI, backend.URIFile},
+	} {
+		if len(secret.filename) == 0 {
+			continue
+		}
+		if len(*secret.value) > 0 {
+			return nil, fmt.Errorf("a value and its file are both defined")
+		}
+		// User names are not secrets: registered, a user called "admin" would hide the admin database in the logs.
+		read := secrets.ReadFile
+		if secret.value == &backend.Username {
+			read = secrets.ReadValueFile
+		}
+		value, err := read(secret.filename)
+		if err != nil {
+			return nil, err
+		}
+		*secret.value = value
+	}
+	secrets.Register(backend.Password, backend.TLSCertificateKeyFilePassword)
+	secrets.RegisterURI(backend.URI)
+
+	if len(backend.URI) == 0 && len(backend.Hostname) == 0 {
+		return nil, fmt.Errorf("hostname or uri is required")
+	}
+	port := backend.Port
+	if port == 0 {
+		port = 27017
+	}
+
+	opts := backend.ConnectionOptions
+	opts.Encryption = shared.Encryption
+	return NewConnectionWithOptions(backend.Hostname, port, backend.Username, backend.Password, opts)
+}
+
+// Get returns the backend called name.
+func (b *Backends) Get(name string) (Proxy, bool) {
+	proxy, ok := b.proxies[name]
+	return proxy, ok
+}
+
+// Wrap returns backends with the same names, whose proxies are the ones returned by wrap.
+func (b *Backends) Wrap(wrap func(name string, proxy Proxy) Proxy) *Backends {
+	proxies := make(map[string]Proxy, len(b.proxies))
+	for name, proxy := range b.proxies {
+		proxies[name] = wrap(name, proxy)
+	}
+	return &Backends{Default: b.Default, proxies: proxies}
+}
+
+// Names returns the names of the backends, sorted.
+func (b *Backends) Names() []string {
+	names := make([]string, 0, len(b.proxies))
+	for name := range b.proxies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HealthCheck checks all backends at the same time, and returns the status of each one.
+func (b *Backends) HealthCheck() *BackendsHealthResponse {
+	response := &BackendsHealthResponse{Backends: map[string]BackendHealth{}}
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for name, proxy := range b.proxies {
+		wg.Add(1)
+		go func(name string, proxy Proxy) {
+			defer wg.Done()
+			health := checkBackend(proxy)
+			mutex.Lock()
+			response.Backends[name] = health
+			mutex.Unlock()
+		}(name, proxy)
+	}
+	wg.Wait()
+	return response
+}
+
+// checkBackend returns the health of proxy. It runs in its own goroutine, where a panic would stop the
+// proxy, so panics are reported as failures too.
+func checkBackend(proxy Proxy) (health BackendHealth) {
+	defer func() {
+		if r := recover(); r != nil {
+			health = BackendHealth{Status: BackendDown, Errors: secrets.Scrub(fmt.Sprint(r))}
+		}
+	}()
+
+	result, err := proxy.HealthCheck()
+	if err != nil {
+		return BackendHealth{Status: BackendDown, Errors: secrets.Scrub(err.Error())}
+	}
+	if result == nil {
+		return BackendHealth{Status: BackendDown, Errors: "no response"}
+	}
+	return BackendHealth{Status: BackendUp, Databases: result.Databases}
+}
@@ -0,0 +1,110 @@
+package db
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/otaviokr/mongodb-proxy-ms/secrets"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ConnectionOptions holds the optional settings of the connection.
+type ConnectionOptions struct {
+	// Encryption, if defined, encrypts the configured fields before they are stored, and decrypts them when read.
+	Encryption *FieldEncryption `json:"-"`
+
+	// URI is a complete connection string, like mongodb+srv://cluster0.example.net/?retryWrites=true or
+	// mongodb://db1:27017,db2:27017/?replicaSet=rs0. If defined, the hostname and port are ignored; the username
+	// and password, if given, replace the credentials of the URI.
+	URI string `json:"uri,omitempty"`
+
+	// AuthMechanism is the authentication mechanism, like SCRAM-SHA-256 or MONGODB-X509.
+	AuthMechanism string `json:"auth_mechanism,omitempty"`
+	// AuthSource is the database where the user is defined (admin, by default).
+	AuthSource string `json:"auth_source,omitempty"`
+	// ReplicaSet is the name of the replica set to connect to.
+	ReplicaSet string `json:"replica_set,omitempty"`
+	// AppName identifies the proxy in the logs and profiler of MongoDB.
+	AppName string `json:"app_name,omitempty"`
+
+	// TLS connects to MongoDB using TLS. It is implied by the other TLS settings.
+	TLS bool `json:"tls,omitempty"`
+	// TLSCAFile holds the PEM encoded CAs that sign the certificates of the MongoDB servers.
+	TLSCAFile string `json:"tls_ca_file,omitempty"`
+	// TLSCertificateKeyFile holds the PEM encoded client certificate and key, as used by MONGODB-X509.
+	TLSCertificateKeyFile string `json:"tls_certificate_key_file,omitempty"`
+	// TLSCertificateKeyFilePassword decrypts the key in TLSCertificateKeyFile, if it is encrypted.
+	TLSCertificateKeyFilePassword string `json:"tls_certificate_key_file_password,omitempty"`
+	// TLSInsecure skips the validation of the server certificates. Only use it for tests.
+	TLSInsecure bool `json:"tls_insecure,omitempty"`
+}
+
+// NewClientOptions returns the options used to connect to MongoDB, with the same arguments as NewConnectionWithOptions.
+// Like the rest of the settings, the values of the URI are only validated when connecting.
+func NewClientOptions(hostname string, port int, username, password string, opts ConnectionOptions) (*options.ClientOptions, error) {
+	uri, err := buildURI(hostname, port, username, password, opts)
+	if err != nil {
+		return nil, err
+	}
+	return options.Client().ApplyURI(uri), nil
+}
+
+// buildURI returns the connection string for the settings, with the structured options added to it.
+func buildURI(hostname string, port int, username, password string, opts ConnectionOptions) (string, error) {
+	uri := opts.URI
+	if len(uri) == 0 {
+		uri = fmt.Sprintf("mongodb://%s%s%s",
+			getUserCredentialForConnectionString(username, password),
+			hostname,
+			validatePortForConnectionString(port))
+	}
+
+	query := url.Values{}
+	set := func(key, value string) {
+		if len(value) > 0 {
+			query.Set(key, value)
+		}
+	}
+	set("authMechanism", opts.AuthMechanism)
+	set("authSource", opts.AuthSource)
+	set("replicaSet", opts.ReplicaSet)
+	set("appName", opts.AppName)
+	set("tlsCAFile", opts.TLSCAFile)
+	set("tlsCertificateKeyFile", opts.TLSCertificateKeyFile)
+	set("tlsCertificateKeyFilePassword", opts.TLSCertificateKeyFilePassword)
+	if opts.TLSInsecure {
+		query.Set("tlsInsecure", "true")
+	}
+	if opts.TLS || len(opts.TLSCAFile) > 0 || len(opts.TLSCertificateKeyFile) > 0 || opts.TLSInsecure {
+		query.Set("tls", "true")
+	}
+
+	replaceCredentials := len(opts.URI) > 0 && len(username) > 0
+	if len(query) == 0 && !replaceCredentials {
+		return uri, nil
+	}
+
+	// url.Parse refuses valid seed lists, like db1:27017,db2.
+	parsed, err := secrets.ParseURI(uri)
+	if err != nil || (parsed.Scheme != "mongodb" && parsed.Scheme != "mongodb+srv") {
+		return "", fmt.Errorf("invalid MongoDB URI: %s", RedactURI(uri))
+	}
+	if replaceCredentials {
+		if len(password) > 0 {
+			parsed.User = url.UserPassword(username, password)
+		} else {
+			// Mechanisms like MONGODB-X509 don't use passwords.
+			parsed.User = url.User(username)
+		}
+	}
+
+	for key, values := range query {
+		parsed.Options[key] = values
+	}
+	return parsed.String(), nil
+}
+
+// RedactURI returns uri with its password, and any secret option, replaced by "xxxxx".
+func RedactURI(uri string) string {
+	return secrets.RedactURI(uri)
+}
@@ -0,0 +1,343 @@
+package db
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readconcern"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/mongo/writeconcern"
+	"go.mongodb.org/mongo-driver/tag"
+)
+
+// ErrConsistencyNotAllowed is returned when a request asks for a read preference, read concern or write concern
+// that the consistency policy doesn't allow in the namespace.
+var ErrConsistencyNotAllowed = errors.New("consistency not allowed")
+
+// MinMaxStalenessSeconds is the smallest max staleness accepted by MongoDB.
+const MinMaxStalenessSeconds = 90
+
+var readConcernLevels = map[string]bool{
+	"local":        true,
+	"available":    true,
+	"majority":     true,
+	"linearizable": true,
+	"snapshot":     true,
+}
+
+// Acknowledgement is the "w" option of a write concern: a number of servers, "majority" or the name of a tag set.
+// In JSON, it may be written as a number or a string.
+type Acknowledgement string
+
+// UnmarshalJSON accepts numbers, like 1, and strings, like "majority".
+func (a *Acknowledgement) UnmarshalJSON(data []byte) error {
+	var number json.Number
+	if err := json.Unmarshal(data, &number); err == nil {
+		*a = Acknowledgement(number.String())
+		return nil
+	}
+	var text string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return fmt.Errorf("w must be a number or a string")
+	}
+	*a = Acknowledgement(text)
+	return nil
+}
+
+// WriteConcern tells how many servers must acknowledge a write, and how long to wait for them.
+type WriteConcern struct {
+	W          Acknowledgement `json:"w,omitempty"`
+	J          *bool           `json:"j,omitempty"`
+	WTimeoutMS int64           `json:"wtimeout_ms,omitempty"`
+}
+
+// Consistency holds the read preference, read concern and write concern of an operation.
+// Empty values keep the settings of the connection (the driver defaults, unless set in the URI).
+type Consistency struct {
+	// ReadPreference is primary, primaryPreferred, secondary, secondaryPreferred or nearest.
+	ReadPreference string `json:"read_preference,omitempty"`
+	// ReadPreferenceTags are the tag sets of the servers to read from, in order of preference.
+	// They require a read preference other than primary.
+	ReadPreferenceTags []map[string]string `json:"read_preference_tags,omitempty"`
+	// MaxStalenessSeconds is how far behind the primary a secondary may be (at least 90 seconds).
+	MaxStalenessSeconds int64 `json:"max_staleness_seconds,omitempty"`
+	// ReadConcern is local, available, majority, linearizable or snapshot.
+	ReadConcern  string        `json:"read_concern,omitempty"`
+	WriteConcern *WriteConcern `json:"write_concern,omitempty"`
+}
+
+// IsZero tells if c keeps all the settings of the connection.
+func (c Consistency) IsZero() bool {
+	return len(c.ReadPreference) == 0 && len(c.ReadPreferenceTags) == 0 && c.MaxStalenessSeconds == 0 &&
+		len(c.ReadConcern) == 0 && c.WriteConcern == nil
+}
+
+// Merge returns c with the values defined in override. The read preference, its tags and max staleness
+// are replaced together; the options of the write concern are replaced one by one.
+func (c Consistency) Merge(override Consistency) Consistency {
+	if len(override.ReadPreference) > 0 || len(override.ReadPreferenceTags) > 0 || override.MaxStalenessSeconds != 0 {
+		c.ReadPreference = override.ReadPreference
+		c.ReadPreferenceTags = override.ReadPreferenceTags
+		c.MaxStalenessSeconds = override.MaxStalenessSeconds
+	}
+	if len(override.ReadConcern) > 0 {
+		c.ReadConcern = override.ReadConcern
+	}
+	if override.WriteConcern != nil {
+		merged := WriteConcern{}
+		if c.WriteConcern != nil {
+			merged = *c.WriteConcern
+		}
+		if len(override.WriteConcern.W) > 0 {
+			merged.W = override.WriteConcern.W
+		}
+		if override.WriteConcern.J != nil {
+			merged.J = override.WriteConcern.J
+		}
+		if override.WriteConcern.WTimeoutMS != 0 {
+			merged.WTimeoutMS = override.WriteConcern.WTimeoutMS
+		}
+		c.WriteConcern = &merged
+	}
+	return c
+}
+
+// Validate checks if MongoDB accepts the combination of settings.
+func (c Consistency) Validate() error {
+	_, err := c.clientOptions()
+	return err
+}
+
+// clientOptions converts c to the options of a MongoDB client.
+func (c Consistency) clientOptions() (*options.ClientOptions, error) {
+	opts := options.Client()
+
+	if len(c.ReadPreference) > 0 {
+		mode, err := readpref.ModeFromString(c.ReadPreference)
+		if err != nil {
+			return nil, err
+		}
+		var readPrefOptions []readpref.Option
+		if len(c.ReadPreferenceTags) > 0 {
+			readPrefOptions = append(readPrefOptions, readpref.WithTagSets(tag.NewTagSetsFromMaps(c.ReadPreferenceTags)...))
+		}
+		if c.MaxStalenessSeconds != 0 {
+			if c.MaxStalenessSeconds < MinMaxStalenessSeconds {
+				return nil, fmt.Errorf("max staleness must be at least %d seconds", MinMaxStalenessSeconds)
+			}
+			readPrefOptions = append(readPrefOptions, readpref.WithMaxStaleness(time.Duration(c.MaxStalenessSeconds)*time.Second))
+		}
+		readPref, err := readpref.New(mode, readPrefOptions...)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetReadPreference(readPref)
+	} else if len(c.ReadPreferenceTags) > 0 || c.MaxStalenessSeconds != 0 {
+		return nil, fmt.Errorf("read preference tags and max staleness require a read preference")
+	}
+
+	if len(c.ReadConcern) > 0 {
+		if !readConcernLevels[c.ReadConcern] {
+			return nil, fmt.Errorf("unknown read concern: %s", c.ReadConcern)
+		}
+		opts.SetReadConcern(&readconcern.ReadConcern{Level: c.ReadConcern})
+	}
+
+	if c.WriteConcern != nil {
+		writeConcern := &writeconcern.WriteConcern{
+			Journal:  c.WriteConcern.J,
+			WTimeout: time.Duration(c.WriteConcern.WTimeoutMS) * time.Millisecond,
+		}
+		if len(c.WriteConcern.W) > 0 {
+			if n, err := strconv.Atoi(string(c.WriteConcern.W)); err == nil {
+				if n < 0 {
+					return nil, fmt.Errorf("w must not be negative")
+				}
+				writeConcern.W = n
+			} else {
+				writeConcern.W = string(c.WriteConcern.W)
+			}
+		}
+		if c.WriteConcern.WTimeoutMS < 0 {
+			return nil, fmt.Errorf("wtimeout must not be negative")
+		}
+		if writeConcern.W == 0 && writeConcern.Journal != nil && *writeConcern.Journal {
+			return nil, fmt.Errorf("unacknowledged writes (w: 0) cannot be journaled")
+		}
+		opts.SetWriteConcern(writeConcern)
+	}
+	return opts, nil
+}
+
+// ConsistencyRule sets the defaults and limits of the consistency in some namespaces.
+// Databases and collections are glob patterns (as in path.Match). Empty limits allow any value.
+type ConsistencyRule struct {
+	Databases   []string    `json:"databases"`
+	Collections []string    `json:"collections,omitempty"`
+	Defaults    Consistency `json:"defaults,omitempty"`
+
+	// ReadPreferences lists the read preferences that may be used.
+	ReadPreferences []string `json:"read_preferences,omitempty"`
+	// ReadConcerns lists the read concerns that may be used.
+	ReadConcerns []string `json:"read_concerns,omitempty"`
+	// WriteConcerns lists the "w" values that may be used, like "1" and "majority".
+	WriteConcerns []string `json:"write_concerns,omitempty"`
+	// MaxWTimeoutMS, if positive, is the longest wtimeout that may be used.
+	MaxWTimeoutMS int64 `json:"max_wtimeout_ms,omitempty"`
+}
+
+// ConsistencyPolicy decides the consistency of the operations in each namespace: the one requested by the client,
+// over the defaults of the first matching rule, over the default of the policy.
+type ConsistencyPolicy struct {
+	Default Consistency       `json:"default,omitempty"`
+	Rules   []ConsistencyRule `json:"rules,omitempty"`
+}
+
+// NewConsistencyPolicy checks that the defaults are valid, and allowed by the limits of their rules.
+func NewConsistencyPolicy(config ConsistencyPolicy) (*ConsistencyPolicy, error) {
+	if err := config.Default.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid default consistency: %v", err)
+	}
+	for i, rule := range config.Rules {
+		if len(rule.Databases) == 0 {
+			return nil, fmt.Errorf("consistency rule %d: databases are required", i)
+		}
+		for _, pattern := range append(append([]string{}, rule.Databases...), rule.Collections...) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("consistency rule %d: invalid pattern %q", i, pattern)
+			}
+		}
+		defaults := config.Default.Merge(rule.Defaults)
+		if err := defaults.Validate(); err != nil {
+			return nil, fmt.Errorf("consistency rule %d: %v", i, err)
+		}
+		if err := rule.check(defaults); err != nil {
+			return nil, fmt.Errorf("consistency rule %d: the defaults are not allowed: %v", i, err)
+		}
+	}
+	return &config, nil
+}
+
+// LoadConsistencyPolicy reads the consistency policy from a JSON file, in the format {"default": {...}, "rules": [...]}.
+func LoadConsistencyPolicy(filename string) (*ConsistencyPolicy, error) {
+	var config ConsistencyPolicy
+	err := readJSONFile(filename, &config)
+	if err != nil {
+		return nil, err
+	}
+	return NewConsistencyPolicy(config)
+}
+
+// Resolve returns the consistency of an operation in collection, in database, as requested by the client.
+// Invalid settings return an error, and settings beyond the limits return ErrConsistencyNotAllowed.
+// A nil policy only validates requested.
+func (p *ConsistencyPolicy) Resolve(database, collection string, requested Consistency) (Consistency, error) {
+	if p == nil {
+		return requested, requested.Validate()
+	}
+
+	consistency := p.Default
+	rule := p.rule(database, collection)
+	if rule != nil {
+		consistency = consistency.Merge(rule.Defaults)
+	}
+	consistency = consistency.Merge(requested)
+
+	if err := consistency.Validate(); err != nil {
+		return Consistency{}, err
+	}
+	if rule != nil {
+		if err := rule.check(consistency); err != nil {
+			return Consistency{}, err
+		}
+	}
+	return consistency, nil
+}
+
+// rule returns the first rule of the namespace, or nil.
+func (p *ConsistencyPolicy) rule(database, collection string) *ConsistencyRule {
+	for i, rule := range p.Rules {
+		if !matchesAny(rule.Databases, database) {
+			continue
+		}
+		if len(rule.Collections) > 0 && !matchesAny(rule.Collections, collection) {
+			continue
+		}
+		return &p.Rules[i]
+	}
+	return nil
+}
+
+// check returns ErrConsistencyNotAllowed if c is beyond the limits of the rule.
+func (r ConsistencyRule) check(c Consistency) error {
+	if len(c.ReadPreference) > 0 && !containsFold(r.ReadPreferences, c.ReadPreference) {
+		return fmt.Errorf("%w: read preference %s", ErrConsistencyNotAllowed, c.ReadPreference)
+	}
+	if len(c.ReadConcern) > 0 && !containsFold(r.ReadConcerns, c.ReadConcern) {
+		return fmt.Errorf("%w: read concern %s", ErrConsistencyNotAllowed, c.ReadConcern)
+	}
+	if c.WriteConcern != nil {
+		if len(c.WriteConcern.W) > 0 && !containsFold(r.WriteConcerns, string(c.WriteConcern.W)) {
+			return fmt.Errorf("%w: write concern w: %s", ErrConsistencyNotAllowed, c.WriteConcern.W)
+		}
+		if r.MaxWTimeoutMS > 0 && c.WriteConcern.WTimeoutMS > r.MaxWTimeoutMS {
+			return fmt.Errorf("%w: wtimeout longer than %d ms", ErrConsistencyNotAllowed, r.MaxWTimeoutMS)
+		}
+	}
+	return nil
+}
+
+// containsFold tells if value is in allowed, ignoring the case. An empty list allows anything.
+func containsFold(allowed []string, value string) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	for _, a := range allowed {
+		if strings.EqualFold(a, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// ConsistentProxy is implemented by the proxies that accept the consistency of each request.
+type ConsistentProxy interface {
+	// WithConsistency returns a Proxy whose operations use consistency.
+	WithConsistency(consistency Consistency) (Proxy, error)
+}
+
+// WithConsistency returns proxy with consistency applied, if proxy is a ConsistentProxy.
+// Other proxies, and zero consistencies, return proxy itself.
+func WithConsistency(proxy Proxy, consistency Consistency) (Proxy, error) {
+	consistent, ok := proxy.(ConsistentProxy)
+	if !ok || consistency.IsZero() {
+		return proxy, nil
+	}
+	return consistent.WithConsistency(consistency)
+}
+
+// WithConsistency returns a copy of m whose operations use consistency, over the settings of the connection.
+func (m *MongoDBProxy) WithConsistency(consistency Consistency) (Proxy, error) {
+	opts, err := consistency.clientOptions()
+	if err != nil {
+		return nil, err
+	}
+	copied := *m
+	copied.clientOptions = options.MergeClientOptions(m.clientOptions, opts)
+	return &copied, nil
+}
@@ -0,0 +1,184 @@
+package db_test
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistencyValidate(t *testing.T) {
+	journal := true
+
+	testCases := []struct {
+		name        string
+		consistency db.Consistency
+		hasError    bool
+	}{
+		{name: "empty", consistency: db.Consistency{}},
+		{
+			name: "nearest with tags and staleness",
+			consistency: db.Consistency{
+				ReadPreference:      "nearest",
+				ReadPreferenceTags:  []map[string]string{{"dc": "east"}, {}},
+				MaxStalenessSeconds: 120,
+			},
+		},
+		{name: "case insensitive mode", consistency: db.Consistency{ReadPreference: "secondaryPreferred"}},
+		{name: "unknown mode", consistency: db.Consistency{ReadPreference: "fastest"}, hasError: true},
+		{name: "primary with tags", consistency: db.Consistency{ReadPreference: "primary", ReadPreferenceTags: []map[string]string{{"dc": "east"}}}, hasError: true},
+		{name: "tags without mode", consistency: db.Consistency{ReadPreferenceTags: []map[string]string{{"dc": "east"}}}, hasError: true},
+		{name: "staleness too short", consistency: db.Consistency{ReadPreference: "secondary", MaxStalenessSeconds: 30}, hasError: true},
+		{name: "snapshot", consistency: db.Consistency{ReadConcern: "snapshot"}},
+		{name: "unknown read concern", consistency: db.Consistency{ReadConcern: "eventual"}, hasError: true},
+		{name: "majority", consistency: db.Consistency{WriteConcern: &db.WriteConcern{W: "majority", J: &journal, WTimeoutMS: 500}}},
+		{name: "numeric w", consistency: db.Consistency{WriteConcern: &db.WriteConcern{W: "2"}}},
+		{name: "negative w", consistency: db.Consistency{WriteConcern: &db.WriteConcern{W: "-1"}}, hasError: true},
+		{name: "journaled unacknowledged", consistency: db.Consistency{WriteConcern: &db.WriteConcern{W: "0", J: &journal}}, hasError: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.consistency.Validate()
+			if tc.hasError {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestConsistencyPolicy(t *testing.T) {
+	var config db.ConsistencyPolicy
+	err := json.Unmarshal([]byte(`{
+		"default": {"read_concern": "local", "write_concern": {"w": 1}},
+		"rules": [
+			{
+				"databases": ["billing"],
+				"defaults": {"read_concern": "majority", "write_concern": {"w": "majority", "wtimeout_ms": 1000}},
+				"read_preferences": ["primary", "primaryPreferred"],
+				"read_concerns": ["majority", "linearizable"],
+				"write_concerns": ["majority"],
+				"max_wtimeout_ms": 5000
+			},
+			{
+				"databases": ["okr_*"],
+				"collections": ["reports"],
+				"defaults": {"read_preference": "secondaryPreferred", "max_staleness_seconds": 120}
+			}
+		]
+	}`), &config)
+	if err != nil {
+		t.FailNow()
+	}
+	policy, err := db.NewConsistencyPolicy(config)
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		name       string
+		database   string
+		collection string
+		requested  db.Consistency
+		expected   db.Consistency
+		notAllowed bool
+	}{
+		{
+			name:     "policy default",
+			database: "okr", collection: "quotes",
+			expected: db.Consistency{ReadConcern: "local", WriteConcern: &db.WriteConcern{W: "1"}},
+		},
+		{
+			name:     "requested over default",
+			database: "okr", collection: "quotes",
+			requested: db.Consistency{ReadPreference: "nearest", WriteConcern: &db.WriteConcern{WTimeoutMS: 200}},
+			expected:  db.Consistency{ReadPreference: "nearest", ReadConcern: "local", WriteConcern: &db.WriteConcern{W: "1", WTimeoutMS: 200}},
+		},
+		{
+			name:     "rule defaults",
+			database: "billing", collection: "invoices",
+			expected: db.Consistency{ReadConcern: "majority", WriteConcern: &db.WriteConcern{W: "majority", WTimeoutMS: 1000}},
+		},
+		{
+			name:     "allowed by the rule",
+			database: "billing", collection: "invoices",
+			requested: db.Consistency{ReadPreference: "primaryPreferred", ReadConcern: "linearizable"},
+			expected:  db.Consistency{ReadPreference: "primaryPreferred", ReadConcern: "linearizable", WriteConcern: &db.WriteConcern{W: "majority", WTimeoutMS: 1000}},
+		},
+		{
+			name:     "read preference not allowed",
+			database: "billing", collection: "invoices",
+			requested:  db.Consistency{ReadPreference: "nearest"},
+			notAllowed: true,
+		},
+		{
+			name:     "unacknowledged writes not allowed",
+			database: "billing", collection: "invoices",
+			requested:  db.Consistency{WriteConcern: &db.WriteConcern{W: "0"}},
+			notAllowed: true,
+		},
+		{
+			name:     "wtimeout too long",
+			database: "billing", collection: "invoices",
+			requested:  db.Consistency{WriteConcern: &db.WriteConcern{WTimeoutMS: 60000}},
+			notAllowed: true,
+		},
+		{
+			name:     "rule with collections",
+			database: "okr_prod", collection: "reports",
+			expected: db.Consistency{ReadPreference: "secondaryPreferred", MaxStalenessSeconds: 120, ReadConcern: "local", WriteConcern: &db.WriteConcern{W: "1"}},
+		},
+		{
+			name:     "mode replaces tags and staleness",
+			database: "okr_prod", collection: "reports",
+			requested: db.Consistency{ReadPreference: "primary"},
+			expected:  db.Consistency{ReadPreference: "primary", ReadConcern: "local", WriteConcern: &db.WriteConcern{W: "1"}},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			actual, err := policy.Resolve(tc.database, tc.collection, tc.requested)
+			if tc.notAllowed {
+				assert.True(t, errors.Is(err, db.ErrConsistencyNotAllowed), "unexpected error: %v", err)
+				return
+			}
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expected, actual)
+			}
+		})
+	}
+
+	var nilPolicy *db.ConsistencyPolicy
+	_, err = nilPolicy.Resolve("okr", "quotes", db.Consistency{ReadConcern: "eventual"})
+	assert.Error(t, err)
+
+	_, err = db.NewConsistencyPolicy(db.ConsistencyPolicy{
+		Rules: []db.ConsistencyRule{{Databases: []string{"billing"}, Defaults: db.Consistency{ReadConcern: "local"}, ReadConcerns: []string{"majority"}}},
+	})
+	assert.Error(t, err, "defaults beyond the limits must be refused")
+}
+
+func TestWithConsistency(t *testing.T) {
+	proxy, err := db.NewConnection("cool_host", 27017, "", "")
+	if err != nil {
+		t.FailNow()
+	}
+
+	same, err := db.WithConsistency(proxy, db.Consistency{})
+	assert.NoError(t, err)
+	assert.Equal(t, proxy, same)
+
+	consistent, err := db.WithConsistency(proxy, db.Consistency{ReadPreference: "nearest", WriteConcern: &db.WriteConcern{W: "majority"}})
+	if assert.NoError(t, err) {
+		assert.NotSame(t, proxy, consistent)
+		assert.Equal(t, proxy.GetURI(), consistent.GetURI())
+	}
+
+	_, err = db.WithConsistency(proxy, db.Consistency{ReadPreference: "fastest"})
+	assert.Error(t, err)
+}
@@ -1,6 +1,12 @@
 package db
 
 import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 )
@@ -27,6 +33,25 @@ type HealthResponse struct {
 	Databases []string `json:"databases"`
 }
 
+const (
+	// BackendUp is the status of the backends that answer.
+	BackendUp = "up"
+	// BackendDown is the status of the backends that fail.
+	BackendDown = "down"
+)
+
+// BackendHealth shows the status of a backend, and its databases when it is up.
+type BackendHealth struct {
+	Status    string   `json:"status"`
+	Databases []string `json:"databases,omitempty"`
+	Errors    string   `json:"errors,omitempty"`
+}
+
+// BackendsHealthResponse shows the status of each backend, by name.
+type BackendsHealthResponse struct {
+	Backends map[string]BackendHealth `json:"backends"`
+}
+
 // HomeResponse is just a dummy JSON to indicate the server is up.
 type HomeResponse struct {
 	Hello string `json:"hello"`
@@ -48,12 +73,288 @@ type UpdateResponse struct {
 	Results *mongo.UpdateResult `json:"results"`
 }
 
+// SearchRequest defines a full-text search over the text index of a collection.
+type SearchRequest struct {
+	Text               string `json:"text" binding:"required"`
+	Language           string `json:"language,omitempty"`
+	CaseSensitive      bool   `json:"case_sensitive,omitempty"`
+	DiacriticSensitive bool   `json:"diacritic_sensitive,omitempty"`
+	Page               int64  `json:"page,omitempty"`
+	PageSize           int64  `json:"page_size,omitempty"`
+}
+
+// SearchResponse returns the documents that match a full-text search, best matches first.
+// Each document has the field "score" with its relevance.
+type SearchResponse struct {
+	Results  []bson.M `json:"results"`
+	Total    int64    `json:"total"`
+	Page     int64    `json:"page"`
+	PageSize int64    `json:"page_size"`
+}
+
+const (
+	// DefaultSearchPageSize is used when a search doesn't define the size of the page.
+	DefaultSearchPageSize = 20
+	// MaxSearchPageSize is the biggest page a search may return.
+	MaxSearchPageSize = 100
+	// MaxSearchPage is the last page a search may return, so the documents skipped stay bounded.
+	MaxSearchPage = 1000
+)
+
+// textSearchLanguages are the languages supported by MongoDB text indexes, by name and ISO 639-1 code.
+var textSearchLanguages = map[string]bool{
+	"none": true,
+
+	"da": true, "danish": true,
+	"nl": true, "dutch": true,
+	"en": true, "english": true,
+	"fi": true, "finnish": true,
+	"fr": true, "french": true,
+	"de": true, "german": true,
+	"hu": true, "hungarian": true,
+	"it": true, "italian": true,
+	"nb": true, "norwegian": true,
+	"pt": true, "portuguese": true,
+	"ro": true, "romanian": true,
+	"ru": true, "russian": true,
+	"es": true, "spanish": true,
+	"sv": true, "swedish": true,
+	"tr": true, "turkish": true,
+}
+
+// Validate checks the search text and language, and fills the pagination defaults.
+func (r *SearchRequest) Validate() error {
+	if len(strings.TrimSpace(r.Text)) == 0 {
+		return fmt.Errorf("search text is empty")
+	}
+
+	r.Language = strings.ToLower(strings.TrimSpace(r.Language))
+	if len(r.Language) > 0 && !textSearchLanguages[r.Language] {
+		return fmt.Errorf("language not supported by text search: %s", r.Language)
+	}
+
+	if r.Page < 1 {
+		r.Page = 1
+	} else if r.Page > MaxSearchPage {
+		return fmt.Errorf("page must not be greater than %d", MaxSearchPage)
+	}
+	if r.PageSize < 1 {
+		r.PageSize = DefaultSearchPageSize
+	} else if r.PageSize > MaxSearchPageSize {
+		return fmt.Errorf("page_size must not be greater than %d", MaxSearchPageSize)
+	}
+	return nil
+}
+
+// FileInfo describes a file stored in GridFS.
+type FileInfo struct {
+	ID          string    `json:"id"`
+	Filename    string    `json:"filename"`
+	Length      int64     `json:"length"`
+	ChunkSize   int32     `json:"chunk_size"`
+	UploadDate  time.Time `json:"upload_date"`
+	ContentType string    `json:"content_type,omitempty"`
+	Metadata    bson.M    `json:"metadata,omitempty"`
+}
+
+// FilesResponse lists the files stored in a GridFS bucket.
+type FilesResponse struct {
+	Files []FileInfo `json:"files"`
+}
+
+// FileDownload gives access to the content of a file stored in GridFS.
+// Close must always be called, to release the connection to the database.
+type FileDownload struct {
+	Info    FileInfo
+	Content io.Reader
+	skip    func(n int64) (int64, error)
+	close   func() error
+}
+
+// NewFileDownload creates a FileDownload that reads content and calls closeFunc when it is closed.
+// If skipFunc is nil, Skip discards the bytes read from content.
+func NewFileDownload(info FileInfo, content io.Reader, skipFunc func(n int64) (int64, error), closeFunc func() error) *FileDownload {
+	return &FileDownload{
+		Info:    info,
+		Content: content,
+		skip:    skipFunc,
+		close:   closeFunc,
+	}
+}
+
+// Skip moves the reading position n bytes forward.
+func (f *FileDownload) Skip(n int64) error {
+	var skipped int64
+	var err error
+	if f.skip != nil {
+		skipped, err = f.skip(n)
+	} else {
+		skipped, err = io.CopyN(ioutil.Discard, f.Content, n)
+	}
+	if err == nil && skipped < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// Close releases the resources used to read the file.
+func (f *FileDownload) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
+
+// DeleteResponse gives the number of deleted documents (or files).
+type DeleteResponse struct {
+	DeletedCount int64 `json:"deleted_count"`
+}
+
+// ExplainRequest describes the operation whose execution plan is requested.
+// Operation may be "find", "aggregate", "count" or "update", and Verbosity may be "queryPlanner" (default),
+// "executionStats" or "allPlansExecution". Explained updates are not applied.
+type ExplainRequest struct {
+	Operation  string      `json:"operation" bson:"operation"`
+	Verbosity  string      `json:"verbosity,omitempty" bson:"verbosity,omitempty"`
+	Filter     interface{} `json:"filter,omitempty" bson:"filter,omitempty"`
+	Sort       interface{} `json:"sort,omitempty" bson:"sort,omitempty"`
+	Projection interface{} `json:"projection,omitempty" bson:"projection,omitempty"`
+	Limit      int64       `json:"limit,omitempty" bson:"limit,omitempty"`
+	Pipeline   interface{} `json:"pipeline,omitempty" bson:"pipeline,omitempty"`
+	Update     interface{} `json:"update,omitempty" bson:"update,omitempty"`
+}
+
+// ExplainResponse has the execution plan of an operation, as returned by MongoDB.
+type ExplainResponse struct {
+	Operation string `json:"operation"`
+	Verbosity string `json:"verbosity"`
+	Plan      bson.D `json:"plan"`
+}
+
+var explainVerbosities = map[string]bool{
+	"queryPlanner":      true,
+	"executionStats":    true,
+	"allPlansExecution": true,
+}
+
+// Validate checks if the operation can be explained, and fills the defaults.
+func (r *ExplainRequest) Validate() error {
+	if len(r.Verbosity) == 0 {
+		r.Verbosity = "queryPlanner"
+	}
+	if !explainVerbosities[r.Verbosity] {
+		return fmt.Errorf("unknown verbosity: %s", r.Verbosity)
+	}
+	if r.Filter == nil {
+		r.Filter = bson.M{}
+	}
+
+	switch r.Operation {
+	case "find", "count":
+	case "aggregate":
+		if r.Pipeline == nil {
+			return fmt.Errorf("aggregate requires a pipeline")
+		}
+	case "update":
+		if r.Update == nil {
+			return fmt.Errorf("update requires the update document")
+		}
+	default:
+		return fmt.Errorf("operation cannot be explained: %q", r.Operation)
+	}
+	return nil
+}
+
+// IndexDetails describes an index defined in a collection.
+type IndexDetails struct {
+	Name   string      `json:"name"`
+	Keys   interface{} `json:"keys"`
+	Unique bool        `json:"unique,omitempty"`
+}
+
+// CollectionDetails has the statistics of a single collection.
+type CollectionDetails struct {
+	Name           string         `json:"name"`
+	Type           string         `json:"type"`
+	Count          int64          `json:"count"`
+	Size           int64          `json:"size"`
+	StorageSize    int64          `json:"storage_size"`
+	TotalIndexSize int64          `json:"total_index_size"`
+	Capped         bool           `json:"capped,omitempty"`
+	Indexes        []IndexDetails `json:"indexes"`
+}
+
+// CollectionsResponse lists the collections available in a database.
+type CollectionsResponse struct {
+	Database    string              `json:"database"`
+	Collections []CollectionDetails `json:"collections"`
+}
+
+// TimeSeriesOptions defines how a time series collection stores its measurements.
+type TimeSeriesOptions struct {
+	TimeField   string `json:"time_field" binding:"required"`
+	MetaField   string `json:"meta_field,omitempty"`
+	Granularity string `json:"granularity,omitempty"`
+}
+
+// CreateCollectionRequest holds the options used when a collection is explicitly created.
+type CreateCollectionRequest struct {
+	Capped           bool               `json:"capped,omitempty"`
+	SizeInBytes      int64              `json:"size_in_bytes,omitempty"`
+	MaxDocuments     int64              `json:"max_documents,omitempty"`
+	Validator        interface{}        `json:"validator,omitempty"`
+	ValidationLevel  string             `json:"validation_level,omitempty"`
+	ValidationAction string             `json:"validation_action,omitempty"`
+	TimeSeries       *TimeSeriesOptions `json:"time_series,omitempty"`
+}
+
+// Validate checks if the combination of options is accepted by MongoDB.
+func (r CreateCollectionRequest) Validate() error {
+	if r.Capped && r.SizeInBytes <= 0 {
+		return fmt.Errorf("capped collections require a positive size_in_bytes")
+	}
+	if r.TimeSeries != nil {
+		if r.Capped {
+			return fmt.Errorf("time series collections cannot be capped")
+		}
+		if len(r.TimeSeries.TimeField) == 0 {
+			return fmt.Errorf("time series collections require a time_field")
+		}
+	}
+	return nil
+}
+
+// RenameCollectionRequest defines the new name of a collection.
+type RenameCollectionRequest struct {
+	To         string `json:"to" binding:"required"`
+	DropTarget bool   `json:"drop_target,omitempty"`
+}
+
+// AdminResponse confirms an administrative operation.
+type AdminResponse struct {
+	Database   string `json:"database"`
+	Collection string `json:"collection,omitempty"`
+	Operation  string `json:"operation"`
+}
+
 // Proxy is the abstraction of what you can do with the database.
 type Proxy interface {
 	GetURI() string
 	HealthCheck() (*HealthResponse, error)
 	Aggregate(database, collection string, filter interface{}) (*AggregateResponse, error)
+	AggregatePipeline(database, collection string, pipeline interface{}) (*FindResponse, error)
 	Insert(database, collection string, entry Quote) (*InsertResponse, error)
 	Find(database, collection string, filter interface{}) (*FindResponse, error)
 	Update(database, collection string, filter, entry interface{}) (*UpdateResponse, error)
+	ListCollections(database string) (*CollectionsResponse, error)
+	CreateCollection(database, collection string, request CreateCollectionRequest) (*AdminResponse, error)
+	RenameCollection(database, collection string, request RenameCollectionRequest) (*AdminResponse, error)
+	DropCollection(database, collection string) (*AdminResponse, error)
+	DropDatabase(database string) (*AdminResponse, error)
+	Search(database, collection string, request SearchRequest) (*SearchResponse, error)
+	Explain(database, collection string, request ExplainRequest) (*ExplainResponse, error)
+	UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (*FileInfo, error)
+	DownloadFile(database, bucket, id string) (*FileDownload, error)
+	ListFiles(database, bucket string) (*FilesResponse, error)
+	DeleteFile(database, bucket, id string) (*DeleteResponse, error)
 }
@@ -0,0 +1,513 @@
+package db
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ErrEncryptedField is returned when a filter or an update uses an encrypted field in a way that can't work,
+// like a range query or an increment.
+var ErrEncryptedField = errors.New("field is encrypted")
+
+const (
+	// encryptedSubtype is the BSON binary subtype of encrypted values (a user defined subtype).
+	encryptedSubtype = 0x80
+	// encryptionFormat is the version of the layout of encrypted values.
+	encryptionFormat = 1
+
+	modeRandom        = 0
+	modeDeterministic = 1
+)
+
+// EncryptionKey is a 256-bit key, base64 encoded, identified by ID.
+type EncryptionKey struct {
+	ID  string `json:"id"`
+	Key string `json:"key"`
+}
+
+// Keyring holds all keys that may have encrypted stored values. New values are encrypted with the Active key.
+type Keyring struct {
+	Active string          `json:"active"`
+	Keys   []EncryptionKey `json:"keys"`
+}
+
+// EncryptedField is a field, possibly a dotted path, whose values are encrypted in a collection.
+// Deterministic fields always have the same encrypted value for the same plain value, so they may be used
+// in equality filters; the others can't be queried at all.
+type EncryptedField struct {
+	Database      string `json:"database"`
+	Collection    string `json:"collection"`
+	Path          string `json:"path"`
+	Deterministic bool   `json:"deterministic,omitempty"`
+}
+
+// FieldEncryption encrypts and decrypts the configured fields, with AES-256-GCM.
+type FieldEncryption struct {
+	keys   map[string]*fieldKey
+	active *fieldKey
+	fields map[string][]EncryptedField
+}
+
+type fieldKey struct {
+	id     string
+	aead   cipher.AEAD
+	ivHash []byte
+}
+
+// NewFieldEncryption creates the encryption of fields, with the keys in keyring.
+func NewFieldEncryption(keyring Keyring, fields []EncryptedField) (*FieldEncryption, error) {
+	e := &FieldEncryption{
+		keys:   map[string]*fieldKey{},
+		fields: map[string][]EncryptedField{},
+	}
+
+	for _, key := range keyring.Keys {
+		if len(key.ID) == 0 || len(key.ID) > 255 {
+			return nil, fmt.Errorf("invalid key ID: %q", key.ID)
+		}
+		if _, exists := e.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key ID: %s", key.ID)
+		}
+		material, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil || len(material) != 32 {
+			return nil, fmt.Errorf("key %s must be 32 bytes, base64 encoded", key.ID)
+		}
+		e.keys[key.ID], err = newFieldKey(key.ID, material)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.active = e.keys[keyring.Active]
+	if e.active == nil {
+		return nil, fmt.Errorf("active key not found: %q", keyring.Active)
+	}
+
+	for _, field := range fields {
+		if len(field.Database) == 0 || len(field.Collection) == 0 || len(field.Path) == 0 || strings.HasPrefix(field.Path, "$") {
+			return nil, fmt.Errorf("invalid encrypted field: %+v", field)
+		}
+		namespace := field.Database + "." + field.Collection
+		e.fields[namespace] = append(e.fields[namespace], field)
+	}
+	return e, nil
+}
+
+// LoadFieldEncryption reads the keyring and the encrypted fields from JSON files.
+// The fields file has the format {"fields": [...]}.
+func LoadFieldEncryption(keysFile, fieldsFile string) (*FieldEncryption, error) {
+	var keyring Keyring
+	err := readJSONFile(keysFile, &keyring)
+	if err != nil {
+		return nil, err
+	}
+
+	var fields struct {
+		Fields []EncryptedField `json:"fields"`
+	}
+	err = readJSONFile(fieldsFile, &fields)
+	if err != nil {
+		return nil, err
+	}
+	err = checkStoredFields(fields.Fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewFieldEncryption(keyring, fields.Fields)
+}
+
+// checkStoredFields refuses the encrypted fields that the webserver never writes: it only inserts and
+// updates Quotes, so the paths must be the names their fields are stored with, like "originalquote".
+func checkStoredFields(fields []EncryptedField) error {
+	stored := storedFieldNames(Quote{})
+	for _, field := range fields {
+		if !stored[field.Path] {
+			names := make([]string, 0, len(stored))
+			for name := range stored {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("encrypted field %s is never written; the stored fields are %s",
+				field.Path, strings.Join(names, ", "))
+		}
+	}
+	return nil
+}
+
+// storedFieldNames returns the names of the fields of the struct value in BSON: the name in their bson tag,
+// or their lowercased Go name.
+func storedFieldNames(value interface{}) map[string]bool {
+	names := map[string]bool{}
+	structType := reflect.TypeOf(value)
+	for i := 0; i < structType.NumField(); i++ {
+		field := structType.Field(i)
+		name := strings.Split(field.Tag.Get("bson"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if len(name) == 0 {
+			name = strings.ToLower(field.Name)
+		}
+		names[name] = true
+	}
+	return names
+}
+
+func readJSONFile(filename string, value interface{}) error {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	err = json.Unmarshal(content, value)
+	if err != nil {
+		return fmt.Errorf("invalid file %s: %v", filename, err)
+	}
+	return nil
+}
+
+// newFieldKey derives the encryption key and the key of deterministic IVs from material.
+func newFieldKey(id string, material []byte) (*fieldKey, error) {
+	block, err := aes.NewCipher(derive(material, "mongodb-proxy-ms encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &fieldKey{
+		id:     id,
+		aead:   aead,
+		ivHash: derive(material, "mongodb-proxy-ms deterministic iv"),
+	}, nil
+}
+
+func derive(material []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, material)
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Fields returns the encrypted fields of collection, in database.
+func (e *FieldEncryption) Fields(database, collection string) []EncryptedField {
+	if e == nil {
+		return nil
+	}
+	return e.fields[database+"."+collection]
+}
+
+// Encrypt encrypts value with the active key.
+func (e *FieldEncryption) Encrypt(value interface{}, deterministic bool) (primitive.Binary, error) {
+	return e.encrypt(e.active, value, deterministic)
+}
+
+func (e *FieldEncryption) encrypt(key *fieldKey, value interface{}, deterministic bool) (primitive.Binary, error) {
+	// Wrapping the value in a document keeps its BSON type.
+	plaintext, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return primitive.Binary{}, err
+	}
+
+	mode := byte(modeRandom)
+	nonce := make([]byte, key.aead.NonceSize())
+	if deterministic {
+		mode = modeDeterministic
+		mac := hmac.New(sha256.New, key.ivHash)
+		mac.Write(plaintext)
+		copy(nonce, mac.Sum(nil))
+	} else if _, err = rand.Read(nonce); err != nil {
+		return primitive.Binary{}, err
+	}
+
+	header := append([]byte{encryptionFormat, mode, byte(len(key.id))}, key.id...)
+	data := append(append(header, nonce...), key.aead.Seal(nil, nonce, plaintext, header)...)
+	return primitive.Binary{Subtype: encryptedSubtype, Data: data}, nil
+}
+
+// Decrypt returns the plain value of an encrypted value, with the key it was encrypted with.
+func (e *FieldEncryption) Decrypt(encrypted primitive.Binary) (interface{}, error) {
+	data := encrypted.Data
+	if encrypted.Subtype != encryptedSubtype || len(data) < 3 || data[0] != encryptionFormat {
+		return nil, errors.New("not an encrypted value")
+	}
+
+	headerSize := 3 + int(data[2])
+	if len(data) < headerSize {
+		return nil, errors.New("truncated encrypted value")
+	}
+	key := e.keys[string(data[3:headerSize])]
+	if key == nil {
+		return nil, fmt.Errorf("unknown encryption key: %q", string(data[3:headerSize]))
+	}
+
+	nonceSize := key.aead.NonceSize()
+	if len(data) < headerSize+nonceSize {
+		return nil, errors.New("truncated encrypted value")
+	}
+	nonce := data[headerSize : headerSize+nonceSize]
+	plaintext, err := key.aead.Open(nil, nonce, data[headerSize+nonceSize:], data[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+
+	var wrapper bson.M
+	err = bson.Unmarshal(plaintext, &wrapper)
+	if err != nil {
+		return nil, err
+	}
+	return wrapper["v"], nil
+}
+
+// equalityValues returns the encrypted value of a deterministic field with every key, so documents written
+// before a key rotation are still found.
+func (e *FieldEncryption) equalityValues(value interface{}) (bson.A, error) {
+	values := bson.A{}
+	for _, key := range e.keys {
+		encrypted, err := e.encrypt(key, value, true)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encrypted)
+	}
+	return values, nil
+}
+
+// EncryptDocument encrypts the configured fields of a document to be inserted in collection, in database.
+func (e *FieldEncryption) EncryptDocument(database, collection string, document bson.M) error {
+	for _, field := range e.Fields(database, collection) {
+		err := e.encryptPath(document, strings.Split(field.Path, "."), field.Deterministic)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e *FieldEncryption) encryptPath(value interface{}, path []string, deterministic bool) error {
+	switch v := value.(type) {
+	case bson.M:
+		current, ok := v[path[0]]
+		if !ok || current == nil {
+			return nil
+		}
+		if len(path) > 1 {
+			return e.encryptPath(current, path[1:], deterministic)
+		}
+		if isEncrypted(current) {
+			return nil
+		}
+		encrypted, err := e.Encrypt(current, deterministic)
+		if err != nil {
+			return err
+		}
+		v[path[0]] = encrypted
+	case bson.A:
+		for _, item := range v {
+			err := e.encryptPath(item, path, deterministic)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// EncryptUpdate encrypts the values that an update document writes to encrypted fields.
+// Operators other than $set and $setOnInsert can't change encrypted fields.
+func (e *FieldEncryption) EncryptUpdate(database, collection string, update bson.M) error {
+	fields := e.Fields(database, collection)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	for operator, value := range update {
+		if !strings.HasPrefix(operator, "$") {
+			// A replacement document.
+			return e.EncryptDocument(database, collection, update)
+		}
+		changes, ok := value.(bson.M)
+		if !ok {
+			continue
+		}
+
+		for key, change := range changes {
+			for _, field := range fields {
+				switch {
+				case key == field.Path && (operator == "$set" || operator == "$setOnInsert"):
+					if change == nil || isEncrypted(change) {
+						continue
+					}
+					encrypted, err := e.Encrypt(change, field.Deterministic)
+					if err != nil {
+						return err
+					}
+					changes[key] = encrypted
+				case strings.HasPrefix(field.Path, key+".") && (operator == "$set" || operator == "$setOnInsert"):
+					err := e.encryptPath(change, strings.Split(strings.TrimPrefix(field.Path, key+"."), "."), field.Deterministic)
+					if err != nil {
+						return err
+					}
+				case key == field.Path && operator == "$unset":
+				case key == field.Path || strings.HasPrefix(key, field.Path+".") || strings.HasPrefix(field.Path, key+"."):
+					return fmt.Errorf("%w: %s can't be changed with %s", ErrEncryptedField, field.Path, operator)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// EncryptFilter replaces the values compared to deterministic fields by their encrypted values, in a filter
+// of collection. Encrypted fields may only be compared for equality ($eq, $ne, $in and $nin).
+func (e *FieldEncryption) EncryptFilter(database, collection string, filter bson.M) error {
+	fields := e.Fields(database, collection)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	for key, value := range filter {
+		switch key {
+		case "$and", "$or", "$nor":
+			clauses, _ := value.(bson.A)
+			for _, clause := range clauses {
+				if document, ok := clause.(bson.M); ok {
+					err := e.EncryptFilter(database, collection, document)
+					if err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+
+		for _, field := range fields {
+			if key != field.Path {
+				if strings.HasPrefix(key, field.Path+".") || strings.HasPrefix(field.Path, key+".") {
+					return fmt.Errorf("%w: %s can only be compared as a whole", ErrEncryptedField, field.Path)
+				}
+				continue
+			}
+			if !field.Deterministic {
+				return fmt.Errorf("%w: %s can't be queried", ErrEncryptedField, field.Path)
+			}
+			encrypted, err := e.encryptComparison(field.Path, value)
+			if err != nil {
+				return err
+			}
+			filter[key] = encrypted
+		}
+	}
+	return nil
+}
+
+func (e *FieldEncryption) encryptComparison(path string, value interface{}) (interface{}, error) {
+	comparison, ok := value.(bson.M)
+	if !ok || !isOperatorDocument(comparison) {
+		values, err := e.equalityValues(value)
+		return bson.M{"$in": values}, err
+	}
+
+	result := bson.M{}
+	for operator, operand := range comparison {
+		var values bson.A
+		switch operator {
+		case "$eq", "$ne":
+			encrypted, err := e.equalityValues(operand)
+			if err != nil {
+				return nil, err
+			}
+			values = encrypted
+		case "$in", "$nin":
+			list, _ := operand.(bson.A)
+			for _, item := range list {
+				encrypted, err := e.equalityValues(item)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, encrypted...)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %s can't be compared with %s", ErrEncryptedField, path, operator)
+		}
+
+		if operator == "$eq" || operator == "$in" {
+			result["$in"] = append(asList(result["$in"]), values...)
+		} else {
+			result["$nin"] = append(asList(result["$nin"]), values...)
+		}
+	}
+	return result, nil
+}
+
+// DecryptDocuments replaces every encrypted value in documents by its plain value.
+// Values that can't be decrypted, like the ones with unknown keys, are kept encrypted.
+func (e *FieldEncryption) DecryptDocuments(documents []bson.M) {
+	if e == nil {
+		return
+	}
+	for _, document := range documents {
+		e.decryptValue(document)
+	}
+}
+
+func (e *FieldEncryption) decryptValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.Binary:
+		if v.Subtype != encryptedSubtype {
+			return v
+		}
+		plain, err := e.Decrypt(v)
+		if err != nil {
+			return v
+		}
+		return plain
+	case bson.M:
+		for key, item := range v {
+			v[key] = e.decryptValue(item)
+		}
+	case bson.D:
+		for i := range v {
+			v[i].Value = e.decryptValue(v[i].Value)
+		}
+	case bson.A:
+		for i := range v {
+			v[i] = e.decryptValue(v[i])
+		}
+	}
+	return value
+}
+
+func isEncrypted(value interface{}) bool {
+	binary, ok := value.(primitive.Binary)
+	return ok && binary.Subtype == encryptedSubtype && bytes.HasPrefix(binary.Data, []byte{encryptionFormat})
+}
+
+func isOperatorDocument(document bson.M) bool {
+	for key := range document {
+		if !strings.HasPrefix(key, "$") {
+			return false
+		}
+	}
+	return len(document) > 0
+}
+
+func asList(value interface{}) bson.A {
+	list, _ := value.(bson.A)
+	return list
+}
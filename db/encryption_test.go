@@ -0,0 +1,188 @@
+package db_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var encryptedFields = []db.EncryptedField{
+	{Database: "hr", Collection: "employees", Path: "ssn", Deterministic: true},
+	{Database: "hr", Collection: "employees", Path: "salary.amount"},
+}
+
+func testKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), 32)))
+}
+
+func newEncryption(t *testing.T, active string) *db.FieldEncryption {
+	encryption, err := db.NewFieldEncryption(db.Keyring{
+		Active: active,
+		Keys:   []db.EncryptionKey{{ID: "2023", Key: testKey('a')}, {ID: "2024", Key: testKey('b')}},
+	}, encryptedFields)
+	if err != nil {
+		t.Fatalf("failed to create encryption: %v", err)
+	}
+	return encryption
+}
+
+func TestFieldEncryptionRoundTrip(t *testing.T) {
+	encryption := newEncryption(t, "2024")
+
+	document := bson.M{"name": "Ana", "ssn": "123-45", "salary": bson.M{"amount": int32(100), "currency": "EUR"}}
+	err := encryption.EncryptDocument("hr", "employees", document)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "Ana", document["name"], "other fields should not be encrypted")
+	assert.IsType(t, primitive.Binary{}, document["ssn"])
+	assert.IsType(t, primitive.Binary{}, document["salary"].(bson.M)["amount"])
+	assert.Equal(t, "EUR", document["salary"].(bson.M)["currency"])
+
+	encryption.DecryptDocuments([]bson.M{document})
+	assert.Equal(t, bson.M{"name": "Ana", "ssn": "123-45", "salary": bson.M{"amount": int32(100), "currency": "EUR"}}, document)
+}
+
+func TestFieldEncryptionModes(t *testing.T) {
+	encryption := newEncryption(t, "2024")
+
+	first, _ := encryption.Encrypt("123-45", true)
+	second, _ := encryption.Encrypt("123-45", true)
+	assert.Equal(t, first, second, "deterministic encryption should be repeatable")
+
+	first, _ = encryption.Encrypt("123-45", false)
+	second, _ = encryption.Encrypt("123-45", false)
+	assert.NotEqual(t, first, second, "random encryption should not be repeatable")
+
+	tampered := primitive.Binary{Subtype: first.Subtype, Data: append([]byte{}, first.Data...)}
+	tampered.Data[len(tampered.Data)-1] ^= 1
+	_, err := encryption.Decrypt(tampered)
+	assert.Error(t, err, "tampered values should not be decrypted")
+}
+
+func TestFieldEncryptionRotation(t *testing.T) {
+	old := newEncryption(t, "2023")
+	encrypted, err := old.Encrypt("123-45", true)
+	assert.NoError(t, err)
+
+	rotated := newEncryption(t, "2024")
+	plain, err := rotated.Decrypt(encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "123-45", plain, "values of old keys should still be decrypted")
+
+	filter := bson.M{"ssn": "123-45"}
+	err = rotated.EncryptFilter("hr", "employees", filter)
+	assert.NoError(t, err)
+	assert.Contains(t, filter["ssn"].(bson.M)["$in"], encrypted, "equality should find values of old keys")
+
+	newer, _ := rotated.Encrypt("123-45", true)
+	assert.NotEqual(t, encrypted, newer, "new values should use the active key")
+	assert.Contains(t, filter["ssn"].(bson.M)["$in"], newer)
+}
+
+func TestFieldEncryptionFilter(t *testing.T) {
+	encryption := newEncryption(t, "2024")
+
+	testCases := map[string]struct {
+		filter    bson.M
+		encrypted bool
+	}{
+		"equality":       {filter: bson.M{"ssn": "123-45"}},
+		"eq":             {filter: bson.M{"ssn": bson.M{"$eq": "123-45"}}},
+		"in":             {filter: bson.M{"$or": bson.A{bson.M{"ssn": bson.M{"$in": bson.A{"1", "2"}}}}}},
+		"otherField":     {filter: bson.M{"name": "Ana"}},
+		"range":          {filter: bson.M{"ssn": bson.M{"$gt": "1"}}, encrypted: true},
+		"randomField":    {filter: bson.M{"salary.amount": 100}, encrypted: true},
+		"parentDocument": {filter: bson.M{"salary": bson.M{"amount": 100}}, encrypted: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			err := encryption.EncryptFilter("hr", "employees", tc.filter)
+			assert.Equal(t, tc.encrypted, errors.Is(err, db.ErrEncryptedField), "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestFieldEncryptionUpdate(t *testing.T) {
+	encryption := newEncryption(t, "2024")
+
+	update := bson.M{"$set": bson.M{"ssn": "999", "salary": bson.M{"amount": 1}, "name": "Ana"}}
+	err := encryption.EncryptUpdate("hr", "employees", update)
+	assert.NoError(t, err)
+	set := update["$set"].(bson.M)
+	assert.IsType(t, primitive.Binary{}, set["ssn"])
+	assert.IsType(t, primitive.Binary{}, set["salary"].(bson.M)["amount"])
+	assert.Equal(t, "Ana", set["name"])
+
+	err = encryption.EncryptUpdate("hr", "employees", bson.M{"$inc": bson.M{"salary.amount": 1}})
+	assert.True(t, errors.Is(err, db.ErrEncryptedField), "increments of encrypted fields should be refused")
+
+	err = encryption.EncryptUpdate("hr", "employees", bson.M{"$unset": bson.M{"ssn": ""}})
+	assert.NoError(t, err, "encrypted fields may be removed")
+}
+
+func TestFieldEncryptionGeoNearQuery(t *testing.T) {
+	proxy, err := db.NewConnectionWithOptions("cool_host", 27017, "", "", db.ConnectionOptions{Encryption: newEncryption(t, "2024")})
+	if err != nil {
+		t.FailNow()
+	}
+
+	// Random values can't be compared, so the query is refused before reaching MongoDB.
+	_, err = proxy.AggregatePipeline("hr", "employees", []bson.M{
+		{"$geoNear": bson.M{"near": bson.A{0, 0}, "key": "location", "query": bson.M{"salary.amount": 100}}},
+	})
+	assert.True(t, errors.Is(err, db.ErrEncryptedField), "unexpected error: %v", err)
+}
+
+func TestLoadFieldEncryption(t *testing.T) {
+	dir := t.TempDir()
+	keysFile := filepath.Join(dir, "keys.json")
+	fieldsFile := filepath.Join(dir, "fields.json")
+	keys := `{"active": "2024", "keys": [{"id": "2024", "key": "` + testKey('a') + `"}]}`
+	if ioutil.WriteFile(keysFile, []byte(keys), 0600) != nil {
+		t.FailNow()
+	}
+
+	testCases := map[string]struct {
+		fields   string
+		hasError bool
+	}{
+		"storedNames":   {fields: `{"fields": [{"database": "library", "collection": "quotes", "path": "originalquote"}, {"database": "library", "collection": "quotes", "path": "last_published"}]}`},
+		"jsonName":      {fields: `{"fields": [{"database": "library", "collection": "quotes", "path": "original_quote"}]}`, hasError: true},
+		"neverWritten":  {fields: `{"fields": [{"database": "hr", "collection": "employees", "path": "ssn"}]}`, hasError: true},
+		"nestedInQuote": {fields: `{"fields": [{"database": "library", "collection": "quotes", "path": "author.name"}]}`, hasError: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if ioutil.WriteFile(fieldsFile, []byte(tc.fields), 0600) != nil {
+				t.FailNow()
+			}
+			_, err := db.LoadFieldEncryption(keysFile, fieldsFile)
+			assert.Equal(t, tc.hasError, err != nil, "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestNewFieldEncryptionErrors(t *testing.T) {
+	testCases := map[string]db.Keyring{
+		"noActiveKey":  {Active: "2025", Keys: []db.EncryptionKey{{ID: "2024", Key: testKey('a')}}},
+		"shortKey":     {Active: "2024", Keys: []db.EncryptionKey{{ID: "2024", Key: base64.StdEncoding.EncodeToString([]byte("short"))}}},
+		"duplicateKey": {Active: "2024", Keys: []db.EncryptionKey{{ID: "2024", Key: testKey('a')}, {ID: "2024", Key: testKey('b')}}},
+	}
+
+	for name, keyring := range testCases {
+		t.Run(name, func(t *testing.T) {
+			_, err := db.NewFieldEncryption(keyring, nil)
+			assert.Error(t, err)
+		})
+	}
+}
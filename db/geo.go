@@ -0,0 +1,265 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// earthRadiusInMeters is the equatorial radius MongoDB documentation uses to convert distances into radians.
+const earthRadiusInMeters = 6378100.0
+
+// distanceUnits maps the accepted distance units to their value in meters.
+var distanceUnits = map[string]float64{
+	"":           1,
+	"m":          1,
+	"meters":     1,
+	"km":         1000,
+	"kilometers": 1000,
+	"mi":         1609.344,
+	"miles":      1609.344,
+	"ft":         0.3048,
+	"feet":       0.3048,
+}
+
+// GeoJSON is a GeoJSON geometry. Only Point and Polygon are accepted by the geospatial queries.
+type GeoJSON struct {
+	Type        string      `json:"type" bson:"type"`
+	Coordinates interface{} `json:"coordinates" bson:"coordinates"`
+}
+
+// CenterSphere is a circle on the surface of the Earth.
+type CenterSphere struct {
+	Center []float64 `json:"center"`
+	Radius float64   `json:"radius"`
+	Unit   string    `json:"unit,omitempty"`
+}
+
+// GeoNearRequest looks for the documents closest to a point.
+type GeoNearRequest struct {
+	Field       string  `json:"field"`
+	Point       GeoJSON `json:"point"`
+	MinDistance float64 `json:"min_distance,omitempty"`
+	MaxDistance float64 `json:"max_distance,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+
+	// These are only used by the $geoNear aggregation.
+	DistanceField string      `json:"distance_field,omitempty"`
+	Query         interface{} `json:"query,omitempty"`
+	Limit         int64       `json:"limit,omitempty"`
+}
+
+// GeoWithinRequest looks for the documents inside an area. Exactly one of Box, Polygon or CenterSphere must be given.
+type GeoWithinRequest struct {
+	Field        string        `json:"field"`
+	Box          [][]float64   `json:"box,omitempty"`
+	Polygon      *GeoJSON      `json:"polygon,omitempty"`
+	CenterSphere *CenterSphere `json:"center_sphere,omitempty"`
+}
+
+// Filter builds the $near filter of the request, with distances converted to meters.
+func (r GeoNearRequest) Filter() (bson.M, error) {
+	err := r.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	near := bson.M{"$geometry": bson.M{"type": "Point", "coordinates": r.Point.Coordinates}}
+	multiplier := distanceUnits[strings.ToLower(r.Unit)]
+	if r.MinDistance > 0 {
+		near["$minDistance"] = r.MinDistance * multiplier
+	}
+	if r.MaxDistance > 0 {
+		near["$maxDistance"] = r.MaxDistance * multiplier
+	}
+
+	return bson.M{r.Field: bson.M{"$near": near}}, nil
+}
+
+// Pipeline builds the $geoNear aggregation of the request. The calculated distances are returned in the unit of the
+// request, in the field DistanceField ("distance" by default).
+func (r GeoNearRequest) Pipeline() ([]bson.M, error) {
+	err := r.validate()
+	if err != nil {
+		return nil, err
+	}
+	if r.Limit < 0 {
+		return nil, fmt.Errorf("limit must not be negative")
+	}
+
+	multiplier := distanceUnits[strings.ToLower(r.Unit)]
+	distanceField := r.DistanceField
+	if len(distanceField) == 0 {
+		distanceField = "distance"
+	}
+
+	geoNear := bson.M{
+		"near":               bson.M{"type": "Point", "coordinates": r.Point.Coordinates},
+		"key":                r.Field,
+		"distanceField":      distanceField,
+		"distanceMultiplier": 1 / multiplier,
+		"spherical":          true,
+	}
+	if r.MinDistance > 0 {
+		geoNear["minDistance"] = r.MinDistance * multiplier
+	}
+	if r.MaxDistance > 0 {
+		geoNear["maxDistance"] = r.MaxDistance * multiplier
+	}
+	if r.Query != nil {
+		geoNear["query"] = r.Query
+	}
+
+	pipeline := []bson.M{{"$geoNear": geoNear}}
+	if r.Limit > 0 {
+		pipeline = append(pipeline, bson.M{"$limit": r.Limit})
+	}
+	return pipeline, nil
+}
+
+// Filter builds the $geoWithin filter of the request.
+func (r GeoWithinRequest) Filter() (bson.M, error) {
+	if len(strings.TrimSpace(r.Field)) == 0 {
+		return nil, fmt.Errorf("field is required")
+	}
+
+	shapes := 0
+	var within bson.M
+	if r.Box != nil {
+		shapes++
+		if len(r.Box) != 2 {
+			return nil, fmt.Errorf("box must have exactly 2 points: bottom left and upper right")
+		}
+		for _, point := range r.Box {
+			err := validatePosition(point)
+			if err != nil {
+				return nil, fmt.Errorf("invalid box: %v", err)
+			}
+		}
+		within = bson.M{"$box": r.Box}
+	}
+	if r.Polygon != nil {
+		shapes++
+		err := validatePolygon(*r.Polygon)
+		if err != nil {
+			return nil, err
+		}
+		within = bson.M{"$geometry": bson.M{"type": "Polygon", "coordinates": r.Polygon.Coordinates}}
+	}
+	if r.CenterSphere != nil {
+		shapes++
+		err := validatePosition(r.CenterSphere.Center)
+		if err != nil {
+			return nil, fmt.Errorf("invalid center: %v", err)
+		}
+		multiplier, ok := distanceUnits[strings.ToLower(r.CenterSphere.Unit)]
+		if !ok {
+			return nil, fmt.Errorf("unknown distance unit: %s", r.CenterSphere.Unit)
+		}
+		if r.CenterSphere.Radius <= 0 {
+			return nil, fmt.Errorf("radius must be positive")
+		}
+		radians := r.CenterSphere.Radius * multiplier / earthRadiusInMeters
+		within = bson.M{"$centerSphere": bson.A{r.CenterSphere.Center, radians}}
+	}
+
+	if shapes != 1 {
+		return nil, fmt.Errorf("exactly one of box, polygon or center_sphere must be given")
+	}
+	return bson.M{r.Field: bson.M{"$geoWithin": within}}, nil
+}
+
+func (r GeoNearRequest) validate() error {
+	if len(strings.TrimSpace(r.Field)) == 0 {
+		return fmt.Errorf("field is required")
+	}
+	if _, ok := distanceUnits[strings.ToLower(r.Unit)]; !ok {
+		return fmt.Errorf("unknown distance unit: %s", r.Unit)
+	}
+	if r.MinDistance < 0 || r.MaxDistance < 0 {
+		return fmt.Errorf("distances must not be negative")
+	}
+	if r.MaxDistance > 0 && r.MinDistance > r.MaxDistance {
+		return fmt.Errorf("min_distance must not be greater than max_distance")
+	}
+	return validatePoint(r.Point)
+}
+
+func validatePoint(g GeoJSON) error {
+	if g.Type != "Point" {
+		return fmt.Errorf("point must be a GeoJSON Point, not %q", g.Type)
+	}
+	position, err := toPosition(g.Coordinates)
+	if err != nil {
+		return err
+	}
+	return validatePosition(position)
+}
+
+func validatePolygon(g GeoJSON) error {
+	if g.Type != "Polygon" {
+		return fmt.Errorf("polygon must be a GeoJSON Polygon, not %q", g.Type)
+	}
+	rings, ok := g.Coordinates.([]interface{})
+	if !ok || len(rings) == 0 {
+		return fmt.Errorf("polygon must have at least one linear ring")
+	}
+
+	for i, r := range rings {
+		ring, ok := r.([]interface{})
+		if !ok || len(ring) < 4 {
+			return fmt.Errorf("ring %d of polygon must have at least 4 positions", i)
+		}
+		positions := make([][]float64, 0, len(ring))
+		for _, p := range ring {
+			position, err := toPosition(p)
+			if err != nil {
+				return fmt.Errorf("ring %d of polygon: %v", i, err)
+			}
+			err = validatePosition(position)
+			if err != nil {
+				return fmt.Errorf("ring %d of polygon: %v", i, err)
+			}
+			positions = append(positions, position)
+		}
+		first, last := positions[0], positions[len(positions)-1]
+		if first[0] != last[0] || first[1] != last[1] {
+			return fmt.Errorf("ring %d of polygon is not closed", i)
+		}
+	}
+	return nil
+}
+
+// toPosition converts the coordinates decoded from JSON into [longitude, latitude].
+func toPosition(coordinates interface{}) ([]float64, error) {
+	switch c := coordinates.(type) {
+	case []float64:
+		return c, nil
+	case []interface{}:
+		position := make([]float64, 0, len(c))
+		for _, value := range c {
+			number, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("coordinates must be numbers")
+			}
+			position = append(position, number)
+		}
+		return position, nil
+	default:
+		return nil, fmt.Errorf("coordinates must be [longitude, latitude]")
+	}
+}
+
+func validatePosition(position []float64) error {
+	if len(position) != 2 {
+		return fmt.Errorf("coordinates must be [longitude, latitude]")
+	}
+	if position[0] < -180 || position[0] > 180 {
+		return fmt.Errorf("longitude out of range: %v", position[0])
+	}
+	if position[1] < -90 || position[1] > 90 {
+		return fmt.Errorf("latitude out of range: %v", position[1])
+	}
+	return nil
+}
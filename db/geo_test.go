@@ -0,0 +1,154 @@
+package db_test
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type GeoTestCase struct {
+	name     string
+	request  string
+	expected string
+	hasError bool
+}
+
+func TestGeoNearFilter(t *testing.T) {
+	testCases := []GeoTestCase{
+		{
+			name:     "nearInKilometers",
+			request:  `{"field":"location","point":{"type":"Point","coordinates":[-48.5,-27.6]},"max_distance":2,"unit":"km"}`,
+			expected: `{"location":{"$near":{"$geometry":{"coordinates":[-48.5,-27.6],"type":"Point"},"$maxDistance":2000}}}`,
+		},
+		{
+			name:     "nearDefaultsToMeters",
+			request:  `{"field":"location","point":{"type":"Point","coordinates":[10,20]},"min_distance":5,"max_distance":50}`,
+			expected: `{"location":{"$near":{"$geometry":{"coordinates":[10,20],"type":"Point"},"$maxDistance":50,"$minDistance":5}}}`,
+		},
+		{
+			name:     "nearLatitudeOutOfRange",
+			request:  `{"field":"location","point":{"type":"Point","coordinates":[10,91]}}`,
+			hasError: true,
+		},
+		{
+			name:     "nearNotAPoint",
+			request:  `{"field":"location","point":{"type":"LineString","coordinates":[[10,20],[11,21]]}}`,
+			hasError: true,
+		},
+		{
+			name:     "nearUnknownUnit",
+			request:  `{"field":"location","point":{"type":"Point","coordinates":[10,20]},"unit":"parsec"}`,
+			hasError: true,
+		},
+		{
+			name:     "nearMinGreaterThanMax",
+			request:  `{"field":"location","point":{"type":"Point","coordinates":[10,20]},"min_distance":10,"max_distance":5}`,
+			hasError: true,
+		},
+		{
+			name:     "nearMissingField",
+			request:  `{"point":{"type":"Point","coordinates":[10,20]}}`,
+			hasError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var request db.GeoNearRequest
+			assert.NoError(t, json.Unmarshal([]byte(tc.request), &request))
+
+			filter, err := request.Filter()
+			if tc.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, toJSON(t, filter), "unexpected filter")
+		})
+	}
+}
+
+func TestGeoNearPipeline(t *testing.T) {
+	var request db.GeoNearRequest
+	assert.NoError(t, json.Unmarshal([]byte(
+		`{"field":"location","point":{"type":"Point","coordinates":[1,2]},"max_distance":1,"unit":"km","limit":5}`),
+		&request))
+
+	pipeline, err := request.Pipeline()
+	assert.NoError(t, err)
+	assert.Equal(t,
+		`{"p":[{"$geoNear":{"distanceField":"distance","distanceMultiplier":0.001,"key":"location","maxDistance":1000,"near":{"coordinates":[1,2],"type":"Point"},"spherical":true}},{"$limit":5}]}`,
+		toJSON(t, bson.M{"p": pipeline}),
+		"unexpected pipeline")
+}
+
+func TestGeoWithinFilter(t *testing.T) {
+	testCases := []GeoTestCase{
+		{
+			name:     "withinBox",
+			request:  `{"field":"location","box":[[0,0],[10,10]]}`,
+			expected: `{"location":{"$geoWithin":{"$box":[[0,0],[10,10]]}}}`,
+		},
+		{
+			name:     "withinPolygon",
+			request:  `{"field":"location","polygon":{"type":"Polygon","coordinates":[[[0,0],[3,6],[6,1],[0,0]]]}}`,
+			expected: `{"location":{"$geoWithin":{"$geometry":{"coordinates":[[[0,0],[3,6],[6,1],[0,0]]],"type":"Polygon"}}}}`,
+		},
+		{
+			name:     "withinCenterSphere",
+			request:  `{"field":"location","center_sphere":{"center":[0,0],"radius":6378.1,"unit":"km"}}`,
+			expected: `{"location":{"$geoWithin":{"$centerSphere":[[0,0],1]}}}`,
+		},
+		{
+			name:     "withinPolygonNotClosed",
+			request:  `{"field":"location","polygon":{"type":"Polygon","coordinates":[[[0,0],[3,6],[6,1],[1,1]]]}}`,
+			hasError: true,
+		},
+		{
+			name:     "withinPolygonTooShort",
+			request:  `{"field":"location","polygon":{"type":"Polygon","coordinates":[[[0,0],[3,6],[0,0]]]}}`,
+			hasError: true,
+		},
+		{
+			name:     "withinTwoShapes",
+			request:  `{"field":"location","box":[[0,0],[10,10]],"center_sphere":{"center":[0,0],"radius":1}}`,
+			hasError: true,
+		},
+		{
+			name:     "withinNoShape",
+			request:  `{"field":"location"}`,
+			hasError: true,
+		},
+		{
+			name:     "withinBoxLongitudeOutOfRange",
+			request:  `{"field":"location","box":[[0,0],[181,10]]}`,
+			hasError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var request db.GeoWithinRequest
+			assert.NoError(t, json.Unmarshal([]byte(tc.request), &request))
+
+			filter, err := request.Filter()
+			if tc.hasError {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, toJSON(t, filter), "unexpected filter")
+		})
+	}
+}
+
+func toJSON(t *testing.T, document interface{}) string {
+	result, err := json.Marshal(document)
+	if err != nil {
+		t.FailNow()
+	}
+	return string(result)
+}
@@ -0,0 +1,355 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrInvalidCall is returned when an interceptor leaves a Call, or its response, with values of the wrong type.
+var ErrInvalidCall = errors.New("invalid call")
+
+// Operation names a method of Proxy.
+type Operation string
+
+// The operations of Proxy.
+const (
+	OpHealthCheck       Operation = "healthCheck"
+	OpAggregate         Operation = "aggregate"
+	OpAggregatePipeline Operation = "aggregatePipeline"
+	OpInsert            Operation = "insert"
+	OpFind              Operation = "find"
+	OpUpdate            Operation = "update"
+	OpListCollections   Operation = "listCollections"
+	OpCreateCollection  Operation = "createCollection"
+	OpRenameCollection  Operation = "renameCollection"
+	OpDropCollection    Operation = "dropCollection"
+	OpDropDatabase      Operation = "dropDatabase"
+	OpSearch            Operation = "search"
+	OpExplain           Operation = "explain"
+	OpUploadFile        Operation = "uploadFile"
+	OpDownloadFile      Operation = "downloadFile"
+	OpListFiles         Operation = "listFiles"
+	OpDeleteFile        Operation = "deleteFile"
+)
+
+// IsWrite tells if the operation changes data or collections.
+func (o Operation) IsWrite() bool {
+	switch o {
+	case OpInsert, OpUpdate, OpCreateCollection, OpRenameCollection, OpDropCollection, OpDropDatabase,
+		OpUploadFile, OpDeleteFile:
+		return true
+	}
+	return false
+}
+
+// Call describes a call to a Proxy. Only the fields used by its Operation are set.
+type Call struct {
+	// Context is the context of the request that made the call, with its principal, if any. It is never nil.
+	Context   context.Context
+	Operation Operation
+	Database  string
+	// Collection is the collection, or the bucket of the file operations.
+	Collection string
+
+	// Filter is the filter of Find and Update, or the stages of Aggregate.
+	Filter interface{}
+	// Pipeline is the pipeline of AggregatePipeline.
+	Pipeline interface{}
+	// Document is the Quote of Insert, or the changes of Update.
+	Document interface{}
+	// Request is the CreateCollectionRequest, RenameCollectionRequest, SearchRequest or ExplainRequest.
+	Request interface{}
+
+	// FileID, Filename, ContentType, Metadata and Content describe the file operations.
+	FileID      string
+	Filename    string
+	ContentType string
+	Metadata    bson.M
+	Content     io.Reader
+}
+
+// Handler runs a call and returns the response of its Proxy method, like *FindResponse.
+type Handler func(call *Call) (interface{}, error)
+
+// Interceptor runs around the calls to a Proxy: it may look at or change the call, call next (or not),
+// and look at or change the response.
+type Interceptor func(call *Call, next Handler) (interface{}, error)
+
+// InterceptedProxy runs the calls to another Proxy through a chain of interceptors.
+type InterceptedProxy struct {
+	Proxy
+	context      context.Context
+	interceptors []Interceptor
+	handler      Handler
+}
+
+// Intercept wraps proxy with interceptors. The first one is the outermost: it sees the calls first,
+// and the responses last.
+func Intercept(proxy Proxy, interceptors ...Interceptor) *InterceptedProxy {
+	return InterceptContext(context.Background(), proxy, interceptors...)
+}
+
+// InterceptContext wraps proxy with interceptors, like Intercept, giving ctx to every call.
+func InterceptContext(ctx context.Context, proxy Proxy, interceptors ...Interceptor) *InterceptedProxy {
+	handler := func(call *Call) (interface{}, error) {
+		return invoke(proxy, call)
+	}
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		interceptor, next := interceptors[i], handler
+		handler = func(call *Call) (interface{}, error) {
+			return interceptor(call, next)
+		}
+	}
+	return &InterceptedProxy{
+		Proxy:        proxy,
+		context:      ctx,
+		interceptors: interceptors,
+		handler:      handler,
+	}
+}
+
+// WithConsistency applies consistency to the wrapped proxy, keeping the same interceptors.
+func (p *InterceptedProxy) WithConsistency(consistency Consistency) (Proxy, error) {
+	proxy, err := WithConsistency(p.Proxy, consistency)
+	if err != nil {
+		return nil, err
+	}
+	return InterceptContext(p.context, proxy, p.interceptors...), nil
+}
+
+// call runs call through the interceptors, with the context of the proxy.
+func (p *InterceptedProxy) call(call *Call) (interface{}, error) {
+	call.Context = p.context
+	return p.handler(call)
+}
+
+// HealthCheck lists the databases through the interceptors.
+func (p *InterceptedProxy) HealthCheck() (*HealthResponse, error) {
+	response, err := p.call(&Call{Operation: OpHealthCheck})
+	result, ok := response.(*HealthResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Aggregate runs the aggregation through the interceptors.
+func (p *InterceptedProxy) Aggregate(database, collection string, filter interface{}) (*AggregateResponse, error) {
+	response, err := p.call(&Call{Operation: OpAggregate, Database: database, Collection: collection, Filter: filter})
+	result, ok := response.(*AggregateResponse)
+	return result, responseError(response, ok, err)
+}
+
+// AggregatePipeline runs the pipeline through the interceptors.
+func (p *InterceptedProxy) AggregatePipeline(database, collection string, pipeline interface{}) (*FindResponse, error) {
+	response, err := p.call(&Call{Operation: OpAggregatePipeline, Database: database, Collection: collection, Pipeline: pipeline})
+	result, ok := response.(*FindResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Insert inserts entry through the interceptors.
+func (p *InterceptedProxy) Insert(database, collection string, entry Quote) (*InsertResponse, error) {
+	response, err := p.call(&Call{Operation: OpInsert, Database: database, Collection: collection, Document: entry})
+	result, ok := response.(*InsertResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Find finds the documents through the interceptors.
+func (p *InterceptedProxy) Find(database, collection string, filter interface{}) (*FindResponse, error) {
+	response, err := p.call(&Call{Operation: OpFind, Database: database, Collection: collection, Filter: filter})
+	result, ok := response.(*FindResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Update updates the documents through the interceptors.
+func (p *InterceptedProxy) Update(database, collection string, filter, entry interface{}) (*UpdateResponse, error) {
+	response, err := p.call(&Call{Operation: OpUpdate, Database: database, Collection: collection, Filter: filter, Document: entry})
+	result, ok := response.(*UpdateResponse)
+	return result, responseError(response, ok, err)
+}
+
+// ListCollections lists the collections through the interceptors.
+func (p *InterceptedProxy) ListCollections(database string) (*CollectionsResponse, error) {
+	response, err := p.call(&Call{Operation: OpListCollections, Database: database})
+	result, ok := response.(*CollectionsResponse)
+	return result, responseError(response, ok, err)
+}
+
+// CreateCollection creates the collection through the interceptors.
+func (p *InterceptedProxy) CreateCollection(database, collection string, request CreateCollectionRequest) (*AdminResponse, error) {
+	response, err := p.call(&Call{Operation: OpCreateCollection, Database: database, Collection: collection, Request: request})
+	result, ok := response.(*AdminResponse)
+	return result, responseError(response, ok, err)
+}
+
+// RenameCollection renames the collection through the interceptors.
+func (p *InterceptedProxy) RenameCollection(database, collection string, request RenameCollectionRequest) (*AdminResponse, error) {
+	response, err := p.call(&Call{Operation: OpRenameCollection, Database: database, Collection: collection, Request: request})
+	result, ok := response.(*AdminResponse)
+	return result, responseError(response, ok, err)
+}
+
+// DropCollection drops the collection through the interceptors.
+func (p *InterceptedProxy) DropCollection(database, collection string) (*AdminResponse, error) {
+	response, err := p.call(&Call{Operation: OpDropCollection, Database: database, Collection: collection})
+	result, ok := response.(*AdminResponse)
+	return result, responseError(response, ok, err)
+}
+
+// DropDatabase drops the database through the interceptors.
+func (p *InterceptedProxy) DropDatabase(database string) (*AdminResponse, error) {
+	response, err := p.call(&Call{Operation: OpDropDatabase, Database: database})
+	result, ok := response.(*AdminResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Search runs the full-text search through the interceptors.
+func (p *InterceptedProxy) Search(database, collection string, request SearchRequest) (*SearchResponse, error) {
+	response, err := p.call(&Call{Operation: OpSearch, Database: database, Collection: collection, Request: request})
+	result, ok := response.(*SearchResponse)
+	return result, responseError(response, ok, err)
+}
+
+// Explain returns the execution plan through the interceptors.
+func (p *InterceptedProxy) Explain(database, collection string, request ExplainRequest) (*ExplainResponse, error) {
+	response, err := p.call(&Call{Operation: OpExplain, Database: database, Collection: collection, Request: request})
+	result, ok := response.(*ExplainResponse)
+	return result, responseError(response, ok, err)
+}
+
+// UploadFile stores the file through the interceptors.
+func (p *InterceptedProxy) UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (*FileInfo, error) {
+	response, err := p.call(&Call{
+		Operation:   OpUploadFile,
+		Database:    database,
+		Collection:  bucket,
+		Filename:    filename,
+		ContentType: contentType,
+		Metadata:    metadata,
+		Content:     content,
+	})
+	result, ok := response.(*FileInfo)
+	return result, responseError(response, ok, err)
+}
+
+// DownloadFile opens the file through the interceptors.
+func (p *InterceptedProxy) DownloadFile(database, bucket, id string) (*FileDownload, error) {
+	response, err := p.call(&Call{Operation: OpDownloadFile, Database: database, Collection: bucket, FileID: id})
+	result, ok := response.(*FileDownload)
+	return result, responseError(response, ok, err)
+}
+
+// ListFiles lists the files through the interceptors.
+func (p *InterceptedProxy) ListFiles(database, bucket string) (*FilesResponse, error) {
+	response, err := p.call(&Call{Operation: OpListFiles, Database: database, Collection: bucket})
+	result, ok := response.(*FilesResponse)
+	return result, responseError(response, ok, err)
+}
+
+// DeleteFile deletes the file through the interceptors.
+func (p *InterceptedProxy) DeleteFile(database, bucket, id string) (*DeleteResponse, error) {
+	response, err := p.call(&Call{Operation: OpDeleteFile, Database: database, Collection: bucket, FileID: id})
+	result, ok := response.(*DeleteResponse)
+	return result, responseError(response, ok, err)
+}
+
+// invoke calls the method of proxy described by call.
+func invoke(proxy Proxy, call *Call) (interface{}, error) {
+	switch call.Operation {
+	case OpHealthCheck:
+		return proxy.HealthCheck()
+	case OpAggregate:
+		return proxy.Aggregate(call.Database, call.Collection, call.Filter)
+	case OpAggregatePipeline:
+		return proxy.AggregatePipeline(call.Database, call.Collection, call.Pipeline)
+	case OpInsert:
+		entry, ok := call.Document.(Quote)
+		if !ok {
+			return nil, fmt.Errorf("%w: insert expects a Quote, not %T", ErrInvalidCall, call.Document)
+		}
+		return proxy.Insert(call.Database, call.Collection, entry)
+	case OpFind:
+		return proxy.Find(call.Database, call.Collection, call.Filter)
+	case OpUpdate:
+		return proxy.Update(call.Database, call.Collection, call.Filter, call.Document)
+	case OpListCollections:
+		return proxy.ListCollections(call.Database)
+	case OpCreateCollection:
+		request, ok := call.Request.(CreateCollectionRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: createCollection expects a CreateCollectionRequest, not %T", ErrInvalidCall, call.Request)
+		}
+		return proxy.CreateCollection(call.Database, call.Collection, request)
+	case OpRenameCollection:
+		request, ok := call.Request.(RenameCollectionRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: renameCollection expects a RenameCollectionRequest, not %T", ErrInvalidCall, call.Request)
+		}
+		return proxy.RenameCollection(call.Database, call.Collection, request)
+	case OpDropCollection:
+		return proxy.DropCollection(call.Database, call.Collection)
+	case OpDropDatabase:
+		return proxy.DropDatabase(call.Database)
+	case OpSearch:
+		request, ok := call.Request.(SearchRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: search expects a SearchRequest, not %T", ErrInvalidCall, call.Request)
+		}
+		return proxy.Search(call.Database, call.Collection, request)
+	case OpExplain:
+		request, ok := call.Request.(ExplainRequest)
+		if !ok {
+			return nil, fmt.Errorf("%w: explain expects an ExplainRequest, not %T", ErrInvalidCall, call.Request)
+		}
+		return proxy.Explain(call.Database, call.Collection, request)
+	case OpUploadFile:
+		return proxy.UploadFile(call.Database, call.Collection, call.Filename, call.ContentType, call.Metadata, call.Content)
+	case OpDownloadFile:
+		return proxy.DownloadFile(call.Database, call.Collection, call.FileID)
+	case OpListFiles:
+		return proxy.ListFiles(call.Database, call.Collection)
+	case OpDeleteFile:
+		return proxy.DeleteFile(call.Database, call.Collection, call.FileID)
+	default:
+		return nil, fmt.Errorf("%w: unknown operation %q", ErrInvalidCall, call.Operation)
+	}
+}
+
+// responseError returns err, or ErrInvalidCall if the response returned by the interceptors doesn't have
+// the type of the Proxy method (ok is false).
+func responseError(response interface{}, ok bool, err error) error {
+	if ok || response == nil || err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: unexpected %T response", ErrInvalidCall, response)
+}
+
+// LogCalls logs the calls that fail, and those slower than slow, unless it is zero.
+func LogCalls(slow time.Duration) Interceptor {
+	return func(call *Call, next Handler) (interface{}, error) {
+		start := time.Now()
+		response, err := next(call)
+		elapsed := time.Since(start)
+
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("operation", string(call.Operation)).
+				Str("database", call.Database).
+				Str("collection", call.Collection).
+				Dur("elapsed", elapsed).
+				Msg("database call failed")
+		} else if slow > 0 && elapsed >= slow {
+			log.Warn().
+				Str("operation", string(call.Operation)).
+				Str("database", call.Database).
+				Str("collection", call.Collection).
+				Dur("elapsed", elapsed).
+				Msg("slow database call")
+		}
+		return response, err
+	}
+}
@@ -0,0 +1,126 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestInterceptorOrder(t *testing.T) {
+	var trace []string
+	tracing := func(name string) db.Interceptor {
+		return func(call *db.Call, next db.Handler) (interface{}, error) {
+			trace = append(trace, name+" before "+string(call.Operation))
+			response, err := next(call)
+			trace = append(trace, name+" after")
+			return response, err
+		}
+	}
+
+	proxy := db.Intercept(&mock.DBProxy{TestCaseID: "findOK"}, tracing("outer"), tracing("inner"))
+	response, err := proxy.Find("cool_db", "cool_collection", bson.M{})
+
+	assert.NoError(t, err)
+	assert.Len(t, response.Results, 1)
+	assert.Equal(t, []string{"outer before find", "inner before find", "inner after", "outer after"}, trace)
+}
+
+func TestInterceptorChangesCall(t *testing.T) {
+	// Interceptors may change the arguments and the response.
+	rename := func(call *db.Call, next db.Handler) (interface{}, error) {
+		if call.Operation == db.OpFind {
+			call.Collection = "renamed"
+		}
+		response, err := next(call)
+		if found, ok := response.(*db.FindResponse); ok {
+			found.Results = append(found.Results, bson.M{"added": true})
+		}
+		return response, err
+	}
+
+	proxy := db.Intercept(&mock.DBProxy{TestCaseID: "findNamespace"}, rename)
+	response, err := proxy.Find("cool_db", "cool_collection", bson.M{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []bson.M{{"database": "cool_db", "collection": "renamed"}, {"added": true}}, response.Results)
+}
+
+func TestInterceptorShortCircuits(t *testing.T) {
+	errReadOnly := errors.New("read-only")
+	readOnly := func(call *db.Call, next db.Handler) (interface{}, error) {
+		if call.Operation.IsWrite() {
+			return nil, errReadOnly
+		}
+		return next(call)
+	}
+
+	proxy := db.Intercept(&mock.DBProxy{TestCaseID: "insertOK"}, readOnly)
+	response, err := proxy.Insert("cool_db", "cool_collection", db.Quote{})
+	assert.Nil(t, response)
+	assert.Equal(t, errReadOnly, err)
+
+	_, err = proxy.DropDatabase("cool_db")
+	assert.Equal(t, errReadOnly, err)
+}
+
+func TestInterceptorInvalidTypes(t *testing.T) {
+	wrongArgument := func(call *db.Call, next db.Handler) (interface{}, error) {
+		call.Document = bson.M{"not": "a quote"}
+		return next(call)
+	}
+	_, err := db.Intercept(&mock.DBProxy{TestCaseID: "insertOK"}, wrongArgument).Insert("cool_db", "cool_collection", db.Quote{})
+	assert.ErrorIs(t, err, db.ErrInvalidCall)
+
+	wrongResponse := func(call *db.Call, next db.Handler) (interface{}, error) {
+		return &db.InsertResponse{}, nil
+	}
+	_, err = db.Intercept(&mock.DBProxy{TestCaseID: "findOK"}, wrongResponse).Find("cool_db", "cool_collection", bson.M{})
+	assert.ErrorIs(t, err, db.ErrInvalidCall)
+}
+
+func TestInterceptorWithConsistency(t *testing.T) {
+	var operations []db.Operation
+	record := func(call *db.Call, next db.Handler) (interface{}, error) {
+		operations = append(operations, call.Operation)
+		return next(call)
+	}
+
+	proxy, err := db.WithConsistency(db.Intercept(&mock.DBProxy{TestCaseID: "findConsistency"}, record),
+		db.Consistency{ReadPreference: "secondary"})
+	if err != nil {
+		t.FailNow()
+	}
+	response, err := proxy.Find("cool_db", "cool_collection", bson.M{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, db.Consistency{ReadPreference: "secondary"}, response.Results[0]["consistency"])
+	assert.Equal(t, []db.Operation{db.OpFind}, operations)
+}
+
+func TestInterceptorContext(t *testing.T) {
+	type key struct{}
+	var values []interface{}
+	record := func(call *db.Call, next db.Handler) (interface{}, error) {
+		values = append(values, call.Context.Value(key{}))
+		return next(call)
+	}
+
+	ctx := context.WithValue(context.Background(), key{}, "request")
+	proxy, err := db.WithConsistency(db.InterceptContext(ctx, &mock.DBProxy{TestCaseID: "findConsistency"}, record),
+		db.Consistency{ReadPreference: "secondary"})
+	if err != nil {
+		t.FailNow()
+	}
+	_, err = proxy.Find("cool_db", "cool_collection", bson.M{})
+	assert.NoError(t, err)
+	_, err = db.Intercept(&mock.DBProxy{TestCaseID: "findOK"}, record).Find("cool_db", "cool_collection", bson.M{})
+	assert.NoError(t, err)
+
+	// Calls without a context get an empty one.
+	assert.Equal(t, []interface{}{"request", nil}, values)
+}
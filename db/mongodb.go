@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -12,25 +13,36 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// ErrNoTextIndex is returned by Search when the collection has no text index.
+var ErrNoTextIndex = errors.New("collection has no text index")
+
 // MongoDBProxy manages everything related to MongoDB connection, queries etc.
 type MongoDBProxy struct {
-	hostname string
-	port     int
-	URI      string
+	hostname      string
+	port          int
+	uri           string
+	clientOptions *options.ClientOptions
+	encryption    *FieldEncryption
 }
 
 // NewConnection instantiates the MongoDB proxy connector (client, context etc.)
 func NewConnection(hostname string, port int, username, password string) (Proxy, error) {
-	// port 27017
-	URI := fmt.Sprintf("mongodb://%s%s%s",
-		getUserCredentialForConnectionString(username, password),
-		hostname,
-		validatePortForConnectionString(port))
+	return NewConnectionWithOptions(hostname, port, username, password, ConnectionOptions{})
+}
+
+// NewConnectionWithOptions instantiates the MongoDB proxy connector, with optional settings.
+func NewConnectionWithOptions(hostname string, port int, username, password string, opts ConnectionOptions) (Proxy, error) {
+	uri, err := buildURI(hostname, port, username, password, opts)
+	if err != nil {
+		return nil, err
+	}
 
 	return &MongoDBProxy{
-		hostname: hostname,
-		port:     port,
-		URI:      URI,
+		hostname:      hostname,
+		port:          port,
+		uri:           uri,
+		clientOptions: options.Client().ApplyURI(uri),
+		encryption:    opts.Encryption,
 	}, nil
 }
 
@@ -54,34 +66,57 @@ func (m *MongoDBProxy) DBWrapperFunc(db, clt string, req []byte,
 
 // Aggregate will compare all entries in a collection and returns the consolidated data..
 func (m *MongoDBProxy) Aggregate(dbName, collName string, filter interface{}) (*AggregateResponse, error) {
-	client, ctx, cancelContext, err := m.getConnection()
+	var parsed []AggregateResponse
+	err := m.aggregate(dbName, collName, filter, &parsed)
 	if err != nil {
-		panic(err)
+		return nil, err
+	}
+
+	if len(parsed) < 1 {
+		return &AggregateResponse{
+			MinPublications: 0,
+		}, nil
 	}
-	defer client.Disconnect(ctx)
-	defer cancelContext()
 
-	cursor, err := client.Database(dbName).Collection(collName).Aggregate(ctx, filter)
+	return &parsed[0], nil
+}
+
+// AggregatePipeline runs any aggregation pipeline in collection collName, and returns the resulting documents.
+func (m *MongoDBProxy) AggregatePipeline(dbName, collName string, pipeline interface{}) (*FindResponse, error) {
+	pipeline, err := m.encryptPipeline(dbName, collName, pipeline)
 	if err != nil {
-		log.Error().
-			Err(err).
-			Msgf("failed to aggregate in database")
 		return nil, err
 	}
 
-	var parsed []AggregateResponse
-	err = cursor.All(ctx, &parsed)
+	parsed := []bson.M{}
+	err = m.aggregate(dbName, collName, pipeline, &parsed)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	m.encryption.DecryptDocuments(parsed)
 
-	if len(parsed) < 1 {
-		return &AggregateResponse{
-			MinPublications: 0,
-		}, nil
+	return &FindResponse{
+		Results: parsed,
+	}, nil
+}
+
+func (m *MongoDBProxy) aggregate(dbName, collName string, pipeline, results interface{}) error {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return err
 	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
 
-	return &parsed[0], nil
+	cursor, err := client.Database(dbName).Collection(collName).Aggregate(ctx, pipeline)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to aggregate in database")
+		return err
+	}
+
+	return cursor.All(ctx, results)
 }
 
 // Insert will create a new document in collection collName in database dbName.
@@ -89,12 +124,24 @@ func (m *MongoDBProxy) Aggregate(dbName, collName string, filter interface{}) (*
 func (m *MongoDBProxy) Insert(dbName, collName string, entry Quote) (*InsertResponse, error) {
 	client, ctx, cancelContext, err := m.getConnection()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer client.Disconnect(ctx)
 	defer cancelContext()
 
-	r, err := client.Database(dbName).Collection(collName).InsertOne(ctx, &entry)
+	var document interface{} = &entry
+	if fields := m.encryption.Fields(dbName, collName); len(fields) > 0 {
+		encrypted, err := toDocument(entry)
+		if err == nil {
+			err = m.encryption.EncryptDocument(dbName, collName, encrypted)
+		}
+		if err != nil {
+			return nil, err
+		}
+		document = encrypted
+	}
+
+	r, err := client.Database(dbName).Collection(collName).InsertOne(ctx, document)
 	if err != nil {
 		log.Error().
 			Str("database", dbName).
@@ -115,11 +162,16 @@ func (m *MongoDBProxy) Insert(dbName, collName string, entry Quote) (*InsertResp
 func (m *MongoDBProxy) Find(dbName, collName string, filter interface{}) (*FindResponse, error) {
 	client, ctx, cancelContext, err := m.getConnection()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer client.Disconnect(ctx)
 	defer cancelContext()
 
+	filter, err = m.encryptFilter(dbName, collName, filter)
+	if err != nil {
+		return nil, err
+	}
+
 	cursor, err := client.Database(dbName).Collection(collName).Find(ctx, filter)
 	if err != nil {
 		log.Error().
@@ -131,23 +183,100 @@ func (m *MongoDBProxy) Find(dbName, collName string, filter interface{}) (*FindR
 	var parsed []bson.M
 	err = cursor.All(ctx, &parsed)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
+	m.encryption.DecryptDocuments(parsed)
 
 	return &FindResponse{
 		Results: parsed,
 	}, nil
 }
 
+// Search will fetch the documents that match the text search in request, using the text index of the collection.
+// The documents are sorted by their relevance, that is added to each one as the field "score".
+func (m *MongoDBProxy) Search(dbName, collName string, request SearchRequest) (*SearchResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	text := bson.M{
+		"$search":             request.Text,
+		"$caseSensitive":      request.CaseSensitive,
+		"$diacriticSensitive": request.DiacriticSensitive,
+	}
+	if len(request.Language) > 0 {
+		text["$language"] = request.Language
+	}
+	filter := bson.M{"$text": text}
+
+	score := bson.M{"score": bson.M{"$meta": "textScore"}}
+	opts := options.Find().
+		SetProjection(score).
+		SetSort(score).
+		SetSkip((request.Page - 1) * request.PageSize).
+		SetLimit(request.PageSize)
+
+	collection := client.Database(dbName).Collection(collName)
+	cursor, err := collection.Find(ctx, filter, opts)
+	if err != nil {
+		if isIndexNotFound(err) {
+			return nil, ErrNoTextIndex
+		}
+		log.Error().
+			Err(err).
+			Msgf("failed to search text in database")
+		return nil, err
+	}
+
+	parsed := []bson.M{}
+	err = cursor.All(ctx, &parsed)
+	if err != nil {
+		return nil, err
+	}
+	m.encryption.DecryptDocuments(parsed)
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to count search results")
+		return nil, err
+	}
+
+	return &SearchResponse{
+		Results:  parsed,
+		Total:    total,
+		Page:     request.Page,
+		PageSize: request.PageSize,
+	}, nil
+}
+
 // Update will modify the fields defined in update in all documents that match filter.
 func (m *MongoDBProxy) Update(database, collection string, filter, entry interface{}) (*UpdateResponse, error) {
 	client, ctx, cancelContext, err := m.getConnection()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer client.Disconnect(ctx)
 	defer cancelContext()
 
+	filter, err = m.encryptFilter(database, collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	entry, err = m.encryptUpdate(database, collection, entry)
+	if err != nil {
+		return nil, err
+	}
+
 	result, err := client.Database(database).Collection(collection).UpdateMany(ctx, filter, entry)
 	if err != nil {
 		log.Error().
@@ -165,7 +294,7 @@ func (m *MongoDBProxy) Update(database, collection string, filter, entry interfa
 func (m *MongoDBProxy) HealthCheck() (*HealthResponse, error) {
 	client, ctx, cancelContext, err := m.getConnection()
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	defer client.Disconnect(ctx)
 	defer cancelContext()
@@ -183,13 +312,13 @@ func (m *MongoDBProxy) HealthCheck() (*HealthResponse, error) {
 	}, nil
 }
 
-// GetURI returns the URI for the database connection.
+// GetURI returns the URI for the database connection, without its password.
 func (m *MongoDBProxy) GetURI() string {
-	return m.URI
+	return RedactURI(m.uri)
 }
 
 func (m *MongoDBProxy) getConnection() (*mongo.Client, context.Context, context.CancelFunc, error) {
-	client, err := mongo.NewClient(options.Client().ApplyURI(m.URI))
+	client, err := mongo.NewClient(m.clientOptions)
 	if err != nil {
 		log.Error().
 			Err(err).
@@ -209,11 +338,89 @@ func (m *MongoDBProxy) getConnection() (*mongo.Client, context.Context, context.
 	return client, ctx, cancel, nil
 }
 
+// encryptFilter converts filter to a document with the encrypted values of the deterministic fields.
+// Collections without encrypted fields keep the original filter.
+func (m *MongoDBProxy) encryptFilter(dbName, collName string, filter interface{}) (interface{}, error) {
+	if len(m.encryption.Fields(dbName, collName)) == 0 || filter == nil {
+		return filter, nil
+	}
+
+	document, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	err = m.encryption.EncryptFilter(dbName, collName, document)
+	return document, err
+}
+
+// encryptPipeline encrypts the query of a leading $geoNear stage, like the filters of Find, since it is the
+// filter of the geospatial queries. The filters of other stages are not encrypted.
+func (m *MongoDBProxy) encryptPipeline(dbName, collName string, pipeline interface{}) (interface{}, error) {
+	if len(m.encryption.Fields(dbName, collName)) == 0 || pipeline == nil {
+		return pipeline, nil
+	}
+
+	var decoded struct {
+		Stages []bson.Raw `bson:"stages"`
+	}
+	raw, err := bson.Marshal(bson.D{{Key: "stages", Value: pipeline}})
+	if err == nil {
+		err = bson.Unmarshal(raw, &decoded)
+	}
+	if err != nil || len(decoded.Stages) == 0 {
+		// MongoDB reports what's wrong with the pipeline.
+		return pipeline, nil
+	}
+
+	first, err := toDocument(decoded.Stages[0])
+	if err != nil {
+		return nil, err
+	}
+	geoNear, ok := first["$geoNear"].(bson.M)
+	if !ok || geoNear["query"] == nil {
+		return pipeline, nil
+	}
+	geoNear["query"], err = m.encryptFilter(dbName, collName, geoNear["query"])
+	if err != nil {
+		return nil, err
+	}
+
+	// The other stages are kept as they are, since the order of their fields may matter, like in $sort.
+	stages := bson.A{first}
+	for _, stage := range decoded.Stages[1:] {
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// encryptUpdate converts entry to a document with the values written to encrypted fields already encrypted.
+func (m *MongoDBProxy) encryptUpdate(dbName, collName string, entry interface{}) (interface{}, error) {
+	if len(m.encryption.Fields(dbName, collName)) == 0 {
+		return entry, nil
+	}
+
+	document, err := toDocument(entry)
+	if err != nil {
+		return nil, err
+	}
+	err = m.encryption.EncryptUpdate(dbName, collName, document)
+	return document, err
+}
+
+func isIndexNotFound(err error) bool {
+	var serverErr mongo.ServerError
+	return errors.As(err, &serverErr) && serverErr.HasErrorCode(27)
+}
+
 func getUserCredentialForConnectionString(username, password string) string {
 	if len(strings.TrimSpace(username)) == 0 || len(strings.TrimSpace(password)) == 0 {
+		// Never log the values: a lone password is still a secret.
+		missing := "password"
+		if len(strings.TrimSpace(username)) == 0 {
+			missing = "username"
+		}
 		log.Warn().
-			Str("username", username).
-			Str("password", password).
+			Str("missing", missing).
 			Msg("ignoring username/password because at least one is empty")
 		return ""
 	}
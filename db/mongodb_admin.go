@@ -0,0 +1,271 @@
+package db
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListCollections returns all collections in database, with their statistics and indexes.
+func (m *MongoDBProxy) ListCollections(dbName string) (*CollectionsResponse, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	database := client.Database(dbName)
+	cursor, err := database.ListCollections(ctx, bson.D{})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Msg("failed to list collections")
+		return nil, err
+	}
+
+	var specs []struct {
+		Name    string `bson:"name"`
+		Type    string `bson:"type"`
+		Options bson.M `bson:"options"`
+	}
+	err = cursor.All(ctx, &specs)
+	if err != nil {
+		return nil, err
+	}
+
+	collections := make([]CollectionDetails, 0, len(specs))
+	for _, spec := range specs {
+		details := CollectionDetails{
+			Name:    spec.Name,
+			Type:    spec.Type,
+			Indexes: []IndexDetails{},
+		}
+		if capped, ok := spec.Options["capped"].(bool); ok {
+			details.Capped = capped
+		}
+
+		// Views have neither storage nor indexes of their own.
+		if spec.Type == "view" {
+			collections = append(collections, details)
+			continue
+		}
+
+		var stats struct {
+			Count          int64 `bson:"count"`
+			Size           int64 `bson:"size"`
+			StorageSize    int64 `bson:"storageSize"`
+			TotalIndexSize int64 `bson:"totalIndexSize"`
+		}
+		err = database.RunCommand(ctx, bson.D{{Key: "collStats", Value: spec.Name}}).Decode(&stats)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("database", dbName).
+				Str("collection", spec.Name).
+				Msg("failed to get collection statistics")
+		} else {
+			details.Count = stats.Count
+			details.Size = stats.Size
+			details.StorageSize = stats.StorageSize
+			details.TotalIndexSize = stats.TotalIndexSize
+		}
+
+		indexCursor, err := database.Collection(spec.Name).Indexes().List(ctx)
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("database", dbName).
+				Str("collection", spec.Name).
+				Msg("failed to list indexes")
+		} else {
+			var indexes []struct {
+				Name   string `bson:"name"`
+				Key    bson.M `bson:"key"`
+				Unique bool   `bson:"unique"`
+			}
+			if err = indexCursor.All(ctx, &indexes); err == nil {
+				for _, index := range indexes {
+					details.Indexes = append(details.Indexes, IndexDetails{
+						Name:   index.Name,
+						Keys:   index.Key,
+						Unique: index.Unique,
+					})
+				}
+			}
+		}
+
+		collections = append(collections, details)
+	}
+
+	return &CollectionsResponse{
+		Database:    dbName,
+		Collections: collections,
+	}, nil
+}
+
+// CreateCollection explicitly creates collName in dbName, applying the options in request.
+func (m *MongoDBProxy) CreateCollection(dbName, collName string, request CreateCollectionRequest) (*AdminResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	err = client.Database(dbName).CreateCollection(ctx, collName, getCreateCollectionOptions(request))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("collection", collName).
+			Msg("failed to create collection")
+		return nil, err
+	}
+
+	log.Info().
+		Str("database", dbName).
+		Str("collection", collName).
+		Msg("created new collection")
+	return &AdminResponse{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  "create",
+	}, nil
+}
+
+// RenameCollection changes the name of collName to request.To, inside the same database.
+func (m *MongoDBProxy) RenameCollection(dbName, collName string, request RenameCollectionRequest) (*AdminResponse, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	command := bson.D{
+		{Key: "renameCollection", Value: fmt.Sprintf("%s.%s", dbName, collName)},
+		{Key: "to", Value: fmt.Sprintf("%s.%s", dbName, request.To)},
+		{Key: "dropTarget", Value: request.DropTarget},
+	}
+	err = client.Database("admin").RunCommand(ctx, command).Err()
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("collection", collName).
+			Str("to", request.To).
+			Msg("failed to rename collection")
+		return nil, err
+	}
+
+	log.Info().
+		Str("database", dbName).
+		Str("collection", collName).
+		Str("to", request.To).
+		Msg("renamed collection")
+	return &AdminResponse{
+		Database:   dbName,
+		Collection: request.To,
+		Operation:  "rename",
+	}, nil
+}
+
+// DropCollection removes collName, and all its documents, from dbName.
+func (m *MongoDBProxy) DropCollection(dbName, collName string) (*AdminResponse, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	err = client.Database(dbName).Collection(collName).Drop(ctx)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("collection", collName).
+			Msg("failed to drop collection")
+		return nil, err
+	}
+
+	log.Warn().
+		Str("database", dbName).
+		Str("collection", collName).
+		Msg("dropped collection")
+	return &AdminResponse{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  "drop",
+	}, nil
+}
+
+// DropDatabase removes dbName and all its collections.
+func (m *MongoDBProxy) DropDatabase(dbName string) (*AdminResponse, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	err = client.Database(dbName).Drop(ctx)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Msg("failed to drop database")
+		return nil, err
+	}
+
+	log.Warn().
+		Str("database", dbName).
+		Msg("dropped database")
+	return &AdminResponse{
+		Database:  dbName,
+		Operation: "dropDatabase",
+	}, nil
+}
+
+func getCreateCollectionOptions(request CreateCollectionRequest) *options.CreateCollectionOptions {
+	opts := options.CreateCollection()
+
+	if request.Capped {
+		opts.SetCapped(true).SetSizeInBytes(request.SizeInBytes)
+		if request.MaxDocuments > 0 {
+			opts.SetMaxDocuments(request.MaxDocuments)
+		}
+	}
+
+	if request.Validator != nil {
+		opts.SetValidator(request.Validator)
+	}
+	if len(request.ValidationLevel) > 0 {
+		opts.SetValidationLevel(request.ValidationLevel)
+	}
+	if len(request.ValidationAction) > 0 {
+		opts.SetValidationAction(request.ValidationAction)
+	}
+
+	if request.TimeSeries != nil {
+		timeSeries := options.TimeSeries().SetTimeField(request.TimeSeries.TimeField)
+		if len(request.TimeSeries.MetaField) > 0 {
+			timeSeries.SetMetaField(request.TimeSeries.MetaField)
+		}
+		if len(request.TimeSeries.Granularity) > 0 {
+			timeSeries.SetGranularity(request.TimeSeries.Granularity)
+		}
+		opts.SetTimeSeriesOptions(timeSeries)
+	}
+
+	return opts
+}
@@ -0,0 +1,82 @@
+package db
+
+import (
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Explain returns the execution plan MongoDB chooses for the operation in request, without changing any document.
+func (m *MongoDBProxy) Explain(dbName, collName string, request ExplainRequest) (*ExplainResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	command := bson.D{
+		{Key: "explain", Value: getExplainedCommand(collName, request)},
+		{Key: "verbosity", Value: request.Verbosity},
+	}
+
+	var plan bson.D
+	err = client.Database(dbName).RunCommand(ctx, command).Decode(&plan)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("collection", collName).
+			Str("operation", request.Operation).
+			Msg("failed to explain operation")
+		return nil, err
+	}
+
+	return &ExplainResponse{
+		Operation: request.Operation,
+		Verbosity: request.Verbosity,
+		Plan:      plan,
+	}, nil
+}
+
+func getExplainedCommand(collName string, request ExplainRequest) bson.D {
+	switch request.Operation {
+	case "aggregate":
+		return bson.D{
+			{Key: "aggregate", Value: collName},
+			{Key: "pipeline", Value: request.Pipeline},
+			{Key: "cursor", Value: bson.M{}},
+		}
+	case "count":
+		return bson.D{
+			{Key: "count", Value: collName},
+			{Key: "query", Value: request.Filter},
+		}
+	case "update":
+		return bson.D{
+			{Key: "update", Value: collName},
+			{Key: "updates", Value: bson.A{
+				bson.M{"q": request.Filter, "u": request.Update, "multi": true},
+			}},
+		}
+	default:
+		command := bson.D{
+			{Key: "find", Value: collName},
+			{Key: "filter", Value: request.Filter},
+		}
+		if request.Sort != nil {
+			command = append(command, bson.E{Key: "sort", Value: request.Sort})
+		}
+		if request.Projection != nil {
+			command = append(command, bson.E{Key: "projection", Value: request.Projection})
+		}
+		if request.Limit > 0 {
+			command = append(command, bson.E{Key: "limit", Value: request.Limit})
+		}
+		return command
+	}
+}
@@ -0,0 +1,221 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/gridfs"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ErrFileNotFound is returned when there is no file with the given ID in the bucket.
+var ErrFileNotFound = errors.New("file not found")
+
+// contentTypeField is where the content type of a file is kept, inside its metadata.
+const contentTypeField = "contentType"
+
+// UploadFile stores content in the GridFS bucket of dbName, and returns the details of the new file.
+func (m *MongoDBProxy) UploadFile(dbName, bucketName, filename, contentType string, metadata bson.M, content io.Reader) (*FileInfo, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	bucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		metadata = bson.M{}
+	}
+	metadata[contentTypeField] = contentType
+
+	id, err := bucket.UploadFromStream(filename, content, options.GridFSUpload().SetMetadata(metadata))
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("bucket", bucketName).
+			Str("filename", filename).
+			Msg("failed to upload file")
+		return nil, err
+	}
+
+	return m.findFile(ctx, bucket, id)
+}
+
+// DownloadFile opens the file id in the GridFS bucket of dbName.
+func (m *MongoDBProxy) DownloadFile(dbName, bucketName, id string) (*FileDownload, error) {
+	fileID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	release := func() error {
+		defer cancelContext()
+		return client.Disconnect(ctx)
+	}
+
+	bucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	stream, err := bucket.OpenDownloadStream(fileID)
+	if err != nil {
+		release()
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrFileNotFound
+		}
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("bucket", bucketName).
+			Str("id", id).
+			Msg("failed to open file")
+		return nil, err
+	}
+
+	return NewFileDownload(getFileInfo(stream.GetFile()), stream, stream.Skip, func() error {
+		stream.Close()
+		return release()
+	}), nil
+}
+
+// ListFiles returns the details of all files in the GridFS bucket of dbName.
+func (m *MongoDBProxy) ListFiles(dbName, bucketName string) (*FilesResponse, error) {
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	bucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+
+	cursor, err := bucket.Find(bson.M{})
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("bucket", bucketName).
+			Msg("failed to list files")
+		return nil, err
+	}
+
+	var files []gridfs.File
+	err = cursor.All(ctx, &files)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &FilesResponse{
+		Files: make([]FileInfo, 0, len(files)),
+	}
+	for i := range files {
+		response.Files = append(response.Files, getFileInfo(&files[i]))
+	}
+	return response, nil
+}
+
+// DeleteFile removes the file id, and all its chunks, from the GridFS bucket of dbName.
+func (m *MongoDBProxy) DeleteFile(dbName, bucketName, id string) (*DeleteResponse, error) {
+	fileID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrFileNotFound
+	}
+
+	client, ctx, cancelContext, err := m.getConnection()
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect(ctx)
+	defer cancelContext()
+
+	bucket, err := gridfs.NewBucket(client.Database(dbName), options.GridFSBucket().SetName(bucketName))
+	if err != nil {
+		return nil, err
+	}
+
+	err = bucket.Delete(fileID)
+	if err != nil {
+		if errors.Is(err, gridfs.ErrFileNotFound) {
+			return nil, ErrFileNotFound
+		}
+		log.Error().
+			Err(err).
+			Str("database", dbName).
+			Str("bucket", bucketName).
+			Str("id", id).
+			Msg("failed to delete file")
+		return nil, err
+	}
+
+	return &DeleteResponse{
+		DeletedCount: 1,
+	}, nil
+}
+
+func (m *MongoDBProxy) findFile(ctx context.Context, bucket *gridfs.Bucket, id interface{}) (*FileInfo, error) {
+	cursor, err := bucket.Find(bson.M{"_id": id})
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	if !cursor.Next(ctx) {
+		return nil, ErrFileNotFound
+	}
+
+	var file gridfs.File
+	err = cursor.Decode(&file)
+	if err != nil {
+		return nil, err
+	}
+
+	info := getFileInfo(&file)
+	return &info, nil
+}
+
+func getFileInfo(file *gridfs.File) FileInfo {
+	info := FileInfo{
+		Filename:   file.Name,
+		Length:     file.Length,
+		ChunkSize:  file.ChunkSize,
+		UploadDate: file.UploadDate,
+	}
+
+	if id, ok := file.ID.(primitive.ObjectID); ok {
+		info.ID = id.Hex()
+	}
+
+	if len(file.Metadata) > 0 {
+		var metadata bson.M
+		if bson.Unmarshal(file.Metadata, &metadata) == nil {
+			if contentType, ok := metadata[contentTypeField].(string); ok {
+				info.ContentType = contentType
+			}
+			delete(metadata, contentTypeField)
+			if len(metadata) > 0 {
+				info.Metadata = metadata
+			}
+		}
+	}
+
+	return info
+}
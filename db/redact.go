@@ -0,0 +1,495 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrProtectedField is returned when a client filters by, or writes to, a field it may not see.
+var ErrProtectedField = errors.New("field is protected")
+
+// firstStages must be the first stage of a pipeline, so the redaction comes right after them.
+var firstStages = map[string]bool{
+	"$geoNear":      true,
+	"$search":       true,
+	"$collStats":    true,
+	"$indexStats":   true,
+	"$changeStream": true,
+}
+
+// RedactingProxy hides fields from the results of another Proxy, and refuses filters and writes that use them.
+// The hidden fields of each namespace are given by HiddenFields; the other methods are passed through.
+type RedactingProxy struct {
+	Proxy
+	HiddenFields func(database, collection string) []string
+}
+
+// NewRedactingProxy wraps proxy, hiding the fields returned by hiddenFields.
+func NewRedactingProxy(proxy Proxy, hiddenFields func(database, collection string) []string) *RedactingProxy {
+	return &RedactingProxy{
+		Proxy:        proxy,
+		HiddenFields: hiddenFields,
+	}
+}
+
+// Aggregate runs the aggregation without the hidden fields, of the collection and of those it joins.
+func (r *RedactingProxy) Aggregate(database, collection string, filter interface{}) (*AggregateResponse, error) {
+	pipeline, err := r.redactPipeline(database, collection, filter)
+	if err != nil {
+		return nil, err
+	}
+	return r.Proxy.Aggregate(database, collection, pipeline)
+}
+
+// AggregatePipeline runs the pipeline without the hidden fields, of the collection and of those it joins.
+// The hidden fields of the collection are also removed from the results.
+func (r *RedactingProxy) AggregatePipeline(database, collection string, pipeline interface{}) (*FindResponse, error) {
+	redacted, err := r.redactPipeline(database, collection, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.Proxy.AggregatePipeline(database, collection, redacted)
+	if hidden := r.HiddenFields(database, collection); result != nil && len(hidden) > 0 {
+		redactDocuments(result.Results, hidden)
+	}
+	return result, err
+}
+
+// Find refuses filters by hidden fields, and removes them from the results.
+func (r *RedactingProxy) Find(database, collection string, filter interface{}) (*FindResponse, error) {
+	hidden := r.HiddenFields(database, collection)
+	if len(hidden) == 0 {
+		return r.Proxy.Find(database, collection, filter)
+	}
+
+	err := checkFilter(filter, hidden)
+	if err != nil {
+		return nil, err
+	}
+	result, err := r.Proxy.Find(database, collection, filter)
+	if result != nil {
+		redactDocuments(result.Results, hidden)
+	}
+	return result, err
+}
+
+// Search removes the hidden fields from the results.
+func (r *RedactingProxy) Search(database, collection string, request SearchRequest) (*SearchResponse, error) {
+	hidden := r.HiddenFields(database, collection)
+	result, err := r.Proxy.Search(database, collection, request)
+	if result != nil && len(hidden) > 0 {
+		redactDocuments(result.Results, hidden)
+	}
+	return result, err
+}
+
+// Insert refuses entries with hidden fields.
+func (r *RedactingProxy) Insert(database, collection string, entry Quote) (*InsertResponse, error) {
+	hidden := r.HiddenFields(database, collection)
+	if len(hidden) > 0 {
+		document, err := toDocument(entry)
+		if err != nil {
+			return nil, err
+		}
+		err = checkWrittenFields(document, hidden)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.Proxy.Insert(database, collection, entry)
+}
+
+// Update refuses filters by hidden fields and updates that change them.
+func (r *RedactingProxy) Update(database, collection string, filter, entry interface{}) (*UpdateResponse, error) {
+	hidden := r.HiddenFields(database, collection)
+	if len(hidden) > 0 {
+		err := checkFilter(filter, hidden)
+		if err == nil {
+			err = checkUpdate(entry, hidden)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.Proxy.Update(database, collection, filter, entry)
+}
+
+// Explain refuses to explain operations that use hidden fields: the statistics of the plan, like the number of
+// documents returned, would tell what the hidden fields hold.
+func (r *RedactingProxy) Explain(database, collection string, request ExplainRequest) (*ExplainResponse, error) {
+	hidden := r.HiddenFields(database, collection)
+	var err error
+	if len(hidden) > 0 {
+		err = checkFilter(request.Filter, hidden)
+		if err == nil && request.Update != nil {
+			err = checkUpdate(request.Update, hidden)
+		}
+	}
+	if err == nil && request.Pipeline != nil {
+		request.Pipeline, err = r.redactPipeline(database, collection, request.Pipeline)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return r.Proxy.Explain(database, collection, request)
+}
+
+// checkUpdate refuses updates that change hidden fields.
+func checkUpdate(entry interface{}, hidden []string) error {
+	update, err := toDocument(entry)
+	if err != nil {
+		return err
+	}
+	for operator, fields := range update {
+		document, ok := fields.(bson.M)
+		if !strings.HasPrefix(operator, "$") || !ok {
+			// A replacement document.
+			return checkWrittenFields(update, hidden)
+		}
+		err = checkWrittenFields(document, hidden)
+		if err == nil && operator == "$rename" {
+			// The new names are written too.
+			renamed := bson.M{}
+			for _, to := range document {
+				if name, ok := to.(string); ok {
+					renamed[name] = true
+				}
+			}
+			err = checkWrittenFields(renamed, hidden)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// redactPipeline adds an exclusion projection of the hidden fields of collection to the start of pipeline,
+// so no stage can read them, and hides those of the collections it joins (see redactJoins).
+// A leading $geoNear runs before the projection, so it may not use hidden fields for its key or query.
+func (r *RedactingProxy) redactPipeline(database, collection string, pipeline interface{}) (interface{}, error) {
+	var stages []interface{}
+	switch p := pipeline.(type) {
+	case []bson.D:
+		for _, stage := range p {
+			stages = append(stages, stage)
+		}
+	case []bson.M:
+		for _, stage := range p {
+			stages = append(stages, stage)
+		}
+	case bson.A:
+		stages = p
+	case []interface{}:
+		stages = p
+	default:
+		// We can't inspect the pipeline, so the redaction can only be done in the results.
+		return pipeline, nil
+	}
+
+	hidden := r.HiddenFields(database, collection)
+	result := make(bson.A, 0, len(stages)+1)
+	position := 0
+	if len(stages) > 0 && firstStages[stageName(stages[0])] {
+		if stageName(stages[0]) == "$geoNear" && len(hidden) > 0 {
+			if err := checkGeoNear(stages[0], hidden); err != nil {
+				return nil, err
+			}
+		}
+		result = append(result, stages[0])
+		position = 1
+	}
+	if len(hidden) > 0 {
+		projection := bson.D{}
+		for _, field := range hidden {
+			projection = append(projection, bson.E{Key: field, Value: 0})
+		}
+		result = append(result, bson.D{{Key: "$project", Value: projection}})
+	}
+
+	joined, err := r.redactJoins(database, stages[position:])
+	if err != nil {
+		return nil, err
+	}
+	return append(result, joined...), nil
+}
+
+// redactJoins hides the hidden fields of the collections joined by stages: the sub-pipelines of $lookup and
+// $unionWith get their own exclusion projection, and $graphLookup, which has no sub-pipeline, is refused.
+// The sub-pipelines of $facet run over the same documents, so only their joins are redacted.
+func (r *RedactingProxy) redactJoins(database string, stages []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, 0, len(stages))
+	for _, stage := range stages {
+		name := stageName(stage)
+		switch name {
+		case "$lookup", "$unionWith", "$graphLookup", "$facet":
+			document, err := toDocument(stage)
+			if err != nil {
+				return nil, err
+			}
+			spec, err := r.redactJoin(database, name, document[name])
+			if err != nil {
+				return nil, err
+			}
+			stage = bson.D{{Key: name, Value: spec}}
+		}
+		result = append(result, stage)
+	}
+	return result, nil
+}
+
+// redactJoin returns the spec of the join stage called name, without the hidden fields of the joined collection.
+func (r *RedactingProxy) redactJoin(database, name string, spec interface{}) (interface{}, error) {
+	switch name {
+	case "$lookup":
+		lookup, _ := spec.(bson.M)
+		from, _ := lookup["from"].(string)
+		hidden := r.HiddenFields(database, from)
+		if foreign, ok := lookup["foreignField"].(string); ok {
+			if field := overlappingField(foreign, hidden); len(field) > 0 {
+				return nil, fmt.Errorf("%w: %s", ErrProtectedField, field)
+			}
+		}
+		if len(hidden) == 0 && lookup["pipeline"] == nil {
+			return lookup, nil
+		}
+		// MongoDB 5.0 accepts a pipeline next to localField and foreignField.
+		pipeline, err := r.redactPipeline(database, from, subPipeline(lookup["pipeline"]))
+		lookup["pipeline"] = pipeline
+		return lookup, err
+	case "$unionWith":
+		var union bson.M
+		if coll, ok := spec.(string); ok {
+			union = bson.M{"coll": coll}
+		} else {
+			union, _ = spec.(bson.M)
+		}
+		coll, _ := union["coll"].(string)
+		if len(r.HiddenFields(database, coll)) == 0 && union["pipeline"] == nil {
+			return spec, nil
+		}
+		pipeline, err := r.redactPipeline(database, coll, subPipeline(union["pipeline"]))
+		union["pipeline"] = pipeline
+		return union, err
+	case "$graphLookup":
+		graphLookup, _ := spec.(bson.M)
+		from, _ := graphLookup["from"].(string)
+		if len(r.HiddenFields(database, from)) > 0 {
+			return nil, fmt.Errorf("%w: $graphLookup can't join %s, which has hidden fields", ErrProtectedField, from)
+		}
+		return graphLookup, nil
+	case "$facet":
+		facets, _ := spec.(bson.M)
+		for facet, pipeline := range facets {
+			stages, _ := pipeline.(bson.A)
+			redacted, err := r.redactJoins(database, stages)
+			if err != nil {
+				return nil, err
+			}
+			facets[facet] = bson.A(redacted)
+		}
+		return facets, nil
+	}
+	return spec, nil
+}
+
+// subPipeline returns the sub-pipeline of a join stage, which may be missing.
+func subPipeline(pipeline interface{}) interface{} {
+	if pipeline == nil {
+		return bson.A{}
+	}
+	return pipeline
+}
+
+// checkGeoNear refuses $geoNear stages that find the distances from, or filter by, hidden fields.
+func checkGeoNear(stage interface{}, hidden []string) error {
+	document, err := toDocument(stage)
+	if err != nil {
+		return err
+	}
+	spec, _ := document["$geoNear"].(bson.M)
+	if key, ok := spec["key"].(string); ok {
+		if field := overlappingField(key, hidden); len(field) > 0 {
+			return fmt.Errorf("%w: %s", ErrProtectedField, field)
+		}
+	}
+	return checkFilter(spec["query"], hidden)
+}
+
+func stageName(stage interface{}) string {
+	switch s := stage.(type) {
+	case bson.D:
+		if len(s) > 0 {
+			return s[0].Key
+		}
+	case bson.M:
+		for key := range s {
+			return key
+		}
+	case map[string]interface{}:
+		for key := range s {
+			return key
+		}
+	}
+	return ""
+}
+
+// checkFilter refuses filters that use hidden fields, at the top level, inside $and, $or and $nor, or in the
+// aggregation expressions of $expr. Other top level operators, like $where, can't be inspected and are refused.
+func checkFilter(filter interface{}, hidden []string) error {
+	document, err := toDocument(filter)
+	if err != nil || document == nil {
+		return err
+	}
+
+	for key, value := range document {
+		switch key {
+		case "$and", "$or", "$nor":
+			clauses, _ := value.(bson.A)
+			for _, clause := range clauses {
+				err = checkFilter(clause, hidden)
+				if err != nil {
+					return err
+				}
+			}
+		case "$expr":
+			err = checkExpression(value, hidden)
+			if err != nil {
+				return err
+			}
+		case "$comment":
+		default:
+			if strings.HasPrefix(key, "$") {
+				return fmt.Errorf("%w: %s can't be used with hidden fields", ErrProtectedField, key)
+			}
+			if field := overlappingField(key, hidden); len(field) > 0 {
+				return fmt.Errorf("%w: %s", ErrProtectedField, field)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExpression refuses aggregation expressions that read hidden fields, through field paths like "$salary",
+// the whole document ("$$ROOT" and "$$CURRENT") or the operators that take field names as strings.
+func checkExpression(expression interface{}, hidden []string) error {
+	switch e := expression.(type) {
+	case string:
+		switch {
+		case strings.HasPrefix(e, "$$ROOT") || strings.HasPrefix(e, "$$CURRENT"):
+			return fmt.Errorf("%w: %s can't be used with hidden fields", ErrProtectedField, e)
+		case strings.HasPrefix(e, "$$"):
+			return nil
+		case strings.HasPrefix(e, "$"):
+			if field := overlappingField(e[1:], hidden); len(field) > 0 {
+				return fmt.Errorf("%w: %s", ErrProtectedField, field)
+			}
+		}
+	case bson.M:
+		for key, value := range e {
+			switch key {
+			case "$getField", "$setField", "$unsetField", "$function", "$accumulator":
+				return fmt.Errorf("%w: %s can't be used with hidden fields", ErrProtectedField, key)
+			case "$literal":
+				continue
+			}
+			if err := checkExpression(value, hidden); err != nil {
+				return err
+			}
+		}
+	case bson.A:
+		for _, value := range e {
+			if err := checkExpression(value, hidden); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// checkWrittenFields refuses documents that set any hidden field. Setting a document that holds
+// a hidden field, like {"salary": {...}} when "salary.amount" is hidden, is refused too.
+func checkWrittenFields(document bson.M, hidden []string) error {
+	for key := range document {
+		if field := overlappingField(key, hidden); len(field) > 0 {
+			return fmt.Errorf("%w: %s", ErrProtectedField, field)
+		}
+	}
+	return nil
+}
+
+// overlappingField returns the hidden field that key reads or writes: the field itself, a field inside it,
+// or the document that holds it.
+func overlappingField(key string, hidden []string) string {
+	for _, field := range hidden {
+		if key == field || strings.HasPrefix(key, field+".") || strings.HasPrefix(field, key+".") {
+			return field
+		}
+	}
+	return ""
+}
+
+// redactDocuments removes the hidden fields from documents.
+func redactDocuments(documents []bson.M, hidden []string) {
+	for _, document := range documents {
+		for _, field := range hidden {
+			removeField(document, strings.Split(field, "."))
+		}
+	}
+}
+
+func removeField(value interface{}, path []string) {
+	switch v := value.(type) {
+	case bson.M:
+		removeFieldFromMap(v, path)
+	case map[string]interface{}:
+		removeFieldFromMap(v, path)
+	case bson.D:
+		for i := range v {
+			if v[i].Key != path[0] {
+				continue
+			}
+			if len(path) == 1 {
+				// Documents can't shrink in place, so the value is blanked instead.
+				v[i].Value = nil
+				continue
+			}
+			removeField(v[i].Value, path[1:])
+		}
+	case bson.A:
+		for _, item := range v {
+			removeField(item, path)
+		}
+	case []interface{}:
+		for _, item := range v {
+			removeField(item, path)
+		}
+	}
+}
+
+func removeFieldFromMap(document map[string]interface{}, path []string) {
+	if len(path) == 1 {
+		delete(document, path[0])
+		return
+	}
+	if nested, ok := document[path[0]]; ok {
+		removeField(nested, path[1:])
+	}
+}
+
+// toDocument converts value to a bson.M, by encoding it as BSON.
+func toDocument(value interface{}) (bson.M, error) {
+	if value == nil {
+		return nil, nil
+	}
+	raw, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.M
+	err = bson.Unmarshal(raw, &document)
+	return document, err
+}
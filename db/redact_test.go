@@ -0,0 +1,217 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recordingProxy returns fixed documents and keeps the pipeline it received.
+type recordingProxy struct {
+	db.Proxy
+	pipeline interface{}
+	updated  bool
+}
+
+func (p *recordingProxy) documents() []bson.M {
+	return []bson.M{
+		{"name": "Ana", "email": "ana@example.com", "salary": bson.M{"amount": 100, "currency": "EUR"}},
+		{"name": "Bia", "salary": bson.A{bson.M{"amount": 90, "year": 2020}}},
+	}
+}
+
+func (p *recordingProxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
+	return &db.FindResponse{Results: p.documents()}, nil
+}
+
+func (p *recordingProxy) AggregatePipeline(database, collection string, pipeline interface{}) (*db.FindResponse, error) {
+	p.pipeline = pipeline
+	return &db.FindResponse{Results: p.documents()}, nil
+}
+
+func (p *recordingProxy) Explain(database, collection string, request db.ExplainRequest) (*db.ExplainResponse, error) {
+	p.pipeline = request.Pipeline
+	return &db.ExplainResponse{}, nil
+}
+
+func (p *recordingProxy) Update(database, collection string, filter, entry interface{}) (*db.UpdateResponse, error) {
+	p.updated = true
+	return &db.UpdateResponse{}, nil
+}
+
+func hideFields(database, collection string) []string {
+	if collection == "employees" {
+		return []string{"email", "salary.amount"}
+	}
+	return nil
+}
+
+func TestRedactingProxyFind(t *testing.T) {
+	proxy := db.NewRedactingProxy(&recordingProxy{}, hideFields)
+
+	result, err := proxy.Find("hr", "employees", bson.M{"name": "Ana"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bson.M{
+		{"name": "Ana", "salary": bson.M{"currency": "EUR"}},
+		{"name": "Bia", "salary": bson.A{bson.M{"year": 2020}}},
+	}, result.Results)
+
+	result, err = proxy.Find("hr", "teams", bson.M{})
+	assert.NoError(t, err)
+	assert.Equal(t, "ana@example.com", result.Results[0]["email"], "other collections should not be redacted")
+
+	for _, filter := range []interface{}{
+		bson.M{"email": "ana@example.com"},
+		bson.D{{Key: "salary.amount", Value: bson.M{"$gt": 50}}},
+		bson.M{"$or": bson.A{bson.M{"name": "Ana"}, bson.M{"salary": bson.M{"amount": 100}}}},
+		bson.M{"$expr": bson.M{"$gt": bson.A{"$salary.amount", 50}}},
+		bson.M{"$expr": bson.M{"$eq": bson.A{bson.M{"$getField": "email"}, "ana@example.com"}}},
+		bson.M{"$expr": bson.M{"$in": bson.A{"ana@example.com", bson.M{"$objectToArray": "$$ROOT"}}}},
+		bson.M{"$where": "this.email == 'ana@example.com'"},
+	} {
+		_, err = proxy.Find("hr", "employees", filter)
+		assert.True(t, errors.Is(err, db.ErrProtectedField), "filter %v should be refused", filter)
+	}
+
+	_, err = proxy.Find("hr", "employees", bson.M{"$expr": bson.M{"$eq": bson.A{"$name", bson.M{"$literal": "$email"}}}})
+	assert.NoError(t, err)
+}
+
+func TestRedactingProxyPipeline(t *testing.T) {
+	recorder := &recordingProxy{}
+	proxy := db.NewRedactingProxy(recorder, hideFields)
+
+	_, err := proxy.AggregatePipeline("hr", "employees", []bson.D{
+		{{Key: "$geoNear", Value: bson.M{"near": bson.A{0, 0}}}},
+		{{Key: "$limit", Value: 1}},
+	})
+	assert.NoError(t, err)
+
+	output, err := bson.MarshalExtJSON(bson.D{{Key: "pipeline", Value: recorder.pipeline}}, false, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"pipeline":[{"$geoNear":{"near":[0,0]}},{"$project":{"email":0,"salary.amount":0}},{"$limit":1}]}`, string(output))
+
+	// $geoNear runs before the redaction, so it may not use hidden fields.
+	for _, geoNear := range []bson.M{
+		{"near": bson.A{0, 0}, "key": "salary.amount"},
+		{"near": bson.A{0, 0}, "query": bson.M{"email": "ana@example.com"}},
+	} {
+		_, err = proxy.AggregatePipeline("hr", "employees", bson.A{bson.M{"$geoNear": geoNear}})
+		assert.True(t, errors.Is(err, db.ErrProtectedField), "stage %v should be refused", geoNear)
+	}
+}
+
+func TestRedactingProxyJoins(t *testing.T) {
+	// teams has no hidden fields, but the pipelines that join employees may not read theirs.
+	projection := bson.D{{Key: "$project", Value: bson.D{{Key: "email", Value: 0}, {Key: "salary.amount", Value: 0}}}}
+	testCases := map[string]struct {
+		stage    bson.M
+		expected interface{}
+	}{
+		"lookup": {
+			stage:    bson.M{"$lookup": bson.M{"from": "employees", "localField": "_id", "foreignField": "team", "as": "members"}},
+			expected: bson.A{projection},
+		},
+		"lookup pipeline": {
+			stage:    bson.M{"$lookup": bson.M{"from": "employees", "pipeline": bson.A{bson.M{"$limit": 1}}, "as": "members"}},
+			expected: bson.A{projection, bson.M{"$limit": int32(1)}},
+		},
+		"unionWith": {
+			stage:    bson.M{"$unionWith": "employees"},
+			expected: bson.A{projection},
+		},
+		"nested lookup": {
+			stage: bson.M{"$lookup": bson.M{"from": "offices", "as": "offices", "pipeline": bson.A{
+				bson.M{"$unionWith": bson.M{"coll": "employees"}},
+			}}},
+			expected: bson.A{bson.D{{Key: "$unionWith", Value: bson.M{"coll": "employees", "pipeline": bson.A{projection}}}}},
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &recordingProxy{}
+			proxy := db.NewRedactingProxy(recorder, hideFields)
+
+			_, err := proxy.AggregatePipeline("hr", "teams", bson.A{tc.stage})
+			if !assert.NoError(t, err) {
+				return
+			}
+			pipeline := recorder.pipeline.(bson.A)
+			if assert.Len(t, pipeline, 1, "teams should not get a projection") {
+				stage := pipeline[0].(bson.D)[0]
+				assert.Equal(t, tc.expected, stage.Value.(bson.M)["pipeline"])
+			}
+		})
+	}
+
+	// Joins of collections without hidden fields are left alone.
+	recorder := &recordingProxy{}
+	proxy := db.NewRedactingProxy(recorder, hideFields)
+	_, err := proxy.AggregatePipeline("hr", "teams", bson.A{bson.M{"$unionWith": "offices"}})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.A{bson.D{{Key: "$unionWith", Value: "offices"}}}, recorder.pipeline)
+
+	for _, stage := range []bson.M{
+		{"$lookup": bson.M{"from": "employees", "localField": "manager", "foreignField": "email", "as": "manager"}},
+		{"$graphLookup": bson.M{"from": "employees", "startWith": "$manager", "connectFromField": "manager", "connectToField": "_id", "as": "chain"}},
+		{"$facet": bson.M{"people": bson.A{bson.M{"$graphLookup": bson.M{"from": "employees"}}}}},
+	} {
+		_, err = proxy.AggregatePipeline("hr", "teams", bson.A{stage})
+		assert.True(t, errors.Is(err, db.ErrProtectedField), "stage %v should be refused", stage)
+	}
+}
+
+func TestRedactingProxyExplain(t *testing.T) {
+	proxy := db.NewRedactingProxy(&recordingProxy{}, hideFields)
+
+	for _, request := range []db.ExplainRequest{
+		{Operation: "find", Filter: bson.M{"salary.amount": bson.M{"$gt": 50}}},
+		{Operation: "aggregate", Pipeline: bson.A{bson.M{"$geoNear": bson.M{"key": "email"}}}},
+		{Operation: "update", Filter: bson.M{"name": "Ana"}, Update: bson.M{"$set": bson.M{"email": "x"}}},
+	} {
+		_, err := proxy.Explain("hr", "employees", request)
+		assert.True(t, errors.Is(err, db.ErrProtectedField), "request %v should be refused", request)
+	}
+
+	// The joins are redacted even when the explained collection has no hidden fields.
+	recorder := &recordingProxy{}
+	proxy = db.NewRedactingProxy(recorder, hideFields)
+	_, err := proxy.Explain("hr", "teams", db.ExplainRequest{
+		Operation: "aggregate",
+		Pipeline:  bson.A{bson.M{"$unionWith": "employees"}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, bson.A{bson.D{{Key: "$unionWith", Value: bson.M{
+		"coll":     "employees",
+		"pipeline": bson.A{bson.D{{Key: "$project", Value: bson.D{{Key: "email", Value: 0}, {Key: "salary.amount", Value: 0}}}}},
+	}}}}, recorder.pipeline)
+}
+
+func TestRedactingProxyUpdate(t *testing.T) {
+	testCases := map[string]struct {
+		update    interface{}
+		protected bool
+	}{
+		"allowed":      {update: bson.D{{Key: "$set", Value: bson.M{"name": "Ana"}}}},
+		"field":        {update: bson.D{{Key: "$set", Value: bson.M{"email": "x"}}}, protected: true},
+		"parent":       {update: bson.D{{Key: "$set", Value: bson.M{"salary": bson.M{"amount": 1}}}}, protected: true},
+		"unset":        {update: bson.M{"$unset": bson.M{"salary.amount": ""}}, protected: true},
+		"renameTarget": {update: bson.M{"$rename": bson.M{"name": "email"}}, protected: true},
+		"replacement":  {update: bson.M{"name": "Ana", "email": "x"}, protected: true},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			recorder := &recordingProxy{}
+			proxy := db.NewRedactingProxy(recorder, hideFields)
+
+			_, err := proxy.Update("hr", "employees", bson.M{"name": "Ana"}, tc.update)
+			assert.Equal(t, tc.protected, errors.Is(err, db.ErrProtectedField), "unexpected error: %v", err)
+			assert.Equal(t, !tc.protected, recorder.updated, "update should only reach the database if allowed")
+		})
+	}
+}
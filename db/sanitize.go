@@ -0,0 +1,288 @@
+package db
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultDeniedOperators run JavaScript on the server, and are denied unless SanitizerConfig says otherwise.
+var DefaultDeniedOperators = []string{"$where", "$function", "$accumulator"}
+
+// DefaultDeniedStages write to other collections, and are denied unless SanitizerConfig says otherwise.
+var DefaultDeniedStages = []string{"$out", "$merge"}
+
+// lookupStages read from other collections, given in the field "from" (or "coll", for $unionWith).
+var lookupStages = map[string]bool{
+	"$lookup":      true,
+	"$graphLookup": true,
+	"$unionWith":   true,
+}
+
+// SanitizerConfig defines which operators and stages clients may not send in filters, updates and pipelines.
+type SanitizerConfig struct {
+	// DeniedOperators may not appear anywhere. If nil, DefaultDeniedOperators is used.
+	DeniedOperators []string
+	// DeniedStages may not appear anywhere in pipelines. If nil, DefaultDeniedStages is used.
+	DeniedStages []string
+	// Strip removes the denied operators and stages, instead of rejecting the whole request.
+	// JavaScript values and lookups into forbidden namespaces are always rejected.
+	Strip bool
+	// Namespace, if defined, checks the collections read by $lookup, $graphLookup and $unionWith,
+	// and returns the real name of the collection.
+	Namespace func(database, collection string) (string, error)
+}
+
+// SanitizeError tells which operator was blocked, and where it was found.
+type SanitizeError struct {
+	Operator string
+	Path     string
+	Reason   string
+}
+
+func (e *SanitizeError) Error() string {
+	return fmt.Sprintf("%s is not allowed at %s: %s", e.Operator, e.Path, e.Reason)
+}
+
+// Sanitizer inspects filters, updates and pipelines sent by clients before they reach MongoDB.
+type Sanitizer struct {
+	denied    map[string]string
+	strip     bool
+	namespace func(database, collection string) (string, error)
+}
+
+// NewSanitizer creates a sanitizer with the policy in config.
+func NewSanitizer(config SanitizerConfig) *Sanitizer {
+	operators := config.DeniedOperators
+	if operators == nil {
+		operators = DefaultDeniedOperators
+	}
+	stages := config.DeniedStages
+	if stages == nil {
+		stages = DefaultDeniedStages
+	}
+
+	s := &Sanitizer{
+		denied:    map[string]string{},
+		strip:     config.Strip,
+		namespace: config.Namespace,
+	}
+	for _, operator := range operators {
+		s.denied[operator] = "operator is denied"
+	}
+	for _, stage := range stages {
+		s.denied[stage] = "stage is denied"
+	}
+	return s
+}
+
+// WithNamespace returns a copy of the sanitizer that checks lookups with namespace.
+func (s *Sanitizer) WithNamespace(namespace func(database, collection string) (string, error)) *Sanitizer {
+	if s == nil {
+		return nil
+	}
+	copied := *s
+	copied.namespace = namespace
+	return &copied
+}
+
+// Sanitize returns value (a filter, an update or a pipeline, named as name in errors) without the denied
+// operators, or a *SanitizeError. Lookups into other collections of database are checked too.
+func (s *Sanitizer) Sanitize(database, name string, value interface{}) (interface{}, error) {
+	if s == nil || value == nil {
+		return value, nil
+	}
+	return s.sanitize(database, name, value)
+}
+
+func (s *Sanitizer) sanitize(database, path string, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case bson.D:
+		result := make(bson.D, 0, len(v))
+		for _, element := range v {
+			sanitized, keep, err := s.sanitizeElement(database, path, element.Key, element.Value)
+			if err != nil {
+				return nil, err
+			}
+			if keep {
+				result = append(result, bson.E{Key: element.Key, Value: sanitized})
+			}
+		}
+		return result, nil
+	case bson.M:
+		result, err := s.sanitizeMap(database, path, v)
+		return bson.M(result), err
+	case map[string]interface{}:
+		return s.sanitizeMap(database, path, v)
+	case bson.A:
+		result, err := s.sanitizeList(database, path, v)
+		return bson.A(result), err
+	case []interface{}:
+		return s.sanitizeList(database, path, v)
+	case []bson.D:
+		result := make([]bson.D, 0, len(v))
+		for i, item := range v {
+			sanitized, err := s.sanitize(database, path+"."+strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			if d := sanitized.(bson.D); len(d) > 0 || len(item) == 0 {
+				result = append(result, d)
+			}
+		}
+		return result, nil
+	case []bson.M:
+		result := make([]bson.M, 0, len(v))
+		for i, item := range v {
+			sanitized, err := s.sanitize(database, path+"."+strconv.Itoa(i), item)
+			if err != nil {
+				return nil, err
+			}
+			if m := sanitized.(bson.M); len(m) > 0 || len(item) == 0 {
+				result = append(result, m)
+			}
+		}
+		return result, nil
+	case primitive.JavaScript, primitive.CodeWithScope, *primitive.CodeWithScope:
+		return nil, &SanitizeError{Operator: "JavaScript", Path: path, Reason: "code is not accepted"}
+	default:
+		return value, nil
+	}
+}
+
+func (s *Sanitizer) sanitizeMap(database, path string, value map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(value))
+	for key, item := range value {
+		sanitized, keep, err := s.sanitizeElement(database, path, key, item)
+		if err != nil {
+			return nil, err
+		}
+		if keep {
+			result[key] = sanitized
+		}
+	}
+	return result, nil
+}
+
+func (s *Sanitizer) sanitizeList(database, path string, value []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, 0, len(value))
+	for i, item := range value {
+		sanitized, err := s.sanitize(database, path+"."+strconv.Itoa(i), item)
+		if err != nil {
+			return nil, err
+		}
+		// Stripping a stage leaves an empty document, which is not a valid stage.
+		if isEmptyDocument(sanitized) && !isEmptyDocument(item) {
+			continue
+		}
+		result = append(result, sanitized)
+	}
+	return result, nil
+}
+
+// sanitizeElement checks a single key of a document, and tells if it should be kept.
+func (s *Sanitizer) sanitizeElement(database, path, key string, value interface{}) (interface{}, bool, error) {
+	elementPath := path + "." + key
+
+	if reason, denied := s.denied[key]; denied {
+		if s.strip {
+			return nil, false, nil
+		}
+		return nil, false, &SanitizeError{Operator: key, Path: path, Reason: reason}
+	}
+
+	if lookupStages[key] {
+		checked, err := s.checkLookup(database, elementPath, key, value)
+		if err != nil {
+			return nil, false, err
+		}
+		value = checked
+	}
+
+	sanitized, err := s.sanitize(database, elementPath, value)
+	return sanitized, true, err
+}
+
+// checkLookup makes sure a lookup stage only reads collections clients may access, and uses their real names.
+func (s *Sanitizer) checkLookup(database, path, stage string, value interface{}) (interface{}, error) {
+	if s.namespace == nil {
+		return value, nil
+	}
+
+	field := "from"
+	if stage == "$unionWith" {
+		if collection, ok := value.(string); ok {
+			return s.checkCollection(database, path, stage, collection)
+		}
+		field = "coll"
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		result := make(bson.D, len(v))
+		copy(result, v)
+		for i := range result {
+			if result[i].Key == field {
+				real, err := s.checkLookupField(database, path, stage, result[i].Value)
+				if err != nil {
+					return nil, err
+				}
+				result[i].Value = real
+			}
+		}
+		return result, nil
+	case bson.M:
+		return s.checkLookupMap(database, path, stage, field, v)
+	case map[string]interface{}:
+		return s.checkLookupMap(database, path, stage, field, v)
+	default:
+		return value, nil
+	}
+}
+
+func (s *Sanitizer) checkLookupMap(database, path, stage, field string, value map[string]interface{}) (map[string]interface{}, error) {
+	result := make(map[string]interface{}, len(value))
+	for key, item := range value {
+		result[key] = item
+	}
+	if from, ok := value[field]; ok {
+		real, err := s.checkLookupField(database, path, stage, from)
+		if err != nil {
+			return nil, err
+		}
+		result[field] = real
+	}
+	return result, nil
+}
+
+func (s *Sanitizer) checkLookupField(database, path, stage string, value interface{}) (interface{}, error) {
+	collection, ok := value.(string)
+	if !ok {
+		// Lookups into other databases are only possible with {db, coll}, which we don't accept.
+		return nil, &SanitizeError{Operator: stage, Path: path, Reason: "only collections of the same database may be read"}
+	}
+	return s.checkCollection(database, path, stage, collection)
+}
+
+func (s *Sanitizer) checkCollection(database, path, stage, collection string) (string, error) {
+	real, err := s.namespace(database, collection)
+	if err != nil {
+		return "", &SanitizeError{Operator: stage, Path: path, Reason: fmt.Sprintf("collection %q is not accessible", collection)}
+	}
+	return real, nil
+}
+
+func isEmptyDocument(value interface{}) bool {
+	switch v := value.(type) {
+	case bson.D:
+		return len(v) == 0
+	case bson.M:
+		return len(v) == 0
+	case map[string]interface{}:
+		return len(v) == 0
+	default:
+		return false
+	}
+}
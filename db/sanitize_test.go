@@ -0,0 +1,150 @@
+package db_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+type SanitizeTestCase struct {
+	name          string
+	config        db.SanitizerConfig
+	input         string
+	expected      string
+	expectedError string
+}
+
+func TestSanitize(t *testing.T) {
+	namespace := func(database, collection string) (string, error) {
+		switch collection {
+		case "authors":
+			return "authors", nil
+		case "public_authors":
+			return "okr_authors", nil
+		default:
+			return "", errors.New("not allowed")
+		}
+	}
+
+	testCases := []SanitizeTestCase{
+		{
+			name:     "allowed",
+			input:    `{"filter":{"author":"Seneca","year":{"$gt":50}}}`,
+			expected: `{"filter":{"author":"Seneca","year":{"$gt":50}}}`,
+		},
+		{
+			name:          "where",
+			input:         `{"filter":{"$where":"sleep(1000)"}}`,
+			expectedError: "$where is not allowed at filter: operator is denied",
+		},
+		{
+			name:          "functionInExpr",
+			input:         `{"filter":{"$or":[{"a":1},{"$expr":{"$function":{"body":"x","args":[],"lang":"js"}}}]}}`,
+			expectedError: "$function is not allowed at filter.$or.1.$expr: operator is denied",
+		},
+		{
+			name:          "javascriptValue",
+			input:         `{"filter":{"a":{"$code":"function() {}"}}}`,
+			expectedError: "JavaScript is not allowed at filter.a: code is not accepted",
+		},
+		{
+			name:          "out",
+			input:         `{"pipeline":[{"$match":{}},{"$out":"stolen"}]}`,
+			expectedError: "$out is not allowed at pipeline.1: stage is denied",
+		},
+		{
+			name:     "stripOut",
+			config:   db.SanitizerConfig{Strip: true},
+			input:    `{"pipeline":[{"$match":{}},{"$out":"stolen"}]}`,
+			expected: `{"pipeline":[{"$match":{}}]}`,
+		},
+		{
+			name:     "stripWhere",
+			config:   db.SanitizerConfig{Strip: true},
+			input:    `{"filter":{"a":1,"$where":"true"}}`,
+			expected: `{"filter":{"a":1}}`,
+		},
+		{
+			name:     "customDenied",
+			config:   db.SanitizerConfig{DeniedOperators: []string{"$regex"}, DeniedStages: []string{}},
+			input:    `{"filter":{"$where":"true"},"pipeline":[{"$out":"x"}]}`,
+			expected: `{"filter":{"$where":"true"},"pipeline":[{"$out":"x"}]}`,
+		},
+		{
+			name:     "lookupAllowed",
+			config:   db.SanitizerConfig{Namespace: namespace},
+			input:    `{"pipeline":[{"$lookup":{"from":"public_authors","localField":"a","foreignField":"_id","as":"x"}}]}`,
+			expected: `{"pipeline":[{"$lookup":{"from":"okr_authors","localField":"a","foreignField":"_id","as":"x"}}]}`,
+		},
+		{
+			name:          "lookupForbidden",
+			config:        db.SanitizerConfig{Namespace: namespace},
+			input:         `{"pipeline":[{"$lookup":{"from":"users","localField":"a","foreignField":"_id","as":"x"}}]}`,
+			expectedError: `$lookup is not allowed at pipeline.0.$lookup: collection "users" is not accessible`,
+		},
+		{
+			name:          "nestedLookupForbidden",
+			config:        db.SanitizerConfig{Namespace: namespace},
+			input:         `{"pipeline":[{"$facet":{"a":[{"$unionWith":"users"}]}}]}`,
+			expectedError: `$unionWith is not allowed at pipeline.0.$facet.a.0.$unionWith: collection "users" is not accessible`,
+		},
+		{
+			name:          "lookupOtherDatabase",
+			config:        db.SanitizerConfig{Namespace: namespace},
+			input:         `{"pipeline":[{"$unionWith":{"coll":{"db":"admin","coll":"system.users"}}}]}`,
+			expectedError: "$unionWith is not allowed at pipeline.0.$unionWith: only collections of the same database may be read",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var input bson.D
+			err := bson.UnmarshalExtJSON([]byte(tc.input), false, &input)
+			if err != nil {
+				t.Fatalf("invalid input: %v", err)
+			}
+
+			sanitizer := db.NewSanitizer(tc.config)
+			result := bson.D{}
+			for _, element := range input {
+				var sanitized interface{}
+				sanitized, err = sanitizer.Sanitize("okr", element.Key, element.Value)
+				if err != nil {
+					break
+				}
+				result = append(result, bson.E{Key: element.Key, Value: sanitized})
+			}
+
+			if len(tc.expectedError) > 0 {
+				var sanitizeError *db.SanitizeError
+				assert.True(t, errors.As(err, &sanitizeError), "expected a SanitizeError")
+				assert.EqualError(t, err, tc.expectedError)
+				return
+			}
+			assert.NoError(t, err)
+			output, err := bson.MarshalExtJSON(result, false, false)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, string(output))
+		})
+	}
+}
+
+func TestSanitizeMaps(t *testing.T) {
+	// Filters decoded with encoding/json are plain maps and slices.
+	filter := map[string]interface{}{
+		"$and": []interface{}{
+			map[string]interface{}{"a": 1},
+			map[string]interface{}{"$where": "true"},
+		},
+	}
+
+	_, err := db.NewSanitizer(db.SanitizerConfig{}).Sanitize("okr", "filter", filter)
+	assert.EqualError(t, err, "$where is not allowed at filter.$and.1: operator is denied")
+
+	sanitized, err := db.NewSanitizer(db.SanitizerConfig{Strip: true}).Sanitize("okr", "filter", filter)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"$and": []interface{}{map[string]interface{}{"a": 1}}}, sanitized)
+}
@@ -0,0 +1,166 @@
+// Package idempotency keeps the responses of writes sent with an idempotency key, so retries of the same
+// request get the original response instead of writing again.
+package idempotency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultTTL is how long the responses are kept.
+	DefaultTTL = 24 * time.Hour
+	// DefaultLockTimeout is how long a request may hold its key before another one may take it over,
+	// in case the proxy stopped before completing it.
+	DefaultLockTimeout = time.Minute
+	// MaxKeyLength is the longest key accepted.
+	MaxKeyLength = 255
+)
+
+// ErrNotReserved is returned when completing or releasing a key that the request doesn't hold anymore.
+var ErrNotReserved = errors.New("idempotency key is not reserved")
+
+// Record is what is stored for each key: the fingerprint of the request and, once it is completed, its response.
+type Record struct {
+	ID          string    `bson:"_id"`
+	Fingerprint string    `bson:"fingerprint"`
+	Status      int       `bson:"status"`
+	ContentType string    `bson:"content_type,omitempty"`
+	Body        []byte    `bson:"body,omitempty"`
+	CreatedAt   time.Time `bson:"created_at"`
+	ExpiresAt   time.Time `bson:"expires_at"`
+}
+
+// Completed tells if the record has the response of the request; otherwise, it is still running.
+func (r *Record) Completed() bool {
+	return r.Status > 0
+}
+
+// Response is the response of a completed request.
+type Response struct {
+	Status      int
+	ContentType string
+	Body        []byte
+}
+
+// Store keeps the records of the idempotency keys. Keys are unique per scope, like the client that sent them.
+type Store interface {
+	// Reserve creates the record of the key for the request with fingerprint, returning nil. If the key
+	// already has a record, it is returned instead, unless it is an abandoned reservation, which is taken over.
+	Reserve(scope, key, fingerprint string) (*Record, error)
+	// Complete stores the response of the request that reserved the key.
+	Complete(scope, key, fingerprint string, response Response) error
+	// Release deletes the reservation of the key, so the request may be sent again.
+	Release(scope, key, fingerprint string) error
+}
+
+// Fingerprint identifies a request by its method, backend, path and body.
+func Fingerprint(method, backend, path string, body []byte) string {
+	hash := sha256.New()
+	for _, part := range []string{method, backend, path} {
+		hash.Write([]byte(part))
+		hash.Write([]byte{0})
+	}
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// recordID combines scope and key in a single identifier.
+func recordID(scope, key string) string {
+	return scope + "\x00" + key
+}
+
+// MemoryStore keeps the records in memory. It is only suitable for a single instance of the proxy.
+type MemoryStore struct {
+	mutex       sync.Mutex
+	records     map[string]*Record
+	ttl         time.Duration
+	lockTimeout time.Duration
+	clock       func() time.Time
+	lastSweep   time.Time
+}
+
+// NewMemoryStore keeps the records for ttl (DefaultTTL, if zero). If clock is nil, time.Now is used.
+func NewMemoryStore(ttl time.Duration, clock func() time.Time) *MemoryStore {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+	if clock == nil {
+		clock = time.Now
+	}
+	return &MemoryStore{
+		records:     map[string]*Record{},
+		ttl:         ttl,
+		lockTimeout: DefaultLockTimeout,
+		clock:       clock,
+	}
+}
+
+// Reserve creates the record of the key, or returns the existing one.
+func (s *MemoryStore) Reserve(scope, key, fingerprint string) (*Record, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := s.clock()
+	s.sweep(now)
+
+	id := recordID(scope, key)
+	if existing, ok := s.records[id]; ok && now.Before(existing.ExpiresAt) {
+		if existing.Completed() || now.Sub(existing.CreatedAt) < s.lockTimeout {
+			copied := *existing
+			return &copied, nil
+		}
+	}
+	s.records[id] = &Record{
+		ID:          id,
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	return nil, nil
+}
+
+// Complete stores the response of the request that reserved the key.
+func (s *MemoryStore) Complete(scope, key, fingerprint string, response Response) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	record, ok := s.records[recordID(scope, key)]
+	if !ok || record.Completed() || record.Fingerprint != fingerprint {
+		return ErrNotReserved
+	}
+	record.Status = response.Status
+	record.ContentType = response.ContentType
+	record.Body = response.Body
+	return nil
+}
+
+// Release deletes the reservation of the key.
+func (s *MemoryStore) Release(scope, key, fingerprint string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	id := recordID(scope, key)
+	record, ok := s.records[id]
+	if !ok || record.Completed() || record.Fingerprint != fingerprint {
+		return ErrNotReserved
+	}
+	delete(s.records, id)
+	return nil
+}
+
+// sweep deletes the expired records, at most once a minute.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+	for id, record := range s.records {
+		if !now.Before(record.ExpiresAt) {
+			delete(s.records, id)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package idempotency_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/idempotency"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFingerprint(t *testing.T) {
+	fingerprint := idempotency.Fingerprint("POST", "", "/insert/db/coll", []byte(`{"a":1}`))
+
+	assert.Len(t, fingerprint, 64)
+	assert.Equal(t, fingerprint, idempotency.Fingerprint("POST", "", "/insert/db/coll", []byte(`{"a":1}`)))
+	assert.NotEqual(t, fingerprint, idempotency.Fingerprint("POST", "", "/insert/db/coll", []byte(`{"a":2}`)))
+	assert.NotEqual(t, fingerprint, idempotency.Fingerprint("POST", "", "/insert/db/other", []byte(`{"a":1}`)))
+	assert.NotEqual(t, fingerprint, idempotency.Fingerprint("POST", "archive", "/insert/db/coll", []byte(`{"a":1}`)))
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Now()
+	store := idempotency.NewMemoryStore(time.Hour, func() time.Time { return now })
+	response := idempotency.Response{Status: 200, ContentType: "application/json", Body: []byte(`{"InsertedID":"1"}`)}
+
+	// The first request reserves the key; the same key of another client is independent.
+	record, err := store.Reserve("client-a", "key", "fp1")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+	record, err = store.Reserve("client-b", "key", "fp2")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// While it runs, the reservation is returned.
+	record, err = store.Reserve("client-a", "key", "fp1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, record) {
+		assert.False(t, record.Completed())
+		assert.Equal(t, "fp1", record.Fingerprint)
+	}
+
+	// Only the request holding the reservation completes it.
+	assert.ErrorIs(t, store.Complete("client-a", "key", "other", response), idempotency.ErrNotReserved)
+	assert.NoError(t, store.Complete("client-a", "key", "fp1", response))
+	assert.ErrorIs(t, store.Complete("client-a", "key", "fp1", response), idempotency.ErrNotReserved)
+	assert.ErrorIs(t, store.Release("client-a", "key", "fp1"), idempotency.ErrNotReserved)
+
+	record, err = store.Reserve("client-a", "key", "fp1")
+	assert.NoError(t, err)
+	if assert.NotNil(t, record) {
+		assert.True(t, record.Completed())
+		assert.Equal(t, 200, record.Status)
+		assert.Equal(t, "application/json", record.ContentType)
+		assert.Equal(t, response.Body, record.Body)
+	}
+
+	// A released key may be reserved again.
+	assert.NoError(t, store.Release("client-b", "key", "fp2"))
+	record, err = store.Reserve("client-b", "key", "fp3")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// An abandoned reservation is taken over.
+	now = now.Add(idempotency.DefaultLockTimeout)
+	record, err = store.Reserve("client-b", "key", "fp4")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	// Completed records expire after the TTL.
+	now = now.Add(time.Hour)
+	record, err = store.Reserve("client-a", "key", "fp5")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+}
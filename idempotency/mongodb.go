@@ -0,0 +1,149 @@
+package idempotency
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// MongoStore keeps the records in a MongoDB collection, which deletes them when they expire with a TTL index,
+// so all the instances of the proxy share them.
+type MongoStore struct {
+	client      *mongo.Client
+	collection  *mongo.Collection
+	ttl         time.Duration
+	lockTimeout time.Duration
+	timeout     time.Duration
+	clock       func() time.Time
+}
+
+// NewMongoStore connects to MongoDB with clientOptions, to keep the records in collection, in database,
+// for ttl (DefaultTTL, if zero). It creates the TTL index of the collection, if needed.
+func NewMongoStore(clientOptions *options.ClientOptions, database, collection string, ttl time.Duration) (*MongoStore, error) {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, clientOptions)
+	if err != nil {
+		return nil, err
+	}
+	store := &MongoStore{
+		client:      client,
+		collection:  client.Database(database).Collection(collection),
+		ttl:         ttl,
+		lockTimeout: DefaultLockTimeout,
+		timeout:     10 * time.Second,
+		clock:       time.Now,
+	}
+
+	_, err = store.collection.Indexes().CreateOne(ctx, mongo.IndexModel{
+		Keys:    bson.D{{Key: "expires_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(0),
+	})
+	if err != nil {
+		_ = client.Disconnect(ctx)
+		return nil, err
+	}
+	return store, nil
+}
+
+// Reserve inserts the record of the key; if it already exists, it returns it, or takes it over if abandoned.
+// The TTL index only deletes expired records about once a minute, so they are ignored here meanwhile.
+func (s *MongoStore) Reserve(scope, key, fingerprint string) (*Record, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	now := s.clock()
+	record := Record{
+		ID:          recordID(scope, key),
+		Fingerprint: fingerprint,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.ttl),
+	}
+	_, err := s.collection.InsertOne(ctx, record)
+	if err == nil {
+		return nil, nil
+	}
+	if !mongo.IsDuplicateKeyError(err) {
+		return nil, err
+	}
+
+	// The record is replaced only if it expired, or if it is a reservation older than the lock timeout.
+	stale := bson.M{
+		"_id": record.ID,
+		"$or": bson.A{
+			bson.M{"expires_at": bson.M{"$lte": now}},
+			bson.M{"status": 0, "created_at": bson.M{"$lte": now.Add(-s.lockTimeout)}},
+		},
+	}
+	result, err := s.collection.ReplaceOne(ctx, stale, record)
+	if err != nil {
+		return nil, err
+	}
+	if result.MatchedCount > 0 {
+		return nil, nil
+	}
+
+	var existing Record
+	err = s.collection.FindOne(ctx, bson.M{"_id": record.ID}).Decode(&existing)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		// It expired and was deleted in the meantime: try again.
+		return s.Reserve(scope, key, fingerprint)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &existing, nil
+}
+
+// Complete stores the response in the record reserved by the request.
+func (s *MongoStore) Complete(scope, key, fingerprint string, response Response) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	result, err := s.collection.UpdateOne(ctx,
+		bson.M{"_id": recordID(scope, key), "fingerprint": fingerprint, "status": 0},
+		bson.M{"$set": bson.M{
+			"status":       response.Status,
+			"content_type": response.ContentType,
+			"body":         response.Body,
+		}})
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		return ErrNotReserved
+	}
+	return nil
+}
+
+// Release deletes the record reserved by the request.
+func (s *MongoStore) Release(scope, key, fingerprint string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	result, err := s.collection.DeleteOne(ctx,
+		bson.M{"_id": recordID(scope, key), "fingerprint": fingerprint, "status": 0})
+	if err != nil {
+		return err
+	}
+	if result.DeletedCount == 0 {
+		return ErrNotReserved
+	}
+	return nil
+}
+
+// Close disconnects from MongoDB.
+func (s *MongoStore) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	return s.client.Disconnect(ctx)
+}
@@ -1,12 +1,27 @@
 package main
 
 import (
+	"math"
 	"os"
+	"os/signal"
 	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/idempotency"
+	"github.com/otaviokr/mongodb-proxy-ms/memory"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/otaviokr/mongodb-proxy-ms/secrets"
 	"github.com/otaviokr/mongodb-proxy-ms/web"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo/options"
 
 	_ "github.com/otaviokr/mongodb-proxy-ms/swagger" // only to triggger compilation; not used in the code here
 )
@@ -14,10 +29,11 @@ import (
 func main() {
 
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnixMs
+	log.Logger = log.Output(secrets.NewScrubbingWriter(os.Stderr))
 	log.Info().Msg("Starting log...")
 
-	dbUsername := os.Getenv("MONGODB_USER")
-	dbPassword := os.Getenv("MONGODB_PASS")
+	dbUsername := getValue("MONGODB_USER")
+	dbPassword := getSecret("MONGODB_PASS")
 	dbHostname := os.Getenv("MONGODB_HOST")
 	portAsString := os.Getenv("MONGODB_PORT")
 	var err error
@@ -30,6 +46,446 @@ func main() {
 		dbPort = 27017
 	}
 
-	router := web.New(dbHostname, dbPort, dbUsername, dbPassword)
+	opts := web.Options{
+		AdminKey: getSecret("PROXY_ADMIN_KEY"),
+	}
+	if maxUpload := os.Getenv("PROXY_MAX_UPLOAD_BYTES"); len(maxUpload) > 0 {
+		opts.MaxUploadBytes, err = strconv.ParseInt(maxUpload, 10, 64)
+		if err != nil {
+			log.Warn().
+				Str("PROXY_MAX_UPLOAD_BYTES", maxUpload).
+				Err(err).
+				Msgf("Assuming default value: %d", web.DefaultMaxUploadBytes)
+		}
+	}
+	if publicPaths := os.Getenv("PROXY_PUBLIC_PATHS"); len(publicPaths) > 0 {
+		opts.PublicPaths = strings.Split(publicPaths, ",")
+	}
+	opts.TrustedProxies = splitList(os.Getenv("PROXY_TRUSTED_PROXIES"))
+
+	opts.Authenticators = getAuthenticators()
+	if len(opts.Authenticators) == 0 {
+		log.Warn().Msg("no API keys, JWT validation or client certificates defined; requests are NOT authenticated")
+	}
+	if policyFile := os.Getenv("PROXY_POLICY_FILE"); len(policyFile) > 0 {
+		opts.Policy, err = auth.LoadPolicy(policyFile)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to load authorization policy")
+		}
+	} else {
+		log.Warn().Msg("PROXY_POLICY_FILE is not defined; requests are NOT authorized")
+	}
+	if fieldsFile := os.Getenv("PROXY_FIELDS_FILE"); len(fieldsFile) > 0 {
+		opts.Fields, err = auth.LoadFieldPolicy(fieldsFile)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to load field policy")
+		}
+	}
+	if consistencyFile := os.Getenv("PROXY_CONSISTENCY_FILE"); len(consistencyFile) > 0 {
+		opts.Consistency, err = db.LoadConsistencyPolicy(consistencyFile)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to load consistency policy")
+		}
+	}
+	if keysFile := os.Getenv("PROXY_ENCRYPTION_KEYS_FILE"); len(keysFile) > 0 {
+		opts.Encryption, err = db.LoadFieldEncryption(keysFile, os.Getenv("PROXY_ENCRYPTED_FIELDS_FILE"))
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to load field encryption")
+		}
+	}
+	opts.Namespaces = getNamespaces()
+	opts.Sanitizer = getSanitizer()
+	opts.RateLimiter = getRateLimiter()
+	opts.Resilience = getResilienceConfig()
+	opts.Cache = getCache()
+	if slow := getIntEnv("PROXY_SLOW_CALL_MS"); slow > 0 {
+		opts.Interceptors = append(opts.Interceptors, db.LogCalls(time.Duration(slow)*time.Millisecond))
+	}
+	opts.Connection = getConnectionOptions()
+	if backendsFile := os.Getenv("PROXY_BACKENDS_FILE"); len(backendsFile) > 0 {
+		opts.Backends, err = db.LoadBackends(backendsFile, db.ConnectionOptions{Encryption: opts.Encryption})
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to load backends")
+		}
+		log.Info().
+			Strs("backends", opts.Backends.Names()).
+			Str("default", opts.Backends.Default).
+			Msg("backends loaded")
+	}
+	opts.Audit = getAuditLogger(dbHostname, dbPort, dbUsername, dbPassword, opts.Connection)
+	opts.AuditValues = os.Getenv("PROXY_AUDIT_VALUES") == "true"
+	opts.Idempotency = getIdempotencyStore(dbHostname, dbPort, dbUsername, dbPassword, opts.Connection)
+	if len(opts.AdminKey) == 0 && opts.Policy == nil {
+		log.Info().Msg("PROXY_ADMIN_KEY is not defined; admin routes are disabled")
+	}
+
+	var router *web.Server
+	if opts.Connection.URI == memory.URI {
+		router = web.NewWithOptions(getMemoryProxy(), opts)
+	} else {
+		router = web.New(dbHostname, dbPort, dbUsername, dbPassword, opts)
+	}
+	if router == nil {
+		log.Fatal().Msg("failed to create the webserver")
+	}
+	if opts.Audit != nil {
+		// The queued entries must be written before the proxy stops.
+		closeOnSignal(opts.Audit)
+		defer opts.Audit.Close()
+	}
+	if certFile := os.Getenv("PROXY_TLS_CERT_FILE"); len(certFile) > 0 {
+		router.RunTLS(":8080", web.TLSOptions{
+			CertFile:          certFile,
+			KeyFile:           os.Getenv("PROXY_TLS_KEY_FILE"),
+			ClientCAFile:      os.Getenv("PROXY_TLS_CLIENT_CA_FILE"),
+			RequireClientCert: os.Getenv("PROXY_TLS_REQUIRE_CLIENT_CERT") == "true",
+		})
+		return
+	}
 	router.Run(":8080")
 }
+
+// closeOnSignal closes the audit trail and exits when the proxy is stopped with SIGINT or SIGTERM.
+func closeOnSignal(logger *audit.Logger) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		received := <-signals
+		log.Info().
+			Str("signal", received.String()).
+			Msg("stopping; writing the queued audit entries")
+		err := logger.Close()
+		if err != nil {
+			log.Error().
+				Err(err).
+				Msg("failed to close the audit trail")
+		}
+		os.Exit(0)
+	}()
+}
+
+// getAuthenticators loads the API keys and the JWT validation settings from the environment.
+func getAuthenticators() []auth.Authenticator {
+	var authenticators []auth.Authenticator
+
+	if len(os.Getenv("PROXY_TLS_CLIENT_CA_FILE")) > 0 {
+		fullSubject := os.Getenv("PROXY_TLS_CLIENT_SUBJECT") == "full"
+		authenticators = append(authenticators, auth.NewCertificateAuthenticator(fullSubject))
+	}
+
+	apiKeys, err := auth.LoadAPIKeyStore(os.Getenv("PROXY_API_KEYS_FILE"), os.Getenv("PROXY_API_KEYS"))
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load API keys")
+	}
+	if apiKeys.Len() > 0 {
+		authenticators = append(authenticators, apiKeys)
+	}
+
+	jwtConfig := auth.JWTConfig{
+		Issuer:     os.Getenv("PROXY_JWT_ISSUER"),
+		Audience:   os.Getenv("PROXY_JWT_AUDIENCE"),
+		HMACSecret: []byte(getSecret("PROXY_JWT_HMAC_SECRET")),
+		JWKSFile:   os.Getenv("PROXY_JWT_JWKS_FILE"),
+		JWKSURL:    os.Getenv("PROXY_JWT_JWKS_URL"),
+		RolesClaim: os.Getenv("PROXY_JWT_ROLES_CLAIM"),
+		Leeway:     30 * time.Second,
+	}
+	if len(jwtConfig.HMACSecret) > 0 || len(jwtConfig.JWKSFile) > 0 || len(jwtConfig.JWKSURL) > 0 {
+		jwtAuthenticator, err := auth.NewJWTAuthenticator(jwtConfig)
+		if err != nil {
+			log.Fatal().
+				Err(err).
+				Msg("failed to configure JWT validation")
+		}
+		authenticators = append(authenticators, jwtAuthenticator)
+	}
+
+	return authenticators
+}
+
+// getNamespaces loads the allowed namespaces and their aliases from the environment.
+// The internal databases of MongoDB are always denied, unless the namespaces file says otherwise.
+func getNamespaces() *auth.Namespaces {
+	var namespaces *auth.Namespaces
+	var err error
+
+	if namespacesFile := os.Getenv("PROXY_NAMESPACES_FILE"); len(namespacesFile) > 0 {
+		namespaces, err = auth.LoadNamespaces(namespacesFile)
+	} else {
+		var config auth.NamespaceConfig
+		if allowed := os.Getenv("PROXY_ALLOWED_NAMESPACES"); len(allowed) > 0 {
+			config.Allow = strings.Split(allowed, ",")
+		}
+		namespaces, err = auth.NewNamespaces(config)
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load namespaces")
+	}
+	return namespaces
+}
+
+// getSanitizer loads the operators and stages clients may not use. Unset variables keep the defaults,
+// and empty ones allow everything.
+func getSanitizer() *db.Sanitizer {
+	var config db.SanitizerConfig
+	if operators, ok := os.LookupEnv("PROXY_DENIED_OPERATORS"); ok {
+		config.DeniedOperators = splitList(operators)
+	}
+	if stages, ok := os.LookupEnv("PROXY_DENIED_STAGES"); ok {
+		config.DeniedStages = splitList(stages)
+	}
+	config.Strip = os.Getenv("PROXY_STRIP_OPERATORS") == "true"
+	return db.NewSanitizer(config)
+}
+
+// getRateLimiter loads the rate limits from PROXY_RATE_LIMIT_FILE or, for a single limit shared by all operations,
+// from PROXY_RATE_LIMIT (requests per second) and PROXY_RATE_BURST. If none is set, clients are not limited.
+func getRateLimiter() *ratelimit.Limiter {
+	var limiter *ratelimit.Limiter
+	var err error
+
+	if limitsFile := os.Getenv("PROXY_RATE_LIMIT_FILE"); len(limitsFile) > 0 {
+		limiter, err = ratelimit.LoadLimiter(limitsFile)
+	} else if rate := os.Getenv("PROXY_RATE_LIMIT"); len(rate) > 0 {
+		var limit ratelimit.Limit
+		limit.Rate, err = strconv.ParseFloat(rate, 64)
+		if err == nil {
+			limit.Burst = int(math.Ceil(limit.Rate))
+			if burst := os.Getenv("PROXY_RATE_BURST"); len(burst) > 0 {
+				limit.Burst, err = strconv.Atoi(burst)
+			}
+		}
+		if err == nil {
+			limiter, err = ratelimit.NewLimiter(ratelimit.Config{Default: limit})
+		}
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load the rate limits")
+	}
+	return limiter
+}
+
+// getResilienceConfig reads the retries and the circuit breaker settings; undefined values use the defaults.
+// PROXY_RETRY_ATTEMPTS=1 disables the retries, and PROXY_BREAKER_FAILURES=-1 disables the circuit breaker.
+func getResilienceConfig() *resilience.Config {
+	return &resilience.Config{
+		MaxAttempts:      getIntEnv("PROXY_RETRY_ATTEMPTS"),
+		InitialBackoff:   time.Duration(getIntEnv("PROXY_RETRY_BACKOFF_MS")) * time.Millisecond,
+		MaxBackoff:       time.Duration(getIntEnv("PROXY_RETRY_MAX_BACKOFF_MS")) * time.Millisecond,
+		FailureThreshold: getIntEnv("PROXY_BREAKER_FAILURES"),
+		OpenDuration:     time.Duration(getIntEnv("PROXY_BREAKER_OPEN_SECONDS")) * time.Second,
+	}
+}
+
+// getIntEnv returns the integer in the environment variable name, or zero if it is not defined.
+func getIntEnv(name string) int {
+	value := os.Getenv(name)
+	if len(value) == 0 {
+		return 0
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Str("variable", name).
+			Msg("invalid number")
+	}
+	return parsed
+}
+
+// getConnectionOptions reads the settings of the connection to MongoDB. MONGODB_URI, if defined,
+// takes precedence over MONGODB_HOST and MONGODB_PORT.
+func getConnectionOptions() db.ConnectionOptions {
+	uri := getSecret("MONGODB_URI")
+	secrets.RegisterURI(uri)
+
+	return db.ConnectionOptions{
+		URI:                           uri,
+		AuthMechanism:                 os.Getenv("MONGODB_AUTH_MECHANISM"),
+		AuthSource:                    os.Getenv("MONGODB_AUTH_SOURCE"),
+		ReplicaSet:                    os.Getenv("MONGODB_REPLICA_SET"),
+		AppName:                       getEnvOrDefault("MONGODB_APP_NAME", "mongodb-proxy-ms"),
+		TLS:                           os.Getenv("MONGODB_TLS") == "true",
+		TLSCAFile:                     os.Getenv("MONGODB_TLS_CA_FILE"),
+		TLSCertificateKeyFile:         os.Getenv("MONGODB_TLS_CERTIFICATE_KEY_FILE"),
+		TLSCertificateKeyFilePassword: getSecret("MONGODB_TLS_CERTIFICATE_KEY_FILE_PASSWORD"),
+		TLSInsecure:                   os.Getenv("MONGODB_TLS_INSECURE") == "true",
+	}
+}
+
+// getAuditLogger creates the audit trail chosen by PROXY_AUDIT_SINK: "stdout", "file" (PROXY_AUDIT_FILE)
+// or "mongodb" (PROXY_AUDIT_DATABASE and PROXY_AUDIT_COLLECTION, in the same server). If unset, nothing is recorded.
+func getAuditLogger(hostname string, port int, username, password string, connection db.ConnectionOptions) *audit.Logger {
+	var sink audit.Sink
+	var err error
+
+	switch kind := os.Getenv("PROXY_AUDIT_SINK"); kind {
+	case "":
+		return nil
+	case "stdout":
+		sink = audit.NewWriterSink(os.Stdout)
+	case "file":
+		sink, err = audit.NewFileSink(os.Getenv("PROXY_AUDIT_FILE"))
+	case "mongodb":
+		var clientOptions *options.ClientOptions
+		clientOptions, err = db.NewClientOptions(hostname, port, username, password, connection)
+		if err == nil {
+			sink, err = audit.NewMongoSink(clientOptions,
+				getEnvOrDefault("PROXY_AUDIT_DATABASE", "audit"),
+				getEnvOrDefault("PROXY_AUDIT_COLLECTION", "events"))
+		}
+	default:
+		log.Fatal().
+			Str("PROXY_AUDIT_SINK", kind).
+			Msg("unknown audit sink")
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to open the audit sink")
+	}
+
+	bufferSize := 0
+	if size := os.Getenv("PROXY_AUDIT_BUFFER"); len(size) > 0 {
+		bufferSize, err = strconv.Atoi(size)
+		if err != nil {
+			log.Fatal().
+				Str("PROXY_AUDIT_BUFFER", size).
+				Err(err).
+				Msg("invalid audit buffer size")
+		}
+	}
+	return audit.NewLogger(sink, bufferSize)
+}
+
+// getCache creates the cache of reads from PROXY_CACHE_FILE or, if unset, from PROXY_CACHE_TTL_SECONDS,
+// PROXY_CACHE_MAX_ENTRIES and PROXY_CACHE_MAX_BYTES. If neither the file nor a TTL is defined, reads are not cached.
+func getCache() *cache.Cache {
+	var readCache *cache.Cache
+	var err error
+
+	if cacheFile := os.Getenv("PROXY_CACHE_FILE"); len(cacheFile) > 0 {
+		readCache, err = cache.Load(cacheFile)
+	} else if ttl := getIntEnv("PROXY_CACHE_TTL_SECONDS"); ttl > 0 {
+		readCache, err = cache.New(cache.Config{
+			TTLSeconds: ttl,
+			MaxEntries: getIntEnv("PROXY_CACHE_MAX_ENTRIES"),
+			MaxBytes:   int64(getIntEnv("PROXY_CACHE_MAX_BYTES")),
+		})
+	}
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to configure the cache")
+	}
+	return readCache
+}
+
+// getIdempotencyStore creates the store of idempotency keys chosen by PROXY_IDEMPOTENCY_STORE: "memory" or
+// "mongodb" (PROXY_IDEMPOTENCY_DATABASE and PROXY_IDEMPOTENCY_COLLECTION, in the same server). The responses
+// are kept for PROXY_IDEMPOTENCY_TTL_HOURS. If unset, the Idempotency-Key header is ignored.
+func getIdempotencyStore(hostname string, port int, username, password string, connection db.ConnectionOptions) idempotency.Store {
+	ttl := time.Duration(getIntEnv("PROXY_IDEMPOTENCY_TTL_HOURS")) * time.Hour
+
+	switch kind := os.Getenv("PROXY_IDEMPOTENCY_STORE"); kind {
+	case "":
+		return nil
+	case "memory":
+		return idempotency.NewMemoryStore(ttl, nil)
+	case "mongodb":
+		clientOptions, err := db.NewClientOptions(hostname, port, username, password, connection)
+		if err == nil {
+			var store *idempotency.MongoStore
+			store, err = idempotency.NewMongoStore(clientOptions,
+				getEnvOrDefault("PROXY_IDEMPOTENCY_DATABASE", "proxy"),
+				getEnvOrDefault("PROXY_IDEMPOTENCY_COLLECTION", "idempotency_keys"),
+				ttl)
+			if err == nil {
+				return store
+			}
+		}
+		log.Fatal().
+			Err(err).
+			Msg("failed to open the idempotency store")
+	default:
+		log.Fatal().
+			Str("PROXY_IDEMPOTENCY_STORE", kind).
+			Msg("unknown idempotency store")
+	}
+	return nil
+}
+
+// getMemoryProxy creates the in-memory database used when MONGODB_URI is "memory://", with the documents in
+// PROXY_MEMORY_SEED_FILE, if defined.
+func getMemoryProxy() *memory.Proxy {
+	log.Warn().Msg("using the in-memory database; nothing is persisted")
+
+	seedFile := os.Getenv("PROXY_MEMORY_SEED_FILE")
+	if len(seedFile) == 0 {
+		return memory.NewProxy()
+	}
+	proxy, err := memory.Load(seedFile)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load the in-memory database")
+	}
+	return proxy
+}
+
+// getSecret reads the secret called name from the file in name+"_FILE", or from the variable name itself.
+func getSecret(name string) string {
+	value, err := secrets.Load(name)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load secret")
+	}
+	return value
+}
+
+// getValue reads the value called name like getSecret, but keeps it in the logs.
+func getValue(name string) string {
+	value, err := secrets.LoadValue(name)
+	if err != nil {
+		log.Fatal().
+			Err(err).
+			Msg("failed to load setting")
+	}
+	return value
+}
+
+// getEnvOrDefault returns the value of the environment variable key, or fallback if it is empty.
+func getEnvOrDefault(key, fallback string) string {
+	if value := os.Getenv(key); len(value) > 0 {
+		return value
+	}
+	return fallback
+}
+
+// splitList splits a comma separated list, ignoring empty items.
+func splitList(list string) []string {
+	items := []string{}
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); len(item) > 0 {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,476 @@
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// aggregate runs pipeline over documents. It supports the stages $match, $project, $addFields (and $set),
+// $unset, $sort, $skip, $limit, $count, $group and $unwind. Expressions may only be field paths, like
+// "$author", literals, and documents and arrays of them.
+func aggregate(documents []bson.D, pipeline []bson.D) ([]bson.D, error) {
+	var err error
+	for _, stage := range pipeline {
+		if len(stage) != 1 {
+			return nil, fmt.Errorf("each stage must have a single field, not %d", len(stage))
+		}
+		name, spec := stage[0].Key, stage[0].Value
+
+		switch name {
+		case "$match":
+			documents, err = stageMatch(documents, spec)
+		case "$project":
+			documents, err = stageProject(documents, spec)
+		case "$addFields", "$set":
+			documents, err = stageAddFields(documents, spec)
+		case "$unset":
+			documents, err = stageUnset(documents, spec)
+		case "$sort":
+			documents, err = stageSort(documents, spec)
+		case "$skip", "$limit":
+			documents, err = stageSlice(documents, name, spec)
+		case "$count":
+			documents, err = stageCount(documents, spec)
+		case "$group":
+			documents, err = stageGroup(documents, spec)
+		case "$unwind":
+			documents, err = stageUnwind(documents, spec)
+		default:
+			err = fmt.Errorf("%w: aggregation stage %s", ErrUnsupported, name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return documents, nil
+}
+
+func stageMatch(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	filter, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$match requires a document")
+	}
+	var matched []bson.D
+	for _, document := range documents {
+		ok, err := match(document, filter)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, document)
+		}
+	}
+	return matched, nil
+}
+
+func stageProject(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	projection, ok := spec.(bson.D)
+	if !ok || len(projection) == 0 {
+		return nil, fmt.Errorf("$project requires a non-empty document")
+	}
+
+	// Projections either include or exclude fields; only _id may be excluded from an inclusion.
+	includeID := true
+	inclusion, exclusion := false, false
+	for _, field := range projection {
+		isFlag := field.Value == nil || isBool(field.Value) || isNumber(field.Value)
+		switch {
+		case field.Key == "_id" && isFlag:
+			includeID = truthy(field.Value)
+		case isFlag && !truthy(field.Value):
+			exclusion = true
+		default:
+			inclusion = true
+		}
+	}
+	if inclusion && exclusion {
+		return nil, fmt.Errorf("$project cannot mix inclusion and exclusion")
+	}
+
+	results := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		var projected bson.D
+		var err error
+		if exclusion || !inclusion {
+			projected = copyDocument(document)
+			for _, field := range projection {
+				if field.Key != "_id" || !includeID {
+					projected = unsetPath(projected, split(field.Key))
+				}
+			}
+		} else {
+			projected = bson.D{}
+			if id, ok := get(document, "_id"); ok && includeID {
+				projected = append(projected, bson.E{Key: "_id", Value: id})
+			}
+			for _, field := range projection {
+				if field.Key == "_id" {
+					if isBool(field.Value) || isNumber(field.Value) {
+						continue
+					}
+				}
+				var value interface{}
+				var found bool
+				if isBool(field.Value) || isNumber(field.Value) {
+					value, found = lookupExact(document, split(field.Key))
+				} else {
+					value, found = evaluate(document, field.Value)
+				}
+				if found {
+					if projected, err = setPath(projected, split(field.Key), copyValue(value)); err != nil {
+						return nil, err
+					}
+				}
+			}
+		}
+		results = append(results, projected)
+	}
+	return results, nil
+}
+
+func stageAddFields(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$addFields requires a document")
+	}
+	results := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		updated := copyDocument(document)
+		for _, field := range fields {
+			value, _ := evaluate(document, field.Value)
+			var err error
+			if updated, err = setPath(updated, split(field.Key), copyValue(value)); err != nil {
+				return nil, err
+			}
+		}
+		results = append(results, updated)
+	}
+	return results, nil
+}
+
+func stageUnset(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	var fields []string
+	switch v := spec.(type) {
+	case string:
+		fields = []string{v}
+	case bson.A:
+		for _, field := range v {
+			name, ok := field.(string)
+			if !ok {
+				return nil, fmt.Errorf("$unset requires field names")
+			}
+			fields = append(fields, name)
+		}
+	default:
+		return nil, fmt.Errorf("$unset requires a field name or an array of them")
+	}
+
+	results := make([]bson.D, 0, len(documents))
+	for _, document := range documents {
+		updated := copyDocument(document)
+		for _, field := range fields {
+			updated = unsetPath(updated, split(field))
+		}
+		results = append(results, updated)
+	}
+	return results, nil
+}
+
+// sortDocuments sorts documents in place by spec, a document of fields and directions (1 or -1).
+func sortDocuments(documents []bson.D, spec bson.D) error {
+	for _, field := range spec {
+		direction, ok := number(field.Value)
+		if !ok || (direction != 1 && direction != -1) {
+			return fmt.Errorf("sort direction of %s must be 1 or -1", field.Key)
+		}
+	}
+
+	sort.SliceStable(documents, func(i, j int) bool {
+		for _, field := range spec {
+			a, _ := lookupExact(documents[i], split(field.Key))
+			b, _ := lookupExact(documents[j], split(field.Key))
+			c := compare(a, b)
+			if direction, _ := number(field.Value); direction < 0 {
+				c = -c
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return false
+	})
+	return nil
+}
+
+func stageSort(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok || len(fields) == 0 {
+		return nil, fmt.Errorf("$sort requires a non-empty document")
+	}
+	sorted := append([]bson.D{}, documents...)
+	return sorted, sortDocuments(sorted, fields)
+}
+
+func stageSlice(documents []bson.D, name string, spec interface{}) ([]bson.D, error) {
+	n, ok := number(spec)
+	if !ok || n < 0 || (name == "$limit" && n == 0) {
+		return nil, fmt.Errorf("%s requires a positive number", name)
+	}
+	count := int(n)
+	if name == "$skip" {
+		if count >= len(documents) {
+			return nil, nil
+		}
+		return documents[count:], nil
+	}
+	if count < len(documents) {
+		return documents[:count], nil
+	}
+	return documents, nil
+}
+
+func stageCount(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	field, ok := spec.(string)
+	if !ok || len(field) == 0 || strings.HasPrefix(field, "$") || strings.Contains(field, ".") {
+		return nil, fmt.Errorf("$count requires a field name")
+	}
+	if len(documents) == 0 {
+		return nil, nil
+	}
+	return []bson.D{{{Key: field, Value: int32(len(documents))}}}, nil
+}
+
+// missing stands for the values of missing fields in a group: accumulators skip them, where null is kept.
+type missing struct{}
+
+// group holds the state of a group, while $group runs.
+type group struct {
+	id     interface{}
+	values map[string][]interface{}
+}
+
+func stageGroup(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	fields, ok := spec.(bson.D)
+	if !ok {
+		return nil, fmt.Errorf("$group requires a document")
+	}
+	idExpression, ok := get(fields, "_id")
+	if !ok {
+		return nil, fmt.Errorf("$group requires an _id")
+	}
+
+	type accumulator struct {
+		field, operator string
+		expression      interface{}
+	}
+	var accumulators []accumulator
+	for _, field := range fields {
+		if field.Key == "_id" {
+			continue
+		}
+		operation, ok := field.Value.(bson.D)
+		if !ok || len(operation) != 1 {
+			return nil, fmt.Errorf("the field %s of $group must have a single accumulator", field.Key)
+		}
+		switch operation[0].Key {
+		case "$sum", "$avg", "$min", "$max", "$first", "$last", "$push", "$addToSet", "$count":
+		default:
+			return nil, fmt.Errorf("%w: accumulator %s", ErrUnsupported, operation[0].Key)
+		}
+		accumulators = append(accumulators, accumulator{field.Key, operation[0].Key, operation[0].Value})
+	}
+
+	var groups []*group
+	for _, document := range documents {
+		id, _ := evaluate(document, idExpression)
+		var current *group
+		for _, existing := range groups {
+			if equal(existing.id, id) {
+				current = existing
+				break
+			}
+		}
+		if current == nil {
+			current = &group{id: id, values: map[string][]interface{}{}}
+			groups = append(groups, current)
+		}
+		for _, acc := range accumulators {
+			value, found := evaluate(document, acc.expression)
+			if acc.operator == "$count" {
+				value, found = int32(1), true
+			}
+			if !found {
+				value = missing{}
+			}
+			current.values[acc.field] = append(current.values[acc.field], value)
+		}
+	}
+
+	results := make([]bson.D, 0, len(groups))
+	for _, g := range groups {
+		result := bson.D{{Key: "_id", Value: g.id}}
+		for _, acc := range accumulators {
+			result = append(result, bson.E{Key: acc.field, Value: accumulate(acc.operator, g.values[acc.field])})
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// accumulate combines the values of a group with an accumulator. Like in MongoDB, $sum and $avg skip the values
+// that are not numbers, $min and $max skip nulls, and $first and $last give null for missing fields.
+func accumulate(operator string, values []interface{}) interface{} {
+	present := make([]interface{}, 0, len(values))
+	for _, value := range values {
+		if _, ok := value.(missing); !ok {
+			present = append(present, value)
+		}
+	}
+
+	switch operator {
+	case "$sum", "$count", "$avg":
+		var sum float64
+		var count int
+		var sample interface{} = int32(0)
+		for _, value := range values {
+			if n, ok := number(value); ok {
+				sum += n
+				count++
+				sample = numberLike(0, sample, value)
+			}
+		}
+		if operator == "$avg" {
+			if count == 0 {
+				return nil
+			}
+			return sum / float64(count)
+		}
+		return numberLike(sum, sample, sample)
+	case "$min", "$max":
+		var result interface{}
+		for _, value := range present {
+			if value == nil {
+				continue
+			}
+			if result == nil || (operator == "$min" && compare(value, result) < 0) ||
+				(operator == "$max" && compare(value, result) > 0) {
+				result = value
+			}
+		}
+		return result
+	case "$first", "$last":
+		if len(values) == 0 {
+			return nil
+		}
+		value := values[0]
+		if operator == "$last" {
+			value = values[len(values)-1]
+		}
+		if _, ok := value.(missing); ok {
+			return nil
+		}
+		return value
+	case "$push":
+		return append(bson.A{}, present...)
+	default: // $addToSet
+		set := bson.A{}
+		for _, value := range present {
+			if !contains(set, value) {
+				set = append(set, value)
+			}
+		}
+		return set
+	}
+}
+
+func stageUnwind(documents []bson.D, spec interface{}) ([]bson.D, error) {
+	var path string
+	var preserve bool
+	switch v := spec.(type) {
+	case string:
+		path = v
+	case bson.D:
+		value, _ := get(v, "path")
+		path, _ = value.(string)
+		flag, _ := get(v, "preserveNullAndEmptyArrays")
+		preserve = truthy(flag)
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("$unwind requires a field path starting with $")
+	}
+	fieldPath := split(path[1:])
+
+	var results []bson.D
+	for _, document := range documents {
+		value, found := lookupExact(document, fieldPath)
+		array, isArray := value.(bson.A)
+		switch {
+		case !found || value == nil || (isArray && len(array) == 0):
+			if preserve {
+				results = append(results, document)
+			}
+		case !isArray:
+			results = append(results, document)
+		default:
+			for _, element := range array {
+				unwound, err := setPath(copyDocument(document), fieldPath, copyValue(element))
+				if err != nil {
+					return nil, err
+				}
+				results = append(results, unwound)
+			}
+		}
+	}
+	return results, nil
+}
+
+// evaluate returns the value of expression for document: field paths ("$author") are replaced by the value
+// of the field, documents and arrays are evaluated field by field, and anything else is a literal.
+func evaluate(document bson.D, expression interface{}) (interface{}, bool) {
+	switch v := expression.(type) {
+	case string:
+		if strings.HasPrefix(v, "$$") {
+			if v == "$$ROOT" || v == "$$CURRENT" {
+				return document, true
+			}
+			return nil, false
+		}
+		if strings.HasPrefix(v, "$") {
+			return lookup(document, split(v[1:]))
+		}
+		return v, true
+	case bson.D:
+		if len(v) == 1 && v[0].Key == "$literal" {
+			return v[0].Value, true
+		}
+		result := bson.D{}
+		for _, field := range v {
+			if value, found := evaluate(document, field.Value); found {
+				result = append(result, bson.E{Key: field.Key, Value: value})
+			}
+		}
+		return result, true
+	case bson.A:
+		result := make(bson.A, 0, len(v))
+		for _, element := range v {
+			value, _ := evaluate(document, element)
+			result = append(result, value)
+		}
+		return result, true
+	default:
+		return expression, true
+	}
+}
+
+func isBool(value interface{}) bool {
+	_, ok := value.(bool)
+	return ok
+}
+
+func isNumber(value interface{}) bool {
+	_, ok := number(value)
+	return ok
+}
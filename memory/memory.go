@@ -0,0 +1,711 @@
+// Package memory implements db.Proxy over documents kept in memory, to test the service end-to-end and to run it
+// without MongoDB. It supports a useful subset of MongoDB: the common query and update operators, the simple
+// aggregation stages, the administrative operations and GridFS files. Nothing is persisted.
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// URI is returned by GetURI, and selects the in-memory database when used as MONGODB_URI.
+const URI = "memory://"
+
+// chunkSize is reported as the chunk size of every file, the default of GridFS.
+const chunkSize = 255 * 1024
+
+// ErrUnsupported is returned for the operators and stages the in-memory database doesn't implement.
+var ErrUnsupported = errors.New("not supported by the in-memory database")
+
+type collection struct {
+	documents    []bson.D
+	capped       bool
+	sizeInBytes  int64
+	maxDocuments int64
+}
+
+type file struct {
+	info    db.FileInfo
+	content []byte
+}
+
+type database struct {
+	collections map[string]*collection
+	buckets     map[string][]*file
+}
+
+// Proxy is a db.Proxy that keeps the databases in memory. It is safe for concurrent use.
+type Proxy struct {
+	mu        sync.RWMutex
+	databases map[string]*database
+}
+
+// NewProxy creates an empty in-memory database.
+func NewProxy() *Proxy {
+	return &Proxy{
+		databases: map[string]*database{},
+	}
+}
+
+// Load creates an in-memory database with the documents in filename, an Extended JSON document of databases,
+// each one a document of collections, each one an array of documents: {"quotes": {"quotes": [{...}, ...]}}.
+func Load(filename string) (*Proxy, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var seed map[string]map[string][]bson.D
+	err = bson.UnmarshalExtJSON(data, false, &seed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filename, err)
+	}
+
+	p := NewProxy()
+	for dbName, collections := range seed {
+		for collName, documents := range collections {
+			list := make([]interface{}, 0, len(documents))
+			for _, document := range documents {
+				list = append(list, document)
+			}
+			if err = p.Seed(dbName, collName, list...); err != nil {
+				return nil, fmt.Errorf("failed to load %s.%s: %w", dbName, collName, err)
+			}
+		}
+	}
+	return p, nil
+}
+
+// Seed inserts documents in collName, creating it if needed. Documents without an _id get a new ObjectID.
+func (p *Proxy) Seed(dbName, collName string, documents ...interface{}) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	coll := p.collection(dbName, collName, true)
+	for _, value := range documents {
+		document, err := toDocument(value)
+		if err != nil {
+			return err
+		}
+		if _, err = coll.insert(document); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetURI returns URI: there is no server to connect to.
+func (p *Proxy) GetURI() string {
+	return URI
+}
+
+// HealthCheck returns the databases that have collections or files.
+func (p *Proxy) HealthCheck() (*db.HealthResponse, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	databases := []string{}
+	for name, database := range p.databases {
+		if len(database.collections) > 0 || len(database.buckets) > 0 {
+			databases = append(databases, name)
+		}
+	}
+	sort.Strings(databases)
+
+	return &db.HealthResponse{
+		Databases: databases,
+	}, nil
+}
+
+// Aggregate runs the pipeline filter in collName, and returns its first result.
+func (p *Proxy) Aggregate(dbName, collName string, filter interface{}) (*db.AggregateResponse, error) {
+	results, err := p.aggregate(dbName, collName, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(results) < 1 {
+		return &db.AggregateResponse{
+			MinPublications: 0,
+		}, nil
+	}
+
+	var parsed db.AggregateResponse
+	encoded, err := bson.Marshal(results[0])
+	if err == nil {
+		err = bson.Unmarshal(encoded, &parsed)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &parsed, nil
+}
+
+// AggregatePipeline runs pipeline in collName, and returns the resulting documents.
+func (p *Proxy) AggregatePipeline(dbName, collName string, pipeline interface{}) (*db.FindResponse, error) {
+	results, err := p.aggregate(dbName, collName, pipeline)
+	if err != nil {
+		return nil, err
+	}
+	return &db.FindResponse{
+		Results: toMaps(results),
+	}, nil
+}
+
+func (p *Proxy) aggregate(dbName, collName string, pipeline interface{}) ([]bson.D, error) {
+	stages, err := toDocuments(pipeline)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return aggregate(p.documents(dbName, collName), stages)
+}
+
+// Insert adds entry to collName, with a new ObjectID.
+func (p *Proxy) Insert(dbName, collName string, entry db.Quote) (*db.InsertResponse, error) {
+	document, err := toDocument(&entry)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	id, err := p.collection(dbName, collName, true).insert(document)
+	if err != nil {
+		return nil, err
+	}
+	return &db.InsertResponse{
+		InsertedID: id,
+	}, nil
+}
+
+// Find returns copies of all documents in collName that match filter.
+func (p *Proxy) Find(dbName, collName string, filter interface{}) (*db.FindResponse, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	found, err := filterDocuments(p.documents(dbName, collName), query)
+	if err != nil {
+		return nil, err
+	}
+	return &db.FindResponse{
+		Results: toMaps(found),
+	}, nil
+}
+
+// Update applies entry, a document of update operators, to all documents in collName that match filter.
+func (p *Proxy) Update(dbName, collName string, filter, entry interface{}) (*db.UpdateResponse, error) {
+	query, err := toDocument(filter)
+	if err != nil {
+		return nil, err
+	}
+	update, err := toDocument(entry)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(update) == 0 {
+		return nil, errNotUpdate
+	}
+	for _, operation := range update {
+		if !strings.HasPrefix(operation.Key, "$") {
+			return nil, errNotUpdate
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	result := &mongo.UpdateResult{}
+	coll := p.collection(dbName, collName, false)
+	if coll == nil {
+		return &db.UpdateResponse{Results: result}, nil
+	}
+
+	// The documents are only replaced once all of them are updated, so a failed update changes nothing.
+	updated := make([]bson.D, len(coll.documents))
+	copy(updated, coll.documents)
+	for i, document := range coll.documents {
+		matched, err := match(document, query)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+		result.MatchedCount++
+
+		changed, err := applyUpdate(document, update)
+		if err != nil {
+			return nil, err
+		}
+		if compare(document, changed) != 0 {
+			updated[i] = changed
+			result.ModifiedCount++
+		}
+	}
+	coll.documents = updated
+
+	return &db.UpdateResponse{
+		Results: result,
+	}, nil
+}
+
+// ListCollections returns the collections of dbName, with their statistics. Every collection has only the
+// index on _id, and its storage size is the size of its documents.
+func (p *Proxy) ListCollections(dbName string) (*db.CollectionsResponse, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	collections := []db.CollectionDetails{}
+	if database, ok := p.databases[dbName]; ok {
+		for name, coll := range database.collections {
+			size := coll.size()
+			collections = append(collections, db.CollectionDetails{
+				Name:        name,
+				Type:        "collection",
+				Count:       int64(len(coll.documents)),
+				Size:        size,
+				StorageSize: size,
+				Capped:      coll.capped,
+				Indexes: []db.IndexDetails{
+					{Name: "_id_", Keys: bson.M{"_id": int32(1)}},
+				},
+			})
+		}
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].Name < collections[j].Name
+	})
+
+	return &db.CollectionsResponse{
+		Database:    dbName,
+		Collections: collections,
+	}, nil
+}
+
+// CreateCollection creates collName in dbName. Only the capped options have an effect: validators and time
+// series are accepted, but ignored.
+func (p *Proxy) CreateCollection(dbName, collName string, request db.CreateCollectionRequest) (*db.AdminResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.collection(dbName, collName, false) != nil {
+		return nil, fmt.Errorf("collection %s.%s already exists", dbName, collName)
+	}
+	coll := p.collection(dbName, collName, true)
+	if request.Capped {
+		coll.capped = true
+		coll.sizeInBytes = request.SizeInBytes
+		coll.maxDocuments = request.MaxDocuments
+	}
+
+	return &db.AdminResponse{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  "create",
+	}, nil
+}
+
+// RenameCollection changes the name of collName to request.To, inside the same database.
+func (p *Proxy) RenameCollection(dbName, collName string, request db.RenameCollectionRequest) (*db.AdminResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	coll := p.collection(dbName, collName, false)
+	if coll == nil {
+		return nil, fmt.Errorf("source namespace %s.%s does not exist", dbName, collName)
+	}
+	if request.To == collName {
+		return nil, fmt.Errorf("cannot rename a collection to itself")
+	}
+	if p.collection(dbName, request.To, false) != nil && !request.DropTarget {
+		return nil, fmt.Errorf("target namespace %s.%s exists", dbName, request.To)
+	}
+
+	database := p.databases[dbName]
+	database.collections[request.To] = coll
+	delete(database.collections, collName)
+
+	return &db.AdminResponse{
+		Database:   dbName,
+		Collection: request.To,
+		Operation:  "rename",
+	}, nil
+}
+
+// DropCollection removes collName, and all its documents, from dbName. Like MongoDB, dropping a collection
+// that doesn't exist succeeds.
+func (p *Proxy) DropCollection(dbName, collName string) (*db.AdminResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if database, ok := p.databases[dbName]; ok {
+		delete(database.collections, collName)
+	}
+
+	return &db.AdminResponse{
+		Database:   dbName,
+		Collection: collName,
+		Operation:  "drop",
+	}, nil
+}
+
+// DropDatabase removes dbName, with all its collections and files.
+func (p *Proxy) DropDatabase(dbName string) (*db.AdminResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.databases, dbName)
+
+	return &db.AdminResponse{
+		Database:  dbName,
+		Operation: "dropDatabase",
+	}, nil
+}
+
+// Search returns the documents of collName whose text fields contain any of the words in request, sorted by the
+// number of words found, that is their "score". There are no text indexes: all text fields are searched, and
+// words are neither stemmed nor stripped of diacritics.
+func (p *Proxy) Search(dbName, collName string, request db.SearchRequest) (*db.SearchResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	words := strings.Fields(request.Text)
+	if !request.CaseSensitive {
+		for i := range words {
+			words[i] = strings.ToLower(words[i])
+		}
+	}
+
+	p.mu.RLock()
+	type scored struct {
+		document bson.D
+		score    float64
+	}
+	var found []scored
+	for _, document := range p.documents(dbName, collName) {
+		var score float64
+		for _, text := range texts(document) {
+			if !request.CaseSensitive {
+				text = strings.ToLower(text)
+			}
+			for _, word := range words {
+				score += float64(strings.Count(text, word))
+			}
+		}
+		if score > 0 {
+			found = append(found, scored{copyDocument(document), score})
+		}
+	}
+	p.mu.RUnlock()
+
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].score > found[j].score
+	})
+
+	results := []bson.M{}
+	for i := (request.Page - 1) * request.PageSize; i < int64(len(found)) && int64(len(results)) < request.PageSize; i++ {
+		result := toMap(found[i].document)
+		result["score"] = found[i].score
+		results = append(results, result)
+	}
+
+	return &db.SearchResponse{
+		Results:  results,
+		Total:    int64(len(found)),
+		Page:     request.Page,
+		PageSize: request.PageSize,
+	}, nil
+}
+
+// Explain describes how the operation in request runs: always as a scan of the whole collection.
+func (p *Proxy) Explain(dbName, collName string, request db.ExplainRequest) (*db.ExplainResponse, error) {
+	err := request.Validate()
+	if err != nil {
+		return nil, err
+	}
+	filter, err := toDocument(request.Filter)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.RLock()
+	documents := p.documents(dbName, collName)
+	var returned []bson.D
+	if request.Operation == "aggregate" {
+		var stages []bson.D
+		if stages, err = toDocuments(request.Pipeline); err == nil {
+			returned, err = aggregate(documents, stages)
+		}
+	} else {
+		returned, err = filterDocuments(documents, filter)
+	}
+	p.mu.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := bson.D{
+		{Key: "queryPlanner", Value: bson.D{
+			{Key: "namespace", Value: dbName + "." + collName},
+			{Key: "parsedQuery", Value: filter},
+			{Key: "winningPlan", Value: bson.D{{Key: "stage", Value: "COLLSCAN"}}},
+			{Key: "rejectedPlans", Value: bson.A{}},
+		}},
+	}
+	if request.Verbosity != "queryPlanner" {
+		plan = append(plan, bson.E{Key: "executionStats", Value: bson.D{
+			{Key: "nReturned", Value: int64(len(returned))},
+			{Key: "totalKeysExamined", Value: int64(0)},
+			{Key: "totalDocsExamined", Value: int64(len(documents))},
+		}})
+	}
+
+	return &db.ExplainResponse{
+		Operation: request.Operation,
+		Verbosity: request.Verbosity,
+		Plan:      plan,
+	}, nil
+}
+
+// UploadFile stores content in bucketName, and returns the details of the new file.
+func (p *Proxy) UploadFile(dbName, bucketName, filename, contentType string, metadata bson.M, content io.Reader) (*db.FileInfo, error) {
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return nil, err
+	}
+
+	info := db.FileInfo{
+		ID:          primitive.NewObjectID().Hex(),
+		Filename:    filename,
+		Length:      int64(len(data)),
+		ChunkSize:   chunkSize,
+		UploadDate:  time.Now().UTC().Truncate(time.Millisecond),
+		ContentType: contentType,
+	}
+	if len(metadata) > 0 {
+		copied, err := toDocument(metadata)
+		if err != nil {
+			return nil, err
+		}
+		info.Metadata = toMap(copied)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	database := p.database(dbName, true)
+	database.buckets[bucketName] = append(database.buckets[bucketName], &file{info: info, content: data})
+	return copyInfo(info), nil
+}
+
+// DownloadFile opens the file id in bucketName.
+func (p *Proxy) DownloadFile(dbName, bucketName, id string) (*db.FileDownload, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	_, f := p.file(dbName, bucketName, id)
+	if f == nil {
+		return nil, db.ErrFileNotFound
+	}
+	return db.NewFileDownload(*copyInfo(f.info), bytes.NewReader(f.content), nil, nil), nil
+}
+
+// ListFiles returns the details of all files in bucketName, in the order they were uploaded.
+func (p *Proxy) ListFiles(dbName, bucketName string) (*db.FilesResponse, error) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	response := &db.FilesResponse{
+		Files: []db.FileInfo{},
+	}
+	if database, ok := p.databases[dbName]; ok {
+		for _, f := range database.buckets[bucketName] {
+			response.Files = append(response.Files, *copyInfo(f.info))
+		}
+	}
+	return response, nil
+}
+
+// DeleteFile removes the file id from bucketName.
+func (p *Proxy) DeleteFile(dbName, bucketName, id string) (*db.DeleteResponse, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	index, f := p.file(dbName, bucketName, id)
+	if f == nil {
+		return nil, db.ErrFileNotFound
+	}
+
+	database := p.databases[dbName]
+	files := database.buckets[bucketName]
+	database.buckets[bucketName] = append(files[:index:index], files[index+1:]...)
+	if len(database.buckets[bucketName]) == 0 {
+		delete(database.buckets, bucketName)
+	}
+
+	return &db.DeleteResponse{
+		DeletedCount: 1,
+	}, nil
+}
+
+// database returns dbName, creating it if create is true. It must be called with the lock held.
+func (p *Proxy) database(dbName string, create bool) *database {
+	d, ok := p.databases[dbName]
+	if !ok && create {
+		d = &database{
+			collections: map[string]*collection{},
+			buckets:     map[string][]*file{},
+		}
+		p.databases[dbName] = d
+	}
+	return d
+}
+
+// collection returns collName, creating it (and its database) if create is true. It must be called with the
+// lock held.
+func (p *Proxy) collection(dbName, collName string, create bool) *collection {
+	d := p.database(dbName, create)
+	if d == nil {
+		return nil
+	}
+	coll, ok := d.collections[collName]
+	if !ok && create {
+		coll = &collection{}
+		d.collections[collName] = coll
+	}
+	return coll
+}
+
+// documents returns the documents of collName, or none if it doesn't exist.
+func (p *Proxy) documents(dbName, collName string) []bson.D {
+	coll := p.collection(dbName, collName, false)
+	if coll == nil {
+		return nil
+	}
+	return coll.documents
+}
+
+// file returns the file id of bucketName, and its position in the bucket.
+func (p *Proxy) file(dbName, bucketName, id string) (int, *file) {
+	fileID, err := primitive.ObjectIDFromHex(id)
+	database, ok := p.databases[dbName]
+	if err != nil || !ok {
+		return -1, nil
+	}
+	for i, f := range database.buckets[bucketName] {
+		if f.info.ID == fileID.Hex() {
+			return i, f
+		}
+	}
+	return -1, nil
+}
+
+// insert adds document, with a new ObjectID if it has no _id, and removes the oldest documents of capped
+// collections that went over their limits.
+func (c *collection) insert(document bson.D) (interface{}, error) {
+	id, ok := get(document, "_id")
+	if !ok {
+		id = primitive.NewObjectID()
+		document = append(bson.D{{Key: "_id", Value: id}}, document...)
+	}
+	for _, existing := range c.documents {
+		if existingID, _ := get(existing, "_id"); equal(existingID, id) {
+			return nil, fmt.Errorf("duplicate key: _id %v", id)
+		}
+	}
+
+	c.documents = append(c.documents, document)
+	if c.capped {
+		for len(c.documents) > 1 && ((c.maxDocuments > 0 && int64(len(c.documents)) > c.maxDocuments) ||
+			c.size() > c.sizeInBytes) {
+			c.documents = c.documents[1:]
+		}
+	}
+	return id, nil
+}
+
+// size is the size of the documents of c, encoded as BSON.
+func (c *collection) size() int64 {
+	var size int64
+	for _, document := range c.documents {
+		if encoded, err := bson.Marshal(document); err == nil {
+			size += int64(len(encoded))
+		}
+	}
+	return size
+}
+
+func filterDocuments(documents []bson.D, filter bson.D) ([]bson.D, error) {
+	var found []bson.D
+	for _, document := range documents {
+		matched, err := match(document, filter)
+		if err != nil {
+			return nil, err
+		}
+		if matched {
+			found = append(found, document)
+		}
+	}
+	return found, nil
+}
+
+// texts returns all strings in value, including those in nested documents and arrays.
+func texts(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return []string{v}
+	case bson.D:
+		var found []string
+		for _, element := range v {
+			found = append(found, texts(element.Value)...)
+		}
+		return found
+	case bson.A:
+		var found []string
+		for _, element := range v {
+			found = append(found, texts(element)...)
+		}
+		return found
+	default:
+		return nil
+	}
+}
+
+func copyInfo(info db.FileInfo) *db.FileInfo {
+	if info.Metadata != nil {
+		copied, err := toDocument(info.Metadata)
+		if err == nil {
+			info.Metadata = toMap(copied)
+		}
+	}
+	return &info
+}
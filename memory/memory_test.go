@@ -0,0 +1,465 @@
+package memory_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/memory"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Interface guard.
+var _ db.Proxy = &memory.Proxy{}
+
+func newProxy(t *testing.T) *memory.Proxy {
+	p := memory.NewProxy()
+	err := p.Seed("library", "quotes",
+		bson.M{"_id": 1, "author": "Seneca", "publications": 3, "tags": bson.A{"stoic", "letters"}, "book": bson.M{"year": 65}},
+		bson.M{"_id": 2, "author": "Epictetus", "publications": 1, "tags": bson.A{"stoic"}},
+		bson.M{"_id": 3, "author": "Cicero", "publications": 5, "tags": bson.A{"rhetoric"}, "book": bson.M{"year": -44}},
+		bson.M{"_id": 4, "author": "Marcus Aurelius", "tags": bson.A{}, "reviews": bson.A{bson.M{"stars": 5}, bson.M{"stars": 2}}},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func ids(results []bson.M) []interface{} {
+	found := []interface{}{}
+	for _, result := range results {
+		found = append(found, result["_id"])
+	}
+	return found
+}
+
+func TestFind(t *testing.T) {
+	p := newProxy(t)
+
+	testCases := []struct {
+		name     string
+		filter   interface{}
+		expected []interface{}
+	}{
+		{"everything", nil, []interface{}{int32(1), int32(2), int32(3), int32(4)}},
+		{"equality", bson.M{"author": "Cicero"}, []interface{}{int32(3)}},
+		{"array element", bson.M{"tags": "stoic"}, []interface{}{int32(1), int32(2)}},
+		{"nested field", bson.M{"book.year": 65}, []interface{}{int32(1)}},
+		{"missing is null", bson.M{"book": nil}, []interface{}{int32(2), int32(4)}},
+		{"comparison", bson.M{"publications": bson.M{"$gte": 3}}, []interface{}{int32(1), int32(3)}},
+		{"comparison across numeric types", bson.M{"publications": bson.M{"$lt": 1.5}}, []interface{}{int32(2)}},
+		{"$in", bson.M{"author": bson.M{"$in": bson.A{"Seneca", "Cicero"}}}, []interface{}{int32(1), int32(3)}},
+		{"$nin", bson.M{"tags": bson.M{"$nin": bson.A{"stoic"}}}, []interface{}{int32(3), int32(4)}},
+		{"$ne", bson.M{"author": bson.M{"$ne": "Seneca"}}, []interface{}{int32(2), int32(3), int32(4)}},
+		{"$exists", bson.M{"publications": bson.M{"$exists": false}}, []interface{}{int32(4)}},
+		{"$regex", bson.M{"author": bson.M{"$regex": "^marcus", "$options": "i"}}, []interface{}{int32(4)}},
+		{"regex value", bson.M{"author": primitive.Regex{Pattern: "tus$"}}, []interface{}{int32(2)}},
+		{"$size", bson.M{"tags": bson.M{"$size": 0}}, []interface{}{int32(4)}},
+		{"$all", bson.M{"tags": bson.M{"$all": bson.A{"letters", "stoic"}}}, []interface{}{int32(1)}},
+		{"$elemMatch", bson.M{"reviews": bson.M{"$elemMatch": bson.M{"stars": bson.M{"$gt": 4}}}}, []interface{}{int32(4)}},
+		{"array of documents", bson.M{"reviews.stars": 2}, []interface{}{int32(4)}},
+		{"$not", bson.M{"publications": bson.M{"$not": bson.M{"$gt": 2}}}, []interface{}{int32(2), int32(4)}},
+		{"$or", bson.M{"$or": bson.A{bson.M{"author": "Seneca"}, bson.M{"publications": 5}}}, []interface{}{int32(1), int32(3)}},
+		{"$and", bson.M{"$and": bson.A{bson.M{"tags": "stoic"}, bson.M{"publications": 1}}}, []interface{}{int32(2)}},
+		{"$nor", bson.M{"$nor": bson.A{bson.M{"tags": "stoic"}, bson.M{"author": "Cicero"}}}, []interface{}{int32(4)}},
+		{"unknown collection", bson.M{}, []interface{}{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			collection := "quotes"
+			if tc.name == "unknown collection" {
+				collection = "letters"
+			}
+			response, err := p.Find("library", collection, tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, ids(response.Results))
+		})
+	}
+
+	_, err := p.Find("library", "quotes", bson.M{"$where": "true"})
+	assert.True(t, errors.Is(err, memory.ErrUnsupported))
+	_, err = p.Find("library", "quotes", bson.M{"author": bson.M{"$text": "x"}})
+	assert.True(t, errors.Is(err, memory.ErrUnsupported))
+}
+
+func TestFindReturnsCopies(t *testing.T) {
+	p := newProxy(t)
+
+	response, err := p.Find("library", "quotes", bson.M{"_id": 1})
+	assert.NoError(t, err)
+	response.Results[0]["author"] = "changed"
+	response.Results[0]["tags"].(bson.A)[0] = "changed"
+
+	response, err = p.Find("library", "quotes", bson.M{"_id": 1})
+	assert.NoError(t, err)
+	assert.Equal(t, "Seneca", response.Results[0]["author"])
+	assert.Equal(t, bson.A{"stoic", "letters"}, response.Results[0]["tags"])
+}
+
+func TestInsert(t *testing.T) {
+	p := memory.NewProxy()
+
+	response, err := p.Insert("library", "quotes", db.Quote{Author: "Seneca", Publications: 2})
+	assert.NoError(t, err)
+	id, ok := response.InsertedID.(primitive.ObjectID)
+	assert.True(t, ok)
+
+	found, err := p.Find("library", "quotes", bson.M{"_id": id})
+	assert.NoError(t, err)
+	if assert.Len(t, found.Results, 1) {
+		assert.Equal(t, "Seneca", found.Results[0]["author"])
+		assert.Equal(t, int32(2), found.Results[0]["publications"])
+	}
+
+	health, err := p.HealthCheck()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"library"}, health.Databases)
+
+	assert.Error(t, p.Seed("library", "quotes", bson.M{"_id": id}), "duplicate _id")
+}
+
+func TestUpdate(t *testing.T) {
+	testCases := []struct {
+		name     string
+		filter   interface{}
+		update   interface{}
+		matched  int64
+		modified int64
+		id       int
+		expected bson.M
+	}{
+		{"$set", bson.M{"_id": 2}, bson.M{"$set": bson.M{"author": "Epictetus of Hierapolis", "book.year": 108}}, 1, 1, 2,
+			bson.M{"_id": int32(2), "author": "Epictetus of Hierapolis", "publications": int32(1), "tags": bson.A{"stoic"}, "book": bson.M{"year": int32(108)}}},
+		{"$set the same value", bson.M{"_id": 2}, bson.M{"$set": bson.M{"author": "Epictetus"}}, 1, 0, 2,
+			bson.M{"_id": int32(2), "author": "Epictetus", "publications": int32(1), "tags": bson.A{"stoic"}}},
+		{"$inc and $unset", bson.M{"_id": 1}, bson.M{"$inc": bson.M{"publications": 2}, "$unset": bson.M{"book": ""}}, 1, 1, 1,
+			bson.M{"_id": int32(1), "author": "Seneca", "publications": int32(5), "tags": bson.A{"stoic", "letters"}}},
+		{"$mul by a float", bson.M{"_id": 2}, bson.M{"$mul": bson.M{"publications": 1.5}}, 1, 1, 2,
+			bson.M{"_id": int32(2), "author": "Epictetus", "publications": 1.5, "tags": bson.A{"stoic"}}},
+		{"$push and $addToSet", bson.M{"_id": 2}, bson.D{{Key: "$push", Value: bson.M{"tags": "discourses"}}, {Key: "$addToSet", Value: bson.M{"quotes": bson.M{"$each": bson.A{"a", "a"}}}}}, 1, 1, 2,
+			bson.M{"_id": int32(2), "author": "Epictetus", "publications": int32(1), "tags": bson.A{"stoic", "discourses"}, "quotes": bson.A{"a"}}},
+		{"$pull", bson.M{"_id": 4}, bson.M{"$pull": bson.M{"reviews": bson.M{"stars": bson.M{"$lt": 3}}}}, 1, 1, 4,
+			bson.M{"_id": int32(4), "author": "Marcus Aurelius", "tags": bson.A{}, "reviews": bson.A{bson.M{"stars": int32(5)}}}},
+		{"$rename, $min and $max", bson.M{"_id": 3}, bson.M{"$rename": bson.M{"author": "name"}, "$min": bson.M{"publications": 2}, "$max": bson.M{"book.year": -10}}, 1, 1, 3,
+			bson.M{"_id": int32(3), "name": "Cicero", "publications": int32(2), "tags": bson.A{"rhetoric"}, "book": bson.M{"year": int32(-10)}}},
+		{"many documents", bson.M{"tags": "stoic"}, bson.M{"$set": bson.M{"school": "stoa"}}, 2, 2, 1,
+			bson.M{"_id": int32(1), "author": "Seneca", "publications": int32(3), "tags": bson.A{"stoic", "letters"}, "book": bson.M{"year": int32(65)}, "school": "stoa"}},
+		{"nothing matched", bson.M{"author": "Plato"}, bson.M{"$set": bson.M{"school": "academy"}}, 0, 0, 1,
+			bson.M{"_id": int32(1), "author": "Seneca", "publications": int32(3), "tags": bson.A{"stoic", "letters"}, "book": bson.M{"year": int32(65)}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProxy(t)
+
+			response, err := p.Update("library", "quotes", tc.filter, tc.update)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.matched, response.Results.MatchedCount)
+			assert.Equal(t, tc.modified, response.Results.ModifiedCount)
+
+			found, err := p.Find("library", "quotes", bson.M{"_id": tc.id})
+			assert.NoError(t, err)
+			assert.Equal(t, []bson.M{tc.expected}, found.Results)
+		})
+	}
+}
+
+func TestUpdateErrors(t *testing.T) {
+	testCases := []struct {
+		name   string
+		update interface{}
+	}{
+		{"replacement", bson.M{"author": "Plato"}},
+		{"empty", bson.M{}},
+		{"immutable _id", bson.M{"$set": bson.M{"_id": 10}}},
+		{"$inc of a string", bson.M{"$inc": bson.M{"author": 1}}},
+		{"unknown operator", bson.M{"$currentDate": bson.M{"updated": true}}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProxy(t)
+
+			_, err := p.Update("library", "quotes", bson.M{}, tc.update)
+			assert.Error(t, err)
+
+			// Failed updates change no document.
+			found, err := p.Find("library", "quotes", bson.M{"author": "Seneca"})
+			assert.NoError(t, err)
+			assert.Len(t, found.Results, 1)
+		})
+	}
+}
+
+func TestAggregatePipeline(t *testing.T) {
+	testCases := []struct {
+		name     string
+		pipeline interface{}
+		expected []bson.M
+	}{
+		{"match, sort and limit", bson.A{
+			bson.M{"$match": bson.M{"publications": bson.M{"$exists": true}}},
+			bson.M{"$sort": bson.M{"publications": -1}},
+			bson.M{"$limit": 2},
+			bson.M{"$project": bson.M{"author": 1}},
+		}, []bson.M{{"_id": int32(3), "author": "Cicero"}, {"_id": int32(1), "author": "Seneca"}}},
+		{"skip and exclusion", bson.A{
+			bson.M{"$sort": bson.M{"_id": 1}},
+			bson.M{"$skip": 3},
+			bson.M{"$project": bson.M{"reviews": 0, "tags": 0}},
+		}, []bson.M{{"_id": int32(4), "author": "Marcus Aurelius"}}},
+		{"computed fields", bson.A{
+			bson.M{"$match": bson.M{"_id": 1}},
+			bson.M{"$project": bson.M{"_id": 0, "name": "$author", "year": "$book.year", "kind": bson.M{"$literal": "letter"}}},
+		}, []bson.M{{"name": "Seneca", "year": int32(65), "kind": "letter"}}},
+		{"unwind and group", bson.A{
+			bson.M{"$unwind": "$tags"},
+			bson.M{"$group": bson.D{
+				{Key: "_id", Value: "$tags"},
+				{Key: "count", Value: bson.M{"$sum": 1}},
+				{Key: "authors", Value: bson.M{"$push": "$author"}},
+				{Key: "publications", Value: bson.M{"$sum": "$publications"}},
+			}},
+			bson.M{"$sort": bson.M{"_id": 1}},
+		}, []bson.M{
+			{"_id": "letters", "count": int32(1), "authors": bson.A{"Seneca"}, "publications": int32(3)},
+			{"_id": "rhetoric", "count": int32(1), "authors": bson.A{"Cicero"}, "publications": int32(5)},
+			{"_id": "stoic", "count": int32(2), "authors": bson.A{"Seneca", "Epictetus"}, "publications": int32(4)},
+		}},
+		{"unwind keeping empty arrays", bson.A{
+			bson.M{"$match": bson.M{"_id": 4}},
+			bson.M{"$unwind": bson.M{"path": "$tags", "preserveNullAndEmptyArrays": true}},
+			bson.M{"$project": bson.M{"author": 1}},
+		}, []bson.M{{"_id": int32(4), "author": "Marcus Aurelius"}}},
+		{"group everything", bson.A{
+			bson.M{"$group": bson.D{
+				{Key: "_id", Value: nil},
+				{Key: "min", Value: bson.M{"$min": "$publications"}},
+				{Key: "max", Value: bson.M{"$max": "$publications"}},
+				{Key: "avg", Value: bson.M{"$avg": "$publications"}},
+				{Key: "first", Value: bson.M{"$first": "$author"}},
+				{Key: "schools", Value: bson.M{"$addToSet": "$school"}},
+			}},
+		}, []bson.M{{"_id": nil, "min": int32(1), "max": int32(5), "avg": 3.0, "first": "Seneca", "schools": bson.A{}}}},
+		{"add fields and count", bson.A{
+			bson.M{"$addFields": bson.M{"stoic": true}},
+			bson.M{"$match": bson.M{"stoic": true}},
+			bson.M{"$count": "total"},
+		}, []bson.M{{"total": int32(4)}}},
+		{"unset", bson.A{
+			bson.M{"$match": bson.M{"_id": 2}},
+			bson.M{"$unset": bson.A{"tags", "publications"}},
+		}, []bson.M{{"_id": int32(2), "author": "Epictetus"}}},
+		{"nothing", bson.A{bson.M{"$match": bson.M{"author": "Plato"}}}, []bson.M{}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := newProxy(t)
+
+			response, err := p.AggregatePipeline("library", "quotes", tc.pipeline)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.expected, response.Results)
+		})
+	}
+
+	p := newProxy(t)
+	_, err := p.AggregatePipeline("library", "quotes", bson.A{bson.M{"$lookup": bson.M{}}})
+	assert.True(t, errors.Is(err, memory.ErrUnsupported))
+	_, err = p.AggregatePipeline("library", "quotes", bson.A{bson.M{"$project": bson.M{"author": 1, "tags": 0}}})
+	assert.Error(t, err, "inclusion and exclusion")
+}
+
+func TestAggregate(t *testing.T) {
+	p := newProxy(t)
+
+	response, err := p.Aggregate("library", "quotes", bson.A{
+		bson.M{"$group": bson.M{"_id": nil, "minpublications": bson.M{"$min": "$publications"}}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, response.MinPublications)
+
+	response, err = p.Aggregate("library", "letters", bson.A{})
+	assert.NoError(t, err)
+	assert.Equal(t, &db.AggregateResponse{MinPublications: 0}, response)
+}
+
+func TestAdmin(t *testing.T) {
+	p := newProxy(t)
+
+	created, err := p.CreateCollection("library", "log", db.CreateCollectionRequest{Capped: true, SizeInBytes: 4096, MaxDocuments: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, &db.AdminResponse{Database: "library", Collection: "log", Operation: "create"}, created)
+	_, err = p.CreateCollection("library", "log", db.CreateCollectionRequest{})
+	assert.Error(t, err, "already exists")
+	_, err = p.CreateCollection("library", "other", db.CreateCollectionRequest{Capped: true})
+	assert.Error(t, err, "capped without size")
+
+	// Capped collections keep only the newest documents.
+	assert.NoError(t, p.Seed("library", "log", bson.M{"n": 1}, bson.M{"n": 2}, bson.M{"n": 3}))
+	found, err := p.Find("library", "log", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, found.Results, 2) {
+		assert.Equal(t, int32(2), found.Results[0]["n"])
+	}
+
+	collections, err := p.ListCollections("library")
+	assert.NoError(t, err)
+	if assert.Len(t, collections.Collections, 2) {
+		assert.Equal(t, "log", collections.Collections[0].Name)
+		assert.True(t, collections.Collections[0].Capped)
+		assert.Equal(t, "quotes", collections.Collections[1].Name)
+		assert.Equal(t, int64(4), collections.Collections[1].Count)
+		assert.True(t, collections.Collections[1].Size > 0)
+		assert.Equal(t, "_id_", collections.Collections[1].Indexes[0].Name)
+	}
+
+	_, err = p.RenameCollection("library", "log", db.RenameCollectionRequest{To: "quotes"})
+	assert.Error(t, err, "target exists")
+	_, err = p.RenameCollection("library", "missing", db.RenameCollectionRequest{To: "other"})
+	assert.Error(t, err, "source is missing")
+	renamed, err := p.RenameCollection("library", "quotes", db.RenameCollectionRequest{To: "log", DropTarget: true})
+	assert.NoError(t, err)
+	assert.Equal(t, &db.AdminResponse{Database: "library", Collection: "log", Operation: "rename"}, renamed)
+	found, err = p.Find("library", "log", nil)
+	assert.NoError(t, err)
+	assert.Len(t, found.Results, 4)
+
+	dropped, err := p.DropCollection("library", "log")
+	assert.NoError(t, err)
+	assert.Equal(t, &db.AdminResponse{Database: "library", Collection: "log", Operation: "drop"}, dropped)
+	collections, err = p.ListCollections("library")
+	assert.NoError(t, err)
+	assert.Empty(t, collections.Collections)
+
+	assert.NoError(t, p.Seed("archive", "quotes", bson.M{"n": 1}))
+	droppedDatabase, err := p.DropDatabase("archive")
+	assert.NoError(t, err)
+	assert.Equal(t, &db.AdminResponse{Database: "archive", Operation: "dropDatabase"}, droppedDatabase)
+	// Databases without collections are not listed.
+	health, err := p.HealthCheck()
+	assert.NoError(t, err)
+	assert.Empty(t, health.Databases)
+}
+
+func TestSearch(t *testing.T) {
+	p := newProxy(t)
+
+	response, err := p.Search("library", "quotes", db.SearchRequest{Text: "stoic seneca", PageSize: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), response.Total)
+	assert.Equal(t, int64(1), response.Page)
+	if assert.Len(t, response.Results, 1) {
+		assert.Equal(t, int32(1), response.Results[0]["_id"])
+		assert.Equal(t, 2.0, response.Results[0]["score"])
+	}
+
+	response, err = p.Search("library", "quotes", db.SearchRequest{Text: "stoic seneca", Page: 2, PageSize: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{int32(2)}, ids(response.Results))
+
+	response, err = p.Search("library", "quotes", db.SearchRequest{Text: "seneca", CaseSensitive: true})
+	assert.NoError(t, err)
+	assert.Empty(t, response.Results)
+
+	_, err = p.Search("library", "quotes", db.SearchRequest{Text: " "})
+	assert.Error(t, err)
+}
+
+func TestExplain(t *testing.T) {
+	p := newProxy(t)
+
+	response, err := p.Explain("library", "quotes", db.ExplainRequest{Operation: "find", Filter: bson.M{"tags": "stoic"}, Verbosity: "executionStats"})
+	assert.NoError(t, err)
+	assert.Equal(t, "executionStats", response.Verbosity)
+	plan := response.Plan.Map()
+	assert.Equal(t, "COLLSCAN", plan["queryPlanner"].(bson.D).Map()["winningPlan"].(bson.D).Map()["stage"])
+	assert.Equal(t, int64(2), plan["executionStats"].(bson.D).Map()["nReturned"])
+
+	response, err = p.Explain("library", "quotes", db.ExplainRequest{Operation: "aggregate", Pipeline: bson.A{bson.M{"$limit": 1}}})
+	assert.NoError(t, err)
+	assert.Equal(t, "queryPlanner", response.Verbosity)
+	assert.NotContains(t, response.Plan.Map(), "executionStats")
+
+	_, err = p.Explain("library", "quotes", db.ExplainRequest{Operation: "delete"})
+	assert.Error(t, err)
+}
+
+func TestFiles(t *testing.T) {
+	p := memory.NewProxy()
+
+	metadata := bson.M{"owner": "seneca"}
+	info, err := p.UploadFile("library", "fs", "letters.txt", "text/plain", metadata, strings.NewReader("Ad Lucilium"))
+	assert.NoError(t, err)
+	assert.Equal(t, "letters.txt", info.Filename)
+	assert.Equal(t, int64(11), info.Length)
+	assert.Equal(t, "text/plain", info.ContentType)
+	assert.Equal(t, bson.M{"owner": "seneca"}, info.Metadata)
+	assert.Equal(t, bson.M{"owner": "seneca"}, metadata, "the metadata of the caller is not changed")
+
+	download, err := p.DownloadFile("library", "fs", info.ID)
+	assert.NoError(t, err)
+	assert.NoError(t, download.Skip(3))
+	content, err := ioutil.ReadAll(download.Content)
+	assert.NoError(t, err)
+	assert.Equal(t, "Lucilium", string(content))
+	assert.NoError(t, download.Close())
+
+	files, err := p.ListFiles("library", "fs")
+	assert.NoError(t, err)
+	assert.Equal(t, []db.FileInfo{*info}, files.Files)
+	files, err = p.ListFiles("library", "images")
+	assert.NoError(t, err)
+	assert.Empty(t, files.Files)
+
+	deleted, err := p.DeleteFile("library", "fs", info.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted.DeletedCount)
+
+	for _, id := range []string{info.ID, "not-an-id"} {
+		_, err = p.DownloadFile("library", "fs", id)
+		assert.True(t, errors.Is(err, db.ErrFileNotFound))
+		_, err = p.DeleteFile("library", "fs", id)
+		assert.True(t, errors.Is(err, db.ErrFileNotFound))
+	}
+}
+
+func TestLoad(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "seed.json")
+	seed := `{"library": {"quotes": [
+		{"_id": {"$oid": "5f4d641403490cb668ed8313"}, "author": "Seneca", "publications": 3},
+		{"author": "Cicero", "written": {"$date": "2020-01-01T00:00:00Z"}}
+	]}}`
+	if err := ioutil.WriteFile(filename, []byte(seed), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := memory.Load(filename)
+	assert.NoError(t, err)
+	found, err := p.Find("library", "quotes", nil)
+	assert.NoError(t, err)
+	if assert.Len(t, found.Results, 2) {
+		id, _ := primitive.ObjectIDFromHex("5f4d641403490cb668ed8313")
+		assert.Equal(t, id, found.Results[0]["_id"])
+		assert.Equal(t, int32(3), found.Results[0]["publications"])
+		assert.IsType(t, primitive.ObjectID{}, found.Results[1]["_id"])
+		assert.IsType(t, primitive.DateTime(0), found.Results[1]["written"])
+	}
+
+	_, err = memory.Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.True(t, os.IsNotExist(err))
+
+	if err = ioutil.WriteFile(filename, []byte(`{"library": []}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = memory.Load(filename)
+	assert.Error(t, err)
+}
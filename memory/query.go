@@ -0,0 +1,303 @@
+package memory
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// match tells if document matches filter. It supports the logical operators ($and, $or, $nor), and the
+// comparison ($eq, $ne, $gt, $gte, $lt, $lte, $in, $nin), element ($exists), evaluation ($regex) and array
+// ($all, $elemMatch, $size) operators, and $not. Other operators fail with ErrUnsupported.
+func match(document bson.D, filter bson.D) (bool, error) {
+	for _, element := range filter {
+		matched, err := matchElement(document, element)
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func matchElement(document bson.D, element bson.E) (bool, error) {
+	switch element.Key {
+	case "$and", "$or", "$nor":
+		filters, ok := element.Value.(bson.A)
+		if !ok || len(filters) == 0 {
+			return false, fmt.Errorf("%s requires a non-empty array", element.Key)
+		}
+		for _, value := range filters {
+			filter, ok := value.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array of documents", element.Key)
+			}
+			matched, err := match(document, filter)
+			if err != nil {
+				return false, err
+			}
+			switch {
+			case element.Key == "$and" && !matched:
+				return false, nil
+			case element.Key == "$or" && matched:
+				return true, nil
+			case element.Key == "$nor" && matched:
+				return false, nil
+			}
+		}
+		return element.Key != "$or", nil
+	case "$comment":
+		return true, nil
+	}
+	if strings.HasPrefix(element.Key, "$") {
+		return false, fmt.Errorf("%w: query operator %s", ErrUnsupported, element.Key)
+	}
+
+	values := resolve(document, split(element.Key))
+	if conditions, ok := operators(element.Value); ok {
+		return matchOperators(values, conditions)
+	}
+	return matchEqual(values, element.Value), nil
+}
+
+// operators returns value as a list of operators, if it is a document whose fields are all operators.
+func operators(value interface{}) (bson.D, bool) {
+	document, ok := value.(bson.D)
+	if !ok || len(document) == 0 {
+		return nil, false
+	}
+	for _, element := range document {
+		if !strings.HasPrefix(element.Key, "$") {
+			return nil, false
+		}
+	}
+	return document, true
+}
+
+func matchOperators(values []interface{}, conditions bson.D) (bool, error) {
+	// $options only modifies $regex.
+	regexOptions, _ := get(conditions, "$options")
+
+	for _, condition := range conditions {
+		var matched bool
+		var err error
+
+		switch condition.Key {
+		case "$eq":
+			matched = matchEqual(values, condition.Value)
+		case "$ne":
+			matched = !matchEqual(values, condition.Value)
+		case "$gt", "$gte", "$lt", "$lte":
+			matched = matchComparison(values, condition.Key, condition.Value)
+		case "$in", "$nin":
+			list, ok := condition.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("%s requires an array", condition.Key)
+			}
+			for _, value := range list {
+				if matchEqual(values, value) {
+					matched = true
+					break
+				}
+			}
+			if condition.Key == "$nin" {
+				matched = !matched
+			}
+		case "$exists":
+			matched = (len(values) > 0) == truthy(condition.Value)
+		case "$regex":
+			options, _ := regexOptions.(string)
+			var pattern *regexp.Regexp
+			pattern, err = compileRegex(condition.Value, options)
+			if err == nil {
+				matched = matchRegex(values, pattern)
+			}
+		case "$options":
+			continue
+		case "$size":
+			size, ok := number(condition.Value)
+			if !ok {
+				return false, fmt.Errorf("$size requires a number")
+			}
+			for _, value := range values {
+				if array, ok := value.(bson.A); ok && float64(len(array)) == size {
+					matched = true
+					break
+				}
+			}
+		case "$all":
+			list, ok := condition.Value.(bson.A)
+			if !ok {
+				return false, fmt.Errorf("$all requires an array")
+			}
+			matched = len(list) > 0
+			for _, value := range list {
+				if !matchEqual(values, value) {
+					matched = false
+					break
+				}
+			}
+		case "$elemMatch":
+			filter, ok := condition.Value.(bson.D)
+			if !ok {
+				return false, fmt.Errorf("$elemMatch requires a document")
+			}
+			matched, err = matchElemMatch(values, filter)
+		case "$not":
+			switch not := condition.Value.(type) {
+			case bson.D:
+				matched, err = matchOperators(values, not)
+			case primitive.Regex:
+				var pattern *regexp.Regexp
+				pattern, err = compileRegex(not, "")
+				if err == nil {
+					matched = matchRegex(values, pattern)
+				}
+			default:
+				return false, fmt.Errorf("$not requires a document or a regular expression")
+			}
+			matched = !matched
+		default:
+			return false, fmt.Errorf("%w: query operator %s", ErrUnsupported, condition.Key)
+		}
+
+		if err != nil || !matched {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// matchEqual tells if any of values, or of their elements if they are arrays, equals expected.
+// Missing fields equal null.
+func matchEqual(values []interface{}, expected interface{}) bool {
+	if regex, ok := expected.(primitive.Regex); ok {
+		pattern, err := compileRegex(regex, "")
+		return err == nil && matchRegex(values, pattern)
+	}
+	if expected == nil && len(values) == 0 {
+		return true
+	}
+	for _, value := range values {
+		if equal(value, expected) {
+			return true
+		}
+		if array, ok := value.(bson.A); ok {
+			for _, element := range array {
+				if equal(element, expected) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// matchComparison tells if any of values, or of their elements, compares to expected as operator says.
+// Only values of the same type are compared, as in MongoDB.
+func matchComparison(values []interface{}, operator string, expected interface{}) bool {
+	check := func(value interface{}) bool {
+		if typeOrder(value) != typeOrder(expected) {
+			return false
+		}
+		c := compare(value, expected)
+		switch operator {
+		case "$gt":
+			return c > 0
+		case "$gte":
+			return c >= 0
+		case "$lt":
+			return c < 0
+		default:
+			return c <= 0
+		}
+	}
+
+	for _, value := range values {
+		if check(value) {
+			return true
+		}
+		if array, ok := value.(bson.A); ok {
+			for _, element := range array {
+				if check(element) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+func matchElemMatch(values []interface{}, filter bson.D) (bool, error) {
+	conditions, onlyOperators := operators(filter)
+	for _, value := range values {
+		array, ok := value.(bson.A)
+		if !ok {
+			continue
+		}
+		for _, element := range array {
+			var matched bool
+			var err error
+			if nested, ok := element.(bson.D); ok && !onlyOperators {
+				matched, err = match(nested, filter)
+			} else if onlyOperators {
+				matched, err = matchOperators([]interface{}{element}, conditions)
+			}
+			if err != nil {
+				return false, err
+			}
+			if matched {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func matchRegex(values []interface{}, pattern *regexp.Regexp) bool {
+	for _, value := range values {
+		if text, ok := value.(string); ok && pattern.MatchString(text) {
+			return true
+		}
+		if array, ok := value.(bson.A); ok {
+			for _, element := range array {
+				if text, ok := element.(string); ok && pattern.MatchString(text) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// compileRegex compiles a pattern given as a string or as a regular expression, with the options i, m and s.
+func compileRegex(value interface{}, options string) (*regexp.Regexp, error) {
+	var pattern string
+	switch v := value.(type) {
+	case string:
+		pattern = v
+	case primitive.Regex:
+		pattern = v.Pattern
+		if len(options) == 0 {
+			options = v.Options
+		}
+	default:
+		return nil, fmt.Errorf("$regex requires a string or a regular expression")
+	}
+
+	var flags string
+	for _, option := range options {
+		switch option {
+		case 'i', 'm', 's':
+			flags += string(option)
+		default:
+			return nil, fmt.Errorf("%w: regular expression option %q", ErrUnsupported, option)
+		}
+	}
+	if len(flags) > 0 {
+		pattern = "(?" + flags + ")" + pattern
+	}
+	return regexp.Compile(pattern)
+}
@@ -0,0 +1,199 @@
+package memory
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// errNotUpdate is returned for updates without operators: UpdateMany doesn't replace documents.
+var errNotUpdate = errors.New("update document requires atomic operators")
+
+// applyUpdate returns a copy of document changed by update. It supports $set, $unset, $inc, $mul, $min, $max,
+// $rename, $push and $addToSet (with $each), and $pull (of equal values, or of those matching a condition).
+func applyUpdate(document bson.D, update bson.D) (bson.D, error) {
+	if len(update) == 0 {
+		return nil, errNotUpdate
+	}
+
+	updated := copyDocument(document)
+	for _, operation := range update {
+		if !strings.HasPrefix(operation.Key, "$") {
+			return nil, errNotUpdate
+		}
+		fields, ok := operation.Value.(bson.D)
+		if !ok {
+			return nil, fmt.Errorf("%s requires a document", operation.Key)
+		}
+
+		for _, field := range fields {
+			if field.Key == "_id" || strings.HasPrefix(field.Key, "_id.") {
+				if current, ok := get(updated, "_id"); !(operation.Key == "$set" && ok && equal(current, field.Value)) {
+					return nil, fmt.Errorf("the field _id is immutable")
+				}
+				continue
+			}
+
+			var err error
+			updated, err = applyOperator(updated, operation.Key, split(field.Key), field.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%s of %s: %w", operation.Key, field.Key, err)
+			}
+		}
+	}
+	return updated, nil
+}
+
+func applyOperator(document bson.D, operator string, path []string, argument interface{}) (bson.D, error) {
+	current, exists := lookupExact(document, path)
+
+	switch operator {
+	case "$set":
+		return setPath(document, path, copyValue(argument))
+	case "$unset":
+		return unsetPath(document, path), nil
+	case "$inc", "$mul":
+		delta, ok := number(argument)
+		if !ok {
+			return nil, fmt.Errorf("requires a number")
+		}
+		if !exists {
+			if operator == "$mul" {
+				return setPath(document, path, zeroLike(argument))
+			}
+			return setPath(document, path, argument)
+		}
+		value, ok := number(current)
+		if !ok {
+			return nil, fmt.Errorf("cannot change a non-numeric field")
+		}
+		if operator == "$inc" {
+			return setPath(document, path, numberLike(value+delta, current, argument))
+		}
+		return setPath(document, path, numberLike(value*delta, current, argument))
+	case "$min", "$max":
+		c := compare(argument, current)
+		if !exists || (operator == "$min" && c < 0) || (operator == "$max" && c > 0) {
+			return setPath(document, path, copyValue(argument))
+		}
+		return document, nil
+	case "$rename":
+		to, ok := argument.(string)
+		if !ok || len(to) == 0 {
+			return nil, fmt.Errorf("requires the new name of the field")
+		}
+		if !exists {
+			return document, nil
+		}
+		return setPath(unsetPath(document, path), split(to), current)
+	case "$push", "$addToSet":
+		array, ok := current.(bson.A)
+		if exists && !ok {
+			return nil, fmt.Errorf("cannot add to a field that is not an array")
+		}
+		values := bson.A{argument}
+		if modifiers, ok := argument.(bson.D); ok {
+			if each, found := get(modifiers, "$each"); found {
+				if values, ok = each.(bson.A); !ok {
+					return nil, fmt.Errorf("$each requires an array")
+				}
+			}
+		}
+		array = append(bson.A{}, array...)
+		for _, value := range values {
+			if operator == "$addToSet" && contains(array, value) {
+				continue
+			}
+			array = append(array, copyValue(value))
+		}
+		return setPath(document, path, array)
+	case "$pull":
+		if !exists {
+			return document, nil
+		}
+		array, ok := current.(bson.A)
+		if !ok {
+			return nil, fmt.Errorf("cannot pull from a field that is not an array")
+		}
+		kept := bson.A{}
+		for _, element := range array {
+			pulled, err := matchPull(element, argument)
+			if err != nil {
+				return nil, err
+			}
+			if !pulled {
+				kept = append(kept, element)
+			}
+		}
+		return setPath(document, path, kept)
+	default:
+		return nil, fmt.Errorf("%w: update operator %s", ErrUnsupported, operator)
+	}
+}
+
+// lookupExact returns the value of path in document, without traversing arrays of documents.
+func lookupExact(document bson.D, path []string) (interface{}, bool) {
+	var value interface{} = document
+	for _, part := range path {
+		nested, ok := value.(bson.D)
+		if !ok {
+			values := resolve(value, []string{part})
+			if _, isArray := value.(bson.A); !isArray || len(values) != 1 {
+				return nil, false
+			}
+			value = values[0]
+			continue
+		}
+		if value, ok = get(nested, part); !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+// matchPull tells if $pull removes element: if it equals condition, or matches it when it is a query.
+func matchPull(element, condition interface{}) (bool, error) {
+	filter, ok := condition.(bson.D)
+	if !ok {
+		return equal(element, condition), nil
+	}
+	if conditions, ok := operators(filter); ok {
+		return matchOperators([]interface{}{element}, conditions)
+	}
+	document, ok := element.(bson.D)
+	if !ok {
+		return false, nil
+	}
+	return match(document, filter)
+}
+
+func contains(array bson.A, value interface{}) bool {
+	for _, element := range array {
+		if equal(element, value) {
+			return true
+		}
+	}
+	return false
+}
+
+// numberLike converts result to the widest type of a and b, as MongoDB does with arithmetic.
+func numberLike(result float64, a, b interface{}) interface{} {
+	_, aFloat := a.(float64)
+	_, bFloat := b.(float64)
+	_, aLong := a.(int64)
+	_, bLong := b.(int64)
+	switch {
+	case aFloat || bFloat:
+		return result
+	case aLong || bLong:
+		return int64(result)
+	default:
+		return int32(result)
+	}
+}
+
+func zeroLike(value interface{}) interface{} {
+	return numberLike(0, value, value)
+}
@@ -0,0 +1,420 @@
+package memory
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Documents are kept as bson.D, converted from any value the driver accepts, so they hold the same types as
+// documents read from MongoDB: bson.D, bson.A, int32, int64, float64, string, primitive.ObjectID and so on.
+
+// toDocument converts value, like a bson.M, a map or a struct, to a bson.D.
+func toDocument(value interface{}) (bson.D, error) {
+	if value == nil {
+		return bson.D{}, nil
+	}
+	encoded, err := bson.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var document bson.D
+	err = bson.Unmarshal(encoded, &document)
+	return document, err
+}
+
+// toDocuments converts a list of documents, like a pipeline.
+func toDocuments(value interface{}) ([]bson.D, error) {
+	if value == nil {
+		return nil, nil
+	}
+	encoded, err := bson.Marshal(bson.M{"list": value})
+	if err != nil {
+		return nil, err
+	}
+	var wrapper struct {
+		List []bson.D `bson:"list"`
+	}
+	err = bson.Unmarshal(encoded, &wrapper)
+	return wrapper.List, err
+}
+
+// toMap converts document to a bson.M, copying it.
+func toMap(document bson.D) bson.M {
+	encoded, err := bson.Marshal(document)
+	if err != nil {
+		return bson.M{}
+	}
+	var result bson.M
+	if bson.Unmarshal(encoded, &result) != nil {
+		return bson.M{}
+	}
+	return result
+}
+
+// toMaps converts documents to bson.M, copying them.
+func toMaps(documents []bson.D) []bson.M {
+	results := make([]bson.M, 0, len(documents))
+	for _, document := range documents {
+		results = append(results, toMap(document))
+	}
+	return results
+}
+
+// copyDocument copies document and all of its nested documents and arrays.
+func copyDocument(document bson.D) bson.D {
+	return copyValue(document).(bson.D)
+}
+
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case bson.D:
+		copied := make(bson.D, len(v))
+		for i, element := range v {
+			copied[i] = bson.E{Key: element.Key, Value: copyValue(element.Value)}
+		}
+		return copied
+	case bson.A:
+		copied := make(bson.A, len(v))
+		for i, element := range v {
+			copied[i] = copyValue(element)
+		}
+		return copied
+	default:
+		return value
+	}
+}
+
+// get returns the field key of document.
+func get(document bson.D, key string) (interface{}, bool) {
+	for _, element := range document {
+		if element.Key == key {
+			return element.Value, true
+		}
+	}
+	return nil, false
+}
+
+// set replaces the field key of document, or appends it.
+func set(document bson.D, key string, value interface{}) bson.D {
+	for i, element := range document {
+		if element.Key == key {
+			document[i].Value = value
+			return document
+		}
+	}
+	return append(document, bson.E{Key: key, Value: value})
+}
+
+// remove deletes the field key of document.
+func remove(document bson.D, key string) bson.D {
+	for i, element := range document {
+		if element.Key == key {
+			return append(document[:i:i], document[i+1:]...)
+		}
+	}
+	return document
+}
+
+// resolve returns the values reached by the dotted path in value, the way queries do: arrays of documents
+// are traversed, so "tags.name" reaches the name of every tag. Missing fields reach nothing.
+func resolve(value interface{}, path []string) []interface{} {
+	if len(path) == 0 {
+		return []interface{}{value}
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, ok := get(v, path[0])
+		if !ok {
+			return nil
+		}
+		return resolve(field, path[1:])
+	case bson.A:
+		if index, err := strconv.Atoi(path[0]); err == nil {
+			if index < 0 || index >= len(v) {
+				return nil
+			}
+			return resolve(v[index], path[1:])
+		}
+		var values []interface{}
+		for _, element := range v {
+			if _, ok := element.(bson.D); ok {
+				values = append(values, resolve(element, path)...)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
+
+// lookup returns the value of the dotted path in document, the way expressions do: arrays of documents give
+// the array of the values of their fields.
+func lookup(value interface{}, path []string) (interface{}, bool) {
+	if len(path) == 0 {
+		return value, true
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		field, ok := get(v, path[0])
+		if !ok {
+			return nil, false
+		}
+		return lookup(field, path[1:])
+	case bson.A:
+		values := bson.A{}
+		for _, element := range v {
+			if _, ok := element.(bson.D); !ok {
+				continue
+			}
+			if found, ok := lookup(element, path); ok {
+				values = append(values, found)
+			}
+		}
+		return values, true
+	default:
+		return nil, false
+	}
+}
+
+// setPath sets the dotted path of document to value, creating the documents in the way.
+func setPath(document bson.D, path []string, value interface{}) (bson.D, error) {
+	if len(path) == 1 {
+		return set(document, path[0], value), nil
+	}
+
+	field, ok := get(document, path[0])
+	switch v := field.(type) {
+	case bson.D:
+		nested, err := setPath(v, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return set(document, path[0], nested), nil
+	case bson.A:
+		index, err := strconv.Atoi(path[1])
+		if err != nil || index < 0 {
+			return nil, fmt.Errorf("cannot create field %q in array %q", path[1], path[0])
+		}
+		for len(v) <= index {
+			v = append(v, nil)
+		}
+		if len(path) == 2 {
+			v[index] = value
+		} else {
+			nested, _ := v[index].(bson.D)
+			if v[index] != nil && nested == nil {
+				return nil, fmt.Errorf("cannot create field %q in element %d of %q", path[2], index, path[0])
+			}
+			nested, err = setPath(nested, path[2:], value)
+			if err != nil {
+				return nil, err
+			}
+			v[index] = nested
+		}
+		return set(document, path[0], v), nil
+	default:
+		if ok && field != nil {
+			return nil, fmt.Errorf("cannot create field %q in %q, which is not a document", path[1], path[0])
+		}
+		nested, err := setPath(bson.D{}, path[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		return set(document, path[0], nested), nil
+	}
+}
+
+// unsetPath removes the dotted path from document, if it exists.
+func unsetPath(document bson.D, path []string) bson.D {
+	if len(path) == 1 {
+		return remove(document, path[0])
+	}
+	field, ok := get(document, path[0])
+	if !ok {
+		return document
+	}
+	switch v := field.(type) {
+	case bson.D:
+		return set(document, path[0], unsetPath(v, path[1:]))
+	case bson.A:
+		index, err := strconv.Atoi(path[1])
+		if err != nil || index < 0 || index >= len(v) {
+			return document
+		}
+		if len(path) == 2 {
+			// Like MongoDB, unsetting an element leaves null in its place.
+			v[index] = nil
+		} else if nested, ok := v[index].(bson.D); ok {
+			v[index] = unsetPath(nested, path[2:])
+		}
+		return set(document, path[0], v)
+	default:
+		return document
+	}
+}
+
+// split splits a dotted path.
+func split(path string) []string {
+	return strings.Split(path, ".")
+}
+
+// typeOrder ranks the types the way MongoDB sorts them.
+func typeOrder(value interface{}) int {
+	switch value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return 1
+	case int32, int64, float64, int, primitive.Decimal128:
+		return 3
+	case string, primitive.Symbol:
+		return 4
+	case bson.D, bson.M:
+		return 5
+	case bson.A:
+		return 6
+	case primitive.Binary, []byte:
+		return 7
+	case primitive.ObjectID:
+		return 8
+	case bool:
+		return 9
+	case primitive.DateTime:
+		return 10
+	case primitive.Timestamp:
+		return 11
+	case primitive.Regex:
+		return 12
+	default:
+		return 13
+	}
+}
+
+// number converts the numeric types to float64.
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int32:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// compare orders a and b the way MongoDB sorts them: first by type, then by value.
+func compare(a, b interface{}) int {
+	orderA, orderB := typeOrder(a), typeOrder(b)
+	if orderA != orderB {
+		return sign(orderA - orderB)
+	}
+
+	if x, ok := number(a); ok {
+		y, _ := number(b)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+
+	switch x := a.(type) {
+	case string:
+		return strings.Compare(x, b.(string))
+	case bson.D:
+		y := b.(bson.D)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := strings.Compare(x[i].Key, y[i].Key); c != 0 {
+				return c
+			}
+			if c := compare(x[i].Value, y[i].Value); c != 0 {
+				return c
+			}
+		}
+		return sign(len(x) - len(y))
+	case bson.A:
+		y := b.(bson.A)
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return sign(len(x) - len(y))
+	case primitive.ObjectID:
+		y := b.(primitive.ObjectID)
+		return bytes.Compare(x[:], y[:])
+	case bool:
+		y := b.(bool)
+		if x == y {
+			return 0
+		}
+		if !x {
+			return -1
+		}
+		return 1
+	case primitive.DateTime:
+		y := b.(primitive.DateTime)
+		return sign64(int64(x) - int64(y))
+	case primitive.Timestamp:
+		y := b.(primitive.Timestamp)
+		if x.T != y.T {
+			return sign64(int64(x.T) - int64(y.T))
+		}
+		return sign64(int64(x.I) - int64(y.I))
+	}
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+// equal tells if a and b are the same value; numbers of different types are equal if their values are.
+func equal(a, b interface{}) bool {
+	return typeOrder(a) == typeOrder(b) && compare(a, b) == 0
+}
+
+func sign(n int) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+func sign64(n int64) int {
+	switch {
+	case n < 0:
+		return -1
+	case n > 0:
+		return 1
+	}
+	return 0
+}
+
+// truthy tells if value counts as true, like in projections: anything but false, null, undefined and zero.
+func truthy(value interface{}) bool {
+	switch v := value.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	}
+	if n, ok := number(value); ok {
+		return n != 0
+	}
+	return true
+}
@@ -0,0 +1,276 @@
+package mock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrUnexpectedCall is returned by Proxy for the calls that match no expectation.
+var ErrUnexpectedCall = errors.New("unexpected call")
+
+// TestingT is the part of *testing.T used by Proxy.
+type TestingT interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+	Cleanup(func())
+}
+
+// Matcher tells if an argument of a call is the expected one.
+type Matcher func(value interface{}) bool
+
+// Any matches every value.
+func Any() Matcher {
+	return func(value interface{}) bool {
+		return true
+	}
+}
+
+// Eq matches the values equal to expected. Filters, updates, pipelines and other documents are compared as
+// BSON, so bson.M, bson.D and structs with the same fields are equal, regardless of the order of the fields.
+// Numbers must have the same BSON type: Go ints are int32 when they fit.
+func Eq(expected interface{}) Matcher {
+	normalized, ok := normalize(expected)
+	return func(value interface{}) bool {
+		if !ok {
+			return reflect.DeepEqual(expected, value)
+		}
+		actual, ok := normalize(value)
+		return ok && reflect.DeepEqual(normalized, actual)
+	}
+}
+
+// Field matches the documents whose field at the dotted path matches matcher, like Field("$set.author", Eq("Seneca")).
+// Missing fields are nil.
+func Field(path string, matcher Matcher) Matcher {
+	return func(value interface{}) bool {
+		normalized, ok := normalize(value)
+		if !ok {
+			return false
+		}
+		for _, key := range strings.Split(path, ".") {
+			document, _ := normalized.(bson.M)
+			normalized = document[key]
+		}
+		return matcher(normalized)
+	}
+}
+
+// normalize converts value to what MongoDB would store: documents become bson.M, and arrays bson.A.
+func normalize(value interface{}) (interface{}, bool) {
+	encoded, err := bson.Marshal(bson.D{{Key: "v", Value: value}})
+	if err != nil {
+		return nil, false
+	}
+	var decoded bson.M
+	if bson.Unmarshal(encoded, &decoded) != nil {
+		return nil, false
+	}
+	return decoded["v"], true
+}
+
+// Expectation is a call Proxy expects, and what it returns.
+type Expectation struct {
+	operation db.Operation
+	matchers  []Matcher
+	response  interface{}
+	err       error
+	run       func(call *db.Call) (interface{}, error)
+	times     int
+	optional  bool
+	calls     int
+}
+
+// Return sets what the call returns: the response of the Proxy method, like a *db.FindResponse, and the error.
+func (e *Expectation) Return(response interface{}, err error) *Expectation {
+	e.response, e.err = response, err
+	return e
+}
+
+// Run makes the call return what f returns, instead of the values given to Return.
+func (e *Expectation) Run(f func(call *db.Call) (interface{}, error)) *Expectation {
+	e.run = f
+	return e
+}
+
+// Times limits the expectation to n calls, that must all happen. Later calls go to the next expectations,
+// so each one may return something different.
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	return e
+}
+
+// Once limits the expectation to a single call.
+func (e *Expectation) Once() *Expectation {
+	return e.Times(1)
+}
+
+// Maybe lets the expectation go without calls. Otherwise, it must be called at least once.
+func (e *Expectation) Maybe() *Expectation {
+	e.optional = true
+	return e
+}
+
+func (e *Expectation) matches(call *db.Call, arguments []interface{}) bool {
+	if e.operation != call.Operation || (e.times > 0 && e.calls >= e.times) {
+		return false
+	}
+	for i, matcher := range e.matchers {
+		if i >= len(arguments) || !matcher(arguments[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Proxy is a db.Proxy programmed by each test: calls return what their expectations say, all calls are recorded,
+// and the test fails on unexpected calls, or if expected calls don't happen by its end.
+type Proxy struct {
+	// Proxy turns every method call into a db.Call, handled by handle.
+	db.Proxy
+
+	t            TestingT
+	mu           sync.Mutex
+	expectations []*Expectation
+	calls        []db.Call
+}
+
+// NewProxy creates a Proxy that expects no calls, and checks its expectations when t finishes.
+func NewProxy(t TestingT) *Proxy {
+	p := &Proxy{t: t}
+	p.Proxy = db.Intercept(&DBProxy{}, p.handle)
+	t.Cleanup(p.AssertExpectations)
+	return p
+}
+
+// On expects calls of operation whose arguments match matchers. The arguments are those of the Proxy method,
+// in the same order, with the content of uploads as []byte; missing matchers match anything.
+// When several expectations match a call, the first one registered is used.
+func (p *Proxy) On(operation db.Operation, matchers ...Matcher) *Expectation {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	expectation := &Expectation{operation: operation, matchers: matchers}
+	p.expectations = append(p.expectations, expectation)
+	return expectation
+}
+
+// Calls returns all calls received, in order.
+func (p *Proxy) Calls() []db.Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]db.Call{}, p.calls...)
+}
+
+// CallsTo returns the calls of operation received, in order.
+func (p *Proxy) CallsTo(operation db.Operation) []db.Call {
+	var calls []db.Call
+	for _, call := range p.Calls() {
+		if call.Operation == operation {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// AssertExpectations fails the test if an expectation didn't get all its calls. NewProxy calls it at the end of
+// the test.
+func (p *Proxy) AssertExpectations() {
+	p.t.Helper()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, e := range p.expectations {
+		switch {
+		case e.times > 0 && e.calls < e.times:
+			p.t.Errorf("expected %d calls of %s, got %d", e.times, e.operation, e.calls)
+		case e.times == 0 && e.calls == 0 && !e.optional:
+			p.t.Errorf("expected a call of %s, got none", e.operation)
+		}
+	}
+}
+
+func (p *Proxy) handle(call *db.Call, next db.Handler) (interface{}, error) {
+	recorded := *call
+	var content []byte
+	if call.Content != nil {
+		var err error
+		if content, err = ioutil.ReadAll(call.Content); err != nil {
+			return nil, err
+		}
+		recorded.Content = bytes.NewReader(content)
+	}
+	arguments := getArguments(&recorded, content)
+
+	p.mu.Lock()
+	p.calls = append(p.calls, recorded)
+	var expectation *Expectation
+	for _, e := range p.expectations {
+		if e.matches(&recorded, arguments) {
+			expectation = e
+			e.calls++
+			break
+		}
+	}
+	p.mu.Unlock()
+
+	if expectation == nil {
+		p.t.Helper()
+		p.t.Errorf("%s: %s%s", ErrUnexpectedCall, call.Operation, formatArguments(arguments))
+		return nil, fmt.Errorf("%w: %s", ErrUnexpectedCall, call.Operation)
+	}
+	if expectation.run != nil {
+		if content != nil {
+			call.Content = bytes.NewReader(content)
+		}
+		return expectation.run(call)
+	}
+	return expectation.response, expectation.err
+}
+
+// getArguments returns the arguments of the Proxy method of call.
+func getArguments(call *db.Call, content []byte) []interface{} {
+	switch call.Operation {
+	case db.OpHealthCheck:
+		return nil
+	case db.OpListCollections, db.OpDropDatabase:
+		return []interface{}{call.Database}
+	case db.OpDropCollection, db.OpListFiles:
+		return []interface{}{call.Database, call.Collection}
+	case db.OpAggregate, db.OpFind:
+		return []interface{}{call.Database, call.Collection, call.Filter}
+	case db.OpAggregatePipeline:
+		return []interface{}{call.Database, call.Collection, call.Pipeline}
+	case db.OpInsert:
+		return []interface{}{call.Database, call.Collection, call.Document}
+	case db.OpUpdate:
+		return []interface{}{call.Database, call.Collection, call.Filter, call.Document}
+	case db.OpCreateCollection, db.OpRenameCollection, db.OpSearch, db.OpExplain:
+		return []interface{}{call.Database, call.Collection, call.Request}
+	case db.OpUploadFile:
+		return []interface{}{call.Database, call.Collection, call.Filename, call.ContentType, call.Metadata, content}
+	case db.OpDownloadFile, db.OpDeleteFile:
+		return []interface{}{call.Database, call.Collection, call.FileID}
+	default:
+		return nil
+	}
+}
+
+func formatArguments(arguments []interface{}) string {
+	formatted := make([]string, 0, len(arguments))
+	for _, argument := range arguments {
+		if text, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: argument}}, false, false); err == nil {
+			formatted = append(formatted, strings.TrimSuffix(strings.TrimPrefix(string(text), `{"v":`), "}"))
+		} else {
+			formatted = append(formatted, fmt.Sprintf("%v", argument))
+		}
+	}
+	return "(" + strings.Join(formatted, ", ") + ")"
+}
@@ -0,0 +1,157 @@
+package mock_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// recorder keeps the failures and cleanups of a test, to check them.
+type recorder struct {
+	failures []string
+	cleanups []func()
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.failures = append(r.failures, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recorder) finish() {
+	for _, f := range r.cleanups {
+		f()
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	testCases := []struct {
+		name     string
+		matcher  mock.Matcher
+		value    interface{}
+		expected bool
+	}{
+		{"any", mock.Any(), nil, true},
+		{"equal strings", mock.Eq("quotes"), "quotes", true},
+		{"different strings", mock.Eq("quotes"), "letters", false},
+		{"maps and documents", mock.Eq(bson.M{"author": "Seneca", "year": 65}), bson.D{{Key: "year", Value: 65}, {Key: "author", Value: "Seneca"}}, true},
+		{"decoded JSON", mock.Eq(bson.M{"tags": bson.A{"stoic"}}), map[string]interface{}{"tags": []interface{}{"stoic"}}, true},
+		{"structs", mock.Eq(bson.M{"author": "Seneca", "originaltitle": "", "originalquote": "", "translatedtitle": "", "translatedquote": ""}), db.Quote{Author: "Seneca"}, true},
+		{"different values", mock.Eq(bson.M{"year": 65}), bson.M{"year": 66}, false},
+		{"different number types", mock.Eq(bson.M{"year": int64(65)}), bson.M{"year": 65}, false},
+		{"requests", mock.Eq(db.RenameCollectionRequest{To: "letters"}), db.RenameCollectionRequest{To: "letters"}, true},
+		{"nested field", mock.Field("$set.author", mock.Eq("Seneca")), bson.D{{Key: "$set", Value: bson.M{"author": "Seneca"}}}, true},
+		{"missing field", mock.Field("$set.year", mock.Eq(nil)), bson.M{"$set": bson.M{"author": "Seneca"}}, true},
+		{"field of a value", mock.Field("author", mock.Any()), "Seneca", true},
+		{"field of a non-document", mock.Field("author", mock.Eq("Seneca")), "Seneca", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, tc.matcher(tc.value))
+		})
+	}
+}
+
+func TestProxy(t *testing.T) {
+	r := &recorder{}
+	p := mock.NewProxy(r)
+
+	p.On(db.OpFind, mock.Eq("library"), mock.Eq("quotes"), mock.Eq(bson.M{"author": "Seneca"})).
+		Return(&db.FindResponse{Results: []bson.M{{"author": "Seneca"}}}, nil)
+	p.On(db.OpUpdate).Return(&db.UpdateResponse{}, nil).Once()
+	p.On(db.OpUpdate).Return(nil, errors.New("write conflict")).Once()
+
+	found, err := p.Find("library", "quotes", bson.M{"author": "Seneca"})
+	assert.NoError(t, err)
+	assert.Equal(t, []bson.M{{"author": "Seneca"}}, found.Results)
+	found, err = p.Find("library", "quotes", bson.M{"author": "Seneca"})
+	assert.NoError(t, err)
+	assert.Len(t, found.Results, 1)
+
+	// Each expectation of Update answers a single call.
+	_, err = p.Update("library", "quotes", bson.M{}, bson.M{"$set": bson.M{"year": 65}})
+	assert.NoError(t, err)
+	_, err = p.Update("library", "quotes", bson.M{}, bson.M{"$set": bson.M{"year": 65}})
+	assert.EqualError(t, err, "write conflict")
+
+	calls := p.Calls()
+	if assert.Len(t, calls, 4) {
+		assert.Equal(t, db.OpFind, calls[0].Operation)
+		assert.Equal(t, "quotes", calls[0].Collection)
+		assert.Equal(t, bson.M{"$set": bson.M{"year": 65}}, calls[3].Document)
+	}
+	assert.Len(t, p.CallsTo(db.OpUpdate), 2)
+
+	r.finish()
+	assert.Empty(t, r.failures)
+}
+
+func TestProxyUnexpectedCalls(t *testing.T) {
+	r := &recorder{}
+	p := mock.NewProxy(r)
+	p.On(db.OpFind, mock.Eq("library"), mock.Eq("quotes"), mock.Eq(bson.M{"author": "Seneca"})).Maybe()
+	p.On(db.OpDropDatabase).Once()
+
+	_, err := p.Find("library", "quotes", bson.M{"author": "Cicero"})
+	assert.True(t, errors.Is(err, mock.ErrUnexpectedCall))
+	_, err = p.HealthCheck()
+	assert.True(t, errors.Is(err, mock.ErrUnexpectedCall))
+
+	// Unexpected calls are recorded too.
+	assert.Len(t, p.Calls(), 2)
+
+	r.finish()
+	assert.Equal(t, []string{
+		`unexpected call: find("library", "quotes", {"author":"Cicero"})`,
+		`unexpected call: healthCheck()`,
+		`expected 1 calls of dropDatabase, got 0`,
+	}, r.failures)
+
+	r = &recorder{}
+	p = mock.NewProxy(r)
+	p.On(db.OpListFiles)
+	r.finish()
+	assert.Equal(t, []string{"expected a call of listFiles, got none"}, r.failures)
+}
+
+func TestProxyRun(t *testing.T) {
+	r := &recorder{}
+	p := mock.NewProxy(r)
+	p.On(db.OpUploadFile, mock.Eq("library"), mock.Eq("fs"), mock.Any(), mock.Any(), mock.Any(), mock.Eq([]byte("Ad Lucilium"))).
+		Run(func(call *db.Call) (interface{}, error) {
+			content, err := ioutil.ReadAll(call.Content)
+			return &db.FileInfo{Filename: call.Filename, Length: int64(len(content))}, err
+		})
+
+	info, err := p.UploadFile("library", "fs", "letters.txt", "text/plain", nil, strings.NewReader("Ad Lucilium"))
+	assert.NoError(t, err)
+	assert.Equal(t, &db.FileInfo{Filename: "letters.txt", Length: 11}, info)
+
+	// The content of recorded uploads can still be read.
+	calls := p.CallsTo(db.OpUploadFile)
+	if assert.Len(t, calls, 1) {
+		content, err := ioutil.ReadAll(calls[0].Content)
+		assert.NoError(t, err)
+		assert.Equal(t, "Ad Lucilium", string(content))
+	}
+
+	// Responses of the wrong type are rejected, like by any interceptor.
+	p.On(db.OpListFiles).Return(&db.FindResponse{}, nil)
+	_, err = p.ListFiles("library", "fs")
+	assert.True(t, errors.Is(err, db.ErrInvalidCall))
+
+	r.finish()
+	assert.Empty(t, r.failures)
+}
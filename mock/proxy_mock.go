@@ -3,6 +3,9 @@ package mock
 import (
 	"context"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/otaviokr/mongodb-proxy-ms/db"
 	"go.mongodb.org/mongo-driver/bson"
@@ -11,8 +14,16 @@ import (
 
 // DBProxy is an implementation of Proxy, to be used in unit tests.
 type DBProxy struct {
-	TestCaseID string
-	HasError   bool
+	TestCaseID  string
+	HasError    bool
+	Consistency db.Consistency
+}
+
+// WithConsistency returns a copy of the mock that keeps consistency, as db.MongoDBProxy does.
+func (m *DBProxy) WithConsistency(consistency db.Consistency) (db.Proxy, error) {
+	copied := *m
+	copied.Consistency = consistency
+	return &copied, nil
 }
 
 // DBWrapperFunc simulates the output from database.
@@ -37,6 +48,18 @@ func (m *DBProxy) Aggregate(db, clt string, req interface{}) (*db.AggregateRespo
 	return nil, nil
 }
 
+// AggregatePipeline simulates the output of MongoDB.AggregatePipeline().
+func (m *DBProxy) AggregatePipeline(database, collection string, pipeline interface{}) (*db.FindResponse, error) {
+	switch m.TestCaseID {
+	case "geoOK":
+		return &db.FindResponse{
+			Results: []bson.M{{"name": "Lighthouse", "distance": 1.2}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
 // Find simulates the output of MongoDB.Find().
 func (m *DBProxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
 
@@ -47,9 +70,18 @@ func (m *DBProxy) Find(database, collection string, filter interface{}) (*db.Fin
 	case "findOK":
 		results = []bson.M{{"foo": "bar", "hello": "world", "pi": 3.14159}}
 		errors = ""
+	case "geoOK":
+		results = []bson.M{{"name": "Lighthouse", "location": bson.M{"type": "Point", "coordinates": bson.A{-48.5, -27.6}}}}
+		errors = ""
 	case "findNothingFound":
 		results = []bson.M{}
 		errors = ""
+	case "findNamespace":
+		results = []bson.M{{"database": database, "collection": collection}}
+		errors = ""
+	case "findConsistency":
+		results = []bson.M{{"consistency": m.Consistency}}
+		errors = ""
 	case "findMissingDBName":
 		// Not reached.
 	case "findMissingCollName":
@@ -124,6 +156,9 @@ func (m *DBProxy) Update(database, collection string, filter, entry interface{})
 	switch m.TestCaseID {
 	case "updateOK":
 		updateResult = mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1, UpsertedCount: 0, UpsertedID: nil}
+	case "updateConsistency":
+		// The consistency is returned in place of the upserted ID, so tests can check it.
+		updateResult = mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1, UpsertedID: m.Consistency}
 	case "updateEmptyFilter":
 		updateResult = mongo.UpdateResult{MatchedCount: 100, ModifiedCount: 100, UpsertedCount: 0, UpsertedID: nil}
 	case "updateEmptyUpdate":
@@ -140,3 +175,165 @@ func (m *DBProxy) Update(database, collection string, filter, entry interface{})
 		Results: &updateResult,
 	}, errors
 }
+
+// ListCollections simulates the output of MongoDB.ListCollections().
+func (m *DBProxy) ListCollections(database string) (*db.CollectionsResponse, error) {
+	switch m.TestCaseID {
+	case "adminListOK":
+		return &db.CollectionsResponse{
+			Database: database,
+			Collections: []db.CollectionDetails{
+				{
+					Name:    "quotes",
+					Type:    "collection",
+					Count:   2,
+					Size:    512,
+					Indexes: []db.IndexDetails{{Name: "_id_", Keys: bson.M{"_id": 1}}},
+				},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// CreateCollection simulates the output of MongoDB.CreateCollection().
+func (m *DBProxy) CreateCollection(database, collection string, request db.CreateCollectionRequest) (*db.AdminResponse, error) {
+	switch m.TestCaseID {
+	case "adminCreateOK":
+		return &db.AdminResponse{Database: database, Collection: collection, Operation: "create"}, nil
+	case "adminCreateExists":
+		return nil, fmt.Errorf("collection already exists")
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// RenameCollection simulates the output of MongoDB.RenameCollection().
+func (m *DBProxy) RenameCollection(database, collection string, request db.RenameCollectionRequest) (*db.AdminResponse, error) {
+	switch m.TestCaseID {
+	case "adminRenameOK":
+		return &db.AdminResponse{Database: database, Collection: request.To, Operation: "rename"}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// DropCollection simulates the output of MongoDB.DropCollection().
+func (m *DBProxy) DropCollection(database, collection string) (*db.AdminResponse, error) {
+	switch m.TestCaseID {
+	case "adminDropOK":
+		return &db.AdminResponse{Database: database, Collection: collection, Operation: "drop"}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// DropDatabase simulates the output of MongoDB.DropDatabase().
+func (m *DBProxy) DropDatabase(database string) (*db.AdminResponse, error) {
+	switch m.TestCaseID {
+	case "adminDropDatabaseOK":
+		return &db.AdminResponse{Database: database, Operation: "dropDatabase"}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// Search simulates the output of MongoDB.Search().
+func (m *DBProxy) Search(database, collection string, request db.SearchRequest) (*db.SearchResponse, error) {
+	switch m.TestCaseID {
+	case "searchOK":
+		return &db.SearchResponse{
+			Results:  []bson.M{{"author": "Seneca", "score": 1.5}},
+			Total:    1,
+			Page:     request.Page,
+			PageSize: request.PageSize,
+		}, nil
+	case "searchNoIndex":
+		return nil, db.ErrNoTextIndex
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// UploadFile simulates the output of MongoDB.UploadFile().
+func (m *DBProxy) UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (*db.FileInfo, error) {
+	switch m.TestCaseID {
+	case "filesOK":
+		data, err := ioutil.ReadAll(content)
+		if err != nil {
+			return nil, err
+		}
+		return &db.FileInfo{
+			ID:          "5f4d641403490cb668ed8313",
+			Filename:    filename,
+			Length:      int64(len(data)),
+			ChunkSize:   261120,
+			ContentType: contentType,
+			Metadata:    metadata,
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// DownloadFile simulates the output of MongoDB.DownloadFile().
+func (m *DBProxy) DownloadFile(database, bucket, id string) (*db.FileDownload, error) {
+	switch m.TestCaseID {
+	case "filesOK":
+		content := "0123456789"
+		info := db.FileInfo{ID: id, Filename: "digits.txt", Length: int64(len(content)), ContentType: "text/plain"}
+		return db.NewFileDownload(info, strings.NewReader(content), nil, nil), nil
+	case "filesNotFound":
+		return nil, db.ErrFileNotFound
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// ListFiles simulates the output of MongoDB.ListFiles().
+func (m *DBProxy) ListFiles(database, bucket string) (*db.FilesResponse, error) {
+	switch m.TestCaseID {
+	case "filesOK":
+		return &db.FilesResponse{
+			Files: []db.FileInfo{{ID: "5f4d641403490cb668ed8313", Filename: "digits.txt", Length: 10, ContentType: "text/plain"}},
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// DeleteFile simulates the output of MongoDB.DeleteFile().
+func (m *DBProxy) DeleteFile(database, bucket, id string) (*db.DeleteResponse, error) {
+	switch m.TestCaseID {
+	case "filesOK":
+		return &db.DeleteResponse{DeletedCount: 1}, nil
+	case "filesNotFound":
+		return nil, db.ErrFileNotFound
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
+
+// Explain simulates the output of MongoDB.Explain().
+func (m *DBProxy) Explain(database, collection string, request db.ExplainRequest) (*db.ExplainResponse, error) {
+	switch m.TestCaseID {
+	case "explainOK":
+		return &db.ExplainResponse{
+			Operation: request.Operation,
+			Verbosity: request.Verbosity,
+			Plan: bson.D{
+				{Key: "queryPlanner", Value: bson.D{
+					{Key: "namespace", Value: database + "." + collection},
+					{Key: "indexFilterSet", Value: false},
+					{Key: "winningPlan", Value: bson.D{
+						{Key: "stage", Value: "FETCH"},
+						{Key: "inputStage", Value: bson.D{{Key: "stage", Value: "IXSCAN"}, {Key: "indexName", Value: "author_1"}}},
+					}},
+				}},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("Unexpected test case: %s", m.TestCaseID)
+	}
+}
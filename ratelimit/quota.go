@@ -0,0 +1,50 @@
+package ratelimit
+
+import (
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+)
+
+// ChargeQuota returns an interceptor that charges the documents read and written by its calls to the daily
+// quota of client in limiter. Failed calls are not charged.
+func ChargeQuota(limiter *Limiter, client string) db.Interceptor {
+	return func(call *db.Call, next db.Handler) (interface{}, error) {
+		response, err := next(call)
+		if err == nil {
+			read, written := usage(call.Operation, response)
+			if read > 0 || written > 0 {
+				limiter.Charge(client, read, written)
+			}
+		}
+		return response, err
+	}
+}
+
+// usage returns the documents read and written by a call of operation, from its response.
+func usage(operation db.Operation, response interface{}) (read, written int64) {
+	switch operation {
+	case db.OpFind, db.OpAggregatePipeline:
+		// The documents returned.
+		if found, ok := response.(*db.FindResponse); ok && found != nil {
+			return int64(len(found.Results)), 0
+		}
+	case db.OpSearch:
+		if found, ok := response.(*db.SearchResponse); ok && found != nil {
+			return int64(len(found.Results)), 0
+		}
+	case db.OpInsert, db.OpUploadFile:
+		// The inserted document, or the stored file as a single document.
+		return 0, 1
+	case db.OpUpdate:
+		// The documents changed or upserted.
+		if updated, ok := response.(*db.UpdateResponse); ok && updated != nil && updated.Results != nil {
+			return 0, updated.Results.ModifiedCount + updated.Results.UpsertedCount
+		}
+	case db.OpDownloadFile:
+		return 1, 0
+	case db.OpDeleteFile:
+		if deleted, ok := response.(*db.DeleteResponse); ok && deleted != nil {
+			return 0, deleted.DeletedCount
+		}
+	}
+	return 0, 0
+}
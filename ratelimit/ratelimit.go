@@ -0,0 +1,288 @@
+// Package ratelimit keeps clients from overloading MongoDB, with token buckets per client and route,
+// and daily quotas of documents read and written.
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+)
+
+// Key parts that may be used to tell the buckets apart.
+const (
+	// KeyPrincipal is the authenticated client, or its IP address when the request is anonymous.
+	KeyPrincipal = "principal"
+	// KeyIP is the IP address of the client.
+	KeyIP = "ip"
+	// KeyRoute is the route of the request, like /find/:Database/:Collection.
+	KeyRoute = "route"
+)
+
+// DefaultKeyBy gives each client its own bucket for each route.
+var DefaultKeyBy = []string{KeyPrincipal, KeyRoute}
+
+// Limit allows Rate requests per second on average, and up to Burst requests at once.
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Quota is how many documents each client may read and write per day (UTC). Zero means unlimited.
+type Quota struct {
+	Read    int64 `json:"read,omitempty"`
+	Written int64 `json:"written,omitempty"`
+}
+
+// Config defines the limits of the requests.
+type Config struct {
+	// KeyBy tells what identifies a bucket. If empty, DefaultKeyBy is used.
+	KeyBy []string `json:"key_by,omitempty"`
+	// Default applies to the operations missing in Operations. If its rate is zero, they are not limited.
+	Default Limit `json:"default"`
+	// Operations overrides the default limit per operation, like "find" or "insert".
+	Operations map[string]Limit `json:"operations,omitempty"`
+	// DailyQuota limits the documents each client reads and writes.
+	DailyQuota Quota `json:"daily_quota,omitempty"`
+	// Clock returns the current time. If nil, time.Now is used.
+	Clock func() time.Time `json:"-"`
+}
+
+// Request identifies who sent a request, and what for.
+type Request struct {
+	Principal string
+	IP        string
+	Route     string
+	Operation string
+}
+
+// Decision tells if a request may proceed, and how much of its limit is left.
+type Decision struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+	// RetryAfter is how long until the next request is allowed, if this one was not.
+	RetryAfter time.Duration
+}
+
+// Limiter enforces the limits and quotas of a Config. It is safe for concurrent use.
+type Limiter struct {
+	config Config
+
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	sweptAt time.Time
+	day     string
+	usage   map[string]*Quota
+}
+
+type bucket struct {
+	limit   Limit
+	tokens  float64
+	updated time.Time
+}
+
+// NewLimiter creates a limiter with config, checking that it is valid.
+func NewLimiter(config Config) (*Limiter, error) {
+	if len(config.KeyBy) == 0 {
+		config.KeyBy = DefaultKeyBy
+	}
+	for _, part := range config.KeyBy {
+		if part != KeyPrincipal && part != KeyIP && part != KeyRoute {
+			return nil, fmt.Errorf("unknown rate limit key: %q", part)
+		}
+	}
+	if err := config.Default.validate("default"); err != nil {
+		return nil, err
+	}
+	for operation, limit := range config.Operations {
+		if err := limit.validate(operation); err != nil {
+			return nil, err
+		}
+	}
+	if config.DailyQuota.Read < 0 || config.DailyQuota.Written < 0 {
+		return nil, fmt.Errorf("daily quotas can't be negative")
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+
+	return &Limiter{
+		config:  config,
+		buckets: map[string]*bucket{},
+		usage:   map[string]*Quota{},
+	}, nil
+}
+
+// LoadLimiter reads the limits from a JSON file, in the same format as Config.
+func LoadLimiter(filename string) (*Limiter, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate limit file %s: %v", filename, err)
+	}
+	return NewLimiter(config)
+}
+
+func (l Limit) validate(name string) error {
+	if l.Rate < 0 || math.IsInf(l.Rate, 0) || math.IsNaN(l.Rate) {
+		return fmt.Errorf("rate limit %s: invalid rate %v", name, l.Rate)
+	}
+	if l.Rate > 0 && l.Burst < 1 {
+		return fmt.Errorf("rate limit %s: burst must be at least 1", name)
+	}
+	return nil
+}
+
+// Allow takes a token from the bucket of request, if there is one left.
+func (l *Limiter) Allow(request Request) Decision {
+	limit := l.limitFor(request.Operation)
+	if limit.Rate == 0 {
+		return Decision{Allowed: true}
+	}
+
+	now := l.config.Clock()
+	key := l.key(request)
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.sweep(now)
+
+	b, ok := l.buckets[key]
+	if !ok || b.limit != limit {
+		b = &bucket{limit: limit, tokens: float64(limit.Burst), updated: now}
+		l.buckets[key] = b
+	}
+	elapsed := now.Sub(b.updated).Seconds()
+	if elapsed > 0 {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+elapsed*limit.Rate)
+		b.updated = now
+	}
+
+	decision := Decision{Limit: limit.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = secondsToDuration((1 - b.tokens) / limit.Rate)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = secondsToDuration((float64(limit.Burst) - b.tokens) / limit.Rate)
+	return decision
+}
+
+// QuotaExceeded tells if client has no documents left to read (or write, if write is set) today.
+// It also returns how long until the quotas are renewed.
+func (l *Limiter) QuotaExceeded(client string, write bool) (bool, time.Duration) {
+	quota := l.config.DailyQuota
+	if quota.Read == 0 && quota.Written == 0 {
+		return false, 0
+	}
+
+	now := l.config.Clock().UTC()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	usage := l.usageOf(client, now)
+
+	exceeded := false
+	if write {
+		exceeded = quota.Written > 0 && usage.Written >= quota.Written
+	} else {
+		exceeded = quota.Read > 0 && usage.Read >= quota.Read
+	}
+	tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+	return exceeded, tomorrow.Sub(now)
+}
+
+// HasQuotas tells if the documents read and written must be counted.
+func (l *Limiter) HasQuotas() bool {
+	return l.config.DailyQuota.Read > 0 || l.config.DailyQuota.Written > 0
+}
+
+// Charge adds the documents read and written by client to its usage of today.
+func (l *Limiter) Charge(client string, read, written int64) {
+	if !l.HasQuotas() || (read == 0 && written == 0) {
+		return
+	}
+
+	now := l.config.Clock().UTC()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	usage := l.usageOf(client, now)
+	usage.Read += read
+	usage.Written += written
+}
+
+// Usage returns how many documents client read and wrote today.
+func (l *Limiter) Usage(client string) Quota {
+	now := l.config.Clock().UTC()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return *l.usageOf(client, now)
+}
+
+// usageOf returns the counters of client, starting new ones every day. The mutex must be held.
+func (l *Limiter) usageOf(client string, now time.Time) *Quota {
+	if day := now.Format("2006-01-02"); day != l.day {
+		l.day = day
+		l.usage = map[string]*Quota{}
+	}
+	usage, ok := l.usage[client]
+	if !ok {
+		usage = &Quota{}
+		l.usage[client] = usage
+	}
+	return usage
+}
+
+func (l *Limiter) limitFor(operation string) Limit {
+	if limit, ok := l.config.Operations[operation]; ok {
+		return limit
+	}
+	return l.config.Default
+}
+
+func (l *Limiter) key(request Request) string {
+	key := request.Operation
+	for _, part := range l.config.KeyBy {
+		switch part {
+		case KeyPrincipal:
+			if len(request.Principal) > 0 {
+				key += "|p:" + request.Principal
+			} else {
+				key += "|ip:" + request.IP
+			}
+		case KeyIP:
+			key += "|ip:" + request.IP
+		case KeyRoute:
+			key += "|r:" + request.Route
+		}
+	}
+	return key
+}
+
+// sweep forgets the buckets that are full again, since they are the same as new ones. The mutex must be held.
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.sweptAt) < time.Minute {
+		return
+	}
+	l.sweptAt = now
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds * float64(time.Second)))
+}
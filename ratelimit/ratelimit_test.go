@@ -0,0 +1,140 @@
+package ratelimit_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// clock is a time that only moves when told so.
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+func newClock() *clock {
+	return &clock{now: time.Date(2024, 1, 5, 23, 59, 0, 0, time.UTC)}
+}
+
+func TestNewLimiter(t *testing.T) {
+	testCases := []struct {
+		name   string
+		config ratelimit.Config
+		valid  bool
+	}{
+		{"empty", ratelimit.Config{}, true},
+		{"default", ratelimit.Config{Default: ratelimit.Limit{Rate: 1, Burst: 5}}, true},
+		{"unknown key", ratelimit.Config{KeyBy: []string{"header"}}, false},
+		{"negative rate", ratelimit.Config{Default: ratelimit.Limit{Rate: -1, Burst: 5}}, false},
+		{"missing burst", ratelimit.Config{Operations: map[string]ratelimit.Limit{"find": {Rate: 1}}}, false},
+		{"negative quota", ratelimit.Config{DailyQuota: ratelimit.Quota{Read: -1}}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := ratelimit.NewLimiter(tc.config)
+			assert.Equal(t, tc.valid, err == nil, "unexpected error: %v", err)
+		})
+	}
+}
+
+func TestLoadLimiter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "limits.json")
+	content := `{"key_by":["ip"],"default":{"rate":10,"burst":20},"operations":{"insert":{"rate":1,"burst":2}},"daily_quota":{"read":1000}}`
+	assert.NoError(t, ioutil.WriteFile(filename, []byte(content), 0600))
+
+	limiter, err := ratelimit.LoadLimiter(filename)
+	assert.NoError(t, err)
+	assert.True(t, limiter.HasQuotas())
+	assert.Equal(t, 2, limiter.Allow(ratelimit.Request{IP: "10.0.0.1", Operation: "insert"}).Limit)
+	assert.Equal(t, 20, limiter.Allow(ratelimit.Request{IP: "10.0.0.1", Operation: "find"}).Limit)
+}
+
+func TestAllow(t *testing.T) {
+	clock := newClock()
+	limiter, err := ratelimit.NewLimiter(ratelimit.Config{
+		Default:    ratelimit.Limit{Rate: 1, Burst: 2},
+		Operations: map[string]ratelimit.Limit{"aggregate": {}},
+		Clock:      clock.Now,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	bot := ratelimit.Request{Principal: "twitter-bot", Route: "/find/:Database/:Collection", Operation: "find"}
+
+	decision := limiter.Allow(bot)
+	assert.Equal(t, ratelimit.Decision{Allowed: true, Limit: 2, Remaining: 1, Reset: time.Second}, decision)
+	decision = limiter.Allow(bot)
+	assert.Equal(t, ratelimit.Decision{Allowed: true, Limit: 2, Remaining: 0, Reset: 2 * time.Second}, decision)
+	decision = limiter.Allow(bot)
+	assert.Equal(t, ratelimit.Decision{Allowed: false, Limit: 2, Remaining: 0, Reset: 2 * time.Second, RetryAfter: time.Second}, decision)
+
+	other := bot
+	other.Principal = "admin-ui"
+	assert.True(t, limiter.Allow(other).Allowed, "each client should have its own bucket")
+	otherRoute := bot
+	otherRoute.Route = "/search/:Database/:Collection"
+	assert.True(t, limiter.Allow(otherRoute).Allowed, "each route should have its own bucket")
+	unlimited := bot
+	unlimited.Operation = "aggregate"
+	assert.Equal(t, ratelimit.Decision{Allowed: true}, limiter.Allow(unlimited), "a zero rate should not limit")
+
+	clock.now = clock.now.Add(1500 * time.Millisecond)
+	decision = limiter.Allow(bot)
+	assert.True(t, decision.Allowed, "the bucket should be refilled")
+	assert.Equal(t, 0, decision.Remaining)
+}
+
+func TestAllowAnonymousByIP(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(ratelimit.Config{Default: ratelimit.Limit{Rate: 1, Burst: 1}})
+	if err != nil {
+		t.FailNow()
+	}
+
+	assert.True(t, limiter.Allow(ratelimit.Request{IP: "10.0.0.1", Operation: "find"}).Allowed)
+	assert.False(t, limiter.Allow(ratelimit.Request{IP: "10.0.0.1", Operation: "find"}).Allowed)
+	assert.True(t, limiter.Allow(ratelimit.Request{IP: "10.0.0.2", Operation: "find"}).Allowed)
+}
+
+func TestDailyQuota(t *testing.T) {
+	clock := newClock()
+	limiter, err := ratelimit.NewLimiter(ratelimit.Config{
+		DailyQuota: ratelimit.Quota{Read: 3, Written: 1},
+		Clock:      clock.Now,
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	exceeded, renewal := limiter.QuotaExceeded("bot", false)
+	assert.False(t, exceeded)
+	assert.Equal(t, time.Minute, renewal)
+
+	proxy := db.Intercept(&mock.DBProxy{TestCaseID: "findOK"}, ratelimit.ChargeQuota(limiter, "bot"))
+	_, err = proxy.Find("cool_db", "cool_collection", bson.M{})
+	assert.NoError(t, err)
+	limiter.Charge("bot", 2, 1)
+
+	assert.Equal(t, ratelimit.Quota{Read: 3, Written: 1}, limiter.Usage("bot"))
+	exceeded, _ = limiter.QuotaExceeded("bot", false)
+	assert.True(t, exceeded, "the read quota should be used")
+	exceeded, _ = limiter.QuotaExceeded("bot", true)
+	assert.True(t, exceeded, "the write quota should be used")
+	exceeded, _ = limiter.QuotaExceeded("other", false)
+	assert.False(t, exceeded, "each client should have its own quota")
+
+	clock.now = clock.now.Add(time.Minute)
+	exceeded, _ = limiter.QuotaExceeded("bot", false)
+	assert.False(t, exceeded, "the quota should be renewed at midnight")
+}
@@ -0,0 +1,154 @@
+package resilience
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned, without calling MongoDB, while the circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// OpenError tells how long the circuit breaker stays open. It matches ErrCircuitOpen in errors.Is.
+type OpenError struct {
+	RetryAfter time.Duration
+}
+
+func (e *OpenError) Error() string {
+	return fmt.Sprintf("%v: retry after %v", ErrCircuitOpen, e.RetryAfter)
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) true.
+func (e *OpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// States of the circuit breaker.
+const (
+	// StateClosed lets all calls through.
+	StateClosed = "closed"
+	// StateOpen fails all calls, until OpenDuration is over.
+	StateOpen = "open"
+	// StateHalfOpen lets a single call through, to find out if MongoDB is back.
+	StateHalfOpen = "half-open"
+)
+
+// Breaker is a circuit breaker: after FailureThreshold consecutive failures, it opens and fails all calls for
+// OpenDuration; then a single call is let through, closing it again if it succeeds.
+type Breaker struct {
+	mutex     sync.Mutex
+	threshold int
+	duration  time.Duration
+	clock     func() time.Time
+	onChange  func(from, to string)
+
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewBreaker creates a closed circuit breaker. A threshold of zero, or less, never opens it.
+// onChange, if not nil, is called on every change of state.
+func NewBreaker(threshold int, duration time.Duration, clock func() time.Time, onChange func(from, to string)) *Breaker {
+	if clock == nil {
+		clock = time.Now
+	}
+	return &Breaker{
+		threshold: threshold,
+		duration:  duration,
+		clock:     clock,
+		onChange:  onChange,
+		state:     StateClosed,
+	}
+}
+
+// Allow returns an OpenError if the call may not go through. Calls that are allowed must report their
+// result with Record.
+func (b *Breaker) Allow() error {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.currentState() {
+	case StateOpen:
+		return &OpenError{RetryAfter: b.retryAfter()}
+	case StateHalfOpen:
+		if b.probing {
+			return &OpenError{RetryAfter: b.duration}
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record reports the result of a call allowed by Allow. Only failures of MongoDB itself count, not errors
+// caused by the request.
+func (b *Breaker) Record(failed bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	state := b.currentState()
+	if state == StateHalfOpen {
+		b.probing = false
+	}
+	if !failed {
+		b.failures = 0
+		if state != StateClosed {
+			b.setState(StateClosed)
+		}
+		return
+	}
+
+	b.failures++
+	if state == StateHalfOpen || (b.threshold > 0 && b.failures >= b.threshold) {
+		b.openedAt = b.clock()
+		if state != StateOpen {
+			b.setState(StateOpen)
+		}
+	}
+}
+
+// State returns the current state: StateClosed, StateOpen or StateHalfOpen.
+func (b *Breaker) State() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.currentState()
+}
+
+// RetryAfter returns how long calls will still be refused, or zero if they are allowed.
+func (b *Breaker) RetryAfter() time.Duration {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	switch b.currentState() {
+	case StateOpen:
+		return b.retryAfter()
+	case StateHalfOpen:
+		if b.probing {
+			return b.duration
+		}
+	}
+	return 0
+}
+
+// currentState moves from open to half-open when OpenDuration is over.
+func (b *Breaker) currentState() string {
+	if b.state == StateOpen && b.clock().Sub(b.openedAt) >= b.duration {
+		b.setState(StateHalfOpen)
+		b.probing = false
+	}
+	return b.state
+}
+
+func (b *Breaker) retryAfter() time.Duration {
+	return b.duration - b.clock().Sub(b.openedAt)
+}
+
+func (b *Breaker) setState(state string) {
+	from := b.state
+	b.state = state
+	if b.onChange != nil {
+		b.onChange(from, state)
+	}
+}
@@ -0,0 +1,190 @@
+package resilience
+
+import (
+	"io"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// Proxy calls another Proxy through a Resilience. Reads and idempotent writes, like drops, are retried after
+// any transient failure; other writes only when MongoDB certainly didn't run them; uploads are never retried.
+type Proxy struct {
+	db.Proxy
+	resilience *Resilience
+}
+
+// NewProxy wraps proxy, calling it through resilience.
+func NewProxy(proxy db.Proxy, resilience *Resilience) *Proxy {
+	return &Proxy{
+		Proxy:      proxy,
+		resilience: resilience,
+	}
+}
+
+// Resilience returns the retries, circuit breaker and metrics of the proxy.
+func (p *Proxy) Resilience() *Resilience {
+	return p.resilience
+}
+
+// WithConsistency applies consistency to the wrapped proxy, keeping the same Resilience.
+func (p *Proxy) WithConsistency(consistency db.Consistency) (db.Proxy, error) {
+	proxy, err := db.WithConsistency(p.Proxy, consistency)
+	if err != nil {
+		return nil, err
+	}
+	return NewProxy(proxy, p.resilience), nil
+}
+
+// HealthCheck lists the databases, with retries.
+func (p *Proxy) HealthCheck() (response *db.HealthResponse, err error) {
+	err = p.resilience.Do("health", RetryTransient, func() error {
+		response, err = p.Proxy.HealthCheck()
+		return err
+	})
+	return response, err
+}
+
+// Aggregate runs the aggregation, with retries.
+func (p *Proxy) Aggregate(database, collection string, filter interface{}) (response *db.AggregateResponse, err error) {
+	err = p.resilience.Do("aggregate", RetryTransient, func() error {
+		response, err = p.Proxy.Aggregate(database, collection, filter)
+		return err
+	})
+	return response, err
+}
+
+// AggregatePipeline runs the pipeline, with retries.
+func (p *Proxy) AggregatePipeline(database, collection string, pipeline interface{}) (response *db.FindResponse, err error) {
+	err = p.resilience.Do("aggregate", RetryTransient, func() error {
+		response, err = p.Proxy.AggregatePipeline(database, collection, pipeline)
+		return err
+	})
+	return response, err
+}
+
+// Insert inserts entry, retrying only if it certainly wasn't inserted.
+func (p *Proxy) Insert(database, collection string, entry db.Quote) (response *db.InsertResponse, err error) {
+	err = p.resilience.Do("insert", RetryUnsent, func() error {
+		response, err = p.Proxy.Insert(database, collection, entry)
+		return err
+	})
+	return response, err
+}
+
+// Find finds the documents, with retries.
+func (p *Proxy) Find(database, collection string, filter interface{}) (response *db.FindResponse, err error) {
+	err = p.resilience.Do("find", RetryTransient, func() error {
+		response, err = p.Proxy.Find(database, collection, filter)
+		return err
+	})
+	return response, err
+}
+
+// Update updates the documents, retrying only if they certainly weren't updated.
+func (p *Proxy) Update(database, collection string, filter, entry interface{}) (response *db.UpdateResponse, err error) {
+	err = p.resilience.Do("update", RetryUnsent, func() error {
+		response, err = p.Proxy.Update(database, collection, filter, entry)
+		return err
+	})
+	return response, err
+}
+
+// ListCollections lists the collections, with retries.
+func (p *Proxy) ListCollections(database string) (response *db.CollectionsResponse, err error) {
+	err = p.resilience.Do("listCollections", RetryTransient, func() error {
+		response, err = p.Proxy.ListCollections(database)
+		return err
+	})
+	return response, err
+}
+
+// CreateCollection creates the collection, retrying only if it certainly wasn't created.
+func (p *Proxy) CreateCollection(database, collection string, request db.CreateCollectionRequest) (response *db.AdminResponse, err error) {
+	err = p.resilience.Do("createCollection", RetryUnsent, func() error {
+		response, err = p.Proxy.CreateCollection(database, collection, request)
+		return err
+	})
+	return response, err
+}
+
+// RenameCollection renames the collection, retrying only if it certainly wasn't renamed.
+func (p *Proxy) RenameCollection(database, collection string, request db.RenameCollectionRequest) (response *db.AdminResponse, err error) {
+	err = p.resilience.Do("renameCollection", RetryUnsent, func() error {
+		response, err = p.Proxy.RenameCollection(database, collection, request)
+		return err
+	})
+	return response, err
+}
+
+// DropCollection drops the collection, with retries: dropping it again does nothing.
+func (p *Proxy) DropCollection(database, collection string) (response *db.AdminResponse, err error) {
+	err = p.resilience.Do("dropCollection", RetryTransient, func() error {
+		response, err = p.Proxy.DropCollection(database, collection)
+		return err
+	})
+	return response, err
+}
+
+// DropDatabase drops the database, with retries: dropping it again does nothing.
+func (p *Proxy) DropDatabase(database string) (response *db.AdminResponse, err error) {
+	err = p.resilience.Do("dropDatabase", RetryTransient, func() error {
+		response, err = p.Proxy.DropDatabase(database)
+		return err
+	})
+	return response, err
+}
+
+// Search runs the full-text search, with retries.
+func (p *Proxy) Search(database, collection string, request db.SearchRequest) (response *db.SearchResponse, err error) {
+	err = p.resilience.Do("search", RetryTransient, func() error {
+		response, err = p.Proxy.Search(database, collection, request)
+		return err
+	})
+	return response, err
+}
+
+// Explain returns the execution plan, with retries.
+func (p *Proxy) Explain(database, collection string, request db.ExplainRequest) (response *db.ExplainResponse, err error) {
+	err = p.resilience.Do("explain", RetryTransient, func() error {
+		response, err = p.Proxy.Explain(database, collection, request)
+		return err
+	})
+	return response, err
+}
+
+// UploadFile stores the file without retries, since content can't be read again, but through the circuit breaker.
+func (p *Proxy) UploadFile(database, bucket, filename, contentType string, metadata bson.M, content io.Reader) (response *db.FileInfo, err error) {
+	err = p.resilience.Do("uploadFile", RetryNever, func() error {
+		response, err = p.Proxy.UploadFile(database, bucket, filename, contentType, metadata, content)
+		return err
+	})
+	return response, err
+}
+
+// DownloadFile opens the file, with retries. Failures while reading its content are not retried.
+func (p *Proxy) DownloadFile(database, bucket, id string) (response *db.FileDownload, err error) {
+	err = p.resilience.Do("downloadFile", RetryTransient, func() error {
+		response, err = p.Proxy.DownloadFile(database, bucket, id)
+		return err
+	})
+	return response, err
+}
+
+// ListFiles lists the files, with retries.
+func (p *Proxy) ListFiles(database, bucket string) (response *db.FilesResponse, err error) {
+	err = p.resilience.Do("listFiles", RetryTransient, func() error {
+		response, err = p.Proxy.ListFiles(database, bucket)
+		return err
+	})
+	return response, err
+}
+
+// DeleteFile deletes the file, retrying only if it certainly wasn't deleted.
+func (p *Proxy) DeleteFile(database, bucket, id string) (response *db.DeleteResponse, err error) {
+	err = p.resilience.Do("deleteFile", RetryUnsent, func() error {
+		response, err = p.Proxy.DeleteFile(database, bucket, id)
+		return err
+	})
+	return response, err
+}
@@ -0,0 +1,279 @@
+// Package resilience retries the calls to MongoDB that fail for transient reasons, and stops calling it
+// while it is down, with a circuit breaker.
+package resilience
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+const (
+	// DefaultMaxAttempts is how many times an operation is tried, counting the first one.
+	DefaultMaxAttempts = 3
+	// DefaultInitialBackoff is the longest wait before the first retry.
+	DefaultInitialBackoff = 100 * time.Millisecond
+	// DefaultMaxBackoff is the longest wait between retries.
+	DefaultMaxBackoff = 2 * time.Second
+	// DefaultFailureThreshold is how many consecutive failures open the circuit breaker.
+	DefaultFailureThreshold = 5
+	// DefaultOpenDuration is how long the circuit breaker stays open before trying MongoDB again.
+	DefaultOpenDuration = 30 * time.Second
+)
+
+// notPrimaryCodes are the codes of the errors returned by servers that refused a write without running it.
+var notPrimaryCodes = []int{10107, 13435, 13436, 189, 11602}
+
+// transientCodes are the codes of server errors that go away on their own, like elections and shutdowns.
+var transientCodes = []int{6, 7, 89, 91, 9001, 11600, 10107, 13435, 13436, 189, 11602}
+
+// Config defines the retries and the circuit breaker. Zero values use the defaults.
+type Config struct {
+	// MaxAttempts is how many times an operation is tried, counting the first one. Use 1 to disable retries.
+	MaxAttempts int
+	// InitialBackoff is the longest wait before the first retry. It doubles on each retry, up to MaxBackoff,
+	// and the actual wait is a random fraction of it (full jitter).
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// FailureThreshold is how many consecutive failures open the circuit breaker. Use -1 to disable it.
+	FailureThreshold int
+	// OpenDuration is how long the circuit breaker stays open.
+	OpenDuration time.Duration
+
+	// Sleep, Clock and Random replace time.Sleep, time.Now and rand.Float64, for tests.
+	Sleep  func(time.Duration)
+	Clock  func() time.Time
+	Random func() float64
+}
+
+// Resilience retries the operations on one MongoDB deployment, and holds its circuit breaker and metrics.
+type Resilience struct {
+	Name    string
+	Breaker *Breaker
+	config  Config
+	metrics metrics
+}
+
+type metrics struct {
+	mutex        sync.Mutex
+	calls        int64
+	retries      map[string]int64
+	failures     int64
+	rejected     int64
+	opened       int64
+	stateChanged time.Time
+}
+
+// Metrics counts the calls, retries and failures of a deployment, and shows the state of its circuit breaker.
+type Metrics struct {
+	State        string           `json:"state"`
+	StateChanged *time.Time       `json:"state_changed,omitempty"`
+	Calls        int64            `json:"calls"`
+	Retries      int64            `json:"retries"`
+	RetriesBy    map[string]int64 `json:"retries_by_operation,omitempty"`
+	Failures     int64            `json:"failures"`
+	Rejected     int64            `json:"rejected"`
+	Opened       int64            `json:"opened"`
+}
+
+// New creates the resilience of the deployment called name, filling the defaults of config.
+func New(name string, config Config) *Resilience {
+	if config.MaxAttempts < 1 {
+		config.MaxAttempts = DefaultMaxAttempts
+	}
+	if config.InitialBackoff <= 0 {
+		config.InitialBackoff = DefaultInitialBackoff
+	}
+	if config.MaxBackoff <= 0 {
+		config.MaxBackoff = DefaultMaxBackoff
+	}
+	if config.FailureThreshold == 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+	if config.OpenDuration <= 0 {
+		config.OpenDuration = DefaultOpenDuration
+	}
+	if config.Sleep == nil {
+		config.Sleep = time.Sleep
+	}
+	if config.Clock == nil {
+		config.Clock = time.Now
+	}
+	if config.Random == nil {
+		config.Random = rand.Float64
+	}
+
+	r := &Resilience{
+		Name:    name,
+		config:  config,
+		metrics: metrics{retries: map[string]int64{}},
+	}
+	r.Breaker = NewBreaker(config.FailureThreshold, config.OpenDuration, config.Clock, r.stateChanged)
+	return r
+}
+
+// Metrics returns a snapshot of the metrics.
+func (r *Resilience) Metrics() Metrics {
+	state := r.Breaker.State()
+
+	r.metrics.mutex.Lock()
+	defer r.metrics.mutex.Unlock()
+	result := Metrics{
+		State:     state,
+		Calls:     r.metrics.calls,
+		RetriesBy: map[string]int64{},
+		Failures:  r.metrics.failures,
+		Rejected:  r.metrics.rejected,
+		Opened:    r.metrics.opened,
+	}
+	if !r.metrics.stateChanged.IsZero() {
+		changed := r.metrics.stateChanged
+		result.StateChanged = &changed
+	}
+	for operation, retries := range r.metrics.retries {
+		result.RetriesBy[operation] = retries
+		result.Retries += retries
+	}
+	return result
+}
+
+// Retry classes tell which failures of an operation may be retried.
+const (
+	// RetryTransient retries reads and idempotent writes after any transient failure.
+	RetryTransient = iota
+	// RetryUnsent retries writes only when MongoDB certainly didn't run them, like when no server was available.
+	RetryUnsent
+	// RetryNever doesn't retry, like uploads, whose content can't be read again.
+	RetryNever
+)
+
+// Do calls fn through the circuit breaker, retrying it with exponential backoff while it fails and
+// the failure may be retried, as told by class.
+func (r *Resilience) Do(operation string, class int, fn func() error) error {
+	r.count(func(m *metrics) { m.calls++ })
+
+	var err error
+	for attempt := 0; attempt < r.config.MaxAttempts; attempt++ {
+		if attempt > 0 {
+			r.config.Sleep(r.backoff(attempt))
+			r.count(func(m *metrics) { m.retries[operation]++ })
+		}
+
+		if allowErr := r.Breaker.Allow(); allowErr != nil {
+			r.count(func(m *metrics) { m.rejected++ })
+			if err == nil {
+				err = allowErr
+			}
+			return err
+		}
+
+		err = fn()
+		failed := IsTransient(err)
+		r.Breaker.Record(failed)
+		if !failed {
+			return err
+		}
+		r.count(func(m *metrics) { m.failures++ })
+
+		if class == RetryNever || (class == RetryUnsent && !IsUnsent(err)) {
+			return err
+		}
+		if r.Breaker.RetryAfter() > 0 {
+			// This failure opened the breaker: waiting to retry would be in vain.
+			return err
+		}
+		log.Warn().
+			Err(err).
+			Str("backend", r.Name).
+			Str("operation", operation).
+			Int("attempt", attempt+1).
+			Msg("transient failure calling MongoDB")
+	}
+	return err
+}
+
+// backoff returns the wait before retry number attempt: a random fraction of the exponential backoff.
+func (r *Resilience) backoff(attempt int) time.Duration {
+	ceiling := float64(r.config.InitialBackoff) * math.Pow(2, float64(attempt-1))
+	if ceiling > float64(r.config.MaxBackoff) {
+		ceiling = float64(r.config.MaxBackoff)
+	}
+	return time.Duration(r.config.Random() * ceiling)
+}
+
+func (r *Resilience) count(update func(m *metrics)) {
+	r.metrics.mutex.Lock()
+	defer r.metrics.mutex.Unlock()
+	update(&r.metrics)
+}
+
+// stateChanged is called by the breaker, with its lock held.
+func (r *Resilience) stateChanged(from, to string) {
+	r.count(func(m *metrics) {
+		m.stateChanged = r.config.Clock()
+		if to == StateOpen {
+			m.opened++
+		}
+	})
+
+	event := log.Info()
+	if to == StateOpen {
+		event = log.Warn()
+	}
+	event.
+		Str("backend", r.Name).
+		Str("from", from).
+		Str("to", to).
+		Msg("circuit breaker changed state")
+}
+
+// IsTransient tells if err is a failure of MongoDB that may go away on its own: network errors, timeouts,
+// no server available, elections and shutdowns. Errors caused by the request, like duplicate keys, are not.
+func IsTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+	if IsUnsent(err) || mongo.IsNetworkError(err) || mongo.IsTimeout(err) || errors.Is(err, mongo.ErrClientDisconnected) {
+		return true
+	}
+	var labeled mongo.LabeledError
+	if errors.As(err, &labeled) && (labeled.HasErrorLabel("RetryableWriteError") || labeled.HasErrorLabel("TransientTransactionError")) {
+		return true
+	}
+	return hasErrorCode(err, transientCodes)
+}
+
+// IsUnsent tells if err certainly happened before MongoDB ran the operation, so even writes may be retried:
+// no server could be selected, or the server refused it for not being the primary.
+func IsUnsent(err error) bool {
+	var selection topology.ServerSelectionError
+	if errors.As(err, &selection) {
+		return true
+	}
+	return hasErrorCode(err, notPrimaryCodes)
+}
+
+func hasErrorCode(err error, codes []int) bool {
+	var serverErr mongo.ServerError
+	if !errors.As(err, &serverErr) {
+		return false
+	}
+	for _, code := range codes {
+		if serverErr.HasErrorCode(code) {
+			return true
+		}
+	}
+	return false
+}
+
+// Guarded is implemented by the proxies protected by a Resilience.
+type Guarded interface {
+	Resilience() *Resilience
+}
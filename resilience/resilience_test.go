@@ -0,0 +1,263 @@
+package resilience_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+var (
+	errNoServer    = topology.ServerSelectionError{Wrapped: errors.New("no primary")}
+	errNetwork     = mongo.CommandError{Code: 0, Message: "connection reset", Labels: []string{"NetworkError"}}
+	errShutdown    = mongo.CommandError{Code: 91, Message: "shutdown in progress"}
+	errNotPrimary  = mongo.CommandError{Code: 10107, Message: "not primary"}
+	errDuplicate   = mongo.WriteException{WriteErrors: []mongo.WriteError{{Code: 11000, Message: "duplicate key"}}}
+	errApplication = errors.New("invalid filter")
+)
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func TestErrorClasses(t *testing.T) {
+	testCases := []struct {
+		err       error
+		transient bool
+		unsent    bool
+	}{
+		{err: nil},
+		{err: errNoServer, transient: true, unsent: true},
+		{err: fmt.Errorf("find: %w", errNoServer), transient: true, unsent: true},
+		{err: errNetwork, transient: true},
+		{err: errShutdown, transient: true},
+		{err: errNotPrimary, transient: true, unsent: true},
+		{err: errDuplicate},
+		{err: errApplication},
+		{err: &resilience.OpenError{RetryAfter: time.Second}},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.transient, resilience.IsTransient(tc.err), "transient: %v", tc.err)
+		assert.Equal(t, tc.unsent, resilience.IsUnsent(tc.err), "unsent: %v", tc.err)
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	var changes []string
+	breaker := resilience.NewBreaker(2, 30*time.Second, clock.Now, func(from, to string) {
+		changes = append(changes, from+">"+to)
+	})
+
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(true)
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(true)
+	assert.Equal(t, resilience.StateClosed, breaker.State(), "a success resets the failures")
+
+	breaker.Record(true)
+	assert.Equal(t, resilience.StateOpen, breaker.State())
+	err := breaker.Allow()
+	assert.True(t, errors.Is(err, resilience.ErrCircuitOpen))
+	assert.Equal(t, 30*time.Second, breaker.RetryAfter())
+
+	clock.now = clock.now.Add(20 * time.Second)
+	assert.Equal(t, 10*time.Second, breaker.RetryAfter())
+
+	clock.now = clock.now.Add(10 * time.Second)
+	assert.Equal(t, resilience.StateHalfOpen, breaker.State())
+	assert.Equal(t, time.Duration(0), breaker.RetryAfter())
+	assert.NoError(t, breaker.Allow(), "the first call is a probe")
+	assert.Error(t, breaker.Allow(), "only one probe at a time")
+	breaker.Record(true)
+	assert.Equal(t, resilience.StateOpen, breaker.State(), "a failed probe opens it again")
+
+	clock.now = clock.now.Add(30 * time.Second)
+	assert.NoError(t, breaker.Allow())
+	breaker.Record(false)
+	assert.Equal(t, resilience.StateClosed, breaker.State())
+
+	assert.Equal(t, []string{"closed>open", "open>half-open", "half-open>open", "open>half-open", "half-open>closed"}, changes)
+
+	disabled := resilience.NewBreaker(-1, time.Second, clock.Now, nil)
+	for i := 0; i < 100; i++ {
+		disabled.Record(true)
+	}
+	assert.Equal(t, resilience.StateClosed, disabled.State())
+}
+
+func TestDo(t *testing.T) {
+	testCases := []struct {
+		name          string
+		class         int
+		errs          []error
+		expectedCalls int
+		expectedErr   error
+	}{
+		{name: "success", class: resilience.RetryTransient, errs: []error{nil}, expectedCalls: 1},
+		{name: "transient then success", class: resilience.RetryTransient, errs: []error{errNetwork, errShutdown, nil}, expectedCalls: 3},
+		{name: "gives up", class: resilience.RetryTransient, errs: []error{errNetwork, errNetwork, errNetwork, nil}, expectedCalls: 3, expectedErr: errNetwork},
+		{name: "application errors are not retried", class: resilience.RetryTransient, errs: []error{errApplication, nil}, expectedCalls: 1, expectedErr: errApplication},
+		{name: "unsent writes are retried", class: resilience.RetryUnsent, errs: []error{errNoServer, errNotPrimary, nil}, expectedCalls: 3},
+		{name: "writes that may have run are not retried", class: resilience.RetryUnsent, errs: []error{errNetwork, nil}, expectedCalls: 1, expectedErr: errNetwork},
+		{name: "never", class: resilience.RetryNever, errs: []error{errNoServer, nil}, expectedCalls: 1, expectedErr: errNoServer},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var sleeps []time.Duration
+			r := resilience.New("prod", resilience.Config{
+				InitialBackoff:   100 * time.Millisecond,
+				MaxBackoff:       150 * time.Millisecond,
+				FailureThreshold: 10,
+				Sleep:            func(d time.Duration) { sleeps = append(sleeps, d) },
+				Random:           func() float64 { return 0.5 },
+			})
+
+			calls := 0
+			err := r.Do("find", tc.class, func() error {
+				calls++
+				return tc.errs[calls-1]
+			})
+
+			assert.Equal(t, tc.expectedErr, err)
+			assert.Equal(t, tc.expectedCalls, calls)
+			expectedSleeps := []time.Duration{50 * time.Millisecond, 75 * time.Millisecond}[:tc.expectedCalls-1]
+			if len(expectedSleeps) == 0 {
+				assert.Empty(t, sleeps)
+			} else {
+				assert.Equal(t, expectedSleeps, sleeps, "full jitter of the exponential backoff, up to the maximum")
+			}
+			assert.Equal(t, int64(tc.expectedCalls-1), r.Metrics().Retries)
+		})
+	}
+}
+
+func TestDoOpensBreaker(t *testing.T) {
+	clock := &fakeClock{now: time.Now()}
+	r := resilience.New("prod", resilience.Config{
+		MaxAttempts:      3,
+		FailureThreshold: 2,
+		OpenDuration:     time.Minute,
+		Sleep:            func(time.Duration) {},
+		Clock:            clock.Now,
+	})
+
+	calls := 0
+	err := r.Do("find", resilience.RetryTransient, func() error {
+		calls++
+		return errNoServer
+	})
+	assert.Equal(t, errNoServer, err, "the last failure is returned, not the open breaker")
+	assert.Equal(t, 2, calls, "the breaker stops the retries")
+
+	err = r.Do("find", resilience.RetryTransient, func() error {
+		calls++
+		return nil
+	})
+	var openErr *resilience.OpenError
+	if assert.True(t, errors.As(err, &openErr)) {
+		assert.Equal(t, time.Minute, openErr.RetryAfter)
+	}
+	assert.Equal(t, 2, calls, "MongoDB is not called while the breaker is open")
+
+	metrics := r.Metrics()
+	assert.Equal(t, resilience.StateOpen, metrics.State)
+	assert.Equal(t, clock.now, *metrics.StateChanged)
+	assert.Equal(t, int64(2), metrics.Calls)
+	assert.Equal(t, int64(1), metrics.Retries)
+	assert.Equal(t, map[string]int64{"find": 1}, metrics.RetriesBy)
+	assert.Equal(t, int64(2), metrics.Failures)
+	assert.Equal(t, int64(1), metrics.Rejected)
+	assert.Equal(t, int64(1), metrics.Opened)
+}
+
+// flakyProxy fails the first calls to Find and Insert.
+type flakyProxy struct {
+	mock.DBProxy
+	failures int
+	err      error
+	calls    int
+}
+
+func (p *flakyProxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return nil, p.err
+	}
+	return &db.FindResponse{Results: []bson.M{{"calls": p.calls}}}, nil
+}
+
+func (p *flakyProxy) Insert(database, collection string, entry db.Quote) (*db.InsertResponse, error) {
+	p.calls++
+	if p.calls <= p.failures {
+		return nil, p.err
+	}
+	return &db.InsertResponse{InsertedID: p.calls}, nil
+}
+
+func (p *flakyProxy) HealthCheck() (*db.HealthResponse, error) {
+	return nil, errNoServer
+}
+
+func (p *flakyProxy) ListCollections(database string) (*db.CollectionsResponse, error) {
+	var collections []db.CollectionDetails
+	return &db.CollectionsResponse{Collections: []db.CollectionDetails{collections[0]}}, nil
+}
+
+func TestProxy(t *testing.T) {
+	config := resilience.Config{Sleep: func(time.Duration) {}}
+
+	flaky := &flakyProxy{failures: 2, err: errNetwork}
+	proxy := resilience.NewProxy(flaky, resilience.New("prod", config))
+	found, err := proxy.Find("okr", "quotes", bson.M{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []bson.M{{"calls": 3}}, found.Results)
+	}
+
+	flaky = &flakyProxy{failures: 1, err: errNetwork}
+	proxy = resilience.NewProxy(flaky, resilience.New("prod", config))
+	_, err = proxy.Insert("okr", "quotes", db.Quote{Author: "Seneca"})
+	assert.Equal(t, errNetwork, err, "the insert may have run")
+	assert.Equal(t, 1, flaky.calls)
+
+	flaky = &flakyProxy{failures: 1, err: errNotPrimary}
+	proxy = resilience.NewProxy(flaky, resilience.New("prod", config))
+	inserted, err := proxy.Insert("okr", "quotes", db.Quote{Author: "Seneca"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, 2, inserted.InsertedID)
+	}
+
+	_, err = proxy.HealthCheck()
+	var selection topology.ServerSelectionError
+	assert.True(t, errors.As(err, &selection), "server selection errors are failures: %v", err)
+
+	// Bugs are not failures of the database: they are neither retried nor counted by the breaker.
+	assert.Panics(t, func() { proxy.ListCollections("okr") })
+	assert.Equal(t, resilience.StateClosed, proxy.Resilience().Breaker.State())
+	assert.Equal(t, proxy.Resilience(), proxy.Resilience())
+
+	consistent, err := proxy.WithConsistency(db.Consistency{ReadConcern: "majority"})
+	if assert.NoError(t, err) {
+		guarded, ok := consistent.(resilience.Guarded)
+		if assert.True(t, ok) {
+			assert.Same(t, proxy.Resilience(), guarded.Resilience())
+		}
+	}
+}
@@ -0,0 +1,123 @@
+// Package secrets loads credentials from files or the environment, and keeps them out of URIs and logs.
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Redacted replaces secrets in anything shown to users or written to logs.
+const Redacted = "xxxxx"
+
+// minRegisteredLength is the shortest secret replaced wherever it appears; shorter values would
+// mangle unrelated text.
+const minRegisteredLength = 4
+
+var registry = struct {
+	sync.RWMutex
+	values map[string]bool
+}{values: map[string]bool{}}
+
+// Load returns the secret called name. If the environment variable name+"_FILE" is defined, the secret is read
+// from that file (like Docker and Kubernetes secret mounts), without its trailing line break; otherwise it is the
+// value of the variable name. The secret is registered, so it is removed from the logs.
+func Load(name string) (string, error) {
+	value, err := LoadValue(name)
+	if err != nil {
+		return "", err
+	}
+	Register(value)
+	return value, nil
+}
+
+// LoadValue returns the value called name, from the file in name+"_FILE" or from the variable name, like Load,
+// but doesn't register it. It is meant for values kept along with the secrets that may appear in the logs,
+// like user names: registering a user called "admin" would hide every admin database and path.
+func LoadValue(name string) (string, error) {
+	if filename := os.Getenv(name + "_FILE"); len(filename) > 0 {
+		if _, ok := os.LookupEnv(name); ok {
+			return "", fmt.Errorf("%s and %s_FILE are both defined", name, name)
+		}
+		value, err := ReadValueFile(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s_FILE: %v", name, err)
+		}
+		return value, nil
+	}
+	return os.Getenv(name), nil
+}
+
+// ReadFile returns the secret in filename, without its trailing line break. The secret is registered,
+// so it is removed from the logs.
+func ReadFile(filename string) (string, error) {
+	value, err := ReadValueFile(filename)
+	if err != nil {
+		return "", err
+	}
+	Register(value)
+	return value, nil
+}
+
+// ReadValueFile returns the value in filename, without its trailing line break, like ReadFile, but doesn't
+// register it.
+func ReadValueFile(filename string) (string, error) {
+	content, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(content), "\r\n"), nil
+}
+
+// Register adds values to the secrets removed from the logs. Values shorter than 4 characters are ignored.
+func Register(values ...string) {
+	registry.Lock()
+	defer registry.Unlock()
+	for _, value := range values {
+		if len(value) >= minRegisteredLength {
+			registry.values[value] = true
+		}
+	}
+}
+
+// RegisterURI registers the password and the secret options of a connection string. If it can't be parsed,
+// the password is still registered as written, and a warning is logged.
+func RegisterURI(uri string) {
+	parsed, err := ParseURI(uri)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("uri", RedactURI(uri)).
+			Msg("failed to parse the connection string, only its password is registered")
+		if scheme := strings.Index(uri, "://"); scheme >= 0 {
+			rest := uri[scheme+3:]
+			if start, end := findPassword(rest); start >= 0 {
+				Register(rest[start:end])
+			}
+		}
+		return
+	}
+	if parsed.User != nil {
+		if password, ok := parsed.User.Password(); ok {
+			Register(password)
+		}
+	}
+	for _, key := range secretURIOptions {
+		Register(parsed.Options.Get(key))
+	}
+}
+
+// registered returns the registered secrets.
+func registered() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+	values := make([]string, 0, len(registry.values))
+	for value := range registry.values {
+		values = append(values, value)
+	}
+	return values
+}
@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// URI is a MongoDB connection string, split in its parts.
+type URI struct {
+	// Scheme is mongodb or mongodb+srv.
+	Scheme string
+	// User has the credentials, if any.
+	User *url.Userinfo
+	// Hosts is the list of hosts, as written, like "db1:27017,db2".
+	Hosts string
+	// Database is the database after the hosts, as written, if any.
+	Database string
+	// Options are the options after the "?".
+	Options url.Values
+}
+
+// ParseURI splits a connection string in its parts. Unlike url.Parse, it accepts seed lists where only some
+// hosts have a port, like mongodb://db1:27017,db2. Like RedactURI, a password with "@" ends at the last one
+// before the path. The errors never quote the URI.
+func ParseURI(uri string) (*URI, error) {
+	scheme := strings.Index(uri, "://")
+	if scheme < 0 {
+		return nil, errors.New("missing scheme")
+	}
+	parsed := &URI{Scheme: uri[:scheme], Options: url.Values{}}
+	rest := uri[scheme+3:]
+
+	if question := strings.Index(rest, "?"); question >= 0 {
+		options, err := url.ParseQuery(rest[question+1:])
+		if err != nil {
+			return nil, errors.New("invalid options")
+		}
+		parsed.Options = options
+		rest = rest[:question]
+	}
+	if slash := strings.Index(rest, "/"); slash >= 0 {
+		parsed.Database = rest[slash+1:]
+		rest = rest[:slash]
+	}
+
+	if at := strings.LastIndex(rest, "@"); at >= 0 {
+		user, err := parseUserinfo(rest[:at])
+		if err != nil {
+			return nil, err
+		}
+		parsed.User = user
+		rest = rest[at+1:]
+	}
+	if len(rest) == 0 {
+		return nil, errors.New("missing hosts")
+	}
+	parsed.Hosts = rest
+	return parsed, nil
+}
+
+// parseUserinfo decodes the percent-encoded credentials of a connection string.
+func parseUserinfo(userinfo string) (*url.Userinfo, error) {
+	username, password, hasPassword := userinfo, "", false
+	if colon := strings.Index(userinfo, ":"); colon >= 0 {
+		username, password, hasPassword = userinfo[:colon], userinfo[colon+1:], true
+	}
+
+	username, err := url.PathUnescape(username)
+	if err == nil && hasPassword {
+		password, err = url.PathUnescape(password)
+	}
+	if err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if hasPassword {
+		return url.UserPassword(username, password), nil
+	}
+	return url.User(username), nil
+}
+
+// String returns the connection string, with the credentials percent-encoded and the options sorted by name.
+func (u *URI) String() string {
+	var b strings.Builder
+	b.WriteString(u.Scheme)
+	b.WriteString("://")
+	if u.User != nil {
+		b.WriteString(u.User.String())
+		b.WriteString("@")
+	}
+	b.WriteString(u.Hosts)
+	// The options must follow a slash, even without a database.
+	b.WriteString("/")
+	b.WriteString(u.Database)
+	if len(u.Options) > 0 {
+		b.WriteString("?")
+		b.WriteString(u.Options.Encode())
+	}
+	return b.String()
+}
@@ -0,0 +1,93 @@
+package swagger
+
+import (
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+)
+
+// swagger:route GET /admin/collections/{Database} listCollections
+// ListCollections shows the collections in the database, with count, size and indexes.
+// Requires the X-Admin-Key header.
+// responses:
+//   200: listCollections
+
+// This text will appear as description of the response body.
+// swagger:response listCollections
+type listCollectionsResponseWrapper struct {
+	// in:body
+	Body db.CollectionsResponse
+}
+
+// swagger:parameters listCollections dropDatabase
+type databaseParamsWrapper struct {
+	// in:header
+	XAdminKey string `json:"X-Admin-Key"`
+	// in:path
+	Database string
+}
+
+// swagger:route POST /admin/create/{Database}/{Collection} createCollection
+// CreateCollection creates a collection (capped, validated or time series).
+// Requires the X-Admin-Key header.
+// responses:
+//   200: admin
+
+// swagger:parameters createCollection
+type createCollectionParamsWrapper struct {
+	// in:header
+	XAdminKey string `json:"X-Admin-Key"`
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.CreateCollectionRequest
+}
+
+// swagger:route POST /admin/rename/{Database}/{Collection} renameCollection
+// RenameCollection changes the name of a collection.
+// Requires the X-Admin-Key header.
+// responses:
+//   200: admin
+
+// swagger:parameters renameCollection
+type renameCollectionParamsWrapper struct {
+	// in:header
+	XAdminKey string `json:"X-Admin-Key"`
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.RenameCollectionRequest
+}
+
+// swagger:route POST /admin/drop/{Database}/{Collection} dropCollection
+// DropCollection removes the collection and all its documents.
+// Requires the X-Admin-Key header.
+// responses:
+//   200: admin
+
+// swagger:parameters dropCollection
+type dropCollectionParamsWrapper struct {
+	// in:header
+	XAdminKey string `json:"X-Admin-Key"`
+	// in:path
+	Database string
+	// in:path
+	Collection string
+}
+
+// swagger:route POST /admin/dropDatabase/{Database} dropDatabase
+// DropDatabase removes the database and all its collections.
+// Requires the X-Admin-Key header.
+// responses:
+//   200: admin
+
+// This text will appear as description of the response body.
+// swagger:response admin
+type adminResponseWrapper struct {
+	// in:body
+	Body db.AdminResponse
+}
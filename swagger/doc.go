@@ -18,11 +18,18 @@
 //     - application/json
 //
 //     Security:
-//     - basic
+//     - api_key:
+//     - bearer:
 //
 //    SecurityDefinitions:
-//    basic:
-//      type: basic
+//    api_key:
+//      type: apiKey
+//      in: header
+//      name: X-API-Key
+//    bearer:
+//      type: apiKey
+//      in: header
+//      name: Authorization
 //
 // swagger:meta
 package swagger
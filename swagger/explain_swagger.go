@@ -0,0 +1,28 @@
+package swagger
+
+import "github.com/otaviokr/mongodb-proxy-ms/db"
+
+// swagger:route POST /explain/{Database}/{Collection} explain
+// Explain returns the execution plan of a find, aggregate, count or update. Updates are not applied.
+// responses:
+//   200: explain
+
+// This text will appear as description of the response body.
+// swagger:response explain
+type explainResponseWrapper struct {
+	// in:body
+	Body db.ExplainResponse
+}
+
+// swagger:parameters explain
+type explainParamsWrapper struct {
+	// This text will appear as description of the request body.
+
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.ExplainRequest
+}
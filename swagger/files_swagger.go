@@ -0,0 +1,93 @@
+package swagger
+
+import "github.com/otaviokr/mongodb-proxy-ms/db"
+
+// swagger:route POST /upload/{Database}/{Bucket} upload
+// Upload stores a file in the GridFS bucket.
+// consumes:
+//   - multipart/form-data
+// responses:
+//   200: upload
+
+// This text will appear as description of the response body.
+// swagger:response upload
+type uploadResponseWrapper struct {
+	// in:body
+	Body db.FileInfo
+}
+
+// swagger:parameters upload
+type uploadParamsWrapper struct {
+	// in:path
+	Database string
+	// in:path
+	Bucket string
+
+	// The file to be stored.
+	// in:formData
+	// swagger:file
+	File interface{} `json:"file"`
+
+	// Optional JSON object saved as metadata of the file.
+	// in:formData
+	Metadata string `json:"metadata"`
+}
+
+// swagger:route GET /download/{Database}/{Bucket}/{ID} download
+// Download returns the content of a file in the GridFS bucket. A single byte range may be requested.
+// produces:
+//   - application/octet-stream
+// responses:
+//   200: download
+//   206: download
+//   404: description: file not found
+
+// This text will appear as description of the response body.
+// swagger:response download
+type downloadResponseWrapper struct {
+	// in:body
+	Body []byte
+}
+
+// swagger:parameters download deleteFile
+type fileParamsWrapper struct {
+	// in:path
+	Database string
+	// in:path
+	Bucket string
+	// in:path
+	ID string
+}
+
+// swagger:route GET /files/{Database}/{Bucket} listFiles
+// ListFiles shows the files in the GridFS bucket.
+// responses:
+//   200: listFiles
+
+// This text will appear as description of the response body.
+// swagger:response listFiles
+type listFilesResponseWrapper struct {
+	// in:body
+	Body db.FilesResponse
+}
+
+// swagger:parameters listFiles
+type listFilesParamsWrapper struct {
+	// in:path
+	Database string
+	// in:path
+	Bucket string
+}
+
+// swagger:route POST /deleteFile/{Database}/{Bucket}/{ID} deleteFile
+// DeleteFile removes a file from the GridFS bucket.
+// responses:
+//   200: deleteFile
+//   404: description: file not found
+
+// This text will appear as description of the response body.
+// swagger:response deleteFile
+type deleteFileResponseWrapper struct {
+	// in:body
+	Body db.DeleteResponse
+}
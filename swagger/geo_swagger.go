@@ -0,0 +1,44 @@
+package swagger
+
+import "github.com/otaviokr/mongodb-proxy-ms/db"
+
+// swagger:route POST /geo/near/{Database}/{Collection} geoNear
+// GeoNear returns the entries closest to a GeoJSON point, nearest first.
+// responses:
+//   200: FindResponse shows the result of the find
+
+// swagger:route POST /geo/geoNear/{Database}/{Collection} geoNearAggregate
+// GeoNearAggregate returns the entries closest to a GeoJSON point, with the distance to it.
+// responses:
+//   200: FindResponse shows the result of the aggregation
+
+// swagger:parameters geoNear geoNearAggregate
+type geoNearParamsWrapper struct {
+	// This text will appear as description of the request body.
+
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.GeoNearRequest
+}
+
+// swagger:route POST /geo/within/{Database}/{Collection} geoWithin
+// GeoWithin returns the entries inside a box, a GeoJSON polygon or a circle on the Earth surface.
+// responses:
+//   200: FindResponse shows the result of the find
+
+// swagger:parameters geoWithin
+type geoWithinParamsWrapper struct {
+	// This text will appear as description of the request body.
+
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.GeoWithinRequest
+}
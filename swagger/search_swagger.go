@@ -0,0 +1,29 @@
+package swagger
+
+import "github.com/otaviokr/mongodb-proxy-ms/db"
+
+// swagger:route POST /search/{Database}/{Collection} search
+// Search returns the entries that match a full-text search, sorted by relevance.
+// The collection must have a text index.
+// responses:
+//   200: search
+
+// This text will appear as description of the response body.
+// swagger:response search
+type searchResponseWrapper struct {
+	// in:body
+	Body db.SearchResponse
+}
+
+// swagger:parameters search
+type searchParamsWrapper struct {
+	// This text will appear as description of the request body.
+
+	// in:path
+	Database string
+	// in:path
+	Collection string
+
+	// in:body
+	Body db.SearchRequest
+}
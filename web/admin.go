@@ -0,0 +1,216 @@
+package web
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminKeyHeader is the header where clients must send the admin key.
+const AdminKeyHeader = "X-Admin-Key"
+
+// DatabaseURI holds the database name passed in URI, for routes that don't target a collection.
+type DatabaseURI struct {
+	Database string `json:"Database" uri:"Database" binding:"required"`
+}
+
+// RequireAdmin aborts the request unless it carries the configured admin key.
+// Without an admin key, access to the admin routes is left to the policy.
+func (w *Server) RequireAdmin(c *gin.Context) {
+	if len(w.options.AdminKey) == 0 {
+		c.Next()
+		return
+	}
+
+	// Comparing digests keeps the comparison time independent of the key length.
+	expected := sha256.Sum256([]byte(w.options.AdminKey))
+	actual := sha256.Sum256([]byte(c.GetHeader(AdminKeyHeader)))
+
+	if subtle.ConstantTimeCompare(expected[:], actual[:]) != 1 {
+		log.Warn().
+			Str("path", c.Request.URL.Path).
+			Str("client", c.ClientIP()).
+			Msg("refused admin request without a valid admin key")
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errors": "admin permission required"})
+		return
+	}
+
+	c.Next()
+}
+
+// ListCollections returns the collections of a database, with their statistics.
+func (w *Server) ListCollections(c *gin.Context) {
+	var databaseDetails DatabaseURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).ListCollections(databaseDetails.Database)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error listing collections")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// CreateCollection creates a new collection with the options given in the request body.
+func (w *Server) CreateCollection(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	var request db.CreateCollectionRequest
+	if c.Request.ContentLength != 0 {
+		err = c.ShouldBindJSON(&request)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+			return
+		}
+	}
+
+	err = request.Validate()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).CreateCollection(databaseDetails.Database, databaseDetails.Collection, request)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error creating collection")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// RenameCollection gives a new name to an existing collection.
+func (w *Server) RenameCollection(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	var request db.RenameCollectionRequest
+	err = c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	// The new name is a namespace too, so it must be allowed like the collection renamed. Otherwise, clients
+	// could overwrite the collections they can't access, with drop_target.
+	if namespaces := w.options.Namespaces; namespaces != nil {
+		database, collection, err := namespaces.Resolve(c.GetString(publicDatabaseKey), request.To)
+		if err != nil || database != databaseDetails.Database {
+			c.JSON(http.StatusNotFound, gin.H{"errors": "namespace not found"})
+			return
+		}
+		request.To = collection
+	}
+	if !w.allows(c, auth.OpAdmin, databaseDetails.Database, request.To) {
+		c.JSON(http.StatusForbidden, gin.H{"errors": "operation not allowed: " + string(auth.OpAdmin)})
+		return
+	}
+
+	result, err := w.proxyFor(c).RenameCollection(databaseDetails.Database, databaseDetails.Collection, request)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error renaming collection")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DropCollection removes a collection and all its documents.
+func (w *Server) DropCollection(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).DropCollection(databaseDetails.Database, databaseDetails.Collection)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error dropping collection")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DropDatabase removes a database and all its collections.
+func (w *Server) DropDatabase(c *gin.Context) {
+	var databaseDetails DatabaseURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).DropDatabase(databaseDetails.Database)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error dropping database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
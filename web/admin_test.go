@@ -0,0 +1,145 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type AdminTestCase struct {
+	testCaseID      string
+	method          string
+	path            string
+	adminKey        string
+	body            string
+	expectedCode    int
+	expectedMessage string
+}
+
+func TestAdmin(t *testing.T) {
+	testCases := []AdminTestCase{
+		{
+			testCaseID:      "adminListOK",
+			method:          "GET",
+			path:            "/admin/collections/cool_db",
+			adminKey:        "s3cr3t",
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"database":"cool_db","collections":[{"name":"quotes","type":"collection","count":2,"size":512,"storage_size":0,"total_index_size":0,"indexes":[{"name":"_id_","keys":{"_id":1}}]}]}`,
+		},
+		{
+			testCaseID:      "adminListOK",
+			method:          "GET",
+			path:            "/admin/collections/cool_db",
+			adminKey:        "wrong",
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: `{"errors":"admin permission required"}`,
+		},
+		{
+			testCaseID:      "adminListOK",
+			method:          "GET",
+			path:            "/admin/collections/cool_db",
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: `{"errors":"admin permission required"}`,
+		},
+		{
+			testCaseID:      "adminCreateOK",
+			method:          "POST",
+			path:            "/admin/create/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			body:            `{"capped":true,"size_in_bytes":4096}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"database":"cool_db","collection":"cool_collection","operation":"create"}`,
+		},
+		{
+			testCaseID:      "adminCreateOK",
+			method:          "POST",
+			path:            "/admin/create/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			body:            `{"capped":true}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"capped collections require a positive size_in_bytes"}`,
+		},
+		{
+			testCaseID:      "adminCreateExists",
+			method:          "POST",
+			path:            "/admin/create/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			expectedCode:    http.StatusInternalServerError,
+			expectedMessage: `""`,
+		},
+		{
+			testCaseID:      "adminRenameOK",
+			method:          "POST",
+			path:            "/admin/rename/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			body:            `{"to":"new_collection"}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"database":"cool_db","collection":"new_collection","operation":"rename"}`,
+		},
+		{
+			testCaseID:      "adminRenameOK",
+			method:          "POST",
+			path:            "/admin/rename/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			body:            `{}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"Key: 'RenameCollectionRequest.To' Error:Field validation for 'To' failed on the 'required' tag"}`,
+		},
+		{
+			testCaseID:      "adminDropOK",
+			method:          "POST",
+			path:            "/admin/drop/cool_db/cool_collection",
+			adminKey:        "s3cr3t",
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"database":"cool_db","collection":"cool_collection","operation":"drop"}`,
+		},
+		{
+			testCaseID:      "adminDropDatabaseOK",
+			method:          "POST",
+			path:            "/admin/dropDatabase/cool_db",
+			adminKey:        "s3cr3t",
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"database":"cool_db","operation":"dropDatabase"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.adminKey) > 0 {
+				request.Header.Set(web.AdminKeyHeader, tc.adminKey)
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{AdminKey: "s3cr3t"})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
+
+func TestAdminDisabled(t *testing.T) {
+	request, err := http.NewRequest("POST", "http://localhost:80/admin/dropDatabase/cool_db", nil)
+	if err != nil {
+		t.FailNow()
+	}
+	request.Header.Set(web.AdminKeyHeader, "")
+
+	recorder := httptest.NewRecorder()
+	ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: "adminDropDatabaseOK"})
+
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "admin routes should not exist without an admin key")
+}
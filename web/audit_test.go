@@ -0,0 +1,114 @@
+package web_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuditTrail(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key")},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		AuthorizeTestCase
+		body              string
+		expectedOperation string
+	}{
+		{AuthorizeTestCase{testCaseID: "insertOK", method: "POST", path: "/insert/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK}, `{"author":"Seneca"}`, "insert"},
+		{AuthorizeTestCase{testCaseID: "updateOK", method: "POST", path: "/update/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK}, `{"filter":{"author":"Seneca"},"update":{"$inc":{"publications":1}}}`, "update"},
+		{AuthorizeTestCase{testCaseID: "findOK", method: "POST", path: "/find/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK}, `{}`, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			var buffer bytes.Buffer
+			logger := audit.NewLogger(audit.NewWriterSink(&buffer), 0)
+
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{
+				Authenticators: []auth.Authenticator{store},
+				Audit:          logger,
+			})
+
+			ws.Router.ServeHTTP(recorder, request)
+			assert.NoError(t, logger.Close())
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			if len(tc.expectedOperation) == 0 {
+				assert.Empty(t, buffer.String(), "reads should not be audited")
+				return
+			}
+
+			var entry map[string]interface{}
+			assert.NoError(t, json.Unmarshal(buffer.Bytes(), &entry))
+			assert.Equal(t, tc.expectedOperation, entry["operation"])
+			assert.Equal(t, "twitter-bot", entry["principal"])
+			assert.Equal(t, "apikey", entry["auth_method"])
+			assert.Equal(t, "cool_db", entry["database"])
+			assert.Equal(t, "cool_collection", entry["collection"])
+		})
+	}
+}
+
+func TestAuditDenials(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key"), Roles: []string{"bot"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Roles: []string{"bot"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"cool_db"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	var buffer bytes.Buffer
+	logger := audit.NewLogger(audit.NewWriterSink(&buffer), 0)
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "findOK"}, web.Options{
+		Authenticators: []auth.Authenticator{store},
+		Policy:         policy,
+		Audit:          logger,
+	})
+
+	for _, path := range []string{"/find/cool_db/cool_collection", "/find/secret_db/cool_collection"} {
+		request, err := http.NewRequest("POST", "http://localhost:80"+path, strings.NewReader(`{}`))
+		if err != nil {
+			t.FailNow()
+		}
+		request.Header.Set(auth.APIKeyHeader, "bot-key")
+		ws.Router.ServeHTTP(httptest.NewRecorder(), request)
+	}
+	assert.NoError(t, logger.Close())
+
+	// Only the denial is recorded, as reads are not.
+	var entry map[string]interface{}
+	assert.NoError(t, json.Unmarshal(buffer.Bytes(), &entry))
+	assert.Equal(t, "twitter-bot", entry["principal"])
+	assert.Equal(t, "apikey", entry["auth_method"])
+	assert.Equal(t, "find", entry["operation"])
+	assert.Equal(t, "secret_db", entry["database"])
+	assert.Equal(t, "cool_collection", entry["collection"])
+	assert.Equal(t, "operation not allowed: find", entry["error"])
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// PrincipalKey is where the authenticated client is kept in the gin context.
+const PrincipalKey = "principal"
+
+// DefaultPublicPaths are the routes that don't need authentication, unless Options.PublicPaths says otherwise.
+var DefaultPublicPaths = []string{"/", "/health", "/health/backends"}
+
+// Authenticate identifies the client with the configured authenticators, and rejects the request if none accepts it.
+// Public paths are always accepted. A path ending with "*" makes all paths starting with it public.
+func (w *Server) Authenticate(c *gin.Context) {
+	// Public paths are still told who the clients that send valid credentials are, like /health/backends.
+	public := w.isPublicPath(c.Request.URL.Path)
+
+	for _, authenticator := range w.options.Authenticators {
+		principal, err := authenticator.Authenticate(c.Request)
+		if errors.Is(err, auth.ErrNoCredentials) {
+			continue
+		}
+		if err != nil && public {
+			break
+		}
+		if err != nil {
+			log.Warn().
+				Err(err).
+				Str("path", c.Request.URL.Path).
+				Str("client", c.ClientIP()).
+				Msg("refused request with invalid credentials")
+			abortUnauthorized(c, err.Error())
+			return
+		}
+
+		log.Debug().
+			Str("principal", principal.ID).
+			Str("method", principal.Method).
+			Str("path", c.Request.URL.Path).
+			Msg("authenticated request")
+		c.Set(PrincipalKey, principal)
+		c.Next()
+		return
+	}
+
+	if public {
+		c.Next()
+		return
+	}
+	log.Warn().
+		Str("path", c.Request.URL.Path).
+		Str("client", c.ClientIP()).
+		Msg("refused request without credentials")
+	abortUnauthorized(c, "authentication required")
+}
+
+// GetPrincipal returns the authenticated client of the request, or nil if it was not authenticated.
+func GetPrincipal(c *gin.Context) *auth.Principal {
+	value, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil
+	}
+	principal, _ := value.(*auth.Principal)
+	return principal
+}
+
+// RequireScope aborts the request unless the authenticated client was granted scope.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal := GetPrincipal(c)
+		if principal == nil || !principal.HasScope(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errors": "missing scope: " + scope})
+			return
+		}
+		c.Next()
+	}
+}
+
+func (w *Server) isPublicPath(path string) bool {
+	publicPaths := w.options.PublicPaths
+	if publicPaths == nil {
+		publicPaths = DefaultPublicPaths
+	}
+
+	for _, public := range publicPaths {
+		if strings.HasSuffix(public, "*") {
+			if strings.HasPrefix(path, strings.TrimSuffix(public, "*")) {
+				return true
+			}
+		} else if path == public {
+			return true
+		}
+	}
+	return false
+}
+
+func abortUnauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="mongodb-proxy-ms"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errors": message})
+}
@@ -0,0 +1,66 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type AuthTestCase struct {
+	testCaseID      string
+	path            string
+	apiKey          string
+	publicPaths     []string
+	expectedCode    int
+	expectedMessage string
+}
+
+func TestAuthenticate(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key")}})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []AuthTestCase{
+		{testCaseID: "healthUp", path: "/health", expectedCode: http.StatusOK, expectedMessage: `{"databases":["a","b","c"]}`},
+		{testCaseID: "healthUp", path: "/", expectedCode: http.StatusOK, expectedMessage: `{"hello":"World"}`},
+		{testCaseID: "findOK", path: "/find/cool_db/cool_collection", expectedCode: http.StatusUnauthorized, expectedMessage: `{"errors":"authentication required"}`},
+		{testCaseID: "findOK", path: "/find/cool_db/cool_collection", apiKey: "guess", expectedCode: http.StatusUnauthorized, expectedMessage: `{"errors":"invalid credentials"}`},
+		{testCaseID: "findOK", path: "/find/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+		{testCaseID: "healthUp", path: "/health", publicPaths: []string{"/"}, expectedCode: http.StatusUnauthorized, expectedMessage: `{"errors":"authentication required"}`},
+		{testCaseID: "findOK", path: "/find/cool_db/cool_collection", publicPaths: []string{"/find/*"}, expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			method := "GET"
+			if tc.path != "/" && tc.path != "/health" {
+				method = "POST"
+			}
+			request, err := http.NewRequest(method, "http://localhost:80"+tc.path, strings.NewReader(`{"id":1}`))
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.apiKey) > 0 {
+				request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{
+				Authenticators: []auth.Authenticator{store},
+				PublicPaths:    tc.publicPaths,
+			})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// Authorize aborts the request unless the policy allows the client to perform op on the database and
+// collection (or GridFS bucket) in the URI. Without a policy, every request is allowed.
+func (w *Server) Authorize(op auth.Operation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		database, collection := getNamespace(c)
+		if !w.allows(c, op, database, collection) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errors": "operation not allowed: " + string(op)})
+			return
+		}
+		c.Next()
+	}
+}
+
+// allows tells if the policy lets the client of the request perform op on the real database and collection,
+// and logs the decision. Denials are also recorded in the audit trail, if any.
+func (w *Server) allows(c *gin.Context, op auth.Operation, database, collection string) bool {
+	policy := w.options.Policy
+	if policy == nil {
+		return true
+	}
+
+	principal := GetPrincipal(c)
+	if principal == nil {
+		principal = auth.Anonymous
+	}
+
+	allowed := policy.Allows(principal, op, database, collection)
+	event := log.Info()
+	if !allowed {
+		event = log.Warn()
+	}
+	event.
+		Str("audit", "authorization").
+		Str("principal", principal.ID).
+		Str("method", principal.Method).
+		Str("operation", string(op)).
+		Str("database", database).
+		Str("collection", collection).
+		Str("path", c.Request.URL.Path).
+		Str("client", c.ClientIP()).
+		Bool("allowed", allowed).
+		Msg("authorization decision")
+
+	if !allowed && w.options.Audit != nil {
+		w.options.Audit.Record(audit.Entry{
+			Principal:  principal.ID,
+			AuthMethod: principal.Method,
+			Client:     c.ClientIP(),
+			Backend:    c.GetString(backendNameKey),
+			Operation:  string(op),
+			Database:   database,
+			Collection: collection,
+			Error:      "operation not allowed: " + string(op),
+		})
+	}
+	return allowed
+}
+
+// getNamespace returns the database and collection (or GridFS bucket) in the URI of the request.
+func getNamespace(c *gin.Context) (string, string) {
+	collection := c.Param("Collection")
+	if len(collection) == 0 {
+		collection = c.Param("Bucket")
+	}
+	return c.Param("Database"), collection
+}
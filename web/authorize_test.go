@@ -0,0 +1,80 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type AuthorizeTestCase struct {
+	testCaseID      string
+	method          string
+	path            string
+	apiKey          string
+	body            string
+	expectedCode    int
+	expectedMessage string
+}
+
+func TestAuthorize(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key"), Roles: []string{"bot"}},
+		{ID: "admin-ui", Hash: auth.HashAPIKey("ui-key")},
+		{ID: "ops", Hash: auth.HashAPIKey("ops-key"), Roles: []string{"ops"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Roles: []string{"bot"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"cool_db"}, Collections: []string{"cool_*"}},
+		{Principals: []string{"admin-ui"}, Operations: []auth.Operation{"*"}, Databases: []string{"*"}},
+		{Roles: []string{"ops"}, Operations: []auth.Operation{auth.OpAdmin}, Databases: []string{"cool_db"}, Collections: []string{"cool_*"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []AuthorizeTestCase{
+		{testCaseID: "findOK", method: "POST", path: "/find/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+		{testCaseID: "findOK", method: "POST", path: "/find/cool_db/secret_collection", apiKey: "bot-key", expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"operation not allowed: find"}`},
+		{testCaseID: "findOK", method: "POST", path: "/update/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"operation not allowed: update"}`},
+		{testCaseID: "filesOK", method: "GET", path: "/files/cool_db/cool_files", apiKey: "bot-key", expectedCode: http.StatusOK},
+		{testCaseID: "adminListOK", method: "GET", path: "/admin/collections/cool_db", apiKey: "bot-key", expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"operation not allowed: admin"}`},
+		{testCaseID: "adminListOK", method: "GET", path: "/admin/collections/cool_db", apiKey: "ui-key", expectedCode: http.StatusOK},
+		{testCaseID: "adminRenameOK", method: "POST", path: "/admin/rename/cool_db/cool_collection", apiKey: "ops-key", body: `{"to":"cool_archive"}`, expectedCode: http.StatusOK},
+		{testCaseID: "adminRenameOK", method: "POST", path: "/admin/rename/cool_db/cool_collection", apiKey: "ops-key", body: `{"to":"secret_collection","drop_target":true}`, expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"operation not allowed: admin"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			body := tc.body
+			if len(body) == 0 {
+				body = `{"id":1}`
+			}
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(body))
+			if err != nil {
+				t.FailNow()
+			}
+			request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{
+				Authenticators: []auth.Authenticator{store},
+				Policy:         policy,
+			})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			if len(tc.expectedMessage) > 0 {
+				assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+)
+
+// BackendHeader chooses the MongoDB deployment of a request, as an alternative to the /backends/:Backend prefix.
+const BackendHeader = "X-Backend"
+
+const (
+	// backendKey is where the Proxy of the chosen backend is kept in the gin context.
+	backendKey = "backend"
+	// backendNameKey is where the name of the chosen backend is kept in the gin context.
+	backendNameKey = "backendName"
+)
+
+// SelectBackend chooses the backend named in the path prefix or, without it, in the X-Backend header.
+// Requests that don't choose one use the default backend. Unknown backends get 404 Not Found.
+func (w *Server) SelectBackend(c *gin.Context) {
+	name := c.Param("Backend")
+	if len(name) == 0 {
+		name = strings.TrimSpace(c.GetHeader(BackendHeader))
+	}
+	if len(name) == 0 {
+		c.Next()
+		return
+	}
+
+	var proxy db.Proxy
+	found := false
+	if w.options.Backends != nil {
+		proxy, found = w.options.Backends.Get(name)
+	}
+	if !found {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"errors": "backend not found"})
+		return
+	}
+
+	c.Set(backendKey, proxy)
+	c.Set(backendNameKey, name)
+	c.Next()
+}
+
+// backendFor returns the Proxy of the backend chosen by the request, or the default one.
+func (w *Server) backendFor(c *gin.Context) db.Proxy {
+	if value, ok := c.Get(backendKey); ok {
+		return value.(db.Proxy)
+	}
+	return w.mongo
+}
+
+// BackendsHealth returns the status of every backend. It answers 200 OK only if all of them are up.
+// Only authenticated clients see the databases of each backend, and why it is down.
+func (w *Server) BackendsHealth(c *gin.Context) {
+	result := w.options.Backends.HealthCheck()
+	anonymous := GetPrincipal(c) == nil
+
+	status := http.StatusOK
+	for name, health := range result.Backends {
+		if health.Status != db.BackendUp {
+			status = http.StatusServiceUnavailable
+		}
+		switch {
+		case anonymous:
+			health = db.BackendHealth{Status: health.Status}
+		case w.options.Namespaces != nil && len(health.Databases) > 0:
+			health.Databases = w.options.Namespaces.PublicDatabases(health.Databases)
+		}
+		result.Backends[name] = health
+	}
+	c.JSON(status, result)
+}
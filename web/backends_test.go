@@ -0,0 +1,116 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelectBackend(t *testing.T) {
+	backends, err := db.NewBackends("", map[string]db.Proxy{
+		"analytics": &mock.DBProxy{TestCaseID: "findNothingFound"},
+		"archive":   &mock.DBProxy{TestCaseID: "healthDown"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		name            string
+		method          string
+		path            string
+		backend         string
+		expectedCode    int
+		expectedMessage string
+	}{
+		{name: "default", method: "POST", path: "/find/okr/quotes", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+		{name: "path prefix", method: "POST", path: "/backends/analytics/find/okr/quotes", expectedCode: http.StatusOK, expectedMessage: `{}`},
+		{name: "header", method: "POST", path: "/find/okr/quotes", backend: "analytics", expectedCode: http.StatusOK, expectedMessage: `{}`},
+		{name: "path over header", method: "POST", path: "/backends/analytics/find/okr/quotes", backend: "staging", expectedCode: http.StatusOK, expectedMessage: `{}`},
+		{name: "unknown prefix", method: "POST", path: "/backends/staging/find/okr/quotes", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"backend not found"}`},
+		{name: "unknown header", method: "POST", path: "/find/okr/quotes", backend: "staging", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"backend not found"}`},
+		{name: "backend health", method: "GET", path: "/backends/archive/health", expectedCode: http.StatusInternalServerError, expectedMessage: `{"databases":null}`},
+		{
+			name: "all backends health", method: "GET", path: "/health/backends", expectedCode: http.StatusServiceUnavailable,
+			expectedMessage: `{"backends":{"analytics":{"status":"down"},"archive":{"status":"down"}}}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(`{}`))
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.backend) > 0 {
+				request.Header.Set(web.BackendHeader, tc.backend)
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "findOK"}, web.Options{Backends: backends})
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+
+	recorder := httptest.NewRecorder()
+	request, _ := http.NewRequest("POST", "http://localhost:80/find/okr/quotes", strings.NewReader(`{}`))
+	request.Header.Set(web.BackendHeader, "analytics")
+	web.NewWithCustomDB(&mock.DBProxy{TestCaseID: "findOK"}).Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusNotFound, recorder.Code, "backends must be configured to be chosen")
+}
+
+func TestBackendsHealthDetails(t *testing.T) {
+	backends, err := db.NewBackends("", map[string]db.Proxy{
+		"analytics": &mock.DBProxy{TestCaseID: "healthUp"},
+		"archive":   &mock.DBProxy{TestCaseID: "healthDown"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{{ID: "ops", Hash: auth.HashAPIKey("ops-key")}})
+	if err != nil {
+		t.FailNow()
+	}
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "healthUp"}, web.Options{
+		Backends:       backends,
+		Authenticators: []auth.Authenticator{store},
+	})
+
+	testCases := []struct {
+		name            string
+		apiKey          string
+		expectedMessage string
+	}{
+		{"anonymous", "", `{"backends":{"analytics":{"status":"up"},"archive":{"status":"down"}}}`},
+		{"invalid key", "wrong", `{"backends":{"analytics":{"status":"up"},"archive":{"status":"down"}}}`},
+		{"authenticated", "ops-key", `{"backends":{"analytics":{"status":"up","databases":["a","b","c"]},"archive":{"status":"down","errors":"healthdown"}}}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("GET", "http://localhost:80/health/backends", nil)
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.apiKey) > 0 {
+				request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+
+			recorder := httptest.NewRecorder()
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusServiceUnavailable, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+)
+
+// cacheReads wraps the main connection and the backends with opts.Cache. It runs before guard, so the
+// results are cached before the retries; the default backend shares the cache scope of the main connection.
+func (w *Server) cacheReads() {
+	if w.options.Cache == nil {
+		return
+	}
+
+	name := w.mainBackendName()
+	main := cache.NewProxy(w.mongo, w.options.Cache, name)
+	w.mongo = main
+
+	if w.options.Backends != nil {
+		w.options.Backends = w.options.Backends.Wrap(func(backend string, proxy db.Proxy) db.Proxy {
+			if backend == name {
+				return main
+			}
+			return cache.NewProxy(proxy, w.options.Cache, backend)
+		})
+	}
+}
+
+// CacheMetrics returns the hits, misses, evictions and size of the cache.
+func (w *Server) CacheMetrics(c *gin.Context) {
+	c.JSON(http.StatusOK, w.options.Cache.Stats())
+}
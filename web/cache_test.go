@@ -0,0 +1,67 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	readCache, err := cache.New(cache.Config{})
+	if err != nil {
+		t.FailNow()
+	}
+	mongo := &mock.DBProxy{}
+	ws := web.NewWithOptions(mongo, web.Options{Cache: readCache, Resilience: &resilience.Config{}})
+
+	testCases := []struct {
+		name         string
+		path         string
+		body         string
+		testCaseID   string
+		expectedBody string
+	}{
+		{"first find", "/find/cool_db/cool_collection", `{"author":"Seneca"}`, "findOK", `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+		// The database would return nothing now, but the result is cached.
+		{"cached find", "/find/cool_db/cool_collection", `{"author":"Seneca"}`, "findNothingFound", `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`},
+		{"other filter", "/find/cool_db/cool_collection", `{"author":"Cicero"}`, "findNothingFound", `{}`},
+		{"insert invalidates", "/insert/cool_db/cool_collection", `{"author":"Seneca"}`, "insertOK", `{"InsertedID":"5f4d641403490cb668ed8313"}`},
+		{"find after insert", "/find/cool_db/cool_collection", `{"author":"Seneca"}`, "findNothingFound", `{}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			recorder := httptest.NewRecorder()
+			mongo.TestCaseID = tc.testCaseID
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedBody, recorder.Body.String())
+		})
+	}
+
+	request, err := http.NewRequest("GET", "http://localhost:80/metrics/cache", nil)
+	if err != nil {
+		t.FailNow()
+	}
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+
+	var stats cache.Stats
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &stats))
+	assert.Equal(t, int64(1), stats.Hits)
+	assert.Equal(t, int64(1), stats.Invalidations)
+}
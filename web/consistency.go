@@ -0,0 +1,185 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// ReadPreferenceHeader chooses the servers to read from: primary, primaryPreferred, secondary,
+	// secondaryPreferred or nearest.
+	ReadPreferenceHeader = "X-Read-Preference"
+	// ReadPreferenceTagsHeader is a tag set, like "dc:east,rack:1". Repeat the header for more tag sets,
+	// in order of preference; an empty value matches any server.
+	ReadPreferenceTagsHeader = "X-Read-Preference-Tags"
+	// MaxStalenessHeader is how many seconds behind the primary a secondary may be.
+	MaxStalenessHeader = "X-Max-Staleness-Seconds"
+	// ReadConcernHeader is local, available, majority, linearizable or snapshot.
+	ReadConcernHeader = "X-Read-Concern"
+	// WriteConcernHeader is the "w" of the write concern: a number of servers, majority or a tag set name.
+	WriteConcernHeader = "X-Write-Concern"
+	// WriteConcernJournalHeader, if true, waits for the writes to reach the journal.
+	WriteConcernJournalHeader = "X-Write-Concern-Journal"
+	// WriteConcernTimeoutHeader is how many milliseconds to wait for the write concern.
+	WriteConcernTimeoutHeader = "X-Write-Concern-Timeout-MS"
+)
+
+const (
+	// requestedConsistencyKey is where the consistency requested in the headers is kept in the gin context.
+	requestedConsistencyKey = "requestedConsistency"
+	// consistencyKey is where the consistency of the operation, after the policy, is kept in the gin context.
+	consistencyKey = "consistency"
+)
+
+// Consistency reads the read preference, read concern and write concern requested in the headers, and applies
+// the defaults and limits of the consistency policy of the namespace. Invalid settings get 400 Bad Request,
+// and settings the policy doesn't allow get 403 Forbidden.
+func (w *Server) Consistency(c *gin.Context) {
+	requested, err := parseConsistencyHeaders(c.Request.Header)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+	c.Set(requestedConsistencyKey, requested)
+
+	if !w.resolveConsistency(c, requested) {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// ConsistencyOption reads the "consistency" option of the routes whose bodies have options, like /update
+// and /search, and merges it over the settings of the headers (see db.Consistency.Merge), so the body wins.
+// It is checked like the headers.
+func (w *Server) ConsistencyOption(c *gin.Context) {
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error reading request body")
+		c.AbortWithStatusJSON(http.StatusBadRequest, "")
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	// Bodies that are not JSON objects are left for the handler to refuse.
+	var options map[string]json.RawMessage
+	if json.Unmarshal(body, &options) != nil || options["consistency"] == nil {
+		c.Next()
+		return
+	}
+	var override db.Consistency
+	err = json.Unmarshal(options["consistency"], &override)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errors": "invalid consistency: " + err.Error()})
+		return
+	}
+
+	requested := db.Consistency{}
+	if value, ok := c.Get(requestedConsistencyKey); ok {
+		requested = value.(db.Consistency)
+	}
+	if !w.resolveConsistency(c, requested.Merge(override)) {
+		c.Abort()
+		return
+	}
+	c.Next()
+}
+
+// resolveConsistency keeps the consistency of the request, after the policy. If it is refused,
+// it writes the response and returns false.
+func (w *Server) resolveConsistency(c *gin.Context, requested db.Consistency) bool {
+	database, collection := getNamespace(c)
+	consistency, err := w.options.Consistency.Resolve(database, collection, requested)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, db.ErrConsistencyNotAllowed) {
+			status = http.StatusForbidden
+			log.Warn().
+				Err(err).
+				Str("path", c.Request.URL.Path).
+				Str("client", c.ClientIP()).
+				Msg("refused request with a consistency that is not allowed")
+		}
+		c.JSON(status, gin.H{"errors": err.Error()})
+		return false
+	}
+	c.Set(consistencyKey, consistency)
+	return true
+}
+
+// getConsistency returns the consistency of the request, if any.
+func getConsistency(c *gin.Context) db.Consistency {
+	if value, ok := c.Get(consistencyKey); ok {
+		return value.(db.Consistency)
+	}
+	return db.Consistency{}
+}
+
+func parseConsistencyHeaders(header http.Header) (db.Consistency, error) {
+	consistency := db.Consistency{
+		ReadPreference: strings.TrimSpace(header.Get(ReadPreferenceHeader)),
+		ReadConcern:    strings.TrimSpace(header.Get(ReadConcernHeader)),
+	}
+
+	for _, value := range header.Values(ReadPreferenceTagsHeader) {
+		tags := map[string]string{}
+		for _, pair := range strings.Split(value, ",") {
+			if len(strings.TrimSpace(pair)) == 0 {
+				continue
+			}
+			parts := strings.SplitN(pair, ":", 2)
+			if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+				return db.Consistency{}, fmt.Errorf("invalid %s: %q", ReadPreferenceTagsHeader, value)
+			}
+			tags[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		consistency.ReadPreferenceTags = append(consistency.ReadPreferenceTags, tags)
+	}
+
+	var err error
+	consistency.MaxStalenessSeconds, err = parseIntHeader(header, MaxStalenessHeader)
+	if err != nil {
+		return db.Consistency{}, err
+	}
+
+	writeConcern := db.WriteConcern{W: db.Acknowledgement(strings.TrimSpace(header.Get(WriteConcernHeader)))}
+	if value := header.Get(WriteConcernJournalHeader); len(value) > 0 {
+		journal, err := strconv.ParseBool(value)
+		if err != nil {
+			return db.Consistency{}, fmt.Errorf("invalid %s: %q", WriteConcernJournalHeader, value)
+		}
+		writeConcern.J = &journal
+	}
+	writeConcern.WTimeoutMS, err = parseIntHeader(header, WriteConcernTimeoutHeader)
+	if err != nil {
+		return db.Consistency{}, err
+	}
+	if writeConcern != (db.WriteConcern{}) {
+		consistency.WriteConcern = &writeConcern
+	}
+	return consistency, nil
+}
+
+func parseIntHeader(header http.Header, name string) (int64, error) {
+	value := header.Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %q", name, value)
+	}
+	return number, nil
+}
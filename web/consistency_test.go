@@ -0,0 +1,158 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConsistency(t *testing.T) {
+	policy, err := db.NewConsistencyPolicy(db.ConsistencyPolicy{
+		Default: db.Consistency{ReadConcern: "local"},
+		Rules: []db.ConsistencyRule{
+			{
+				Databases:       []string{"billing"},
+				Defaults:        db.Consistency{WriteConcern: &db.WriteConcern{W: "majority"}},
+				ReadPreferences: []string{"primary"},
+				WriteConcerns:   []string{"majority"},
+			},
+		},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		name            string
+		testCaseID      string
+		path            string
+		headers         map[string][]string
+		body            string
+		expectedCode    int
+		expectedMessage string
+	}{
+		{
+			name:            "defaults",
+			testCaseID:      "findConsistency",
+			path:            "/find/okr/quotes",
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"consistency":{"read_concern":"local"}}]}`,
+		},
+		{
+			name:       "headers",
+			testCaseID: "findConsistency",
+			path:       "/find/okr/quotes",
+			headers: map[string][]string{
+				web.ReadPreferenceHeader:     {"nearest"},
+				web.ReadPreferenceTagsHeader: {"dc:east, rack:1", ""},
+				web.MaxStalenessHeader:       {"120"},
+				web.ReadConcernHeader:        {"majority"},
+			},
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"consistency":{"read_preference":"nearest","read_preference_tags":[{"dc":"east","rack":"1"},{}],"max_staleness_seconds":120,"read_concern":"majority"}}]}`,
+		},
+		{
+			name:            "invalid header",
+			testCaseID:      "findConsistency",
+			path:            "/find/okr/quotes",
+			headers:         map[string][]string{web.MaxStalenessHeader: {"soon"}},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"invalid X-Max-Staleness-Seconds: \"soon\""}`,
+		},
+		{
+			name:            "invalid combination",
+			testCaseID:      "findConsistency",
+			path:            "/find/okr/quotes",
+			headers:         map[string][]string{web.ReadPreferenceHeader: {"secondary"}, web.MaxStalenessHeader: {"10"}},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"max staleness must be at least 90 seconds"}`,
+		},
+		{
+			name:            "not allowed in the namespace",
+			testCaseID:      "findConsistency",
+			path:            "/find/billing/invoices",
+			headers:         map[string][]string{web.ReadPreferenceHeader: {"secondary"}},
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: `{"errors":"consistency not allowed: read preference secondary"}`,
+		},
+		{
+			name:            "write concern headers",
+			testCaseID:      "updateConsistency",
+			path:            "/update/okr/quotes",
+			headers:         map[string][]string{web.WriteConcernHeader: {"2"}, web.WriteConcernJournalHeader: {"true"}, web.WriteConcernTimeoutHeader: {"500"}},
+			body:            `{"filter":{},"updates":{"author":"Seneca"}}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":{"MatchedCount":1,"ModifiedCount":1,"UpsertedCount":0,"UpsertedID":{"read_concern":"local","write_concern":{"w":"2","j":true,"wtimeout_ms":500}}}}`,
+		},
+		{
+			name:            "body over headers",
+			testCaseID:      "updateConsistency",
+			path:            "/update/okr/quotes",
+			headers:         map[string][]string{web.WriteConcernHeader: {"2"}, web.WriteConcernTimeoutHeader: {"500"}},
+			body:            `{"filter":{},"updates":{"author":"Seneca"},"consistency":{"write_concern":{"w":"majority"}}}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":{"MatchedCount":1,"ModifiedCount":1,"UpsertedCount":0,"UpsertedID":{"read_concern":"local","write_concern":{"w":"majority","wtimeout_ms":500}}}}`,
+		},
+		{
+			name:            "body not allowed in the namespace",
+			testCaseID:      "updateConsistency",
+			path:            "/update/billing/invoices",
+			body:            `{"filter":{},"updates":{"author":"Seneca"},"consistency":{"write_concern":{"w":1}}}`,
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: `{"errors":"consistency not allowed: write concern w: 1"}`,
+		},
+		{
+			name:            "body of a query",
+			testCaseID:      "findConsistency",
+			path:            "/geo/within/okr/quotes",
+			headers:         map[string][]string{web.ReadConcernHeader: {"majority"}},
+			body:            `{"field":"location","box":[[0,0],[1,1]],"consistency":{"read_preference":"nearest"}}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"consistency":{"read_preference":"nearest","read_concern":"majority"}}]}`,
+		},
+		{
+			name:            "invalid body",
+			testCaseID:      "findConsistency",
+			path:            "/geo/within/okr/quotes",
+			body:            `{"field":"location","box":[[0,0],[1,1]],"consistency":"majority"}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"invalid consistency: json: cannot unmarshal string into Go value of type db.Consistency"}`,
+		},
+		{
+			name:            "write concern not allowed in the namespace",
+			testCaseID:      "updateConsistency",
+			path:            "/update/billing/invoices",
+			headers:         map[string][]string{web.WriteConcernHeader: {"1"}},
+			body:            `{"filter":{},"updates":{"author":"Seneca"}}`,
+			expectedCode:    http.StatusForbidden,
+			expectedMessage: `{"errors":"consistency not allowed: write concern w: 1"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			for name, values := range tc.headers {
+				for _, value := range values {
+					request.Header.Add(name, value)
+				}
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{Consistency: policy})
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
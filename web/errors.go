@@ -0,0 +1,38 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/rs/zerolog/log"
+)
+
+// respondError answers the requests whose call to the database failed because of the request itself, like
+// filters by protected fields, or because the circuit breaker of the database opened, and tells if it did.
+// Other errors are left to the handler.
+func respondError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, resilience.ErrCircuitOpen):
+		// The breaker may open while the request runs, or refuse it while another request probes MongoDB,
+		// so the check before the request is not enough.
+		var open *resilience.OpenError
+		if errors.As(err, &open) {
+			c.Header("Retry-After", seconds(open.RetryAfter))
+		}
+		log.Warn().
+			Str("path", c.Request.URL.Path).
+			Str("client", c.ClientIP()).
+			Msg("refused request while the database is unavailable")
+		c.JSON(http.StatusServiceUnavailable, gin.H{"errors": "database unavailable"})
+	case errors.Is(err, db.ErrProtectedField):
+		c.JSON(http.StatusForbidden, gin.H{"errors": err.Error()})
+	case errors.Is(err, db.ErrEncryptedField):
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,107 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// explainedOperations are the operations of the policy that clients must be allowed to explain, since
+// plans with executionStats run them: an aggregation may even read other collections with $lookup.
+var explainedOperations = map[string]auth.Operation{
+	"find":      auth.OpFind,
+	"count":     auth.OpFind,
+	"aggregate": auth.OpAggregate,
+	"update":    auth.OpUpdate,
+}
+
+// Explain returns the execution plan of a find, aggregate, count or update, to check if indexes are used.
+func (w *Server) Explain(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error reading request body")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	// Filters and pipelines may use Extended JSON, just like in Find.
+	var request db.ExplainRequest
+	err = bson.UnmarshalExtJSON(body, false, &request)
+	if err == nil {
+		err = request.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	op := explainedOperations[request.Operation]
+	if !w.allows(c, op, databaseDetails.Database, databaseDetails.Collection) {
+		c.JSON(http.StatusForbidden, gin.H{"errors": "operation not allowed: " + string(op)})
+		return
+	}
+
+	fields := []struct {
+		name  string
+		value *interface{}
+	}{
+		{"filter", &request.Filter},
+		{"sort", &request.Sort},
+		{"projection", &request.Projection},
+		{"pipeline", &request.Pipeline},
+		{"update", &request.Update},
+	}
+	for _, field := range fields {
+		var ok bool
+		*field.value, ok = w.sanitize(c, databaseDetails.Database, field.name, *field.value)
+		if !ok {
+			return
+		}
+	}
+
+	result, err := w.proxyFor(c).Explain(databaseDetails.Database, databaseDetails.Collection, request)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error while explaining operation")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	// Plans have BSON types (timestamps, 64-bit integers...) that are better represented in Extended JSON.
+	plan, err := bson.MarshalExtJSON(result.Plan, false, false)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error encoding execution plan")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"operation": result.Operation,
+		"verbosity": result.Verbosity,
+		"plan":      json.RawMessage(plan),
+	})
+}
@@ -0,0 +1,110 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExplain(t *testing.T) {
+	testCases := []AdminTestCase{
+		{
+			testCaseID:      "explainOK",
+			body:            `{"operation":"find","filter":{"author":"Seneca"}}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"operation":"find","plan":{"queryPlanner":{"namespace":"cool_db.quotes","indexFilterSet":false,"winningPlan":{"stage":"FETCH","inputStage":{"stage":"IXSCAN","indexName":"author_1"}}}},"verbosity":"queryPlanner"}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			body:            `{"operation":"update","filter":{"_id":{"$oid":"5f4d641403490cb668ed8313"}},"update":{"$inc":{"publications":1}},"verbosity":"executionStats"}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"operation":"update","plan":{"queryPlanner":{"namespace":"cool_db.quotes","indexFilterSet":false,"winningPlan":{"stage":"FETCH","inputStage":{"stage":"IXSCAN","indexName":"author_1"}}}},"verbosity":"executionStats"}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			body:            `{"operation":"aggregate"}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"aggregate requires a pipeline"}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			body:            `{"operation":"insert"}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"operation cannot be explained: \"insert\""}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			body:            `{"operation":"count","verbosity":"everything"}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"unknown verbosity: everything"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80/explain/cool_db/quotes", strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: tc.testCaseID})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
+
+func TestExplainAuthorization(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "reader", Hash: auth.HashAPIKey("reader-key"), Roles: []string{"reader"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Roles: []string{"reader"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"cool_db"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "explainOK"}, web.Options{
+		Authenticators: []auth.Authenticator{store},
+		Policy:         policy,
+	})
+
+	testCases := []struct {
+		body            string
+		expectedCode    int
+		expectedMessage string
+	}{
+		{`{"operation":"count","filter":{"author":"Seneca"}}`, http.StatusOK, ""},
+		{`{"operation":"aggregate","pipeline":[{"$lookup":{"from":"secrets","localField":"a","foreignField":"b","as":"c"}}],"verbosity":"executionStats"}`, http.StatusForbidden, `{"errors":"operation not allowed: aggregate"}`},
+		{`{"operation":"update","filter":{},"update":{"$set":{"author":"Seneca"}}}`, http.StatusForbidden, `{"errors":"operation not allowed: update"}`},
+	}
+
+	for _, tc := range testCases {
+		request, err := http.NewRequest("POST", "http://localhost:80/explain/cool_db/quotes", strings.NewReader(tc.body))
+		if err != nil {
+			t.FailNow()
+		}
+		request.Header.Set(auth.APIKeyHeader, "reader-key")
+
+		recorder := httptest.NewRecorder()
+		ws.Router.ServeHTTP(recorder, request)
+
+		assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code for %s", tc.body)
+		if len(tc.expectedMessage) > 0 {
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFieldPolicy(t *testing.T) {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "twitter-bot", Hash: auth.HashAPIKey("bot-key")},
+		{ID: "admin-ui", Hash: auth.HashAPIKey("ui-key"), Roles: []string{"editor"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	fields, err := auth.NewFieldPolicy([]auth.FieldRule{
+		{Databases: []string{"cool_db"}, Fields: []string{"pi", "author"}, AllowRoles: []string{"editor"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []struct {
+		AuthorizeTestCase
+		body string
+	}{
+		{AuthorizeTestCase{testCaseID: "findOK", method: "POST", path: "/find/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world"}]}`}, `{"hello":"world"}`},
+		{AuthorizeTestCase{testCaseID: "findOK", method: "POST", path: "/find/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"field is protected: pi"}`}, `{"pi":{"$gt":3}}`},
+		{AuthorizeTestCase{testCaseID: "findOK", method: "POST", path: "/find/cool_db/cool_collection", apiKey: "ui-key", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`}, `{"pi":{"$gt":3}}`},
+		{AuthorizeTestCase{testCaseID: "findOK", method: "POST", path: "/find/other_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`}, `{}`},
+		{AuthorizeTestCase{testCaseID: "insertOK", method: "POST", path: "/insert/cool_db/cool_collection", apiKey: "bot-key", expectedCode: http.StatusForbidden, expectedMessage: `{"errors":"field is protected: author"}`}, `{"author":"Seneca"}`},
+		{AuthorizeTestCase{testCaseID: "insertOK", method: "POST", path: "/insert/cool_db/cool_collection", apiKey: "ui-key", expectedCode: http.StatusOK}, `{"author":"Seneca"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{
+				Authenticators: []auth.Authenticator{store},
+				Fields:         fields,
+			})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			if len(tc.expectedMessage) > 0 {
+				assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+			}
+		})
+	}
+}
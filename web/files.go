@@ -0,0 +1,332 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// DefaultMaxUploadBytes is the biggest file accepted by Upload, unless Options.MaxUploadBytes says otherwise.
+const DefaultMaxUploadBytes = 16 << 20
+
+// errRangeNotSatisfiable is returned by parseRange when the range is outside the file.
+var errRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// inlineContentTypes are shown by browsers when downloaded. The content type comes from the client that
+// uploaded the file, so other types, like HTML or SVG, are saved instead: shown on the origin of the proxy,
+// their scripts could act for whoever opens them.
+var inlineContentTypes = map[string]bool{
+	"text/plain":      true,
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"audio/mpeg":      true,
+	"audio/ogg":       true,
+	"video/mp4":       true,
+	"video/webm":      true,
+	"application/pdf": true,
+}
+
+// BucketURI holds the database and GridFS bucket passed in URI.
+type BucketURI struct {
+	Database string `json:"Database" uri:"Database" binding:"required"`
+	Bucket   string `json:"Bucket" uri:"Bucket" binding:"required"`
+}
+
+// FileURI holds the database, GridFS bucket and file ID passed in URI.
+type FileURI struct {
+	Database string `json:"Database" uri:"Database" binding:"required"`
+	Bucket   string `json:"Bucket" uri:"Bucket" binding:"required"`
+	ID       string `json:"ID" uri:"ID" binding:"required"`
+}
+
+// Upload stores the file sent in the multipart form field "file" in GridFS.
+// The optional form field "metadata" may have a JSON object to be saved with the file.
+func (w *Server) Upload(c *gin.Context) {
+	var bucketDetails BucketURI
+	err := c.ShouldBindUri(&bucketDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	maxUploadBytes := w.options.MaxUploadBytes
+	if maxUploadBytes <= 0 {
+		maxUploadBytes = DefaultMaxUploadBytes
+	}
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxUploadBytes)
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	var metadata bson.M
+	if raw := c.PostForm("metadata"); len(raw) > 0 {
+		err = json.Unmarshal([]byte(raw), &metadata)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": fmt.Sprintf("invalid metadata: %v", err)})
+			return
+		}
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+	defer file.Close()
+
+	content := bufio.NewReader(file)
+	contentType := getContentType(header.Header.Get("Content-Type"), header.Filename, content)
+
+	result, err := w.proxyFor(c).UploadFile(bucketDetails.Database, bucketDetails.Bucket, header.Filename, contentType, metadata, content)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error uploading file into database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// Download streams a file stored in GridFS. A single byte range may be requested with the Range header.
+func (w *Server) Download(c *gin.Context) {
+	var fileDetails FileURI
+	err := c.ShouldBindUri(&fileDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	download, err := w.proxyFor(c).DownloadFile(fileDetails.Database, fileDetails.Bucket, fileDetails.ID)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		if errors.Is(err, db.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": err.Error()})
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error downloading file from database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+	defer download.Close()
+
+	info := download.Info
+	contentType := info.ContentType
+	if len(contentType) == 0 {
+		contentType = "application/octet-stream"
+	}
+
+	c.Header("Accept-Ranges", "bytes")
+	c.Header("Content-Type", contentType)
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Content-Disposition", mime.FormatMediaType(getDisposition(contentType), map[string]string{"filename": info.Filename}))
+
+	status := http.StatusOK
+	start, length := int64(0), info.Length
+	if rangeHeader := c.GetHeader("Range"); len(rangeHeader) > 0 {
+		start, length, err = parseRange(rangeHeader, info.Length)
+		switch {
+		case errors.Is(err, errRangeNotSatisfiable):
+			c.Header("Content-Range", fmt.Sprintf("bytes */%d", info.Length))
+			c.JSON(http.StatusRequestedRangeNotSatisfiable, gin.H{"errors": err.Error()})
+			return
+		case err != nil:
+			// Ranges we don't support are ignored, and the whole file is sent instead.
+			start, length = 0, info.Length
+		default:
+			status = http.StatusPartialContent
+			c.Header("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, info.Length))
+		}
+	}
+
+	if start > 0 {
+		err = download.Skip(start)
+		if err != nil {
+			log.Error().
+				Err(err).
+				Str("id", info.ID).
+				Msg("failed to skip to the requested range")
+			c.JSON(http.StatusInternalServerError, "")
+			return
+		}
+	}
+
+	c.Header("Content-Length", strconv.FormatInt(length, 10))
+	c.Status(status)
+	if c.Request.Method == http.MethodHead {
+		return
+	}
+
+	_, err = io.CopyN(c.Writer, download.Content, length)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("id", info.ID).
+			Msg("failed to stream file")
+	}
+}
+
+// ListFiles returns the details of the files stored in a GridFS bucket.
+func (w *Server) ListFiles(c *gin.Context) {
+	var bucketDetails BucketURI
+	err := c.ShouldBindUri(&bucketDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).ListFiles(bucketDetails.Database, bucketDetails.Bucket)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error listing files in database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// DeleteFile removes a file from a GridFS bucket.
+func (w *Server) DeleteFile(c *gin.Context) {
+	var fileDetails FileURI
+	err := c.ShouldBindUri(&fileDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).DeleteFile(fileDetails.Database, fileDetails.Bucket, fileDetails.ID)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		if errors.Is(err, db.ErrFileNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": err.Error()})
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error deleting file from database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// getDisposition tells browsers to show the files of the types in inlineContentTypes, and to save the others.
+func getDisposition(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && inlineContentTypes[mediaType] {
+		return "inline"
+	}
+	return "attachment"
+}
+
+// getContentType returns the content type declared by the client, or guesses it from the file name or content.
+func getContentType(declared, filename string, content *bufio.Reader) string {
+	if len(declared) > 0 && declared != "application/octet-stream" {
+		return declared
+	}
+	if byExtension := mime.TypeByExtension(filepath.Ext(filename)); len(byExtension) > 0 {
+		return byExtension
+	}
+	head, _ := content.Peek(512)
+	return http.DetectContentType(head)
+}
+
+// parseRange parses a Range header with a single range ("bytes=0-99", "bytes=100-" or "bytes=-100"),
+// returning its first byte and length inside a file of size bytes.
+func parseRange(header string, size int64) (int64, int64, error) {
+	spec := strings.TrimSpace(header)
+	if !strings.HasPrefix(spec, "bytes=") {
+		return 0, size, fmt.Errorf("invalid range: %s", header)
+	}
+	spec = strings.TrimPrefix(spec, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, size, fmt.Errorf("multiple ranges are not supported")
+	}
+
+	parts := strings.SplitN(spec, "-", 2)
+	if len(parts) != 2 {
+		return 0, size, fmt.Errorf("invalid range: %s", header)
+	}
+	first, last := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	if len(first) == 0 {
+		// Suffix range: the last N bytes.
+		suffix, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || suffix < 0 {
+			return 0, size, fmt.Errorf("invalid range: %s", header)
+		}
+		if suffix == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if suffix > size {
+			suffix = size
+		}
+		return size - suffix, suffix, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, size, fmt.Errorf("invalid range: %s", header)
+	}
+
+	end := size - 1
+	if len(last) > 0 {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, size, fmt.Errorf("invalid range: %s", header)
+		}
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+	if end >= size {
+		end = size - 1
+	}
+
+	return start, end - start + 1, nil
+}
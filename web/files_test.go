@@ -0,0 +1,145 @@
+package web_test
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+type DownloadTestCase struct {
+	testCaseID           string
+	rangeHeader          string
+	expectedCode         int
+	expectedMessage      string
+	expectedContentRange string
+}
+
+func TestDownload(t *testing.T) {
+	testCases := []DownloadTestCase{
+		{testCaseID: "filesOK", expectedCode: http.StatusOK, expectedMessage: "0123456789"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=2-5", expectedCode: http.StatusPartialContent, expectedMessage: "2345", expectedContentRange: "bytes 2-5/10"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=7-", expectedCode: http.StatusPartialContent, expectedMessage: "789", expectedContentRange: "bytes 7-9/10"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=-4", expectedCode: http.StatusPartialContent, expectedMessage: "6789", expectedContentRange: "bytes 6-9/10"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=8-100", expectedCode: http.StatusPartialContent, expectedMessage: "89", expectedContentRange: "bytes 8-9/10"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=0-1,4-5", expectedCode: http.StatusOK, expectedMessage: "0123456789"},
+		{testCaseID: "filesOK", rangeHeader: "bytes=10-", expectedCode: http.StatusRequestedRangeNotSatisfiable, expectedMessage: `{"errors":"range not satisfiable"}`, expectedContentRange: "bytes */10"},
+		{testCaseID: "filesNotFound", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"file not found"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest("GET", "http://localhost:80/download/cool_db/images/5f4d641403490cb668ed8313", nil)
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.rangeHeader) > 0 {
+				request.Header.Set("Range", tc.rangeHeader)
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: tc.testCaseID})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+			assert.Equal(t, tc.expectedContentRange, recorder.Header().Get("Content-Range"), "unexpected Content-Range")
+			if tc.expectedCode == http.StatusOK || tc.expectedCode == http.StatusPartialContent {
+				assert.Equal(t, "text/plain", recorder.Header().Get("Content-Type"), "unexpected Content-Type")
+			}
+		})
+	}
+}
+
+func TestDownloadDisposition(t *testing.T) {
+	testCases := []struct {
+		contentType string
+		expected    string
+	}{
+		{"text/plain; charset=utf-8", `inline; filename=notes.txt`},
+		{"image/png", `inline; filename=notes.txt`},
+		{"text/html", `attachment; filename=notes.txt`},
+		{"image/svg+xml", `attachment; filename=notes.txt`},
+		{"invalid/", `attachment; filename=notes.txt`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.contentType, func(t *testing.T) {
+			proxy := mock.NewProxy(t)
+			info := db.FileInfo{ID: "5f4d641403490cb668ed8313", Filename: "notes.txt", Length: 4, ContentType: tc.contentType}
+			proxy.On(db.OpDownloadFile).Return(db.NewFileDownload(info, strings.NewReader("note"), nil, nil), nil)
+
+			request, err := http.NewRequest("GET", "http://localhost:80/download/cool_db/files/5f4d641403490cb668ed8313", nil)
+			if err != nil {
+				t.FailNow()
+			}
+			recorder := httptest.NewRecorder()
+			web.NewWithCustomDB(proxy).Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, http.StatusOK, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expected, recorder.Header().Get("Content-Disposition"))
+			assert.Equal(t, "nosniff", recorder.Header().Get("X-Content-Type-Options"))
+		})
+	}
+}
+
+func TestUpload(t *testing.T) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	file, err := form.CreateFormFile("file", "seneca.png")
+	if err != nil {
+		t.FailNow()
+	}
+	file.Write([]byte("not really a png"))
+	form.WriteField("metadata", `{"author":"Seneca"}`)
+	form.Close()
+
+	request, err := http.NewRequest("POST", "http://localhost:80/upload/cool_db/images", body)
+	if err != nil {
+		t.FailNow()
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	recorder := httptest.NewRecorder()
+	ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: "filesOK"})
+
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "unexpected status code")
+	assert.Equal(t,
+		`{"id":"5f4d641403490cb668ed8313","filename":"seneca.png","length":16,"chunk_size":261120,"upload_date":"0001-01-01T00:00:00Z","content_type":"image/png","metadata":{"author":"Seneca"}}`,
+		recorder.Body.String(),
+		"unexpected response")
+}
+
+func TestUploadTooBig(t *testing.T) {
+	body := &bytes.Buffer{}
+	form := multipart.NewWriter(body)
+	file, err := form.CreateFormFile("file", "big.bin")
+	if err != nil {
+		t.FailNow()
+	}
+	file.Write(make([]byte, 2048))
+	form.Close()
+
+	request, err := http.NewRequest("POST", "http://localhost:80/upload/cool_db/images", body)
+	if err != nil {
+		t.FailNow()
+	}
+	request.Header.Set("Content-Type", form.FormDataContentType())
+
+	recorder := httptest.NewRecorder()
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "filesOK"}, web.Options{MaxUploadBytes: 1024})
+
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusBadRequest, recorder.Code, "unexpected status code")
+}
@@ -0,0 +1,129 @@
+package web
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+)
+
+// GeoNear returns the documents closest to a point, nearest first.
+func (w *Server) GeoNear(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	var request db.GeoNearRequest
+	if !bindGeoRequest(c, &databaseDetails, &request) {
+		return
+	}
+
+	filter, err := request.Filter()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).Find(databaseDetails.Database, databaseDetails.Collection, filter)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error while searching near a point in database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GeoWithin returns the documents inside a box, polygon or circle.
+func (w *Server) GeoWithin(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	var request db.GeoWithinRequest
+	if !bindGeoRequest(c, &databaseDetails, &request) {
+		return
+	}
+
+	filter, err := request.Filter()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).Find(databaseDetails.Database, databaseDetails.Collection, filter)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error while searching within an area in database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GeoNearAggregate returns the documents closest to a point, with their distance to it.
+func (w *Server) GeoNearAggregate(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	var request db.GeoNearRequest
+	if !bindGeoRequest(c, &databaseDetails, &request) {
+		return
+	}
+
+	var ok bool
+	request.Query, ok = w.sanitize(c, databaseDetails.Database, "query", request.Query)
+	if !ok {
+		return
+	}
+
+	pipeline, err := request.Pipeline()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).AggregatePipeline(databaseDetails.Database, databaseDetails.Collection, pipeline)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error while aggregating near a point in database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// bindGeoRequest parses the URI and the JSON body of the geospatial routes. If it fails, the response is already sent.
+func bindGeoRequest(c *gin.Context, databaseDetails *DatabaseDetailsURI, request interface{}) bool {
+	err := c.ShouldBindUri(databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return false
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err == nil {
+		err = json.Unmarshal(body, request)
+	}
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error reading request body")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return false
+	}
+	return true
+}
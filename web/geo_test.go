@@ -0,0 +1,69 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeo(t *testing.T) {
+	testCases := []AdminTestCase{
+		{
+			testCaseID:      "geoOK",
+			path:            "/geo/near/cool_db/places",
+			body:            `{"field":"location","point":{"type":"Point","coordinates":[-48.5,-27.6]},"max_distance":1,"unit":"km"}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"location":{"coordinates":[-48.5,-27.6],"type":"Point"},"name":"Lighthouse"}]}`,
+		},
+		{
+			testCaseID:      "geoOK",
+			path:            "/geo/within/cool_db/places",
+			body:            `{"field":"location","center_sphere":{"center":[-48.5,-27.6],"radius":10,"unit":"km"}}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"location":{"coordinates":[-48.5,-27.6],"type":"Point"},"name":"Lighthouse"}]}`,
+		},
+		{
+			testCaseID:      "geoOK",
+			path:            "/geo/geoNear/cool_db/places",
+			body:            `{"field":"location","point":{"type":"Point","coordinates":[-48.5,-27.6]},"unit":"km","limit":1}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"distance":1.2,"name":"Lighthouse"}]}`,
+		},
+		{
+			testCaseID:      "geoOK",
+			path:            "/geo/near/cool_db/places",
+			body:            `{"field":"location","point":{"type":"Point","coordinates":[-200,-27.6]}}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"longitude out of range: -200"}`,
+		},
+		{
+			testCaseID:      "geoOK",
+			path:            "/geo/within/cool_db/places",
+			body:            `not json`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"invalid character 'o' in literal null (expecting 'u')"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: tc.testCaseID})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
@@ -7,15 +7,99 @@ import (
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
 	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/idempotency"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
 	"github.com/rs/zerolog/log"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
 // Server wraps everything related to web server we provide.
 type Server struct {
-	Router *gin.Engine
-	mongo  db.Proxy
+	Router    *gin.Engine
+	mongo     db.Proxy
+	options   Options
+	sanitizer *db.Sanitizer
+}
+
+// Options holds the optional settings of the webserver.
+type Options struct {
+	// AdminKey enables the /admin routes. Requests to them must send the same value in the X-Admin-Key header.
+	// If empty, the /admin routes are only available when Policy is defined.
+	AdminKey string
+
+	// Authenticators identify the clients. If empty, requests are not authenticated at all.
+	Authenticators []auth.Authenticator
+
+	// PublicPaths are accessible without authentication. If nil, DefaultPublicPaths is used.
+	PublicPaths []string
+
+	// Policy decides which operations each client may perform on each database and collection.
+	// If nil, requests are not authorized at all.
+	Policy *auth.Policy
+
+	// Namespaces restricts which databases and collections may be accessed, and maps their public names.
+	// If nil, any namespace the MongoDB user can see is accessible.
+	Namespaces *auth.Namespaces
+
+	// Fields hides some fields of the documents from some clients. If nil, clients see whole documents.
+	Fields *auth.FieldPolicy
+
+	// Connection has the settings of the connection to MongoDB, like a complete URI or TLS. Only used by New.
+	Connection db.ConnectionOptions
+
+	// Encryption encrypts some fields before they are stored in MongoDB. Only used by New.
+	Encryption *db.FieldEncryption
+
+	// Sanitizer blocks operators and stages in filters and pipelines. If nil, the default policy of db.NewSanitizer is used.
+	Sanitizer *db.Sanitizer
+
+	// Audit records the writes and admin operations of every client. If nil, nothing is recorded.
+	Audit *audit.Logger
+
+	// AuditValues records the values written, instead of only the names of the fields.
+	AuditValues bool
+
+	// Consistency sets the defaults and limits of the read preference, read concern and write concern in each
+	// namespace. If nil, requests use the settings of the connection, unless they choose their own.
+	Consistency *db.ConsistencyPolicy
+
+	// Backends are other MongoDB deployments, chosen by the requests with the X-Backend header or the
+	// /backends/:Backend path prefix. If Backends.Default is defined, New uses it instead of connecting to dbHost.
+	Backends *db.Backends
+
+	// Resilience retries the operations that fail for transient reasons, and fails fast with 503 Service
+	// Unavailable while MongoDB is down. Each backend has its own circuit breaker. If nil, failures are not retried.
+	Resilience *resilience.Config
+
+	// Idempotency keeps the responses of the writes sent with the Idempotency-Key header, to replay them when
+	// the clients retry. If nil, the header is ignored.
+	Idempotency idempotency.Store
+
+	// Interceptors run around every call of the handlers to the main connection and the backends, outside
+	// the cache, the retries and the other layers, with the principal in the context of each call (see
+	// auth.FromContext). The first one is the outermost.
+	Interceptors []db.Interceptor
+
+	// Cache keeps the results of finds and aggregations, and forgets those of a collection when the proxy
+	// writes to it. If nil, every read reaches MongoDB.
+	Cache *cache.Cache
+
+	// RateLimiter limits how often each client may send requests, and how many documents it may read
+	// and write per day. If nil, clients are not limited.
+	RateLimiter *ratelimit.Limiter
+
+	// MaxUploadBytes limits the size of the files sent to /upload. If zero, DefaultMaxUploadBytes is used.
+	MaxUploadBytes int64
+
+	// TrustedProxies lists the addresses or CIDR ranges of the reverse proxies in front of the webserver.
+	// Only requests coming from them may tell the address of the client, in X-Forwarded-For or X-Real-IP.
+	// If empty, the address of the connection is always used.
+	TrustedProxies []string
 }
 
 // DatabaseDetailsURI holds the database information passed in URI.
@@ -33,15 +117,22 @@ type UpdateRequest struct {
 // NewCustom creates a new instance of Server.
 func NewCustom(router *gin.Engine, mongo db.Proxy) *Server {
 	return &Server{
-		Router: router,
-		mongo:  mongo,
+		Router:    router,
+		mongo:     mongo,
+		sanitizer: db.NewSanitizer(db.SanitizerConfig{}),
 	}
 }
 
 // New creates a new instance of a WebServer.
-func New(dbHost string, dbPort int, dbUser, dbPass string) *Server {
+func New(dbHost string, dbPort int, dbUser, dbPass string, opts Options) *Server {
+	if opts.Backends != nil && len(opts.Backends.Default) > 0 {
+		mongo, _ := opts.Backends.Get(opts.Backends.Default)
+		return NewWithOptions(mongo, opts)
+	}
 
-	mongo, err := db.NewConnection(dbHost, dbPort, dbUser, dbPass)
+	connection := opts.Connection
+	connection.Encryption = opts.Encryption
+	mongo, err := db.NewConnectionWithOptions(dbHost, dbPort, dbUser, dbPass, connection)
 	if err != nil {
 		// If connection failed, certainly health() should fail too
 		log.Error().
@@ -51,29 +142,93 @@ func New(dbHost string, dbPort int, dbUser, dbPass string) *Server {
 		return nil
 	}
 
-	return NewWithCustomDB(mongo)
+	return NewWithOptions(mongo, opts)
 }
 
 // NewWithCustomDB creates a new instance of a WebServer, with a custom DB handler.
 func NewWithCustomDB(mongo db.Proxy) *Server {
+	return NewWithOptions(mongo, Options{})
+}
+
+// NewWithOptions creates a new instance of a WebServer, with a custom DB handler and optional settings.
+func NewWithOptions(mongo db.Proxy, opts Options) *Server {
 	router := gin.Default()
 	router.Use(cors.Default())
+	if err := router.SetTrustedProxies(opts.TrustedProxies); err != nil {
+		log.Error().
+			Err(err).
+			Strs("proxies", opts.TrustedProxies).
+			Msg("invalid trusted proxies; forwarded client addresses are ignored")
+		router.SetTrustedProxies(nil)
+	}
 
 	ws := &Server{
-		Router: router,
-		mongo:  mongo,
+		Router:    router,
+		mongo:     mongo,
+		options:   opts,
+		sanitizer: opts.Sanitizer,
 	}
+	if ws.sanitizer == nil {
+		ws.sanitizer = db.NewSanitizer(db.SanitizerConfig{})
+	}
+	ws.cacheReads()
+	ws.guard()
 
-	router.GET("/", ws.Home)
-	router.GET("/health", ws.Health)
-	router.POST("/aggregate/:Database/:Collection", ws.Aggregate)
-	router.POST("/insert/:Database/:Collection", ws.Insert)
-	router.POST("/find/:Database/:Collection", ws.Find)
-	router.POST("/update/:Database/:Collection", ws.Update)
+	if len(opts.Authenticators) > 0 {
+		router.Use(ws.Authenticate)
+	}
+	router.Use(ws.SelectBackend)
+	router.Use(ws.ResolveNamespace)
+	router.Use(ws.Consistency)
+	if opts.Resilience != nil {
+		router.Use(ws.CircuitBreaker)
+	}
+
+	ws.routes(router)
+	if opts.Backends != nil {
+		ws.routes(router.Group("/backends/:Backend"))
+		router.GET("/health/backends", ws.BackendsHealth)
+	}
+	if opts.Resilience != nil {
+		router.GET("/metrics/resilience", ws.ResilienceMetrics)
+	}
+	if opts.Cache != nil {
+		router.GET("/metrics/cache", ws.CacheMetrics)
+	}
 
 	return ws
 }
 
+// routes registers the routes of the proxy in r.
+func (w *Server) routes(r gin.IRouter) {
+	r.GET("/", w.Home)
+	r.GET("/health", w.Health)
+	r.POST("/aggregate/:Database/:Collection", w.RateLimit(auth.OpAggregate), w.Authorize(auth.OpAggregate), w.Aggregate)
+	r.POST("/insert/:Database/:Collection", w.RateLimit(auth.OpInsert), w.Authorize(auth.OpInsert), w.Idempotent, w.Insert)
+	r.POST("/find/:Database/:Collection", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.Find)
+	r.POST("/update/:Database/:Collection", w.RateLimit(auth.OpUpdate), w.Authorize(auth.OpUpdate), w.Idempotent, w.ConsistencyOption, w.Update)
+	r.POST("/search/:Database/:Collection", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.ConsistencyOption, w.Search)
+	// Explain authorizes the operation explained, once it knows which one it is.
+	r.POST("/explain/:Database/:Collection", w.RateLimit(auth.OpFind), w.ConsistencyOption, w.Explain)
+	r.POST("/geo/near/:Database/:Collection", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.ConsistencyOption, w.GeoNear)
+	r.POST("/geo/within/:Database/:Collection", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.ConsistencyOption, w.GeoWithin)
+	r.POST("/geo/geoNear/:Database/:Collection", w.RateLimit(auth.OpAggregate), w.Authorize(auth.OpAggregate), w.ConsistencyOption, w.GeoNearAggregate)
+	r.POST("/upload/:Database/:Bucket", w.RateLimit(auth.OpInsert), w.Authorize(auth.OpInsert), w.Upload)
+	r.GET("/download/:Database/:Bucket/:ID", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.Download)
+	r.HEAD("/download/:Database/:Bucket/:ID", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.Download)
+	r.GET("/files/:Database/:Bucket", w.RateLimit(auth.OpFind), w.Authorize(auth.OpFind), w.ListFiles)
+	r.POST("/deleteFile/:Database/:Bucket/:ID", w.RateLimit(auth.OpDelete), w.Authorize(auth.OpDelete), w.Idempotent, w.DeleteFile)
+
+	if len(w.options.AdminKey) > 0 || w.options.Policy != nil {
+		admin := r.Group("/admin", w.RateLimit(auth.OpAdmin), w.RequireAdmin, w.Authorize(auth.OpAdmin))
+		admin.GET("/collections/:Database", w.ListCollections)
+		admin.POST("/create/:Database/:Collection", w.CreateCollection)
+		admin.POST("/rename/:Database/:Collection", w.RenameCollection)
+		admin.POST("/drop/:Database/:Collection", w.DropCollection)
+		admin.POST("/dropDatabase/:Database", w.DropDatabase)
+	}
+}
+
 // Run starts the webserver on address.
 func (w *Server) Run(address string) {
 	err := w.Router.Run(address)
@@ -123,8 +278,11 @@ func (w *Server) Aggregate(c *gin.Context) {
 		},
 	}
 
-	result, err := w.mongo.Aggregate(databaseDetails.Database, databaseDetails.Collection, aggregation)
+	result, err := w.proxyFor(c).Aggregate(databaseDetails.Database, databaseDetails.Collection, aggregation)
 	if err != nil {
+		if respondError(c, err) {
+			return
+		}
 		log.Error().
 			Err(err).
 			Msgf("error aggregating data into database")
@@ -147,8 +305,11 @@ func (w *Server) Home(c *gin.Context) {
 
 // Health return the names of available databases or err is DB is down.
 func (w *Server) Health(c *gin.Context) {
-	result, err := w.mongo.HealthCheck()
+	result, err := w.proxyFor(c).HealthCheck()
 	if err != nil {
+		if respondError(c, err) {
+			return
+		}
 		log.Error().
 			Err(err).
 			Msgf("failed to connect to mongodb")
@@ -156,6 +317,11 @@ func (w *Server) Health(c *gin.Context) {
 		return
 	}
 
+	// Clients only see the databases they may access, by the names they use.
+	if w.options.Namespaces != nil {
+		result = &db.HealthResponse{Databases: w.options.Namespaces.PublicDatabases(result.Databases)}
+	}
+
 	c.JSON(http.StatusOK, result)
 }
 
@@ -185,8 +351,11 @@ func (w *Server) Insert(c *gin.Context) {
 		panic(err)
 	}
 
-	result, err := w.mongo.Insert(databaseDetails.Database, databaseDetails.Collection, quote)
+	result, err := w.proxyFor(c).Insert(databaseDetails.Database, databaseDetails.Collection, quote)
 	if err != nil {
+		if respondError(c, err) {
+			return
+		}
 		log.Error().
 			Err(err).
 			Msgf("error inserting data into database")
@@ -237,8 +406,16 @@ func (w *Server) Find(c *gin.Context) {
 		}
 	}
 
-	result, err := w.mongo.Find(databaseDetails.Database, databaseDetails.Collection, filterParsed)
+	filterParsed, ok := w.sanitize(c, databaseDetails.Database, "filter", filterParsed)
+	if !ok {
+		return
+	}
+
+	result, err := w.proxyFor(c).Find(databaseDetails.Database, databaseDetails.Collection, filterParsed)
 	if err != nil {
+		if respondError(c, err) {
+			return
+		}
 		log.Error().
 			Err(err).
 			Msgf("error while searching data in database")
@@ -277,9 +454,17 @@ func (w *Server) Update(c *gin.Context) {
 		panic(err)
 	}
 
+	filter, ok := w.sanitize(c, databaseDetails.Database, "filter", parsed.Filter)
+	if !ok {
+		return
+	}
+
 	update := bson.D{{"$set", parsed.Updates}}
-	result, err := w.mongo.Update(databaseDetails.Database, databaseDetails.Collection, parsed.Filter, update)
+	result, err := w.proxyFor(c).Update(databaseDetails.Database, databaseDetails.Collection, filter, update)
 	if err != nil {
+		if respondError(c, err) {
+			return
+		}
 		log.Error().
 			Err(err).
 			Msgf("error while updating data...")
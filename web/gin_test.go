@@ -8,9 +8,12 @@ import (
 	"testing"
 
 	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
 	"github.com/otaviokr/mongodb-proxy-ms/mock"
 	"github.com/otaviokr/mongodb-proxy-ms/web"
 	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 type TestCase struct {
@@ -294,3 +297,29 @@ func TestUpdate(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateArguments(t *testing.T) {
+	proxy := mock.NewProxy(t)
+	proxy.On(db.OpUpdate, mock.Eq("cool_db"), mock.Eq("cool_collection"),
+		mock.Eq(bson.M{"author": "Seneca"}),
+		mock.Field("$set.author", mock.Eq("Lucius Annaeus Seneca"))).
+		Return(&db.UpdateResponse{Results: &mongo.UpdateResult{MatchedCount: 1, ModifiedCount: 1}}, nil).
+		Once()
+	ws := web.NewWithCustomDB(proxy)
+
+	request, err := http.NewRequest("POST", "http://localhost:80/update/cool_db/cool_collection",
+		strings.NewReader(`{"filter":{"author":"Seneca"},"updates":{"author":"Lucius Annaeus Seneca","publications":3}}`))
+	if err != nil {
+		t.FailNow()
+	}
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	calls := proxy.CallsTo(db.OpUpdate)
+	if assert.Len(t, calls, 1) {
+		// The changes are always wrapped in $set, so the fields not sent are cleared.
+		expected := bson.D{{Key: "$set", Value: db.Quote{Author: "Lucius Annaeus Seneca", Publications: 3}}}
+		assert.True(t, mock.Eq(expected)(calls[0].Document))
+	}
+}
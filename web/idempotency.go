@@ -0,0 +1,139 @@
+package web
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/idempotency"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers of the idempotent requests.
+const (
+	// IdempotencyKeyHeader is sent by the clients to make a write idempotent.
+	IdempotencyKeyHeader = "Idempotency-Key"
+	// IdempotentReplayedHeader is "true" in the responses replayed from a previous request with the same key.
+	IdempotentReplayedHeader = "Idempotent-Replayed"
+)
+
+// Idempotent runs a write sent with the Idempotency-Key header only once per client and key: repeating it
+// replays the original response. Reusing the key with a different request fails with 409 Conflict, as does
+// repeating it while the first one is still running. Server errors are not kept, so the request may be retried.
+// Keys belong to the principal, or to the address of anonymous clients.
+// Without a store, or without the header, every request runs.
+func (w *Server) Idempotent(c *gin.Context) {
+	store := w.options.Idempotency
+	key := c.GetHeader(IdempotencyKeyHeader)
+	if store == nil || len(key) == 0 {
+		c.Next()
+		return
+	}
+	if len(key) > idempotency.MaxKeyLength || strings.TrimSpace(key) != key {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"errors": "invalid idempotency key"})
+		return
+	}
+
+	body, err := ioutil.ReadAll(c.Request.Body)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("error reading request body")
+		c.AbortWithStatusJSON(http.StatusBadRequest, "")
+		return
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	scope := quotaClient(c)
+	fingerprint := idempotency.Fingerprint(c.Request.Method, c.GetString(backendNameKey), c.Request.URL.Path, body)
+	existing, err := store.Reserve(scope, key, fingerprint)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Str("client", scope).
+			Msg("failed to reserve the idempotency key")
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"errors": "idempotency store unavailable"})
+		return
+	}
+
+	switch {
+	case existing == nil:
+		w.runIdempotent(c, store, scope, key, fingerprint)
+	case existing.Fingerprint != fingerprint:
+		log.Warn().
+			Str("client", scope).
+			Str("path", c.Request.URL.Path).
+			Msg("idempotency key reused with a different request")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"errors": "idempotency key reused with a different request"})
+	case !existing.Completed():
+		c.Header("Retry-After", "1")
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"errors": "request with this idempotency key is in progress"})
+	default:
+		c.Header(IdempotentReplayedHeader, "true")
+		c.Data(existing.Status, existing.ContentType, existing.Body)
+		c.Abort()
+	}
+}
+
+// runIdempotent runs the request that reserved the key, and keeps its response. If the handler panics,
+// the key is released before the panic goes on, so retries don't wait for the reservation to expire.
+func (w *Server) runIdempotent(c *gin.Context, store idempotency.Store, scope, key, fingerprint string) {
+	recorder := &responseRecorder{ResponseWriter: c.Writer}
+	c.Writer = recorder
+	defer func() {
+		if r := recover(); r != nil {
+			c.Writer = recorder.ResponseWriter
+			releaseIdempotent(store, scope, key, fingerprint)
+			panic(r)
+		}
+	}()
+	c.Next()
+	c.Writer = recorder.ResponseWriter
+
+	status := c.Writer.Status()
+	if status >= http.StatusInternalServerError {
+		releaseIdempotent(store, scope, key, fingerprint)
+		return
+	}
+
+	err := store.Complete(scope, key, fingerprint, idempotency.Response{
+		Status:      status,
+		ContentType: c.Writer.Header().Get("Content-Type"),
+		Body:        recorder.body.Bytes(),
+	})
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("client", scope).
+			Msg("failed to store the response of the idempotent request")
+	}
+}
+
+// releaseIdempotent frees the key of a request whose response is not kept.
+func releaseIdempotent(store idempotency.Store, scope, key, fingerprint string) {
+	err := store.Release(scope, key, fingerprint)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("client", scope).
+			Msg("failed to release the idempotency key")
+	}
+}
+
+// responseRecorder keeps a copy of the body written to the response.
+type responseRecorder struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.body.Write(data)
+	return r.ResponseWriter.Write(data)
+}
+
+func (r *responseRecorder) WriteString(data string) (int, error) {
+	r.body.WriteString(data)
+	return r.ResponseWriter.WriteString(data)
+}
@@ -0,0 +1,147 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/idempotency"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+// newKeyStore accepts the API keys "seneca-key" and "cicero-key".
+func newKeyStore(t *testing.T) *auth.APIKeyStore {
+	store, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "seneca", Hash: auth.HashAPIKey("seneca-key")},
+		{ID: "cicero", Hash: auth.HashAPIKey("cicero-key")},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	return store
+}
+
+func TestIdempotent(t *testing.T) {
+	mongo := &mock.DBProxy{}
+	store := idempotency.NewMemoryStore(time.Hour, nil)
+	ws := web.NewWithOptions(mongo, web.Options{Idempotency: store, Authenticators: []auth.Authenticator{newKeyStore(t)}})
+
+	testCases := []struct {
+		name             string
+		path             string
+		apiKey           string
+		key              string
+		body             string
+		testCaseID       string
+		expectedCode     int
+		expectedBody     string
+		expectedReplayed string
+	}{
+		{"without key", "/insert/cool_db/cool_collection", "seneca-key", "", `{"author":"Seneca"}`, "insertOK", http.StatusOK, `{"InsertedID":"5f4d641403490cb668ed8313"}`, ""},
+		{"first insert", "/insert/cool_db/cool_collection", "seneca-key", "key-1", `{"author":"Seneca"}`, "insertOK", http.StatusOK, `{"InsertedID":"5f4d641403490cb668ed8313"}`, ""},
+		// The database would fail now, but the original response is replayed without calling it.
+		{"retried insert", "/insert/cool_db/cool_collection", "seneca-key", "key-1", `{"author":"Seneca"}`, "insertError", http.StatusOK, `{"InsertedID":"5f4d641403490cb668ed8313"}`, "true"},
+		{"different body", "/insert/cool_db/cool_collection", "seneca-key", "key-1", `{"author":"Cicero"}`, "insertOK", http.StatusConflict, `{"errors":"idempotency key reused with a different request"}`, ""},
+		{"different path", "/insert/cool_db/other_collection", "seneca-key", "key-1", `{"author":"Seneca"}`, "insertOK", http.StatusConflict, `{"errors":"idempotency key reused with a different request"}`, ""},
+		{"server error", "/insert/cool_db/cool_collection", "seneca-key", "key-2", `{"author":"Seneca"}`, "insertError", http.StatusInternalServerError, `""`, ""},
+		{"retried after server error", "/insert/cool_db/cool_collection", "seneca-key", "key-2", `{"author":"Seneca"}`, "insertOK", http.StatusOK, `{"InsertedID":"5f4d641403490cb668ed8313"}`, ""},
+		{"key too long", "/insert/cool_db/cool_collection", "seneca-key", strings.Repeat("k", idempotency.MaxKeyLength+1), `{"author":"Seneca"}`, "insertOK", http.StatusBadRequest, `{"errors":"invalid idempotency key"}`, ""},
+		{"update", "/update/cool_db/cool_collection", "seneca-key", "key-3", `{"filter":{},"updates":{"author":"Seneca"}}`, "updateOK", http.StatusOK, "", ""},
+		{"retried update", "/update/cool_db/cool_collection", "seneca-key", "key-3", `{"filter":{},"updates":{"author":"Seneca"}}`, "updateError", http.StatusOK, "", "true"},
+		// Keys belong to each principal: the same key of another one runs again.
+		{"other principal", "/insert/cool_db/cool_collection", "cicero-key", "key-1", `{"author":"Cicero"}`, "insertOK", http.StatusOK, `{"InsertedID":"5f4d641403490cb668ed8313"}`, ""},
+		{"anonymous", "/insert/cool_db/cool_collection", "", "key-4", `{"author":"Seneca"}`, "insertOK", http.StatusUnauthorized, "", ""},
+		// The handler panics on invalid JSON: the key is released, so the retry runs again instead of waiting.
+		{"panic", "/insert/cool_db/cool_collection", "seneca-key", "key-5", `{"author":`, "insertOK", http.StatusInternalServerError, "", ""},
+		{"retried after panic", "/insert/cool_db/cool_collection", "seneca-key", "key-5", `{"author":`, "insertOK", http.StatusInternalServerError, "", ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			if len(tc.apiKey) > 0 {
+				request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			}
+			if len(tc.key) > 0 {
+				request.Header.Set(web.IdempotencyKeyHeader, tc.key)
+			}
+			recorder := httptest.NewRecorder()
+			mongo.TestCaseID = tc.testCaseID
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			if len(tc.expectedBody) > 0 {
+				assert.Equal(t, tc.expectedBody, recorder.Body.String())
+			}
+			assert.Equal(t, tc.expectedReplayed, recorder.Header().Get(web.IdempotentReplayedHeader))
+		})
+	}
+}
+
+func TestIdempotentInProgress(t *testing.T) {
+	store := idempotency.NewMemoryStore(time.Hour, nil)
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "insertOK"}, web.Options{Idempotency: store, Authenticators: []auth.Authenticator{newKeyStore(t)}})
+
+	// Another instance of the proxy is still running the same request.
+	body := `{"author":"Seneca"}`
+	fingerprint := idempotency.Fingerprint("POST", "", "/insert/cool_db/cool_collection", []byte(body))
+	_, err := store.Reserve("principal:seneca", "key", fingerprint)
+	assert.NoError(t, err)
+
+	request, err := http.NewRequest("POST", "http://localhost:80/insert/cool_db/cool_collection", strings.NewReader(body))
+	if err != nil {
+		t.FailNow()
+	}
+	request.Header.Set(auth.APIKeyHeader, "seneca-key")
+	request.Header.Set(web.IdempotencyKeyHeader, "key")
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusConflict, recorder.Code)
+	assert.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, `{"errors":"request with this idempotency key is in progress"}`, recorder.Body.String())
+}
+
+func TestIdempotentAnonymous(t *testing.T) {
+	// Without authenticators, every client is anonymous, and its keys belong to its address.
+	store := idempotency.NewMemoryStore(time.Hour, nil)
+	mongo := &mock.DBProxy{}
+	ws := web.NewWithOptions(mongo, web.Options{Idempotency: store})
+
+	testCases := []struct {
+		name             string
+		remoteAddr       string
+		testCaseID       string
+		expectedCode     int
+		expectedReplayed string
+	}{
+		{"first insert", "192.0.2.1:1234", "insertOK", http.StatusOK, ""},
+		{"retried insert", "192.0.2.1:5678", "insertError", http.StatusOK, "true"},
+		{"other client", "192.0.2.2:1234", "insertError", http.StatusInternalServerError, ""},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80/insert/cool_db/cool_collection", strings.NewReader(`{"author":"Seneca"}`))
+			if err != nil {
+				t.FailNow()
+			}
+			request.RemoteAddr = tc.remoteAddr
+			request.Header.Set(web.IdempotencyKeyHeader, "key")
+			recorder := httptest.NewRecorder()
+			mongo.TestCaseID = tc.testCaseID
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedReplayed, recorder.Header().Get(web.IdempotentReplayedHeader))
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package web_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/memory"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMemoryProxy runs requests end-to-end, over the in-memory database.
+func TestMemoryProxy(t *testing.T) {
+	ws := web.NewWithCustomDB(memory.NewProxy())
+
+	post := func(path, body string) (int, map[string]interface{}) {
+		request, err := http.NewRequest("POST", "http://localhost:80"+path, strings.NewReader(body))
+		if err != nil {
+			t.FailNow()
+		}
+		recorder := httptest.NewRecorder()
+		ws.Router.ServeHTTP(recorder, request)
+
+		var response map[string]interface{}
+		json.Unmarshal(recorder.Body.Bytes(), &response)
+		return recorder.Code, response
+	}
+
+	code, inserted := post("/insert/library/quotes", `{"author":"Seneca","original_quote":"Omnia aliena sunt, tempus tantum nostrum est","publications":2}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.NotEmpty(t, inserted["InsertedID"])
+	code, _ = post("/insert/library/quotes", `{"author":"Cicero","publications":5}`)
+	assert.Equal(t, http.StatusOK, code)
+
+	code, found := post("/find/library/quotes", `{"author":"Seneca"}`)
+	assert.Equal(t, http.StatusOK, code)
+	if results, ok := found["results"].([]interface{}); assert.True(t, ok) && assert.Len(t, results, 1) {
+		quote := results[0].(map[string]interface{})
+		assert.Equal(t, inserted["InsertedID"], quote["_id"])
+		assert.Equal(t, "Omnia aliena sunt, tempus tantum nostrum est", quote["originalquote"])
+	}
+
+	code, updated := post("/update/library/quotes", `{"filter":{"author":"Seneca"},"updates":{"author":"Lucius Annaeus Seneca","publications":3}}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, map[string]interface{}{"MatchedCount": 1.0, "ModifiedCount": 1.0, "UpsertedCount": 0.0, "UpsertedID": nil}, updated["results"])
+
+	code, found = post("/find/library/quotes", `{"publications":{"$gt":2}}`)
+	assert.Equal(t, http.StatusOK, code)
+	if results, ok := found["results"].([]interface{}); assert.True(t, ok) && assert.Len(t, results, 2) {
+		assert.Equal(t, "Lucius Annaeus Seneca", results[0].(map[string]interface{})["author"])
+	}
+
+	code, found = post("/find/library/quotes", `{"author":"Seneca"}`)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, found["results"])
+}
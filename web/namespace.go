@@ -0,0 +1,45 @@
+package web
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// publicDatabaseKey is where the database name used by the client is kept in the gin context.
+const publicDatabaseKey = "publicDatabase"
+
+// ResolveNamespace replaces the database and collection (or GridFS bucket) in the URI by their real names,
+// and rejects namespaces that are not allowed as if they did not exist. Without namespace rules, URIs are kept as is.
+func (w *Server) ResolveNamespace(c *gin.Context) {
+	namespaces := w.options.Namespaces
+	database, collection := getNamespace(c)
+	if namespaces == nil || len(database) == 0 {
+		c.Next()
+		return
+	}
+
+	realDatabase, realCollection, err := namespaces.Resolve(database, collection)
+	if err != nil {
+		log.Warn().
+			Str("database", database).
+			Str("collection", collection).
+			Str("path", c.Request.URL.Path).
+			Str("client", c.ClientIP()).
+			Msg("refused request to a namespace that is not allowed")
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"errors": "namespace not found"})
+		return
+	}
+
+	c.Set(publicDatabaseKey, database)
+	for i := range c.Params {
+		switch c.Params[i].Key {
+		case "Database":
+			c.Params[i].Value = realDatabase
+		case "Collection", "Bucket":
+			c.Params[i].Value = realCollection
+		}
+	}
+	c.Next()
+}
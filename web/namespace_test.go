@@ -0,0 +1,83 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveNamespace(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{
+		Aliases: map[string]string{"quotes": "okr_prod", "quotes.latest": "okr_prod.quotes_2021"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []AdminTestCase{
+		{testCaseID: "findNamespace", method: "POST", path: "/find/okr_dev/quotes", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"collection":"quotes","database":"okr_dev"}]}`},
+		{testCaseID: "findNamespace", method: "POST", path: "/find/quotes/authors", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"collection":"authors","database":"okr_prod"}]}`},
+		{testCaseID: "findNamespace", method: "POST", path: "/find/quotes/latest", expectedCode: http.StatusOK, expectedMessage: `{"results":[{"collection":"quotes_2021","database":"okr_prod"}]}`},
+		{testCaseID: "findNamespace", method: "POST", path: "/find/okr_prod/authors", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"namespace not found"}`},
+		{testCaseID: "findNamespace", method: "POST", path: "/find/admin/system.users", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"namespace not found"}`},
+		{testCaseID: "adminDropDatabaseOK", method: "POST", path: "/admin/dropDatabase/local", adminKey: "s3cr3t", expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"namespace not found"}`},
+		{testCaseID: "adminRenameOK", method: "POST", path: "/admin/rename/quotes/authors", adminKey: "s3cr3t", body: `{"to":"latest"}`, expectedCode: http.StatusOK, expectedMessage: `{"database":"okr_prod","collection":"quotes_2021","operation":"rename"}`},
+		{testCaseID: "adminRenameOK", method: "POST", path: "/admin/rename/quotes/authors", adminKey: "s3cr3t", body: `{"to":"quotes_2021","drop_target":true}`, expectedCode: http.StatusNotFound, expectedMessage: `{"errors":"namespace not found"}`},
+		{testCaseID: "adminRenameOK", method: "POST", path: "/admin/rename/okr_dev/quotes", adminKey: "s3cr3t", body: `{"to":"authors"}`, expectedCode: http.StatusOK, expectedMessage: `{"database":"okr_dev","collection":"authors","operation":"rename"}`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			body := tc.body
+			if len(body) == 0 {
+				body = `{}`
+			}
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(body))
+			if err != nil {
+				t.FailNow()
+			}
+			request.Header.Set(web.AdminKeyHeader, tc.adminKey)
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{
+				AdminKey:   "s3cr3t",
+				Namespaces: namespaces,
+			})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
+
+func TestHealthNamespaces(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{
+		Aliases: map[string]string{"quotes": "okr_prod"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	proxy := mock.NewProxy(t)
+	proxy.On(db.OpHealthCheck).Return(&db.HealthResponse{Databases: []string{"admin", "config", "local", "okr_dev", "okr_prod"}}, nil)
+	ws := web.NewWithOptions(proxy, web.Options{Namespaces: namespaces})
+
+	request, err := http.NewRequest("GET", "http://localhost:80/health", nil)
+	if err != nil {
+		t.FailNow()
+	}
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusOK, recorder.Code, "unexpected status code")
+	assert.Equal(t, `{"databases":["okr_dev","quotes"]}`, recorder.Body.String(), "internal databases and real names should be hidden")
+}
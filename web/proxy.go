@@ -0,0 +1,59 @@
+package web
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/audit"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/rs/zerolog/log"
+)
+
+// proxyFor returns the database handler of the backend chosen by the request, applying its consistency and
+// the field policy of its client, recording its changes in the audit trail and running its calls through
+// the interceptors, the outermost layer, which charge its daily quota too.
+func (w *Server) proxyFor(c *gin.Context) db.Proxy {
+	proxy := w.backendFor(c)
+	principal := GetPrincipal(c)
+
+	if consistent, err := db.WithConsistency(proxy, getConsistency(c)); err == nil {
+		proxy = consistent
+	} else {
+		// Not expected: the consistency was validated by the Consistency middleware.
+		log.Error().
+			Err(err).
+			Msg("failed to apply the consistency of the request")
+	}
+
+	if fields := w.options.Fields; fields != nil {
+		proxy = db.NewRedactingProxy(proxy, func(database, collection string) []string {
+			return fields.HiddenFields(principal, database, collection)
+		})
+	}
+
+	if w.options.Audit != nil {
+		if principal == nil {
+			principal = auth.Anonymous
+		}
+		actor := audit.Actor{
+			Principal:  principal.ID,
+			AuthMethod: principal.Method,
+			Client:     c.ClientIP(),
+			Backend:    c.GetString(backendNameKey),
+		}
+		proxy = audit.NewProxy(proxy, w.options.Audit, actor, w.options.AuditValues)
+	}
+
+	interceptors := w.options.Interceptors
+	if limiter := w.options.RateLimiter; limiter != nil && limiter.HasQuotas() {
+		interceptors = append(interceptors[:len(interceptors):len(interceptors)], ratelimit.ChargeQuota(limiter, quotaClient(c)))
+	}
+	if len(interceptors) > 0 {
+		ctx := c.Request.Context()
+		if principal := GetPrincipal(c); principal != nil {
+			ctx = auth.NewContext(ctx, principal)
+		}
+		proxy = db.InterceptContext(ctx, proxy, interceptors...)
+	}
+	return proxy
+}
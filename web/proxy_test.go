@@ -0,0 +1,79 @@
+package web_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/cache"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterceptors(t *testing.T) {
+	keys, err := auth.NewAPIKeyStore([]auth.APIKey{
+		{ID: "reader", Hash: auth.HashAPIKey("reader-key")},
+		{ID: "editor", Hash: auth.HashAPIKey("editor-key"), Roles: []string{"editor"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	readCache, err := cache.New(cache.Config{})
+	if err != nil {
+		t.FailNow()
+	}
+
+	// Only editors may write, and every call is counted, even those answered by the cache.
+	var calls []db.Operation
+	editorsOnly := func(call *db.Call, next db.Handler) (interface{}, error) {
+		calls = append(calls, call.Operation)
+		principal := auth.FromContext(call.Context)
+		if call.Operation.IsWrite() && (principal == nil || !principal.HasRole("editor")) {
+			return nil, errors.New("read only")
+		}
+		return next(call)
+	}
+
+	mongo := &mock.DBProxy{}
+	ws := web.NewWithOptions(mongo, web.Options{
+		Authenticators: []auth.Authenticator{keys},
+		Cache:          readCache,
+		Interceptors:   []db.Interceptor{editorsOnly},
+	})
+
+	testCases := []struct {
+		name          string
+		path          string
+		apiKey        string
+		body          string
+		testCaseID    string
+		expectedCode  int
+		expectedCalls []db.Operation
+	}{
+		{"find", "/find/cool_db/cool_collection", "reader-key", `{"author":"Seneca"}`, "findOK", http.StatusOK, []db.Operation{db.OpFind}},
+		{"cached find", "/find/cool_db/cool_collection", "reader-key", `{"author":"Seneca"}`, "findNothingFound", http.StatusOK, []db.Operation{db.OpFind, db.OpFind}},
+		{"refused insert", "/insert/cool_db/cool_collection", "reader-key", `{"author":"Seneca"}`, "insertOK", http.StatusInternalServerError, []db.Operation{db.OpFind, db.OpFind, db.OpInsert}},
+		{"insert", "/insert/cool_db/cool_collection", "editor-key", `{"author":"Seneca"}`, "insertOK", http.StatusOK, []db.Operation{db.OpFind, db.OpFind, db.OpInsert, db.OpInsert}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+			request.Header.Set(auth.APIKeyHeader, tc.apiKey)
+			recorder := httptest.NewRecorder()
+			mongo.TestCaseID = tc.testCaseID
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedCalls, calls)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package web
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/rs/zerolog/log"
+)
+
+// Headers describing the rate limit of the request, as proposed by the IETF HTTPAPI working group.
+const (
+	RateLimitLimitHeader     = "RateLimit-Limit"
+	RateLimitRemainingHeader = "RateLimit-Remaining"
+	RateLimitResetHeader     = "RateLimit-Reset"
+)
+
+// RateLimit aborts the request with 429 Too Many Requests if the client sent too many requests for op,
+// or has used its daily quota of documents. Without a limiter, every request proceeds.
+func (w *Server) RateLimit(op auth.Operation) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := w.options.RateLimiter
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		var principal string
+		if p := GetPrincipal(c); p != nil {
+			principal = p.ID
+		}
+		decision := limiter.Allow(ratelimit.Request{
+			Principal: principal,
+			IP:        c.ClientIP(),
+			Route:     c.FullPath(),
+			Operation: string(op),
+		})
+		if decision.Limit > 0 {
+			c.Header(RateLimitLimitHeader, strconv.Itoa(decision.Limit))
+			c.Header(RateLimitRemainingHeader, strconv.Itoa(decision.Remaining))
+			c.Header(RateLimitResetHeader, seconds(decision.Reset))
+		}
+		if !decision.Allowed {
+			w.refuse(c, op, decision.RetryAfter, "rate limit exceeded")
+			return
+		}
+
+		// Admin operations don't read or write documents, so they are not charged to any quota.
+		if op == auth.OpAdmin {
+			c.Next()
+			return
+		}
+		write := op != auth.OpFind && op != auth.OpAggregate
+		if exceeded, renewal := limiter.QuotaExceeded(quotaClient(c), write); exceeded {
+			w.refuse(c, op, renewal, "daily quota exceeded")
+			return
+		}
+		c.Next()
+	}
+}
+
+func (w *Server) refuse(c *gin.Context, op auth.Operation, retryAfter time.Duration, reason string) {
+	log.Warn().
+		Str("operation", string(op)).
+		Str("path", c.Request.URL.Path).
+		Str("client", quotaClient(c)).
+		Msg(reason)
+	c.Header("Retry-After", seconds(retryAfter))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"errors": reason})
+}
+
+// quotaClient identifies whom the documents of the request are charged to: the principal, or the IP
+// address of anonymous clients. The address is only taken from X-Forwarded-For when the request comes
+// from one of Options.TrustedProxies.
+func quotaClient(c *gin.Context) string {
+	if principal := GetPrincipal(c); principal != nil {
+		return "principal:" + principal.ID
+	}
+	return "ip:" + c.ClientIP()
+}
+
+// seconds formats d in whole seconds, rounding up.
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,101 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/ratelimit"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(ratelimit.Config{
+		Default:    ratelimit.Limit{Rate: 0.001, Burst: 2},
+		Operations: map[string]ratelimit.Limit{"insert": {}},
+		DailyQuota: ratelimit.Quota{Written: 1},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	mongo := &mock.DBProxy{}
+	ws := web.NewWithOptions(mongo, web.Options{RateLimiter: limiter, AdminKey: "s3cr3t"})
+
+	testCases := []struct {
+		name              string
+		path              string
+		testCaseID        string
+		expectedCode      int
+		expectedRemaining string
+		expectedMessage   string
+	}{
+		{"first find", "/find/cool_db/cool_collection", "findOK", http.StatusOK, "1", ""},
+		{"second find", "/find/cool_db/cool_collection", "findOK", http.StatusOK, "0", ""},
+		{"too many finds", "/find/cool_db/cool_collection", "findOK", http.StatusTooManyRequests, "0", `{"errors":"rate limit exceeded"}`},
+		{"other route", "/search/cool_db/cool_collection", "findOK", http.StatusBadRequest, "1", ""},
+		{"first insert", "/insert/cool_db/cool_collection", "insertOK", http.StatusOK, "", ""},
+		{"quota exceeded", "/insert/cool_db/cool_collection", "insertOK", http.StatusTooManyRequests, "", `{"errors":"daily quota exceeded"}`},
+		{"admin without quota", "/admin/drop/cool_db/cool_collection", "adminDropOK", http.StatusOK, "1", ""},
+	}
+
+	for i, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest("POST", "http://localhost:80"+tc.path, strings.NewReader(`{"author":"Seneca"}`))
+			if err != nil {
+				t.FailNow()
+			}
+			// Without trusted proxies, the forwarded address is ignored, so it can't get a new bucket.
+			request.Header.Set("X-Forwarded-For", "203.0.113."+strconv.Itoa(i))
+			request.Header.Set(web.AdminKeyHeader, "s3cr3t")
+			recorder := httptest.NewRecorder()
+			mongo.TestCaseID = tc.testCaseID
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedRemaining, recorder.Header().Get(web.RateLimitRemainingHeader))
+			if tc.expectedCode == http.StatusTooManyRequests {
+				assert.NotEmpty(t, recorder.Header().Get("Retry-After"))
+				assert.Equal(t, tc.expectedMessage, recorder.Body.String())
+			}
+		})
+	}
+}
+
+func TestRateLimitTrustedProxies(t *testing.T) {
+	limiter, err := ratelimit.NewLimiter(ratelimit.Config{
+		KeyBy:   []string{"ip"},
+		Default: ratelimit.Limit{Rate: 0.001, Burst: 1},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "findOK"}, web.Options{
+		RateLimiter:    limiter,
+		TrustedProxies: []string{"10.0.0.0/8"},
+	})
+
+	find := func(remoteAddr, forwardedFor string) int {
+		request, err := http.NewRequest("POST", "http://localhost:80/find/cool_db/cool_collection", strings.NewReader(`{}`))
+		if err != nil {
+			t.FailNow()
+		}
+		request.RemoteAddr = remoteAddr
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		recorder := httptest.NewRecorder()
+		ws.Router.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// Behind a trusted proxy, each forwarded client has its own bucket.
+	assert.Equal(t, http.StatusOK, find("10.0.0.1:40000", "203.0.113.1"))
+	assert.Equal(t, http.StatusTooManyRequests, find("10.0.0.1:40000", "203.0.113.1"))
+	assert.Equal(t, http.StatusOK, find("10.0.0.1:40000", "203.0.113.2"))
+
+	// Other clients can't choose their address.
+	assert.Equal(t, http.StatusOK, find("192.0.2.1:40000", "203.0.113.3"))
+	assert.Equal(t, http.StatusTooManyRequests, find("192.0.2.1:40000", "203.0.113.4"))
+}
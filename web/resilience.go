@@ -0,0 +1,89 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/rs/zerolog/log"
+)
+
+// MainBackendName names the main connection in the metrics, unless it is one of the configured backends.
+const MainBackendName = "main"
+
+// guard wraps the main connection and the backends with the retries and circuit breaker of opts.Resilience.
+// The default backend shares the circuit breaker of the main connection.
+func (w *Server) guard() {
+	config := w.options.Resilience
+	if config == nil {
+		return
+	}
+
+	name := w.mainBackendName()
+	main := resilience.NewProxy(w.mongo, resilience.New(name, *config))
+	w.mongo = main
+
+	if w.options.Backends != nil {
+		w.options.Backends = w.options.Backends.Wrap(func(backend string, proxy db.Proxy) db.Proxy {
+			if backend == name {
+				return main
+			}
+			return resilience.NewProxy(proxy, resilience.New(backend, *config))
+		})
+	}
+}
+
+// mainBackendName names the main connection: the default backend, if any, since they are the same deployment.
+func (w *Server) mainBackendName() string {
+	if w.options.Backends != nil && len(w.options.Backends.Default) > 0 {
+		return w.options.Backends.Default
+	}
+	return MainBackendName
+}
+
+// CircuitBreaker fails the requests with 503 Service Unavailable, and the Retry-After header, while
+// the circuit breaker of their backend is open, without waiting for MongoDB.
+func (w *Server) CircuitBreaker(c *gin.Context) {
+	if len(c.Param("Database")) == 0 && !strings.HasSuffix(c.FullPath(), "/health") {
+		c.Next()
+		return
+	}
+
+	guarded, ok := w.backendFor(c).(resilience.Guarded)
+	if !ok {
+		c.Next()
+		return
+	}
+	if retryAfter := guarded.Resilience().Breaker.RetryAfter(); retryAfter > 0 {
+		log.Warn().
+			Str("backend", guarded.Resilience().Name).
+			Str("path", c.Request.URL.Path).
+			Str("client", c.ClientIP()).
+			Msg("refused request while the database is unavailable")
+		c.Header("Retry-After", seconds(retryAfter))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"errors": "database unavailable"})
+		return
+	}
+	c.Next()
+}
+
+// ResilienceMetrics returns the retries, failures and circuit breaker state of each backend.
+func (w *Server) ResilienceMetrics(c *gin.Context) {
+	metrics := map[string]resilience.Metrics{}
+	add := func(proxy db.Proxy) {
+		if guarded, ok := proxy.(resilience.Guarded); ok {
+			metrics[guarded.Resilience().Name] = guarded.Resilience().Metrics()
+		}
+	}
+
+	add(w.mongo)
+	if w.options.Backends != nil {
+		for _, name := range w.options.Backends.Names() {
+			proxy, _ := w.options.Backends.Get(name)
+			add(proxy)
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{"backends": metrics})
+}
@@ -0,0 +1,106 @@
+package web_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/resilience"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/topology"
+)
+
+// unavailableProxy fails every Find as if no server could be reached.
+type unavailableProxy struct {
+	mock.DBProxy
+}
+
+func (p *unavailableProxy) Find(database, collection string, filter interface{}) (*db.FindResponse, error) {
+	return nil, topology.ServerSelectionError{Wrapped: errors.New("no primary")}
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	backends, err := db.NewBackends("", map[string]db.Proxy{
+		"analytics": &mock.DBProxy{TestCaseID: "findOK"},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+
+	ws := web.NewWithOptions(&unavailableProxy{DBProxy: mock.DBProxy{TestCaseID: "healthUp"}}, web.Options{
+		Backends:   backends,
+		Resilience: &resilience.Config{MaxAttempts: 1, FailureThreshold: 1},
+	})
+
+	testCases := []struct {
+		name               string
+		method             string
+		path               string
+		expectedCode       int
+		expectedRetryAfter string
+	}{
+		{name: "failure opens the breaker", method: "POST", path: "/find/okr/quotes", expectedCode: http.StatusInternalServerError},
+		{name: "fails fast", method: "POST", path: "/find/okr/quotes", expectedCode: http.StatusServiceUnavailable, expectedRetryAfter: "30"},
+		{name: "health fails fast", method: "GET", path: "/health", expectedCode: http.StatusServiceUnavailable, expectedRetryAfter: "30"},
+		{name: "home is not affected", method: "GET", path: "/", expectedCode: http.StatusOK},
+		{name: "other backends are not affected", method: "POST", path: "/backends/analytics/find/okr/quotes", expectedCode: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(`{}`))
+			if err != nil {
+				t.FailNow()
+			}
+			recorder := httptest.NewRecorder()
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedRetryAfter, recorder.Header().Get("Retry-After"))
+			if tc.expectedCode == http.StatusServiceUnavailable {
+				assert.Equal(t, `{"errors":"database unavailable"}`, recorder.Body.String())
+			}
+		})
+	}
+
+	request, _ := http.NewRequest("GET", "http://localhost:80/metrics/resilience", nil)
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+
+	var metrics struct {
+		Backends map[string]resilience.Metrics `json:"backends"`
+	}
+	if assert.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &metrics)) {
+		assert.Equal(t, resilience.StateOpen, metrics.Backends[web.MainBackendName].State)
+		assert.Equal(t, int64(1), metrics.Backends[web.MainBackendName].Failures)
+		assert.Equal(t, resilience.StateClosed, metrics.Backends["analytics"].State)
+		assert.Equal(t, int64(1), metrics.Backends["analytics"].Calls)
+	}
+}
+
+func TestCircuitOpensDuringRequest(t *testing.T) {
+	// The breaker opened after the check that runs before the handlers, or refused the request while
+	// another one probed MongoDB.
+	proxy := mock.NewProxy(t)
+	proxy.On(db.OpUpdate).Return(nil, &resilience.OpenError{RetryAfter: 2500 * time.Millisecond})
+	ws := web.NewWithOptions(proxy, web.Options{})
+
+	request, err := http.NewRequest("POST", "http://localhost:80/update/cool_db/cool_collection", strings.NewReader(`{"filter":{},"updates":{"author":"Seneca"}}`))
+	if err != nil {
+		t.FailNow()
+	}
+	recorder := httptest.NewRecorder()
+	ws.Router.ServeHTTP(recorder, request)
+
+	assert.Equal(t, http.StatusServiceUnavailable, recorder.Code)
+	assert.Equal(t, "3", recorder.Header().Get("Retry-After"))
+	assert.Equal(t, `{"errors":"database unavailable"}`, recorder.Body.String())
+}
@@ -0,0 +1,54 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// sanitize removes the denied operators from value (a filter, an update or a pipeline, called name in errors).
+// If value is rejected, it writes the 400 response and returns false.
+func (w *Server) sanitize(c *gin.Context, database, name string, value interface{}) (interface{}, bool) {
+	sanitizer := w.sanitizer.WithNamespace(func(database, collection string) (string, error) {
+		return w.resolveLookup(c, database, collection)
+	})
+
+	sanitized, err := sanitizer.Sanitize(database, name, value)
+	if err != nil {
+		log.Warn().
+			Err(err).
+			Str("path", c.Request.URL.Path).
+			Str("client", c.ClientIP()).
+			Msg("refused request with denied operators")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return nil, false
+	}
+	return sanitized, true
+}
+
+// resolveLookup returns the real name of a collection read by a lookup in database, as long as the namespace
+// rules and the policy let the client read it.
+func (w *Server) resolveLookup(c *gin.Context, database, collection string) (string, error) {
+	if w.options.Namespaces != nil {
+		// Clients refer to collections by their public names, in the public database of the request.
+		realDatabase, realCollection, err := w.options.Namespaces.Resolve(c.GetString(publicDatabaseKey), collection)
+		if err != nil {
+			return "", err
+		}
+		if realDatabase != database {
+			return "", fmt.Errorf("collection %s is in another database", collection)
+		}
+		collection = realCollection
+	}
+
+	if w.options.Policy != nil {
+		principal := GetPrincipal(c)
+		if !w.options.Policy.Allows(principal, auth.OpFind, database, collection) {
+			return "", fmt.Errorf("collection %s may not be read", collection)
+		}
+	}
+	return collection, nil
+}
@@ -0,0 +1,88 @@
+package web_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitize(t *testing.T) {
+	namespaces, err := auth.NewNamespaces(auth.NamespaceConfig{Allow: []string{"cool_db.cool_*"}})
+	if err != nil {
+		t.FailNow()
+	}
+
+	testCases := []AdminTestCase{
+		{
+			testCaseID:      "findOK",
+			method:          "POST",
+			path:            "/find/cool_db/cool_collection",
+			body:            `{"$where":"sleep(1000) || true"}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"$where is not allowed at filter: operator is denied"}`,
+		},
+		{
+			testCaseID:      "findOK",
+			method:          "POST",
+			path:            "/find/cool_db/cool_collection",
+			body:            `{"hello":"world"}`,
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"foo":"bar","hello":"world","pi":3.14159}]}`,
+		},
+		{
+			testCaseID:      "updateOK",
+			method:          "POST",
+			path:            "/update/cool_db/cool_collection",
+			body:            `{"filter":{"$expr":{"$function":{"body":"return true","args":[],"lang":"js"}}},"updates":{"author":"x"}}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"$function is not allowed at filter.$expr: operator is denied"}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			method:          "POST",
+			path:            "/explain/cool_db/cool_collection",
+			body:            `{"operation":"aggregate","pipeline":[{"$merge":{"into":"stolen"}}]}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"$merge is not allowed at pipeline.0: stage is denied"}`,
+		},
+		{
+			testCaseID:      "explainOK",
+			method:          "POST",
+			path:            "/explain/cool_db/cool_collection",
+			body:            `{"operation":"aggregate","pipeline":[{"$lookup":{"from":"secrets","localField":"a","foreignField":"b","as":"c"}}]}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"$lookup is not allowed at pipeline.0.$lookup: collection \"secrets\" is not accessible"}`,
+		},
+		{
+			testCaseID:      "geoOK",
+			method:          "POST",
+			path:            "/geo/geoNear/cool_db/cool_collection",
+			body:            `{"field":"location","point":{"type":"Point","coordinates":[-48.5,-27.6]},"query":{"$where":"true"}}`,
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"$where is not allowed at query: operator is denied"}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest(tc.method, "http://localhost:80"+tc.path, strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: tc.testCaseID}, web.Options{Namespaces: namespaces})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
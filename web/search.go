@@ -0,0 +1,51 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/db"
+	"github.com/rs/zerolog/log"
+)
+
+// Search returns the documents that match a full-text search, best matches first.
+func (w *Server) Search(c *gin.Context) {
+	var databaseDetails DatabaseDetailsURI
+	err := c.ShouldBindUri(&databaseDetails)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msgf("failed to parse URI")
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	var request db.SearchRequest
+	err = c.ShouldBindJSON(&request)
+	if err == nil {
+		err = request.Validate()
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+		return
+	}
+
+	result, err := w.proxyFor(c).Search(databaseDetails.Database, databaseDetails.Collection, request)
+	if err != nil {
+		if respondError(c, err) {
+			return
+		}
+		if errors.Is(err, db.ErrNoTextIndex) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": err.Error()})
+			return
+		}
+		log.Error().
+			Err(err).
+			Msgf("error while searching text in database")
+		c.JSON(http.StatusInternalServerError, "")
+		return
+	}
+
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,114 @@
+package web_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearch(t *testing.T) {
+	testCases := []TestCase{
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"fortune","language":"English","page":2,"page_size":10}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"author":"Seneca","score":1.5}],"total":1,"page":2,"page_size":10}`,
+		},
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"fortune"}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusOK,
+			expectedMessage: `{"results":[{"author":"Seneca","score":1.5}],"total":1,"page":1,"page_size":20}`,
+		},
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"fortune","language":"latin"}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"language not supported by text search: latin"}`,
+			hasError:        true,
+		},
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"fortune","page_size":1000}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"page_size must not be greater than 100"}`,
+			hasError:        true,
+		},
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"fortune","page":9223372036854775807}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"page must not be greater than 1000"}`,
+			hasError:        true,
+		},
+		{
+			testCaseID: "searchOK",
+			body:       `{"text":"   "}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"search text is empty"}`,
+			hasError:        true,
+		},
+		{
+			testCaseID: "searchNoIndex",
+			body:       `{"text":"fortune"}`,
+			params: []gin.Param{
+				{Key: "db", Value: "cool_db"},
+				{Key: "collection", Value: "cool_collection"},
+			},
+			expectedCode:    http.StatusBadRequest,
+			expectedMessage: `{"errors":"collection has no text index"}`,
+			hasError:        true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.testCaseID, func(t *testing.T) {
+			request, err := http.NewRequest(
+				"POST",
+				fmt.Sprintf("http://localhost:80/search/%s/%s", tc.params[0].Value, tc.params[1].Value),
+				strings.NewReader(tc.body))
+			if err != nil {
+				t.FailNow()
+			}
+
+			recorder := httptest.NewRecorder()
+			ws := web.NewWithCustomDB(&mock.DBProxy{TestCaseID: tc.testCaseID})
+
+			ws.Router.ServeHTTP(recorder, request)
+
+			assert.Equal(t, tc.expectedCode, recorder.Code, "unexpected status code")
+			assert.Equal(t, tc.expectedMessage, recorder.Body.String(), "unexpected response")
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultReloadInterval is how often the certificate files are checked for changes, unless told otherwise.
+const DefaultReloadInterval = 30 * time.Second
+
+// TLSOptions defines how the webserver serves HTTPS.
+type TLSOptions struct {
+	// CertFile and KeyFile hold the PEM encoded certificate (with its chain) and private key of the server.
+	CertFile string
+	KeyFile  string
+
+	// ClientCAFile holds the PEM encoded CAs that sign client certificates. If empty, clients are not asked for one.
+	ClientCAFile string
+
+	// RequireClientCert refuses connections without a valid client certificate. Otherwise, the certificate is
+	// optional, but verified when presented.
+	RequireClientCert bool
+
+	// ReloadInterval is how often the files are checked for changes. If zero, DefaultReloadInterval is used;
+	// if negative, they are never reloaded.
+	ReloadInterval time.Duration
+}
+
+// CertificateReloader keeps the server certificate and the client CAs in memory, reloading them when
+// their files change, so certificates can be renewed without restarting the server.
+type CertificateReloader struct {
+	options TLSOptions
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	modified    map[string]time.Time
+
+	stop chan struct{}
+	once sync.Once
+}
+
+// NewCertificateReloader loads the files in opts, and starts watching them.
+func NewCertificateReloader(opts TLSOptions) (*CertificateReloader, error) {
+	if len(opts.CertFile) == 0 || len(opts.KeyFile) == 0 {
+		return nil, fmt.Errorf("TLS requires a certificate and a key file")
+	}
+	if opts.RequireClientCert && len(opts.ClientCAFile) == 0 {
+		return nil, fmt.Errorf("client certificates can only be required with a client CA file")
+	}
+	if opts.ReloadInterval == 0 {
+		opts.ReloadInterval = DefaultReloadInterval
+	}
+
+	r := &CertificateReloader{
+		options: opts,
+		stop:    make(chan struct{}),
+	}
+	err := r.Reload()
+	if err != nil {
+		return nil, err
+	}
+	if opts.ReloadInterval > 0 {
+		go r.watch()
+	}
+	return r, nil
+}
+
+// Reload reads the files again. On error, the certificates already loaded are kept.
+func (r *CertificateReloader) Reload() error {
+	modified := map[string]time.Time{}
+	for _, filename := range r.files() {
+		info, err := os.Stat(filename)
+		if err != nil {
+			return err
+		}
+		modified[filename] = info.ModTime()
+	}
+
+	certificate, err := tls.LoadX509KeyPair(r.options.CertFile, r.options.KeyFile)
+	if err != nil {
+		return fmt.Errorf("invalid TLS certificate: %v", err)
+	}
+
+	var clientCAs *x509.CertPool
+	if len(r.options.ClientCAFile) > 0 {
+		content, err := ioutil.ReadFile(r.options.ClientCAFile)
+		if err != nil {
+			return err
+		}
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(content) {
+			return fmt.Errorf("no certificates found in %s", r.options.ClientCAFile)
+		}
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.certificate = &certificate
+	r.clientCAs = clientCAs
+	r.modified = modified
+	return nil
+}
+
+// TLSConfig returns the configuration for the webserver, always using the latest certificates.
+// It offers HTTP/2 and HTTP/1.1.
+func (r *CertificateReloader) TLSConfig() *tls.Config {
+	base := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+		GetCertificate: r.getCertificate,
+	}
+	base.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		r.mutex.RLock()
+		defer r.mutex.RUnlock()
+
+		// The configuration returned here replaces the base one, so the protocols must be copied,
+		// or HTTP/2 would not be negotiated.
+		config := &tls.Config{
+			MinVersion:   base.MinVersion,
+			NextProtos:   base.NextProtos,
+			Certificates: []tls.Certificate{*r.certificate},
+		}
+		if r.clientCAs != nil {
+			config.ClientCAs = r.clientCAs
+			config.ClientAuth = tls.VerifyClientCertIfGiven
+			if r.options.RequireClientCert {
+				config.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+		}
+		return config, nil
+	}
+	return base
+}
+
+func (r *CertificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.certificate, nil
+}
+
+// Close stops watching the files.
+func (r *CertificateReloader) Close() {
+	r.once.Do(func() {
+		close(r.stop)
+	})
+}
+
+func (r *CertificateReloader) files() []string {
+	files := []string{r.options.CertFile, r.options.KeyFile}
+	if len(r.options.ClientCAFile) > 0 {
+		files = append(files, r.options.ClientCAFile)
+	}
+	return files
+}
+
+// changed tells if any file was modified since it was loaded.
+func (r *CertificateReloader) changed() bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	for filename, modified := range r.modified {
+		info, err := os.Stat(filename)
+		if err == nil && !info.ModTime().Equal(modified) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *CertificateReloader) watch() {
+	ticker := time.NewTicker(r.options.ReloadInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			err := r.Reload()
+			if err != nil {
+				// The files may be half written; they are checked again in the next tick.
+				log.Error().
+					Err(err).
+					Msg("failed to reload the TLS certificates; keeping the previous ones")
+				continue
+			}
+			log.Info().Msg("reloaded the TLS certificates")
+		}
+	}
+}
+
+// RunTLS starts the webserver on address, serving HTTPS with the certificates in opts.
+func (w *Server) RunTLS(address string, opts TLSOptions) {
+	reloader, err := NewCertificateReloader(opts)
+	if err != nil {
+		log.Error().
+			Err(err).
+			Msg("failed to load the TLS certificates")
+		return
+	}
+	defer reloader.Close()
+
+	server := &http.Server{
+		Addr:      address,
+		Handler:   w.Router,
+		TLSConfig: reloader.TLSConfig(),
+	}
+	// The certificates come from the TLS configuration, so no files are passed here.
+	err = server.ListenAndServeTLS("", "")
+	log.Error().
+		Err(err).
+		Msg("error while running the webserver")
+}
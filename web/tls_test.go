@@ -0,0 +1,231 @@
+package web_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/otaviokr/mongodb-proxy-ms/auth"
+	"github.com/otaviokr/mongodb-proxy-ms/mock"
+	"github.com/otaviokr/mongodb-proxy-ms/web"
+	"github.com/stretchr/testify/assert"
+)
+
+// testCertificate is a certificate and its key, signed by parent (or by itself, if parent is nil).
+type testCertificate struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+	pem         []byte
+	keyPEM      []byte
+}
+
+func newTestCertificate(t *testing.T, subject pkix.Name, parent *testCertificate, isCA bool) *testCertificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.FailNow()
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               subject,
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.certificate, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.FailNow()
+	}
+	certificate, _ := x509.ParseCertificate(der)
+	keyDER, _ := x509.MarshalECPrivateKey(key)
+
+	return &testCertificate{
+		certificate: certificate,
+		key:         key,
+		pem:         pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:      pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCertificate) tlsCertificate() tls.Certificate {
+	certificate, _ := tls.X509KeyPair(c.pem, c.keyPEM)
+	return certificate
+}
+
+func writeFile(t *testing.T, filename string, content []byte) {
+	if err := ioutil.WriteFile(filename, content, 0600); err != nil {
+		t.FailNow()
+	}
+}
+
+func TestNewCertificateReloader(t *testing.T) {
+	dir := t.TempDir()
+	server := newTestCertificate(t, pkix.Name{CommonName: "proxy"}, nil, false)
+	writeFile(t, filepath.Join(dir, "cert.pem"), server.pem)
+	writeFile(t, filepath.Join(dir, "key.pem"), server.keyPEM)
+	writeFile(t, filepath.Join(dir, "empty.pem"), []byte{})
+
+	testCases := []struct {
+		name  string
+		opts  web.TLSOptions
+		valid bool
+	}{
+		{"valid", web.TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem")}, true},
+		{"missing key", web.TLSOptions{CertFile: filepath.Join(dir, "cert.pem")}, false},
+		{"wrong key", web.TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "empty.pem")}, false},
+		{"required without CA", web.TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), RequireClientCert: true}, false},
+		{"empty CA", web.TLSOptions{CertFile: filepath.Join(dir, "cert.pem"), KeyFile: filepath.Join(dir, "key.pem"), ClientCAFile: filepath.Join(dir, "empty.pem")}, false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.opts.ReloadInterval = -1
+			reloader, err := web.NewCertificateReloader(tc.opts)
+			assert.Equal(t, tc.valid, err == nil, "unexpected error: %v", err)
+			if reloader != nil {
+				reloader.Close()
+			}
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test CA"}, nil, true)
+	server := newTestCertificate(t, pkix.Name{CommonName: "proxy"}, ca, false)
+	client := newTestCertificate(t, pkix.Name{CommonName: "reporting", OrganizationalUnit: []string{"analytics"}}, ca, false)
+	stranger := newTestCertificate(t, pkix.Name{CommonName: "reporting"}, nil, false)
+
+	opts := web.TLSOptions{
+		CertFile:       filepath.Join(dir, "cert.pem"),
+		KeyFile:        filepath.Join(dir, "key.pem"),
+		ClientCAFile:   filepath.Join(dir, "ca.pem"),
+		ReloadInterval: -1,
+	}
+	writeFile(t, opts.CertFile, server.pem)
+	writeFile(t, opts.KeyFile, server.keyPEM)
+	writeFile(t, opts.ClientCAFile, ca.pem)
+
+	reloader, err := web.NewCertificateReloader(opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer reloader.Close()
+
+	policy, err := auth.NewPolicy([]auth.Rule{
+		{Roles: []string{"analytics"}, Operations: []auth.Operation{auth.OpFind}, Databases: []string{"*"}},
+	})
+	if err != nil {
+		t.FailNow()
+	}
+	ws := web.NewWithOptions(&mock.DBProxy{TestCaseID: "findOK"}, web.Options{
+		Authenticators: []auth.Authenticator{auth.NewCertificateAuthenticator(false)},
+		Policy:         policy,
+	})
+	httpServer := httptest.NewUnstartedServer(ws.Router)
+	httpServer.TLS = reloader.TLSConfig()
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	newClient := func(certificate *testCertificate) *http.Client {
+		config := &tls.Config{RootCAs: roots}
+		if certificate != nil {
+			config.Certificates = []tls.Certificate{certificate.tlsCertificate()}
+		}
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+	}
+
+	testCases := []struct {
+		name         string
+		client       *testCertificate
+		expectedCode int
+	}{
+		{"client certificate", client, http.StatusOK},
+		{"without certificate", nil, http.StatusUnauthorized},
+		// Clients don't send certificates the server won't accept, so this one is anonymous.
+		{"certificate from another CA", stranger, http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			response, err := newClient(tc.client).Post(httpServer.URL+"/find/cool_db/cool_collection", "application/json", nil)
+			if assert.NoError(t, err) {
+				assert.Equal(t, tc.expectedCode, response.StatusCode)
+				response.Body.Close()
+			}
+		})
+	}
+
+	// Renew the server certificate, and check it is used by new connections.
+	renewed := newTestCertificate(t, pkix.Name{CommonName: "proxy renewed"}, ca, false)
+	writeFile(t, opts.CertFile, renewed.pem)
+	writeFile(t, opts.KeyFile, renewed.keyPEM)
+	assert.NoError(t, reloader.Reload())
+
+	connection, err := tls.Dial("tcp", httpServer.Listener.Addr().String(), &tls.Config{
+		RootCAs:    roots,
+		ServerName: "127.0.0.1",
+		NextProtos: []string{"h2", "http/1.1"},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "proxy renewed", connection.ConnectionState().PeerCertificates[0].Subject.CommonName)
+		assert.Equal(t, "h2", connection.ConnectionState().NegotiatedProtocol, "HTTP/2 should be negotiated")
+		connection.Close()
+	}
+}
+
+func TestRequireClientCert(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, pkix.Name{CommonName: "test CA"}, nil, true)
+	server := newTestCertificate(t, pkix.Name{CommonName: "proxy"}, ca, false)
+
+	opts := web.TLSOptions{
+		CertFile:          filepath.Join(dir, "cert.pem"),
+		KeyFile:           filepath.Join(dir, "key.pem"),
+		ClientCAFile:      filepath.Join(dir, "ca.pem"),
+		RequireClientCert: true,
+		ReloadInterval:    -1,
+	}
+	writeFile(t, opts.CertFile, server.pem)
+	writeFile(t, opts.KeyFile, server.keyPEM)
+	writeFile(t, opts.ClientCAFile, ca.pem)
+
+	reloader, err := web.NewCertificateReloader(opts)
+	if err != nil {
+		t.FailNow()
+	}
+	defer reloader.Close()
+
+	httpServer := httptest.NewUnstartedServer(web.NewWithCustomDB(&mock.DBProxy{TestCaseID: "healthUp"}).Router)
+	httpServer.TLS = reloader.TLSConfig()
+	httpServer.StartTLS()
+	defer httpServer.Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.certificate)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}}}
+	_, err = client.Get(httpServer.URL + "/health")
+	assert.Error(t, err, "connections without a client certificate should be refused")
+}